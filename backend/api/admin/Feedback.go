@@ -0,0 +1,181 @@
+package admin
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type FeedbackReportResponse struct {
+	GroupBy string                     `json:"group_by"`
+	Rows    []database.FeedbackSummary `json:"rows"`
+}
+
+type FeedbackRatingItem struct {
+	MessageUUID     string `json:"message_uuid"`
+	ChatUUID        string `json:"chat_uuid"`
+	MessageText     string `json:"message_text"`
+	UserUUID        string `json:"user_uuid"`
+	BotUUID         string `json:"bot_uuid"`
+	BotName         string `json:"bot_name"`
+	ModelConfigUUID string `json:"model_config_uuid,omitempty"`
+	Backend         string `json:"backend"`
+	Model           string `json:"model"`
+	Rating          int    `json:"rating"`
+	Comment         string `json:"comment"`
+	RatedAt         string `json:"rated_at"`
+}
+
+type FeedbackRatingsResponse struct {
+	Total int64                `json:"total"`
+	Rows  []FeedbackRatingItem `json:"rows"`
+}
+
+// feedbackFilterFromQuery reads the bot, model_config, model, rating, from and
+// to query parameters, writing the error response when one is invalid. from
+// and to are inclusive YYYY-MM-DD days.
+func feedbackFilterFromQuery(w http.ResponseWriter, r *http.Request, DB *gorm.DB) (database.FeedbackFilter, bool) {
+	query := r.URL.Query()
+	filter := database.FeedbackFilter{ModelName: strings.TrimSpace(query.Get("model"))}
+
+	if from := strings.TrimSpace(query.Get("from")); from != "" {
+		day, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			http.Error(w, "from and to must be YYYY-MM-DD days", http.StatusBadRequest)
+			return filter, false
+		}
+		filter.From = &day
+	}
+	if to := strings.TrimSpace(query.Get("to")); to != "" {
+		day, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			http.Error(w, "from and to must be YYYY-MM-DD days", http.StatusBadRequest)
+			return filter, false
+		}
+		end := day.AddDate(0, 0, 1)
+		filter.To = &end
+	}
+	if rating := strings.TrimSpace(query.Get("rating")); rating != "" {
+		parsed, err := strconv.Atoi(rating)
+		if err != nil || (parsed != database.RatingThumbsUp && parsed != database.RatingThumbsDown) {
+			http.Error(w, "rating must be 1 or -1", http.StatusBadRequest)
+			return filter, false
+		}
+		filter.Rating = parsed
+	}
+	if botUUID := strings.TrimSpace(query.Get("bot")); botUUID != "" {
+		target, err := findUsageUser(DB, botUUID)
+		if err != nil {
+			http.Error(w, "bot not found", http.StatusNotFound)
+			return filter, false
+		}
+		filter.BotUserId = target.ID
+	}
+	if modelConfigUUID := strings.TrimSpace(query.Get("model_config")); modelConfigUUID != "" {
+		var modelConfig database.ModelConfig
+		if err := DB.Select("id").Where("uuid = ?", modelConfigUUID).First(&modelConfig).Error; err != nil {
+			http.Error(w, "model config not found", http.StatusNotFound)
+			return filter, false
+		}
+		filter.ModelConfigId = modelConfig.ID
+	}
+	return filter, true
+}
+
+// GetFeedbackReport aggregates the ratings of bot answers grouped by bot (the
+// default), model_config or model. The bot, model_config, model, rating, from
+// and to query parameters narrow the aggregated ratings.
+func GetFeedbackReport(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	groupBy := strings.TrimSpace(r.URL.Query().Get("group_by"))
+	if groupBy == "" {
+		groupBy = "bot"
+	}
+	filter, ok := feedbackFilterFromQuery(w, r, DB)
+	if !ok {
+		return
+	}
+
+	rows, err := database.SummarizeFeedback(DB, filter, groupBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FeedbackReportResponse{GroupBy: groupBy, Rows: rows})
+}
+
+// ListFeedbackRatings lists single ratings with their comments, newest first,
+// narrowed by the same query parameters as GetFeedbackReport. page and limit
+// (at most 200) page through them.
+func ListFeedbackRatings(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	filter, ok := feedbackFilterFromQuery(w, r, DB)
+	if !ok {
+		return
+	}
+	page, limit := 1, 50
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && parsed > 0 {
+		page = parsed
+	}
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = min(parsed, 200)
+	}
+
+	ratings, total, err := database.ListMessageRatings(DB, filter, limit, (page-1)*limit)
+	if err != nil {
+		http.Error(w, "Failed to list ratings", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]FeedbackRatingItem, 0, len(ratings))
+	for _, rating := range ratings {
+		item := FeedbackRatingItem{
+			MessageUUID: rating.Message.UUID,
+			ChatUUID:    rating.Message.Chat.UUID,
+			UserUUID:    rating.User.UUID,
+			BotUUID:     rating.BotUser.UUID,
+			BotName:     rating.BotUser.Name,
+			Backend:     rating.Backend,
+			Model:       rating.ModelName,
+			Rating:      rating.Rating,
+			Comment:     rating.Comment,
+			RatedAt:     rating.UpdatedAt.String(),
+		}
+		if rating.Message.Text != nil {
+			item.MessageText = *rating.Message.Text
+		}
+		if rating.ModelConfig != nil {
+			item.ModelConfigUUID = rating.ModelConfig.UUID
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FeedbackRatingsResponse{Total: total, Rows: items})
+}
@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"backend/api/msgmate"
+	"backend/database"
+	"backend/server/util"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ProviderListItem struct {
+	Name            string `json:"name"`
+	APIKeyAvailable bool   `json:"api_key_available"`
+}
+
+type ProviderModelsResponse struct {
+	Provider string                  `json:"provider"`
+	Endpoint string                  `json:"endpoint"`
+	Models   []msgmate.ProviderModel `json:"models"`
+}
+
+func ListProviders(w http.ResponseWriter, r *http.Request) {
+	_, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	items := []ProviderListItem{}
+	for _, name := range msgmate.ListProviders() {
+		items = append(items, ProviderListItem{
+			Name:            name,
+			APIKeyAvailable: database.ProviderAPIKey(name) != "",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// ListProviderModels runs the model discovery of a provider, optionally
+// against the endpoint given in the query instead of the provider default.
+// The stored API key is only sent to the provider's own endpoint, never to
+// an endpoint from the query.
+func ListProviderModels(w http.ResponseWriter, r *http.Request) {
+	_, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	providerName := r.PathValue("provider")
+	provider, ok := msgmate.GetProvider(providerName)
+	if !ok {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return
+	}
+
+	override := strings.TrimSpace(r.URL.Query().Get("endpoint"))
+	endpoint, err := provider.ResolveEndpoint(override)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	apiKey := database.ProviderAPIKey(provider.Name())
+	if override != "" {
+		apiKey = ""
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	models, err := provider.ListModels(ctx, endpoint, apiKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list models: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProviderModelsResponse{
+		Provider: provider.Name(),
+		Endpoint: endpoint,
+		Models:   models,
+	})
+}
@@ -0,0 +1,160 @@
+package admin
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RetentionPolicyItem struct {
+	UUID   string `json:"uuid"`
+	Scope  string `json:"scope"`
+	Target string `json:"target,omitempty"`
+	// BotName is set on bot policies.
+	BotName string `json:"bot_name,omitempty"`
+	database.RetentionPolicyLimits
+}
+
+type SetRetentionPolicyRequest struct {
+	Scope string `json:"scope"`
+	// Target is the bot UUID of bot policies and the chat type of chat type policies.
+	Target string `json:"target"`
+	database.RetentionPolicyLimits
+}
+
+func retentionPolicyItem(policy database.RetentionPolicy) RetentionPolicyItem {
+	item := RetentionPolicyItem{
+		UUID:                  policy.UUID,
+		Scope:                 policy.Scope,
+		Target:                policy.RetentionTarget(),
+		RetentionPolicyLimits: policy.RetentionPolicyLimits,
+	}
+	if policy.BotRuntimeConfig != nil {
+		item.BotName = policy.BotRuntimeConfig.Name
+	}
+	return item
+}
+
+// ListRetentionPolicies lists the global, bot and chat type retention policies.
+func ListRetentionPolicies(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	policies, err := database.ListRetentionPolicies(DB)
+	if err != nil {
+		http.Error(w, "Failed to list retention policies", http.StatusInternalServerError)
+		return
+	}
+	items := make([]RetentionPolicyItem, len(policies))
+	for i, policy := range policies {
+		items[i] = retentionPolicyItem(policy)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// SetRetentionPolicy creates or replaces the retention policy of a scope,
+// days of 0 keep rows forever.
+func SetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	var data SetRetentionPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	data.Scope = strings.ToLower(strings.TrimSpace(data.Scope))
+	data.Target = strings.TrimSpace(data.Target)
+
+	var bot *database.BotRuntimeConfig
+	if data.Scope == database.RetentionScopeBot {
+		var runtime database.BotRuntimeConfig
+		err := DB.Where("uuid = ?", data.Target).First(&runtime).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "bot not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to load bot", http.StatusInternalServerError)
+			return
+		}
+		bot = &runtime
+	}
+
+	policy, err := database.SetRetentionPolicy(DB, data.Scope, bot, data.Target, data.RetentionPolicyLimits)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retentionPolicyItem(*policy))
+}
+
+func DeleteRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	err = database.DeleteRetentionPolicy(DB, r.PathValue("policy_uuid"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Retention policy not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete retention policy", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetRetentionReport is a dry run of the retention purge: it reports what a
+// purge would delete now without deleting anything.
+func GetRetentionReport(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	plan, err := database.PlanRetentionPurge(DB, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to plan retention purge", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan.Report)
+}
@@ -0,0 +1,111 @@
+package admin
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type UploadLimitItem struct {
+	UserUUID      string `json:"user_uuid"`
+	UserName      string `json:"user_name"`
+	MaxUploadSize int64  `json:"max_upload_size"`
+}
+
+type UploadLimitsResponse struct {
+	DefaultMaxUploadSize int64             `json:"default_max_upload_size"`
+	Limits               []UploadLimitItem `json:"limits"`
+}
+
+type SetUploadLimitRequest struct {
+	MaxUploadSize int64 `json:"max_upload_size"`
+}
+
+// ListUploadLimits lists the users whose resumable uploads are limited to
+// another size than the default.
+func ListUploadLimits(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	limits := []database.UploadLimit{}
+	if err := DB.Preload("User").Order("user_id asc").Find(&limits).Error; err != nil {
+		http.Error(w, "Failed to list upload limits", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]UploadLimitItem, 0, len(limits))
+	for _, limit := range limits {
+		items = append(items, UploadLimitItem{UserUUID: limit.User.UUID, UserName: limit.User.Name, MaxUploadSize: limit.MaxUploadSize})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UploadLimitsResponse{DefaultMaxUploadSize: database.DefaultMaxUploadSize, Limits: items})
+}
+
+// SetUploadLimit creates or replaces the largest resumable upload, in bytes,
+// a user may create.
+func SetUploadLimit(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	target, err := findUsageUser(DB, strings.TrimSpace(r.PathValue("user_uuid")))
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	var data SetUploadLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := database.SetUploadLimit(DB, target.ID, data.MaxUploadSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UploadLimitItem{UserUUID: target.UUID, UserName: target.Name, MaxUploadSize: limit.MaxUploadSize})
+}
+
+func DeleteUploadLimit(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	target, err := findUsageUser(DB, strings.TrimSpace(r.PathValue("user_uuid")))
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if err := database.DeleteUploadLimit(DB, target.ID); err != nil {
+		http.Error(w, "Failed to delete upload limit", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
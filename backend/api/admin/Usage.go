@@ -0,0 +1,239 @@
+package admin
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TokenUsageResponse struct {
+	GroupBy string                       `json:"group_by"`
+	Rows    []database.TokenUsageSummary `json:"rows"`
+}
+
+type TokenQuotaItem struct {
+	Subject  string `json:"subject"`
+	UserUUID string `json:"user_uuid"`
+	UserName string `json:"user_name"`
+	database.TokenQuotaLimits
+	UsedToday      int64   `json:"used_today"`
+	UsedThisMonth  int64   `json:"used_this_month"`
+	SpentThisMonth float64 `json:"spent_this_month"`
+}
+
+func findUsageUser(DB *gorm.DB, userUUID string) (*database.User, error) {
+	var target database.User
+	if err := DB.First(&target, "uuid = ?", userUUID).Error; err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// GetTokenUsage sums the tokens and cost of the usage ledger grouped by user,
+// bot, chat, model or day (the default). The user, bot, chat, model, from and
+// to query parameters narrow the summed entries, from and to are inclusive
+// YYYY-MM-DD days.
+func GetTokenUsage(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	groupBy := strings.TrimSpace(query.Get("group_by"))
+	if groupBy == "" {
+		groupBy = "day"
+	}
+
+	filter := database.TokenUsageFilter{
+		ChatUUID:  strings.TrimSpace(query.Get("chat")),
+		ModelName: strings.TrimSpace(query.Get("model")),
+		FromDay:   strings.TrimSpace(query.Get("from")),
+		ToDay:     strings.TrimSpace(query.Get("to")),
+	}
+	for _, day := range []string{filter.FromDay, filter.ToDay} {
+		if day != "" && !database.IsValidTokenUsageDay(day) {
+			http.Error(w, "from and to must be YYYY-MM-DD days", http.StatusBadRequest)
+			return
+		}
+	}
+	if userUUID := strings.TrimSpace(query.Get("user")); userUUID != "" {
+		target, err := findUsageUser(DB, userUUID)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		filter.UserId = target.ID
+	}
+	if botUUID := strings.TrimSpace(query.Get("bot")); botUUID != "" {
+		target, err := findUsageUser(DB, botUUID)
+		if err != nil {
+			http.Error(w, "bot not found", http.StatusNotFound)
+			return
+		}
+		filter.BotUserId = target.ID
+	}
+
+	rows, err := database.SummarizeTokenUsage(DB, filter, groupBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TokenUsageResponse{GroupBy: groupBy, Rows: rows})
+}
+
+// ListTokenQuotas lists all quotas with the usage they currently count against.
+func ListTokenQuotas(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	quotas := []database.TokenQuota{}
+	if err := DB.Preload("User").Order("subject asc, user_id asc").Find(&quotas).Error; err != nil {
+		http.Error(w, "Failed to list quotas", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]TokenQuotaItem, 0, len(quotas))
+	for _, quota := range quotas {
+		item, err := tokenQuotaItem(DB, quota, quota.User)
+		if err != nil {
+			http.Error(w, "Failed to sum quota usage", http.StatusInternalServerError)
+			return
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// SetTokenQuota creates or replaces the daily and monthly token quota and the
+// monthly budget of a user or bot, limits of 0 are unlimited.
+func SetTokenQuota(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	subject, target, ok := resolveQuotaTarget(DB, w, r)
+	if !ok {
+		return
+	}
+
+	var data database.TokenQuotaLimits
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	quota, err := database.SetTokenQuota(DB, subject, target.ID, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	item, err := tokenQuotaItem(DB, *quota, *target)
+	if err != nil {
+		http.Error(w, "Failed to sum quota usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+func DeleteTokenQuota(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		http.Error(w, "User is not an admin", http.StatusForbidden)
+		return
+	}
+
+	subject, target, ok := resolveQuotaTarget(DB, w, r)
+	if !ok {
+		return
+	}
+
+	if err := database.DeleteTokenQuota(DB, subject, target.ID); err != nil {
+		http.Error(w, "Failed to delete quota", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveQuotaTarget reads the {subject} and {user_uuid} path values. Bot
+// quotas can only be set on automated users.
+func resolveQuotaTarget(DB *gorm.DB, w http.ResponseWriter, r *http.Request) (string, *database.User, bool) {
+	subject := strings.ToLower(strings.TrimSpace(r.PathValue("subject")))
+	if !database.IsValidTokenQuotaSubject(subject) {
+		http.Error(w, fmt.Sprintf("subject must be %q or %q", database.TokenQuotaSubjectUser, database.TokenQuotaSubjectBot), http.StatusBadRequest)
+		return "", nil, false
+	}
+
+	target, err := findUsageUser(DB, strings.TrimSpace(r.PathValue("user_uuid")))
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return "", nil, false
+	}
+	if subject == database.TokenQuotaSubjectBot && !target.IsAutomated {
+		http.Error(w, "bot quotas can only be set on bot users", http.StatusBadRequest)
+		return "", nil, false
+	}
+	return subject, target, true
+}
+
+func tokenQuotaItem(DB *gorm.DB, quota database.TokenQuota, target database.User) (TokenQuotaItem, error) {
+	now := time.Now().UTC()
+	usedToday, err := database.TokenUsageSince(DB, quota.Subject, quota.UserId, database.TokenUsageDay(now))
+	if err != nil {
+		return TokenQuotaItem{}, err
+	}
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	usedThisMonth, err := database.TokenUsageSince(DB, quota.Subject, quota.UserId, database.TokenUsageDay(monthStart))
+	if err != nil {
+		return TokenQuotaItem{}, err
+	}
+	spentThisMonth, err := database.TokenSpendSince(DB, quota.Subject, quota.UserId, database.TokenUsageDay(monthStart))
+	if err != nil {
+		return TokenQuotaItem{}, err
+	}
+
+	return TokenQuotaItem{
+		Subject:          quota.Subject,
+		UserUUID:         target.UUID,
+		UserName:         target.Name,
+		TokenQuotaLimits: quota.TokenQuotaLimits,
+		UsedToday:        usedToday,
+		UsedThisMonth:    usedThisMonth,
+		SpentThisMonth:   spentThisMonth,
+	}, nil
+}
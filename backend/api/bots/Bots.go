@@ -16,12 +16,13 @@ import (
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
 	extiface "github.com/msgmate-io/go-integration-interface/integrationinterface"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
-var errAmbiguousIdentifier = errors.New("ambiguous bot identifier")
+var ErrAmbiguousIdentifier = errors.New("ambiguous bot identifier")
 
 func applyIntegrationDefaultsForUser(DB *gorm.DB, user *database.User, config map[string]interface{}) (map[string]interface{}, error) {
 	_ = DB
@@ -529,7 +530,7 @@ func resolveByBotUsername(DB *gorm.DB, user *database.User, username string) (da
 		return database.BotRuntimeConfig{}, gorm.ErrRecordNotFound
 	}
 	if len(matches) > 1 {
-		return database.BotRuntimeConfig{}, errAmbiguousIdentifier
+		return database.BotRuntimeConfig{}, ErrAmbiguousIdentifier
 	}
 	return matches[0], nil
 }
@@ -559,7 +560,7 @@ func parseBoolQuery(raw string) bool {
 	}
 }
 
-func resolveReadableBot(DB *gorm.DB, user *database.User, identifier string) (database.BotRuntimeConfig, error) {
+func ResolveReadableBot(DB *gorm.DB, user *database.User, identifier string) (database.BotRuntimeConfig, error) {
 	if identifier == "" {
 		return database.BotRuntimeConfig{}, gorm.ErrRecordNotFound
 	}
@@ -582,7 +583,7 @@ func resolveReadableBot(DB *gorm.DB, user *database.User, identifier string) (da
 		return database.BotRuntimeConfig{}, err
 	}
 	if len(matches) > 1 {
-		return database.BotRuntimeConfig{}, errAmbiguousIdentifier
+		return database.BotRuntimeConfig{}, ErrAmbiguousIdentifier
 	}
 	if len(matches) == 1 {
 		return matches[0], nil
@@ -591,7 +592,7 @@ func resolveReadableBot(DB *gorm.DB, user *database.User, identifier string) (da
 }
 
 func resolveOwnedBot(DB *gorm.DB, user *database.User, identifier string) (database.BotRuntimeConfig, error) {
-	runtime, err := resolveReadableBot(DB, user, identifier)
+	runtime, err := ResolveReadableBot(DB, user, identifier)
 	if err != nil {
 		return database.BotRuntimeConfig{}, err
 	}
@@ -870,9 +871,9 @@ func (h *BotsHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 
 	identifier := strings.TrimSpace(r.PathValue("identifier"))
-	runtime, err := resolveReadableBot(DB, user, identifier)
+	runtime, err := ResolveReadableBot(DB, user, identifier)
 	if err != nil {
-		if errors.Is(err, errAmbiguousIdentifier) {
+		if errors.Is(err, ErrAmbiguousIdentifier) {
 			http.Error(w, "ambiguous bot identifier", http.StatusConflict)
 			return
 		}
@@ -906,7 +907,7 @@ func (h *BotsHandler) Update(w http.ResponseWriter, r *http.Request) {
 	identifier := strings.TrimSpace(r.PathValue("identifier"))
 	runtime, err := resolveOwnedBot(DB, user, identifier)
 	if err != nil {
-		if errors.Is(err, errAmbiguousIdentifier) {
+		if errors.Is(err, ErrAmbiguousIdentifier) {
 			http.Error(w, "ambiguous bot identifier", http.StatusConflict)
 			return
 		}
@@ -1016,7 +1017,7 @@ func (h *BotsHandler) SaveConfig(w http.ResponseWriter, r *http.Request) {
 	identifier := strings.TrimSpace(r.PathValue("identifier"))
 	runtime, err := resolveOwnedBot(DB, user, identifier)
 	if err != nil {
-		if errors.Is(err, errAmbiguousIdentifier) {
+		if errors.Is(err, ErrAmbiguousIdentifier) {
 			http.Error(w, "ambiguous bot identifier", http.StatusConflict)
 			return
 		}
@@ -1090,7 +1091,7 @@ func (h *BotsHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	identifier := strings.TrimSpace(r.PathValue("identifier"))
 	runtime, err := resolveOwnedBot(DB, user, identifier)
 	if err != nil {
-		if errors.Is(err, errAmbiguousIdentifier) {
+		if errors.Is(err, ErrAmbiguousIdentifier) {
 			http.Error(w, "ambiguous bot identifier", http.StatusConflict)
 			return
 		}
@@ -1134,9 +1135,9 @@ func (h *BotsHandler) CreateInteraction(w http.ResponseWriter, r *http.Request)
 	}
 
 	identifier := strings.TrimSpace(r.PathValue("identifier"))
-	runtime, err := resolveReadableBot(DB, user, identifier)
+	runtime, err := ResolveReadableBot(DB, user, identifier)
 	if err != nil {
-		if errors.Is(err, errAmbiguousIdentifier) {
+		if errors.Is(err, ErrAmbiguousIdentifier) {
 			http.Error(w, "ambiguous bot identifier", http.StatusConflict)
 			return
 		}
@@ -1154,6 +1155,48 @@ func (h *BotsHandler) CreateInteraction(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	chat, share, err := CreateInteractionChat(DB, queueClient, queueInspector, user, runtime, req)
+	if err != nil {
+		var interactionErr *InteractionError
+		if errors.As(err, &interactionErr) {
+			http.Error(w, interactionErr.Message, interactionErr.Status)
+			return
+		}
+		http.Error(w, "Failed to create interaction", http.StatusInternalServerError)
+		return
+	}
+
+	response := BotInteractionResponse{ChatUUID: chat.UUID}
+	if req.AutoShare {
+		response.ChatShareUUID = share.ChatShareUUID
+		response.ChatShare = &BotInteractionChatShare{
+			ChatUUID:      chat.UUID,
+			ChatShareUUID: share.ChatShareUUID,
+		}
+		baseURL := requestBaseURL(r)
+		if baseURL != "" {
+			response.SharedInteractionURL = baseURL + "/interaction/" + share.ChatShareUUID
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// InteractionError rejects an interaction request, Status is the HTTP status to answer with.
+type InteractionError struct {
+	Status  int
+	Message string
+}
+
+func (e *InteractionError) Error() string {
+	return e.Message
+}
+
+// CreateInteractionChat creates an interaction chat of user with the bot from
+// the bot's default config and req's overrides, sends req.Message as its first
+// message and enqueues the bot's reply. The share is only set with AutoShare.
+func CreateInteractionChat(DB *gorm.DB, queueClient *asynq.Client, queueInspector *asynq.Inspector, user *database.User, runtime database.BotRuntimeConfig, req CreateBotInteractionRequest) (database.Chat, database.SharedChatInstance, error) {
 	effectiveConfig := decodeSharedConfig(runtime.DefaultSharedConfig)
 	for k, v := range req.ConfigOverrides {
 		effectiveConfig[k] = v
@@ -1161,22 +1204,18 @@ func (h *BotsHandler) CreateInteraction(w http.ResponseWriter, r *http.Request)
 	effectiveConfig["tool_init"] = req.ToolInit
 	withDefaultsConfig, defaultsErr := applyIntegrationDefaultsForUser(DB, user, effectiveConfig)
 	if defaultsErr != nil {
-		http.Error(w, "Failed to apply integration shared config defaults", http.StatusInternalServerError)
-		return
+		return database.Chat{}, database.SharedChatInstance{}, &InteractionError{Status: http.StatusInternalServerError, Message: "Failed to apply integration shared config defaults"}
 	}
 	effectiveConfig = withDefaultsConfig
 	if err := validateAndAttachDynamicToolsForUser(DB, user, effectiveConfig); err != nil {
-		http.Error(w, fmt.Sprintf("invalid tools/tool_init: %v", err), http.StatusBadRequest)
-		return
+		return database.Chat{}, database.SharedChatInstance{}, &InteractionError{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid tools/tool_init: %v", err)}
 	}
 	if err := validateAndAttachMCPIntegrationsForUser(DB, user, effectiveConfig); err != nil {
-		http.Error(w, fmt.Sprintf("invalid integrations: %v", err), http.StatusBadRequest)
-		return
+		return database.Chat{}, database.SharedChatInstance{}, &InteractionError{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid integrations: %v", err)}
 	}
 	configJSON, err := json.Marshal(effectiveConfig)
 	if err != nil {
-		http.Error(w, "Failed to process config", http.StatusBadRequest)
-		return
+		return database.Chat{}, database.SharedChatInstance{}, &InteractionError{Status: http.StatusBadRequest, Message: "Failed to process config"}
 	}
 
 	var chat database.Chat
@@ -1223,8 +1262,7 @@ func (h *BotsHandler) CreateInteraction(w http.ResponseWriter, r *http.Request)
 		return nil
 	})
 	if err != nil {
-		http.Error(w, "Failed to create interaction", http.StatusInternalServerError)
-		return
+		return database.Chat{}, database.SharedChatInstance{}, &InteractionError{Status: http.StatusInternalServerError, Message: "Failed to create interaction"}
 	}
 
 	if _, enqueueErr := workqueue.EnqueueBotReply(queueClient, queueInspector, workqueue.BotReplyPayload{
@@ -1232,23 +1270,7 @@ func (h *BotsHandler) CreateInteraction(w http.ResponseWriter, r *http.Request)
 		MessageUUID: message.UUID,
 		BotUserID:   runtime.BotUserId,
 	}); enqueueErr != nil {
-		http.Error(w, "Failed to enqueue bot reply", http.StatusInternalServerError)
-		return
-	}
-
-	response := BotInteractionResponse{ChatUUID: chat.UUID}
-	if req.AutoShare {
-		response.ChatShareUUID = share.ChatShareUUID
-		response.ChatShare = &BotInteractionChatShare{
-			ChatUUID:      chat.UUID,
-			ChatShareUUID: share.ChatShareUUID,
-		}
-		baseURL := requestBaseURL(r)
-		if baseURL != "" {
-			response.SharedInteractionURL = baseURL + "/interaction/" + share.ChatShareUUID
-		}
+		return database.Chat{}, database.SharedChatInstance{}, &InteractionError{Status: http.StatusInternalServerError, Message: "Failed to enqueue bot reply"}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	return chat, share, nil
 }
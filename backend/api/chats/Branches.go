@@ -0,0 +1,175 @@
+package chats
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type ListedBranch struct {
+	ListedMessage
+	// Active is set for the branch shown in the chat.
+	Active bool `json:"active"`
+}
+
+type ListedBranches struct {
+	ChatUUID    string         `json:"chat_uuid"`
+	MessageUUID string         `json:"message_uuid"`
+	Rows        []ListedBranch `json:"rows"`
+}
+
+type ActivateBranchResponse struct {
+	ChatUUID        string `json:"chat_uuid"`
+	MessageUUID     string `json:"message_uuid"`
+	LeafMessageUUID string `json:"leaf_message_uuid"`
+}
+
+// ListBranches lists the alternative branches at a message.
+//
+//	@Summary      List message branches
+//	@Description  List the message and its siblings, the alternatives created by reruns and replaced bot answers, oldest first.
+//	@Tags         messages
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Message UUID"
+//	@Success      200 {object} chats.ListedBranches
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/branches [get]
+func (h *ChatsHandler) ListBranches(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	chat, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+
+	siblings, err := database.ListMessageBranches(DB, message)
+	if err != nil {
+		http.Error(w, "Failed to load branches", http.StatusInternalServerError)
+		return
+	}
+	rows := make([]ListedBranch, len(siblings))
+	for i, sibling := range siblings {
+		rows[i] = ListedBranch{
+			ListedMessage: convertMessageToListedMessage(sibling),
+			Active:        !sibling.Inactive,
+		}
+		if len(siblings) > 1 {
+			rows[i].BranchCount = len(siblings)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListedBranches{
+		ChatUUID:    chat.UUID,
+		MessageUUID: message.UUID,
+		Rows:        rows,
+	})
+}
+
+// ActivateBranch switches the chat to the branch through a message.
+//
+//	@Summary      Switch branch
+//	@Description  Make the branch through the message the active one. The branch continues to its newest message, which becomes the chat's latest message. Bot replies in progress are cancelled.
+//	@Tags         messages
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Message UUID"
+//	@Success      200 {object} chats.ActivateBranchResponse
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/activate [post]
+func (h *ChatsHandler) ActivateBranch(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	ch, err := util.GetWebsocket(r)
+	if err != nil {
+		http.Error(w, "Unable to get websocket", http.StatusBadRequest)
+		return
+	}
+
+	chat, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+
+	cancelInFlightBotReplies(r, chat.UUID)
+	leafID, err := database.ActivateMessageBranch(DB, chat.ID, message.ID)
+	if err != nil {
+		http.Error(w, "Failed to switch branch", http.StatusInternalServerError)
+		return
+	}
+	var leaf database.Message
+	if err := DB.Select("uuid").First(&leaf, leafID).Error; err != nil {
+		http.Error(w, "Failed to switch branch", http.StatusInternalServerError)
+		return
+	}
+
+	publishParticipantEvent(DB, ch, chat, ch.MessageHandler.BranchSwitched(chat.UUID, message.UUID, leaf.UUID, user.UUID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ActivateBranchResponse{
+		ChatUUID:        chat.UUID,
+		MessageUUID:     message.UUID,
+		LeafMessageUUID: leaf.UUID,
+	})
+}
+
+// ForkChat copies a chat up to a message into a new chat.
+//
+//	@Summary      Fork chat
+//	@Description  Create a new chat with the same participants and a copy of the shared config, containing the branch up to and including the message.
+//	@Tags         chats
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Last message to copy"
+//	@Success      200 {object} chats.ListedChat
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/fork [post]
+func (h *ChatsHandler) ForkChat(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	chat, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+
+	fork, err := database.ForkChat(DB, chat, message.ID)
+	if errors.Is(err, database.ErrMessageNotInChat) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fork chat", http.StatusInternalServerError)
+		return
+	}
+
+	if err := DB.Preload("User1").
+		Preload("User2").
+		Preload("SharedConfig").
+		Preload("LatestMessage").
+		Preload("Participants.User").
+		First(fork, fork.ID).Error; err != nil {
+		http.Error(w, "Failed to load forked chat", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(convertChatToListedChat(user, *fork))
+}
@@ -180,6 +180,19 @@ func (h *ChatsHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The first message may only attach files the user can access
+	attachedFiles := make([]*database.UploadedFile, 0, len(data.Attachments))
+	if data.FirstMessage != "" {
+		for _, attachment := range data.Attachments {
+			uploadedFile, err := findAttachableFile(DB, attachment.FileID, user.ID)
+			if err != nil {
+				writeSendMessageError(w, err)
+				return
+			}
+			attachedFiles = append(attachedFiles, uploadedFile)
+		}
+	}
+
 	// TODO check for blocked users
 	// Small optimization, try to always ensure User1Id < User2Id
 	var chat database.Chat
@@ -240,38 +253,11 @@ func (h *ChatsHandler) Create(w http.ResponseWriter, r *http.Request) {
 		chat.LatestMessageId = &message.ID
 		DB.Save(&chat)
 
-		// If this is an AI interaction chat with attachments, share files with the bot user
-		if data.ChatType == "interaction" && len(data.Attachments) > 0 {
-			log.Printf("Sharing %d attachments with bot user for AI interaction", len(data.Attachments))
-
-			for _, attachment := range data.Attachments {
-				// Get the file record
-				var uploadedFile database.UploadedFile
-				if err := DB.Where("file_id = ?", attachment.FileID).First(&uploadedFile).Error; err != nil {
-					log.Printf("Warning: File %s not found for sharing with bot user", attachment.FileID)
-					continue
-				}
-
-				// Check if file is already shared with the bot user
-				var existingAccess database.FileAccess
-				result := DB.Where("user_id = ? AND uploaded_file_id = ?", otherUser.ID, uploadedFile.ID).First(&existingAccess)
-				if result.Error != nil {
-					// File access doesn't exist, create it
-					fileAccess := database.FileAccess{
-						UserID:         otherUser.ID,
-						UploadedFileID: uploadedFile.ID,
-						Permission:     "view",
-						CreatedAt:      time.Now(),
-					}
-					if err := DB.Create(&fileAccess).Error; err != nil {
-						log.Printf("Error sharing file %s (ID: %d) with bot user %d: %v", attachment.FileID, uploadedFile.ID, otherUser.ID, err)
-						// Don't fail the chat creation if file sharing fails
-					} else {
-						log.Printf("Successfully shared file %s (ID: %d) with bot user %d for AI interaction", attachment.FileID, uploadedFile.ID, otherUser.ID)
-					}
-				} else {
-					log.Printf("File %s (ID: %d) already shared with bot user %d", attachment.FileID, uploadedFile.ID, otherUser.ID)
-				}
+		// Share the attached files with the other user, the bot of interactions
+		for _, uploadedFile := range attachedFiles {
+			if err := database.GrantFileViewAccess(DB, uploadedFile, []uint{otherUser.ID}, time.Now()); err != nil {
+				log.Printf("Error sharing file %s (ID: %d) with user %d: %v", uploadedFile.FileID, uploadedFile.ID, otherUser.ID, err)
+				// Don't fail the chat creation if file sharing fails
 			}
 		}
 	}
@@ -310,6 +296,8 @@ func (h *ChatsHandler) Create(w http.ResponseWriter, r *http.Request) {
 					BotUserID:   otherUser.ID,
 				}); enqueueErr != nil {
 					log.Printf("Failed to enqueue initial bot reply for chat %s: %v", chat.UUID, enqueueErr)
+				} else {
+					publishBotTyping(DB, ch, chat, []database.User{otherUser}, true)
 				}
 			}
 		} else {
@@ -0,0 +1,272 @@
+package chats
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"backend/workqueue"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type EditMessageRequest struct {
+	Text string `json:"text"`
+}
+
+type EditMessageResponse struct {
+	Message  ListedMessage `json:"message"`
+	Revision int           `json:"revision"`
+	// RemovedMessageUUIDs are the answers moved to an inactive branch.
+	RemovedMessageUUIDs []string `json:"removed_message_uuids"`
+	Enqueued            bool     `json:"enqueued"`
+}
+
+type ListedMessageRevision struct {
+	Revision   int    `json:"revision"`
+	Text       string `json:"text"`
+	EditorUUID string `json:"editor_uuid"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// findVisibleChatMessage loads a message of a chat the user participates in,
+// writing the error response when either is not found.
+func findVisibleChatMessage(w http.ResponseWriter, DB *gorm.DB, user *database.User, chatUUID, messageUUID string) (database.Chat, database.Message, bool) {
+	var chat database.Chat
+	var message database.Message
+	if err := DB.Preload("User1").
+		Preload("User2").
+		Preload("SharedConfig").
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUUID).
+		First(&chat).Error; err != nil {
+		http.Error(w, "Chat not found", http.StatusNotFound)
+		return chat, message, false
+	}
+
+	query := DB.Preload("Sender").Where("uuid = ? AND chat_id = ?", messageUUID, chat.ID)
+	if !isGroupChat(chat) {
+		query = query.Where("receiver_id = ? OR sender_id = ?", user.ID, user.ID)
+	}
+	if err := query.First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return chat, message, false
+		}
+		http.Error(w, "Failed to load message", http.StatusInternalServerError)
+		return chat, message, false
+	}
+	return chat, message, true
+}
+
+// editReplyBots returns the bots that reply again to an edited message under
+// the "replace" edit reply policy. Only the last human message of the active
+// branch is replied to again.
+func editReplyBots(DB *gorm.DB, chat database.Chat, user database.User, message database.Message, text string) ([]database.User, error) {
+	if message.Inactive || chat.SharedConfig == nil || database.EditReplyPolicy(chat.SharedConfig.ConfigData) != database.EditReplyPolicyReplace {
+		return nil, nil
+	}
+
+	var laterHumanMessages int64
+	if err := DB.Model(&database.Message{}).
+		Where("chat_id = ? AND id > ? AND inactive = ?", chat.ID, message.ID, false).
+		Where("sender_id IN (SELECT id FROM users WHERE is_automated = ?)", false).
+		Count(&laterHumanMessages).Error; err != nil {
+		return nil, err
+	}
+	if laterHumanMessages > 0 {
+		return nil, nil
+	}
+
+	if isGroupChat(chat) {
+		_, bots, err := resolveMessageRecipients(DB, chat, user, text)
+		return bots, err
+	}
+	counterparty, ok := getChatCounterparty(chat, user)
+	if !ok || !counterparty.IsAutomated || message.ReceiverId != counterparty.ID {
+		return nil, nil
+	}
+	return []database.User{counterparty}, nil
+}
+
+// EditMessage replaces the text of one of the user's own messages.
+//
+//	@Summary      Edit a message
+//	@Description  Replace the text of your own message and record the revision. When the chat's edit_reply_policy is "replace" and the message is the last one you sent, the bot answers after it are moved to an inactive branch and the bot replies again.
+//	@Tags         messages
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Message UUID"
+//	@Param        request body EditMessageRequest true "New text"
+//	@Success      200 {object} chats.EditMessageResponse
+//	@Failure      400 {string} string "Invalid request"
+//	@Failure      403 {string} string "Forbidden"
+//	@Failure      404 {string} string "Not found"
+//	@Failure      409 {string} string "Conflict"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/edit [post]
+func (h *ChatsHandler) EditMessage(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	ch, err := util.GetWebsocket(r)
+	if err != nil {
+		http.Error(w, "Unable to get websocket", http.StatusBadRequest)
+		return
+	}
+
+	var data EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(data.Text) == "" {
+		http.Error(w, "Message text cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	chat, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+	if message.SenderId != user.ID {
+		http.Error(w, "Only your own messages can be edited", http.StatusForbidden)
+		return
+	}
+	if message.DataType == "event" {
+		http.Error(w, "Event messages cannot be edited", http.StatusConflict)
+		return
+	}
+
+	bots, err := editReplyBots(DB, chat, *user, message, data.Text)
+	if err != nil {
+		http.Error(w, "Failed to resolve chat participants", http.StatusInternalServerError)
+		return
+	}
+	if len(bots) > 0 {
+		cancelInFlightBotReplies(r, chat.UUID)
+	}
+
+	var revision *database.MessageRevision
+	removedMessageUUIDs := []string{}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var editErr error
+		revision, editErr = database.EditMessage(tx, &message, user.ID, data.Text, time.Now())
+		if editErr != nil || len(bots) == 0 {
+			return editErr
+		}
+
+		// The previous answers stay as an inactive branch next to the new one
+		removed, err := database.DeactivateMessagesFrom(tx, chat.ID, message.ID+1)
+		if err != nil {
+			return err
+		}
+		removedMessageUUIDs = removed
+		return tx.Model(&chat).Update("latest_message_id", message.ID).Error
+	})
+	if errors.Is(err, database.ErrMessageTextUnchanged) {
+		http.Error(w, "Message text is unchanged", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+		return
+	}
+
+	publishParticipantEvent(DB, ch, chat, ch.MessageHandler.MessageEdited(
+		chat.UUID,
+		message.UUID,
+		user.UUID,
+		data.Text,
+		revision.Revision,
+		message.EditedAt.String(),
+		removedMessageUUIDs,
+	))
+
+	enqueued := false
+	if len(bots) > 0 {
+		queueClient, clientErr := util.GetAsynqClient(r)
+		queueInspector, inspectorErr := util.GetAsynqInspector(r)
+		if clientErr != nil || inspectorErr != nil {
+			http.Error(w, "Async queue unavailable", http.StatusInternalServerError)
+			return
+		}
+		for _, bot := range bots {
+			payload := workqueue.BotReplyPayload{
+				ChatUUID:    chat.UUID,
+				MessageUUID: message.UUID,
+				BotUserID:   bot.ID,
+			}
+			var enqueueErr error
+			if isGroupChat(chat) {
+				_, enqueueErr = workqueue.EnqueueGroupBotReply(queueClient, queueInspector, payload)
+			} else {
+				_, enqueueErr = workqueue.EnqueueBotReply(queueClient, queueInspector, payload)
+			}
+			if enqueueErr != nil {
+				http.Error(w, "Failed to schedule bot response", http.StatusInternalServerError)
+				return
+			}
+		}
+		publishBotTyping(DB, ch, chat, bots, true)
+		enqueued = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(EditMessageResponse{
+		Message:             convertMessageToListedMessage(message),
+		Revision:            revision.Revision,
+		RemovedMessageUUIDs: removedMessageUUIDs,
+		Enqueued:            enqueued,
+	})
+}
+
+// ListMessageRevisions lists the text revisions of an edited message.
+//
+//	@Summary      List message revisions
+//	@Description  List every version of a message's text, oldest first. Messages that were never edited have no revisions.
+//	@Tags         messages
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Message UUID"
+//	@Success      200 {array} chats.ListedMessageRevision
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/revisions [get]
+func (h *ChatsHandler) ListMessageRevisions(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	_, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+
+	revisions, err := database.ListMessageRevisions(DB, message.ID)
+	if err != nil {
+		http.Error(w, "Failed to load revisions", http.StatusInternalServerError)
+		return
+	}
+	listed := make([]ListedMessageRevision, len(revisions))
+	for i, revision := range revisions {
+		listed[i] = ListedMessageRevision{
+			Revision:   revision.Revision,
+			Text:       revision.Text,
+			EditorUUID: revision.Editor.UUID,
+			CreatedAt:  revision.CreatedAt.String(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listed)
+}
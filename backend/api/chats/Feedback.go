@@ -0,0 +1,249 @@
+package chats
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+type ReactionResponse struct {
+	MessageUUID string `json:"message_uuid"`
+	Emoji       string `json:"emoji"`
+	// Changed is false when the reaction was already added or removed.
+	Changed   bool                     `json:"changed"`
+	Reactions []database.ReactionCount `json:"reactions"`
+}
+
+type RateMessageRequest struct {
+	// Rating is 1 for thumbs up and -1 for thumbs down.
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type ListedRating struct {
+	MessageUUID string `json:"message_uuid"`
+	Rating      int    `json:"rating"`
+	Comment     string `json:"comment"`
+	Backend     string `json:"backend"`
+	Model       string `json:"model"`
+	RatedAt     string `json:"rated_at"`
+}
+
+// reactionResponse counts the reactions of a message after a change and
+// tells the chat's participants about it.
+func reactionResponse(w http.ResponseWriter, r *http.Request, DB *gorm.DB, chat database.Chat, message database.Message, user *database.User, emoji string, added, changed bool) {
+	counts, err := database.CountMessageReactions(DB, []database.Message{message})
+	if err != nil {
+		http.Error(w, "Failed to count reactions", http.StatusInternalServerError)
+		return
+	}
+	reactions := counts[message.ID]
+	if reactions == nil {
+		reactions = []database.ReactionCount{}
+	}
+
+	if changed {
+		ch, err := util.GetWebsocket(r)
+		if err != nil {
+			http.Error(w, "Unable to get websocket", http.StatusBadRequest)
+			return
+		}
+		count := 0
+		for _, reaction := range reactions {
+			if reaction.Emoji == emoji {
+				count = reaction.Count
+			}
+		}
+		publishParticipantEvent(DB, ch, chat, ch.MessageHandler.MessageReaction(chat.UUID, message.UUID, user.UUID, emoji, added, count))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReactionResponse{
+		MessageUUID: message.UUID,
+		Emoji:       emoji,
+		Changed:     changed,
+		Reactions:   reactions,
+	})
+}
+
+// AddReaction reacts to a message with an emoji.
+//
+//	@Summary      Add a reaction
+//	@Description  React to a message with an emoji or :shortcode:. The chat's participants are notified with a message_reaction event.
+//	@Tags         messages
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Message UUID"
+//	@Param        request body ReactionRequest true "Reaction"
+//	@Success      200 {object} chats.ReactionResponse
+//	@Failure      400 {string} string "Invalid reaction"
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/reactions [post]
+func (h *ChatsHandler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	var data ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	emoji, err := database.NormalizeReaction(data.Emoji)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chat, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+
+	added, err := database.AddMessageReaction(DB, message.ID, user.ID, emoji)
+	if err != nil {
+		http.Error(w, "Failed to add reaction", http.StatusInternalServerError)
+		return
+	}
+	reactionResponse(w, r, DB, chat, message, user, emoji, true, added)
+}
+
+// RemoveReaction removes a reaction of the user from a message.
+//
+//	@Summary      Remove a reaction
+//	@Description  Remove your reaction with an emoji from a message.
+//	@Tags         messages
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Message UUID"
+//	@Param        emoji path string true "Reaction, URL encoded"
+//	@Success      200 {object} chats.ReactionResponse
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/reactions/{emoji} [delete]
+func (h *ChatsHandler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	chat, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+
+	emoji := r.PathValue("emoji")
+	removed, err := database.RemoveMessageReaction(DB, message.ID, user.ID, emoji)
+	if err != nil {
+		http.Error(w, "Failed to remove reaction", http.StatusInternalServerError)
+		return
+	}
+	reactionResponse(w, r, DB, chat, message, user, emoji, false, removed)
+}
+
+// RateMessage rates a bot answer.
+//
+//	@Summary      Rate a bot answer
+//	@Description  Rate a bot answer thumbs up (1) or down (-1) with an optional comment. Rating again replaces your previous rating. The rating is recorded with the bot and model that produced the answer.
+//	@Tags         messages
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Message UUID"
+//	@Param        request body RateMessageRequest true "Rating"
+//	@Success      200 {object} chats.ListedRating
+//	@Failure      400 {string} string "Invalid rating"
+//	@Failure      404 {string} string "Not found"
+//	@Failure      409 {string} string "Not a bot answer"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/rating [put]
+func (h *ChatsHandler) RateMessage(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	var data RateMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	_, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+
+	rating, err := database.RateMessage(DB, message, user.ID, data.Rating, data.Comment)
+	if errors.Is(err, database.ErrInvalidRating) || errors.Is(err, database.ErrRatingCommentLong) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrRatingNotBotAnswer) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to rate message", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListedRating{
+		MessageUUID: message.UUID,
+		Rating:      rating.Rating,
+		Comment:     rating.Comment,
+		Backend:     rating.Backend,
+		Model:       rating.ModelName,
+		RatedAt:     rating.UpdatedAt.String(),
+	})
+}
+
+// DeleteRating withdraws the user's rating of a bot answer.
+//
+//	@Summary      Delete a rating
+//	@Description  Withdraw your rating of a bot answer.
+//	@Tags         messages
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Message UUID"
+//	@Success      204 "No content"
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/rating [delete]
+func (h *ChatsHandler) DeleteRating(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	_, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+
+	result := DB.Unscoped().Where("message_id = ? AND user_id = ?", message.ID, user.ID).Delete(&database.MessageRating{})
+	if result.Error != nil {
+		http.Error(w, "Failed to delete rating", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Rating not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
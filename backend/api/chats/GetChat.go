@@ -67,7 +67,9 @@ func (h *ChatsHandler) GetChat(w http.ResponseWriter, r *http.Request) {
 		Preload("User2").
 		Preload("SharedConfig").
 		Preload("LatestMessage").
-		Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUuid, user.ID, user.ID).
+		Preload("Participants.User").
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUuid).
 		First(&chat)
 
 	if result.Error != nil {
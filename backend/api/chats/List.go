@@ -18,6 +18,12 @@ type ListedChat struct {
 	Config        interface{}       `json:"config"`
 	ChatShareUUID string            `json:"chat_share_uuid,omitempty"`
 	SharedChatURL string            `json:"shared_interaction_url,omitempty"`
+	// Group chat fields; Partner is the creator for group chats.
+	Title            string                     `json:"title,omitempty"`
+	BotTriggerPolicy string                     `json:"bot_trigger_policy,omitempty"`
+	Participants     []database.ChatParticipant `json:"participants,omitempty"`
+	// UnreadCount is set by the chat list.
+	UnreadCount int64 `json:"unread_count,omitempty"`
 }
 
 type ListedChatsPage struct {
@@ -43,13 +49,19 @@ func convertChatToListedChat(user *database.User, chat database.Chat) ListedChat
 		}
 	}
 
-	return ListedChat{
+	listedChat := ListedChat{
 		UUID:          chat.UUID,
 		Partner:       partner,
 		ChatType:      chat.ChatType,
 		LatestMessage: chat.LatestMessage,
 		Config:        config,
 	}
+	if isGroupChat(chat) {
+		listedChat.Title = chat.Title
+		listedChat.BotTriggerPolicy = chat.BotTriggerPolicy
+		listedChat.Participants = chat.Participants
+	}
+	return listedChat
 }
 
 // List returns a list of chats for a specified user.
@@ -62,6 +74,7 @@ func convertChatToListedChat(user *database.User, chat database.Chat) ListedChat
 //	@Param        page  query  int  false  "Page number"  default(1)
 //	@Param        limit query  int  false  "Page size"     default(40)
 //	@Param        chat_types query string false "Chat types to filter by"
+//	@Param        q query string false "Only chats whose title or participant names contain this text"
 //	@Success      200 {object} chats.ListedChatsPage "Paginated list of chats"
 //	@Failure      400 {string} string "Unable to get database or user"
 //	@Failure      500 {string} string "Internal server error"
@@ -90,7 +103,7 @@ func (h *ChatsHandler) List(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build the base query
-	query := DB.Where("user1_id = ? OR user2_id = ?", user.ID, user.ID)
+	query := DB.Scopes(database.ChatParticipantScope(user.ID))
 
 	// Handle chat_types filter
 	if chatTypesParam := r.URL.Query().Get("chat_types"); chatTypesParam != "" {
@@ -128,12 +141,22 @@ func (h *ChatsHandler) List(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Handle the q filter on chat titles and participant names
+	if search := strings.TrimSpace(r.URL.Query().Get("q")); search != "" {
+		pattern := "%" + database.EscapeLikePattern(strings.ToLower(search)) + "%"
+		query = query.Where(
+			`LOWER(title) LIKE ? ESCAPE '\' OR id IN (SELECT cp.chat_id FROM chat_participants cp JOIN users u ON u.id = cp.user_id WHERE cp.user_id <> ? AND cp.deleted_at IS NULL AND LOWER(u.name) LIKE ? ESCAPE '\')`,
+			pattern, user.ID, pattern,
+		)
+	}
+
 	// Apply pagination and preloads
 	q := query.Scopes(database.Paginate(&chats, &pagination, DB)).
 		Preload("User1").
 		Preload("User2").
 		Preload("SharedConfig").
 		Preload("LatestMessage").
+		Preload("Participants.User").
 		Find(&chats)
 
 	if q.Error != nil {
@@ -141,9 +164,20 @@ func (h *ChatsHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	chatIDs := make([]uint, len(chats))
+	for i, chat := range chats {
+		chatIDs[i] = chat.ID
+	}
+	unreadCounts, err := database.CountUnreadMessages(DB, user.ID, chatIDs)
+	if err != nil {
+		http.Error(w, "Failed to count unread messages", http.StatusInternalServerError)
+		return
+	}
+
 	listedChats := make([]ListedChat, len(chats))
 	for i, chat := range chats {
 		listedChats[i] = convertChatToListedChat(user, chat)
+		listedChats[i].UnreadCount = unreadCounts[chat.ID]
 	}
 
 	response := ListedChatsPage{
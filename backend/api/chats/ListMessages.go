@@ -5,7 +5,10 @@ import (
 	"backend/server/util"
 	"encoding/json"
 	"net/http"
+	"slices"
 	"strconv"
+
+	"gorm.io/gorm"
 )
 
 type ListedMessage struct {
@@ -20,6 +23,19 @@ type ListedMessage struct {
 	Reasoning         *[]string               `json:"reasoning"`
 	ToolCalls         *[]interface{}          `json:"tool_calls"`
 	MetaData          *map[string]interface{} `json:"meta_data"`
+	Edited            bool                    `json:"edited"`
+	EditedAt          string                  `json:"edited_at,omitempty"`
+	// ReadAt is set once the receiver of a two-party chat read the message.
+	ReadAt string `json:"read_at,omitempty"`
+	// BranchCount is the number of alternative branches at this message,
+	// set when there is more than one.
+	BranchCount int `json:"branch_count,omitempty"`
+	// ReplyToUUID is the message this message replies to, ReplyCount the
+	// number of replies to this message.
+	ReplyToUUID string `json:"reply_to_uuid,omitempty"`
+	ReplyCount  int    `json:"reply_count,omitempty"`
+	// Reactions counts the users per emoji, in the order emojis were first used.
+	Reactions []database.ReactionCount `json:"reactions,omitempty"`
 }
 
 type ListedMessagesPage struct {
@@ -27,6 +43,10 @@ type ListedMessagesPage struct {
 	Page       int             `json:"page"`
 	TotalPages int             `json:"total_pages"`
 	Rows       []ListedMessage `json:"rows"`
+	// HasMore and NextCursor are set when listing by cursor, NextCursor is
+	// passed as the same before/after parameter to continue listing.
+	HasMore    bool   `json:"has_more,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 func convertMessageToListedMessage(message database.Message) ListedMessage {
@@ -47,6 +67,15 @@ func convertMessageToListedMessage(message database.Message) ListedMessage {
 		}
 	}
 
+	editedAt := ""
+	if message.EditedAt != nil {
+		editedAt = message.EditedAt.String()
+	}
+	readAt := ""
+	if message.ReadAt != nil {
+		readAt = message.ReadAt.String()
+	}
+
 	return ListedMessage{
 		UUID:              message.UUID,
 		SendAt:            message.CreatedAt.String(),
@@ -59,10 +88,46 @@ func convertMessageToListedMessage(message database.Message) ListedMessage {
 		Reasoning:         message.Reasoning,
 		ToolCalls:         &toolCalls,
 		MetaData:          &messageMetaData,
+		Edited:            message.EditedAt != nil,
+		EditedAt:          editedAt,
+		ReadAt:            readAt,
 	}
 }
 
+// convertChatMessages converts messages of a chat and sets how many branches
+// there are at each of them, what they reply to, how many replies they have and
+// their reactions.
+func convertChatMessages(DB *gorm.DB, chatID uint, messages []database.Message) ([]ListedMessage, error) {
+	branchCounts, err := database.CountMessageBranches(DB, chatID, messages)
+	if err != nil {
+		return nil, err
+	}
+	replyToUUIDs, err := database.ReplyToUUIDs(DB, messages)
+	if err != nil {
+		return nil, err
+	}
+	replyCounts, err := database.CountMessageReplies(DB, messages)
+	if err != nil {
+		return nil, err
+	}
+	reactions, err := database.CountMessageReactions(DB, messages)
+	if err != nil {
+		return nil, err
+	}
+	listedMessages := make([]ListedMessage, len(messages))
+	for i, message := range messages {
+		listedMessages[i] = convertMessageToListedMessage(message)
+		listedMessages[i].BranchCount = branchCounts[message.ID]
+		listedMessages[i].ReplyToUUID = replyToUUIDs[message.ID]
+		listedMessages[i].ReplyCount = replyCounts[message.ID]
+		listedMessages[i].Reactions = reactions[message.ID]
+	}
+	return listedMessages, nil
+}
+
 // List returns a list of messages for a specified chat ( owned by the user).
+// With the before or after cursor, messages are listed by keyset instead of
+// by page, which stays stable while new messages arrive.
 //
 //	@Summary      Get Chat messages
 //	@Description  Retrieve a list of messages associated with a specific chat UUID
@@ -71,6 +136,8 @@ func convertMessageToListedMessage(message database.Message) ListedMessage {
 //	@Produce      json
 //	@Param        page  query  int  false  "Page number"  default(1)
 //	@Param        limit query  int  false  "Page size"     default(10)
+//	@Param        before query string false "Only messages older than this message UUID"
+//	@Param        after  query string false "Only messages newer than this message UUID"
 //	@Param        chat_uuid path string true "Chat UUID"
 //	@Success      200 {object} chats.ListedMessagesPage "Paginated list of messages"
 //	@Failure      400 {string} string "Invalid user ID"
@@ -108,7 +175,8 @@ func (h *ChatsHandler) ListMessages(w http.ResponseWriter, r *http.Request) {
 	var chat database.Chat
 	result := DB.Preload("User1").
 		Preload("User2").
-		Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUuid, user.ID, user.ID).
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUuid).
 		First(&chat)
 
 	if result.Error != nil {
@@ -116,9 +184,18 @@ func (h *ChatsHandler) ListMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Now list the messages paginated
-	result = DB.Scopes(database.Paginate(&messages, &pagination, DB)).
-		Where("chat_id = ? AND (receiver_id = ? OR sender_id = ?)", chat.ID, user.ID, user.ID).
+	// Now list the messages of the active branch paginated, group members see every message in the chat
+	query := DB.Where("chat_id = ? AND inactive = ?", chat.ID, false)
+	if !isGroupChat(chat) {
+		query = query.Where("receiver_id = ? OR sender_id = ?", user.ID, user.ID)
+	}
+
+	before, after := r.URL.Query().Get("before"), r.URL.Query().Get("after")
+	if before != "" || after != "" {
+		listMessagesByCursor(w, DB, query, chat, before, after, pagination.Limit)
+		return
+	}
+	result = query.Scopes(database.Paginate(&messages, &pagination, DB)).
 		Where("deleted_at IS NULL").
 		Preload("Sender").
 		Find(&messages)
@@ -128,9 +205,10 @@ func (h *ChatsHandler) ListMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	listedMessages := make([]ListedMessage, len(messages))
-	for i, message := range messages {
-		listedMessages[i] = convertMessageToListedMessage(message)
+	listedMessages, err := convertChatMessages(DB, chat.ID, messages)
+	if err != nil {
+		http.Error(w, "Couldn't load message details", http.StatusInternalServerError)
+		return
 	}
 
 	response := ListedMessagesPage{
@@ -144,3 +222,59 @@ func (h *ChatsHandler) ListMessages(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 
 }
+
+// listMessagesByCursor lists up to limit messages right before or after the
+// cursor message, newest first like the paginated listing.
+func listMessagesByCursor(w http.ResponseWriter, DB *gorm.DB, query *gorm.DB, chat database.Chat, before, after string, limit int) {
+	if before != "" && after != "" {
+		http.Error(w, "Only one of before and after can be set", http.StatusBadRequest)
+		return
+	}
+	cursorUUID := before
+	if after != "" {
+		cursorUUID = after
+	}
+
+	// Deleted messages remain valid cursors
+	var cursor database.Message
+	if err := DB.Unscoped().Select("id").Where("chat_id = ? AND uuid = ?", chat.ID, cursorUUID).First(&cursor).Error; err != nil {
+		http.Error(w, "Invalid message cursor", http.StatusBadRequest)
+		return
+	}
+
+	if before != "" {
+		query = query.Where("id < ?", cursor.ID).Order("id desc")
+	} else {
+		query = query.Where("id > ?", cursor.ID).Order("id asc")
+	}
+
+	var messages []database.Message
+	if err := query.Where("deleted_at IS NULL").
+		Limit(limit + 1).
+		Preload("Sender").
+		Find(&messages).Error; err != nil {
+		http.Error(w, "Couldn't find messages", http.StatusBadRequest)
+		return
+	}
+
+	response := ListedMessagesPage{Limit: limit}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		response.HasMore = true
+	}
+	if len(messages) > 0 {
+		response.NextCursor = messages[len(messages)-1].UUID
+	}
+	if after != "" {
+		slices.Reverse(messages)
+	}
+	rows, err := convertChatMessages(DB, chat.ID, messages)
+	if err != nil {
+		http.Error(w, "Couldn't load message details", http.StatusInternalServerError)
+		return
+	}
+	response.Rows = rows
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
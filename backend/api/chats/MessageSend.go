@@ -6,12 +6,14 @@ import (
 	"backend/server/util"
 	"backend/workqueue"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/hibiken/asynq"
 	"gorm.io/gorm"
 )
 
@@ -23,6 +25,8 @@ type SendMessage struct {
 	ToolInit    map[string]interface{}  `json:"tool_init,omitempty"`
 	ToolCalls   *[]interface{}          `json:"tool_calls,omitempty"`
 	Attachments *[]FileAttachment       `json:"attachments,omitempty"`
+	// ReplyTo is the UUID of an earlier message of the chat to reply to
+	ReplyTo string `json:"reply_to,omitempty"`
 }
 
 type SendMessageWithReasoning struct {
@@ -137,36 +141,97 @@ func (h *ChatsHandler) MessageSend(w http.ResponseWriter, r *http.Request) {
 		Preload("User2").
 		Preload("SharedConfig").
 		Preload("LatestMessage").
-		Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUuid, user.ID, user.ID).
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUuid).
 		First(&chat)
 
 	if result.Error != nil {
 		http.Error(w, "Invalid chat UUID", http.StatusBadRequest)
+		return
 	}
 
-	var receiverId uint
-	var receiver database.User
-	if chat.User1.ID == user.ID {
-		receiverId = chat.User2.ID
-		receiver = chat.User2
-	} else {
-		receiverId = chat.User1.ID
-		receiver = chat.User1
+	// Bots reply through the queue, SendChatMessage fails only when one is addressed without it
+	queueClient, _ := util.GetAsynqClient(r)
+	queueInspector, _ := util.GetAsynqInspector(r)
+	listedMessage, err := SendChatMessage(DB, ch, queueClient, queueInspector, chat, *user, data)
+	if err != nil {
+		writeSendMessageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listedMessage)
+}
+
+// SendMessageError rejects a message, Status is the HTTP status to answer with.
+type SendMessageError struct {
+	Status  int
+	Message string
+}
+
+func (e *SendMessageError) Error() string {
+	return e.Message
+}
+
+// findAttachableFile loads a file the user may attach to a message. Attaching
+// shares the file with the receivers, so it takes the owner or an edit grant.
+func findAttachableFile(DB *gorm.DB, fileID string, userID uint) (*database.UploadedFile, error) {
+	uploadedFile, permission, err := database.FindAccessibleFile(DB, fileID, userID)
+	if errors.Is(err, database.ErrFileAccessDenied) || (err == nil && !database.CanShareFile(permission)) {
+		return nil, &SendMessageError{Status: http.StatusForbidden, Message: "Access denied to file attachment"}
+	} else if err != nil {
+		return nil, &SendMessageError{Status: http.StatusBadRequest, Message: "Invalid file attachment"}
+	}
+	return uploadedFile, nil
+}
+
+func writeSendMessageError(w http.ResponseWriter, err error) {
+	var sendErr *SendMessageError
+	if errors.As(err, &sendErr) {
+		http.Error(w, sendErr.Message, sendErr.Status)
+		return
+	}
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// SendChatMessage stores a message of user in chat, shares its attachments
+// with the receivers and either publishes it to them or enqueues the replies
+// of the addressed bots. Rejected messages return a *SendMessageError.
+func SendChatMessage(DB *gorm.DB, ch *wsapi.WebSocketHandler, queueClient *asynq.Client, queueInspector *asynq.Inspector, chat database.Chat, user database.User, data SendMessage) (ListedMessage, error) {
+	chatUuid := chat.UUID
+	receivers, bots, err := resolveMessageRecipients(DB, chat, user, data.Text)
+	if err != nil {
+		return ListedMessage{}, &SendMessageError{Status: http.StatusInternalServerError, Message: "Failed to resolve chat participants"}
 	}
 
 	var message database.Message = database.Message{
 		ChatId:     chat.ID,
 		SenderId:   user.ID,
-		ReceiverId: receiverId,
+		ReceiverId: primaryReceiverID(user, receivers, bots),
 		Text:       &data.Text,
 	}
 
+	// Replies quote the message they reply to in their meta data
+	if data.ReplyTo != "" {
+		replyTo, err := database.FindReplyTarget(DB, chat.ID, data.ReplyTo)
+		if errors.Is(err, database.ErrReplyToNotFound) {
+			return ListedMessage{}, &SendMessageError{Status: http.StatusBadRequest, Message: "Replied to message not found"}
+		}
+		if err != nil {
+			return ListedMessage{}, &SendMessageError{Status: http.StatusInternalServerError, Message: "Failed to load replied to message"}
+		}
+		message.ReplyToId = &replyTo.ID
+		if data.MetaData == nil {
+			data.MetaData = &map[string]interface{}{}
+		}
+		(*data.MetaData)[database.ReplyToMetaKey] = database.QuoteMessage(*replyTo)
+	}
+
 	var effectiveToolInit map[string]interface{}
 	if data.ToolInit != nil {
 		effectiveToolInit, err = applyMessageToolInitUpdate(DB, &chat, data.ToolInit)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid tool_init update: %v", err), http.StatusBadRequest)
-			return
+			return ListedMessage{}, &SendMessageError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid tool_init update: %v", err)}
 		}
 	}
 
@@ -185,39 +250,22 @@ func (h *ChatsHandler) MessageSend(w http.ResponseWriter, r *http.Request) {
 
 	// Handle file attachments
 	if data.Attachments != nil {
-		// Validate that all attachments belong to the user and enrich with file details
+		// Validate that the user can access all attachments and enrich with file details
 		enrichedAttachments := make([]FileAttachment, len(*data.Attachments))
 		for i, attachment := range *data.Attachments {
-			var uploadedFile database.UploadedFile
-			if err := DB.Where("file_id = ?", attachment.FileID).First(&uploadedFile).Error; err != nil {
-				http.Error(w, "Invalid file attachment", http.StatusBadRequest)
-				return
+			uploadedFile, err := findAttachableFile(DB, attachment.FileID, user.ID)
+			if err != nil {
+				return ListedMessage{}, err
 			}
 
-			if uploadedFile.OwnerID != user.ID {
-				http.Error(w, "Access denied to file attachment", http.StatusForbidden)
-				return
+			// Share the file with every receiver
+			receiverIDs := make([]uint, 0, len(receivers))
+			for _, receiver := range receivers {
+				receiverIDs = append(receiverIDs, receiver.ID)
 			}
-
-			// Share the file with the receiver
-			var existingAccess database.FileAccess
-			result := DB.Where("user_id = ? AND uploaded_file_id = ?", receiverId, uploadedFile.ID).First(&existingAccess)
-			if result.Error != nil {
-				// File access doesn't exist, create it
-				fileAccess := database.FileAccess{
-					UserID:         receiverId,
-					UploadedFileID: uploadedFile.ID,
-					Permission:     "view",
-					CreatedAt:      time.Now(),
-				}
-				if err := DB.Create(&fileAccess).Error; err != nil {
-					log.Printf("Error sharing file %s (ID: %d) with user %d: %v", attachment.FileID, uploadedFile.ID, receiverId, err)
-					// Don't fail the message send if file sharing fails
-				} else {
-					log.Printf("Successfully shared file %s (ID: %d) with user %d", attachment.FileID, uploadedFile.ID, receiverId)
-				}
-			} else {
-				log.Printf("File %s (ID: %d) already shared with user %d", attachment.FileID, uploadedFile.ID, receiverId)
+			if err := database.GrantFileViewAccess(DB, uploadedFile, receiverIDs, time.Now()); err != nil {
+				log.Printf("Error sharing file %s (ID: %d) with the receivers: %v", attachment.FileID, uploadedFile.ID, err)
+				// Don't fail the message send if file sharing fails
 			}
 
 			// Enrich attachment with file details
@@ -296,39 +344,48 @@ func (h *ChatsHandler) MessageSend(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return ListedMessage{}, &SendMessageError{Status: http.StatusInternalServerError, Message: "Internal server error"}
 	}
 
-	isAutomatedReceiver := isAutomatedUserFromDB(DB, receiver)
-	if isAutomatedReceiver {
-		queueClient, clientErr := util.GetAsynqClient(r)
-		if clientErr != nil {
-			http.Error(w, "Async queue unavailable", http.StatusInternalServerError)
-			return
-		}
+	if isGroupChat(chat) {
+		// Group members all get the message; only mentioned bots (or all, per policy) reply.
+		SendWebsocketMessageToMany(ch, humanReceiverUUIDs(receivers), chatUuid, user, data)
+		if len(bots) > 0 {
+			if queueClient == nil || queueInspector == nil {
+				return ListedMessage{}, &SendMessageError{Status: http.StatusInternalServerError, Message: "Async queue unavailable"}
+			}
 
-		queueInspector, inspectorErr := util.GetAsynqInspector(r)
-		if inspectorErr != nil {
-			http.Error(w, "Async queue unavailable", http.StatusInternalServerError)
-			return
+			for _, bot := range bots {
+				if _, enqueueErr := workqueue.EnqueueGroupBotReply(queueClient, queueInspector, workqueue.BotReplyPayload{
+					ChatUUID:    chatUuid,
+					MessageUUID: message.UUID,
+					BotUserID:   bot.ID,
+				}); enqueueErr != nil {
+					return ListedMessage{}, &SendMessageError{Status: http.StatusInternalServerError, Message: "Failed to schedule bot response"}
+				}
+			}
+			publishBotTyping(DB, ch, chat, bots, true)
+		}
+	} else if len(bots) > 0 {
+		if queueClient == nil || queueInspector == nil {
+			return ListedMessage{}, &SendMessageError{Status: http.StatusInternalServerError, Message: "Async queue unavailable"}
 		}
 
 		if _, enqueueErr := workqueue.EnqueueBotReply(queueClient, queueInspector, workqueue.BotReplyPayload{
 			ChatUUID:    chatUuid,
 			MessageUUID: message.UUID,
-			BotUserID:   receiver.ID,
+			BotUserID:   bots[0].ID,
 		}); enqueueErr != nil {
-			http.Error(w, "Failed to schedule bot response", http.StatusInternalServerError)
-			return
+			return ListedMessage{}, &SendMessageError{Status: http.StatusInternalServerError, Message: "Failed to schedule bot response"}
 		}
+		publishBotTyping(DB, ch, chat, bots[:1], true)
 	} else {
 		// For human receivers, continue to publish websocket updates immediately.
-		SendWebsocketMessage(ch, receiver.UUID, chatUuid, *user, data)
+		for _, receiver := range receivers {
+			SendWebsocketMessage(ch, receiver.UUID, chatUuid, user, data)
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-
 	// Convert the message to ListedMessage format to include metadata
 	messageMetaData := map[string]interface{}{}
 	if message.MetaData != nil {
@@ -358,8 +415,10 @@ func (h *ChatsHandler) MessageSend(w http.ResponseWriter, r *http.Request) {
 		ToolCalls:  &toolCalls,
 		MetaData:   &messageMetaData,
 	}
+	listedMessage.ReplyToUUID = data.ReplyTo
+
+	return listedMessage, nil
 
-	json.NewEncoder(w).Encode(listedMessage)
 }
 
 func isAutomatedUserFromDB(DB *gorm.DB, receiver database.User) bool {
@@ -508,48 +567,116 @@ func (h *ChatsHandler) SignalSendMessage(w http.ResponseWriter, r *http.Request)
 	}
 
 	var chat database.Chat
-	result := DB.Preload("User1").
-		Preload("User2").
-		Preload("LatestMessage").
-		Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUuid, user.ID, user.ID).
+	result := DB.Preload("LatestMessage").
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUuid).
 		First(&chat)
 
 	if result.Error != nil {
 		http.Error(w, "Invalid chat UUID", http.StatusBadRequest)
+		return
 	}
 
-	var receiver database.User
-	if chat.User1.ID == user.ID {
-		receiver = chat.User2
-	} else {
-		receiver = chat.User1
+	// Typing indicators are relayed to the chat's participants without being stored
+	if signal == "typing" || signal == "stop_typing" {
+		publishParticipantEvent(DB, ch, chat, ch.MessageHandler.Typing(chatUuid, user.UUID, signal == "typing", database.HumanTypingTimeout))
+		return
 	}
 
-	if signal == "interrupt" {
-		if receiver.IsAutomated {
+	if signal == "interrupt" && isGroupChat(chat) {
+		bots, botsErr := chatBotParticipants(DB, chat)
+		if botsErr != nil {
+			http.Error(w, "Failed to resolve chat participants", http.StatusInternalServerError)
+			return
+		}
+		if len(bots) > 0 {
 			queueInspector, inspectorErr := util.GetAsynqInspector(r)
 			if inspectorErr != nil {
 				http.Error(w, "Async queue unavailable", http.StatusInternalServerError)
 				return
 			}
-			workqueue.CancelBotReplyTask(queueInspector, chatUuid)
-		} else {
-			ch.MessageHandler.SendMessage(
-				ch,
-				receiver.UUID,
-				ch.MessageHandler.InterruptSignal(
-					chatUuid,
-					user.UUID,
-				),
-			)
+			workqueue.CancelGroupBotReplyTasks(queueInspector, chatUuid, botUserIDs(bots))
+			cancelInFlightBotReplies(r, chatUuid)
+			publishBotTyping(DB, ch, chat, bots, false)
 		}
-	} else {
+		receivers, _, receiversErr := resolveMessageRecipients(DB, chat, *user, "")
+		if receiversErr != nil {
+			http.Error(w, "Failed to resolve chat participants", http.StatusInternalServerError)
+			return
+		}
+		ch.MessageHandler.SendMessageToMany(
+			ch,
+			humanReceiverUUIDs(receivers),
+			ch.MessageHandler.InterruptSignal(
+				chatUuid,
+				user.UUID,
+			),
+		)
+		return
+	}
+
+	if signal != "interrupt" {
 		http.Error(w, "Invalid signal", http.StatusBadRequest)
 		return
 	}
+
+	// The other party of a two-party chat is its remaining participant
+	receivers, bots, receiversErr := resolveMessageRecipients(DB, chat, *user, "")
+	if receiversErr != nil {
+		http.Error(w, "Failed to resolve chat participants", http.StatusInternalServerError)
+		return
+	}
+	if len(bots) > 0 {
+		queueInspector, inspectorErr := util.GetAsynqInspector(r)
+		if inspectorErr != nil {
+			http.Error(w, "Async queue unavailable", http.StatusInternalServerError)
+			return
+		}
+		workqueue.CancelBotReplyTask(queueInspector, chatUuid)
+		cancelInFlightBotReplies(r, chatUuid)
+		publishBotTyping(DB, ch, chat, bots, false)
+		return
+	}
+	ch.MessageHandler.SendMessageToMany(
+		ch,
+		humanReceiverUUIDs(receivers),
+		ch.MessageHandler.InterruptSignal(
+			chatUuid,
+			user.UUID,
+		),
+	)
+}
+
+// cancelInFlightBotReplies stops replies in the chat that are already streaming,
+// on whichever server or worker process is generating them.
+func cancelInFlightBotReplies(r *http.Request, chatUuid string) {
+	replyCanceler, err := util.GetReplyCanceler(r)
+	if err != nil {
+		return
+	}
+	if err := replyCanceler.Cancel(r.Context(), chatUuid); err != nil {
+		log.Printf("Failed to broadcast bot reply cancellation for chat %s: %v", chatUuid, err)
+	}
 }
 
 func SendWebsocketMessage(ch *wsapi.WebSocketHandler, receiverId string, chatUuid string, user database.User, data MessageData) {
+	ch.MessageHandler.SendMessage(
+		ch,
+		receiverId,
+		newWebsocketMessage(ch, chatUuid, user, data),
+	)
+}
+
+// SendWebsocketMessageToMany delivers a new message to several receivers, e.g. all members of a group chat.
+func SendWebsocketMessageToMany(ch *wsapi.WebSocketHandler, receiverIds []string, chatUuid string, user database.User, data MessageData) {
+	ch.MessageHandler.SendMessageToMany(
+		ch,
+		receiverIds,
+		newWebsocketMessage(ch, chatUuid, user, data),
+	)
+}
+
+func newWebsocketMessage(ch *wsapi.WebSocketHandler, chatUuid string, user database.User, data MessageData) []byte {
 	// Convert attachments to websocket format
 	var wsAttachments *[]wsapi.FileAttachment
 	if data.GetAttachments() != nil {
@@ -566,17 +693,13 @@ func SendWebsocketMessage(ch *wsapi.WebSocketHandler, receiverId string, chatUui
 		wsAttachments = &attachments
 	}
 
-	ch.MessageHandler.SendMessage(
-		ch,
-		receiverId,
-		ch.MessageHandler.NewMessage(
-			chatUuid,
-			user.UUID,
-			data.GetText(),
-			data.GetReasoning(),
-			data.GetMetaData(),
-			data.GetToolCalls(),
-			wsAttachments,
-		),
+	return ch.MessageHandler.NewMessage(
+		chatUuid,
+		user.UUID,
+		data.GetText(),
+		data.GetReasoning(),
+		data.GetMetaData(),
+		data.GetToolCalls(),
+		wsAttachments,
 	)
 }
@@ -0,0 +1,592 @@
+package chats
+
+import (
+	wsapi "backend/api/websocket"
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type CreateGroupChat struct {
+	Title            string                 `json:"title"`
+	ContactTokens    []string               `json:"contact_tokens"`
+	BotTriggerPolicy string                 `json:"bot_trigger_policy,omitempty"`
+	SharedConfig     map[string]interface{} `json:"shared_config,omitempty"`
+}
+
+type JoinChat struct {
+	ContactToken string `json:"contact_token"`
+}
+
+type UpdateBotTriggerPolicy struct {
+	BotTriggerPolicy string `json:"bot_trigger_policy"`
+}
+
+type ListedParticipants struct {
+	ChatUUID         string                     `json:"chat_uuid"`
+	BotTriggerPolicy string                     `json:"bot_trigger_policy"`
+	Rows             []database.ChatParticipant `json:"rows"`
+}
+
+var mentionPattern = regexp.MustCompile(`@([\p{L}\p{N}_.\-]+)`)
+
+func isGroupChat(chat database.Chat) bool {
+	return chat.ChatType == database.ChatTypeGroup
+}
+
+// isMentioned reports whether text contains an @-mention of the user's username or name.
+func isMentioned(text string, user database.User) bool {
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		handle := strings.TrimRight(match[1], ".-")
+		if handle == "" {
+			continue
+		}
+		if strings.EqualFold(handle, user.Username) || strings.EqualFold(handle, user.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveMessageRecipients returns every participant a message from sender should be
+// delivered to and the bots among them that should reply. Two-party chats keep the
+// original behavior of always triggering an automated counterparty; group chats only
+// trigger bots on a human message, either by @-mention or by the chat's trigger policy.
+func resolveMessageRecipients(DB *gorm.DB, chat database.Chat, sender database.User, text string) ([]database.User, []database.User, error) {
+	participants, err := database.ListChatParticipants(DB, chat.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	receivers := []database.User{}
+	for _, participant := range participants {
+		if participant.UserId == sender.ID {
+			continue
+		}
+		receivers = append(receivers, participant.User)
+	}
+
+	bots := []database.User{}
+	if !isGroupChat(chat) {
+		for _, receiver := range receivers {
+			if isAutomatedUserFromDB(DB, receiver) {
+				bots = append(bots, receiver)
+			}
+		}
+		return receivers, bots, nil
+	}
+
+	if sender.IsAutomated {
+		return receivers, bots, nil
+	}
+	for _, receiver := range receivers {
+		if !isAutomatedUserFromDB(DB, receiver) {
+			continue
+		}
+		if chat.BotTriggerPolicy == database.ChatBotTriggerAlways || isMentioned(text, receiver) {
+			bots = append(bots, receiver)
+		}
+	}
+	return receivers, bots, nil
+}
+
+// primaryReceiverID picks the ReceiverId stored on a message. Group messages are
+// addressed to the first triggered bot, or the first other participant otherwise.
+func primaryReceiverID(sender database.User, receivers []database.User, bots []database.User) uint {
+	if len(bots) > 0 {
+		return bots[0].ID
+	}
+	if len(receivers) > 0 {
+		return receivers[0].ID
+	}
+	return sender.ID
+}
+
+func humanReceiverUUIDs(receivers []database.User) []string {
+	uuids := []string{}
+	for _, receiver := range receivers {
+		if receiver.IsAutomated {
+			continue
+		}
+		uuids = append(uuids, receiver.UUID)
+	}
+	return uuids
+}
+
+// HumanRecipientUUIDs returns the human participants a message of sender in
+// the chat is delivered to, resolved like SendChatMessage does.
+func HumanRecipientUUIDs(DB *gorm.DB, chatUUID string, sender database.User) ([]string, error) {
+	var chat database.Chat
+	if err := DB.Where("uuid = ?", chatUUID).First(&chat).Error; err != nil {
+		return nil, err
+	}
+	receivers, _, err := resolveMessageRecipients(DB, chat, sender, "")
+	if err != nil {
+		return nil, err
+	}
+	return humanReceiverUUIDs(receivers), nil
+}
+
+func botUserIDs(bots []database.User) []uint {
+	ids := make([]uint, len(bots))
+	for i, bot := range bots {
+		ids[i] = bot.ID
+	}
+	return ids
+}
+
+func chatBotParticipants(DB *gorm.DB, chat database.Chat) ([]database.User, error) {
+	participants, err := database.ListChatParticipants(DB, chat.ID)
+	if err != nil {
+		return nil, err
+	}
+	bots := []database.User{}
+	for _, participant := range participants {
+		if participant.User.IsAutomated {
+			bots = append(bots, participant.User)
+		}
+	}
+	return bots, nil
+}
+
+func publishParticipantEvent(DB *gorm.DB, ch *wsapi.WebSocketHandler, chat database.Chat, encMessage []byte, extraReceivers ...database.User) {
+	participants, err := database.ListChatParticipants(DB, chat.ID)
+	if err != nil {
+		log.Printf("warning: failed to load participants for chat %s: %v", chat.UUID, err)
+		return
+	}
+	receivers := make([]database.User, 0, len(participants)+len(extraReceivers))
+	for _, participant := range participants {
+		receivers = append(receivers, participant.User)
+	}
+	receivers = append(receivers, extraReceivers...)
+	ch.MessageHandler.SendMessageToMany(ch, humanReceiverUUIDs(receivers), encMessage)
+}
+
+func findParticipantGroupChat(w http.ResponseWriter, DB *gorm.DB, user *database.User, chatUUID string) (database.Chat, *database.ChatParticipant, bool) {
+	var chat database.Chat
+	if err := DB.Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUUID).
+		First(&chat).Error; err != nil {
+		http.Error(w, "Chat not found", http.StatusNotFound)
+		return chat, nil, false
+	}
+	if !isGroupChat(chat) {
+		http.Error(w, "Participants can only be managed in group chats", http.StatusConflict)
+		return chat, nil, false
+	}
+	participant, err := database.GetChatParticipant(DB, chat.ID, user.ID)
+	if err != nil {
+		http.Error(w, "Chat not found", http.StatusNotFound)
+		return chat, nil, false
+	}
+	return chat, participant, true
+}
+
+// CreateGroup creates a group chat with several humans and bots.
+//
+//	@Summary      Create a group chat
+//	@Description  Create a chat with multiple participants. The creator becomes the owner. Bots reply on @-mention or, with policy "always", to every human message.
+//	@Tags         chats
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        request body CreateGroupChat true "Group chat creation request"
+//	@Success      200  {object}  ListedChat
+//	@Failure      400  {string}  string "Invalid request"
+//	@Router       /api/v1/chats/groups/create [post]
+func (h *ChatsHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	ch, err := util.GetWebsocket(r)
+	if err != nil {
+		http.Error(w, "Unable to get websocket", http.StatusBadRequest)
+		return
+	}
+
+	var data CreateGroupChat
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	policy := database.ChatBotTriggerMention
+	if data.BotTriggerPolicy != "" {
+		policy = database.NormalizeChatBotTriggerPolicy(data.BotTriggerPolicy)
+		if policy == "" {
+			http.Error(w, "Invalid bot_trigger_policy", http.StatusBadRequest)
+			return
+		}
+	}
+
+	members := []database.User{}
+	for _, contactToken := range data.ContactTokens {
+		var member database.User
+		if err := DB.First(&member, "contact_token = ?", strings.TrimSpace(contactToken)).Error; err != nil {
+			http.Error(w, "Invalid contact token", http.StatusBadRequest)
+			return
+		}
+		if member.ID == user.ID {
+			continue
+		}
+		members = append(members, member)
+	}
+
+	var sharedConfigOwner database.User
+	for _, member := range members {
+		if member.IsAutomated {
+			sharedConfigOwner = member
+			break
+		}
+	}
+	resolvedSharedConfig, err := resolveSharedConfigForChat(DB, sharedConfigOwner, data.SharedConfig)
+	if err != nil {
+		http.Error(w, "Failed to resolve shared_config", http.StatusInternalServerError)
+		return
+	}
+
+	// Group chats keep the creator in both legacy user columns; membership lives in chat_participants.
+	chat := database.Chat{
+		User1Id:          user.ID,
+		User2Id:          user.ID,
+		ChatType:         database.ChatTypeGroup,
+		Title:            strings.TrimSpace(data.Title),
+		BotTriggerPolicy: policy,
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&chat).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&database.ChatParticipant{}).
+			Where("chat_id = ? AND user_id = ?", chat.ID, user.ID).
+			Update("role", database.ChatParticipantRoleOwner).Error; err != nil {
+			return err
+		}
+		for _, member := range members {
+			if err := database.EnsureChatParticipant(tx, chat.ID, member.ID, database.ChatParticipantRoleMember); err != nil {
+				return err
+			}
+		}
+		if resolvedSharedConfig != nil {
+			configData, err := json.Marshal(resolvedSharedConfig)
+			if err != nil {
+				return err
+			}
+			sharedConfig := database.SharedChatConfig{
+				ChatId:     chat.ID,
+				ConfigData: configData,
+			}
+			if err := tx.Create(&sharedConfig).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&chat).Update("shared_config_id", sharedConfig.ID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to create group chat", http.StatusInternalServerError)
+		return
+	}
+
+	for _, member := range members {
+		publishParticipantEvent(DB, ch, chat, ch.MessageHandler.ParticipantJoined(chat.UUID, member.UUID, user.UUID))
+	}
+
+	DB.Preload("User1").Preload("User2").Preload("SharedConfig").Preload("LatestMessage").Preload("Participants.User").First(&chat, chat.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(convertChatToListedChat(user, chat))
+}
+
+// ListParticipants returns the members of a chat.
+//
+//	@Summary      List chat participants
+//	@Tags         chats
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Success      200  {object}  ListedParticipants
+//	@Failure      404  {string}  string "Chat not found"
+//	@Router       /api/v1/chats/{chat_uuid}/participants [get]
+func (h *ChatsHandler) ListParticipants(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	var chat database.Chat
+	if err := DB.Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", r.PathValue("chat_uuid")).
+		First(&chat).Error; err != nil {
+		http.Error(w, "Chat not found", http.StatusNotFound)
+		return
+	}
+
+	participants, err := database.ListChatParticipants(DB, chat.ID)
+	if err != nil {
+		http.Error(w, "Failed to load participants", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListedParticipants{
+		ChatUUID:         chat.UUID,
+		BotTriggerPolicy: chat.BotTriggerPolicy,
+		Rows:             participants,
+	})
+}
+
+// JoinChat adds a user or bot to a group chat.
+//
+//	@Summary      Add a participant
+//	@Description  Any participant of a group chat can add another user or bot by contact token.
+//	@Tags         chats
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        request body JoinChat true "Participant to add"
+//	@Success      200  {object}  database.ChatParticipant
+//	@Failure      400  {string}  string "Invalid contact token"
+//	@Failure      404  {string}  string "Chat not found"
+//	@Failure      409  {string}  string "Not a group chat"
+//	@Router       /api/v1/chats/{chat_uuid}/participants/join [post]
+func (h *ChatsHandler) JoinChat(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	ch, err := util.GetWebsocket(r)
+	if err != nil {
+		http.Error(w, "Unable to get websocket", http.StatusBadRequest)
+		return
+	}
+
+	var data JoinChat
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	chat, _, ok := findParticipantGroupChat(w, DB, user, r.PathValue("chat_uuid"))
+	if !ok {
+		return
+	}
+
+	var member database.User
+	if err := DB.First(&member, "contact_token = ?", strings.TrimSpace(data.ContactToken)).Error; err != nil {
+		http.Error(w, "Invalid contact token", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.EnsureChatParticipant(DB, chat.ID, member.ID, database.ChatParticipantRoleMember); err != nil {
+		http.Error(w, "Failed to add participant", http.StatusInternalServerError)
+		return
+	}
+
+	participant, err := database.GetChatParticipant(DB, chat.ID, member.ID)
+	if err != nil {
+		http.Error(w, "Failed to add participant", http.StatusInternalServerError)
+		return
+	}
+
+	publishParticipantEvent(DB, ch, chat, ch.MessageHandler.ParticipantJoined(chat.UUID, member.UUID, user.UUID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(participant)
+}
+
+// LeaveChat removes the authenticated user from a group chat.
+//
+//	@Summary      Leave a group chat
+//	@Description  Leave a group chat. If the owner leaves, ownership passes to the longest-standing human participant.
+//	@Tags         chats
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Success      200  {string}  string "Left chat"
+//	@Failure      404  {string}  string "Chat not found"
+//	@Failure      409  {string}  string "Not a group chat"
+//	@Router       /api/v1/chats/{chat_uuid}/participants/leave [post]
+func (h *ChatsHandler) LeaveChat(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	ch, err := util.GetWebsocket(r)
+	if err != nil {
+		http.Error(w, "Unable to get websocket", http.StatusBadRequest)
+		return
+	}
+
+	chat, participant, ok := findParticipantGroupChat(w, DB, user, r.PathValue("chat_uuid"))
+	if !ok {
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := database.RemoveChatParticipant(tx, chat.ID, user.ID); err != nil {
+			return err
+		}
+		if participant.Role != database.ChatParticipantRoleOwner {
+			return nil
+		}
+		var successor database.ChatParticipant
+		err := tx.Joins("JOIN users ON users.id = chat_participants.user_id").
+			Where("chat_participants.chat_id = ? AND users.is_automated = ?", chat.ID, false).
+			Order("chat_participants.id asc").
+			First(&successor).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&successor).Update("role", database.ChatParticipantRoleOwner).Error
+	})
+	if err != nil {
+		http.Error(w, "Failed to leave chat", http.StatusInternalServerError)
+		return
+	}
+
+	publishParticipantEvent(DB, ch, chat, ch.MessageHandler.ParticipantLeft(chat.UUID, user.UUID, user.UUID), *user)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Left chat"))
+}
+
+// KickParticipant removes another participant from a group chat.
+//
+//	@Summary      Remove a participant
+//	@Description  Only the owner of a group chat can remove other participants.
+//	@Tags         chats
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        user_uuid path string true "UUID of the participant to remove"
+//	@Success      200  {string}  string "Participant removed"
+//	@Failure      400  {string}  string "Invalid request"
+//	@Failure      403  {string}  string "Forbidden"
+//	@Failure      404  {string}  string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/participants/{user_uuid}/kick [post]
+func (h *ChatsHandler) KickParticipant(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	ch, err := util.GetWebsocket(r)
+	if err != nil {
+		http.Error(w, "Unable to get websocket", http.StatusBadRequest)
+		return
+	}
+
+	chat, participant, ok := findParticipantGroupChat(w, DB, user, r.PathValue("chat_uuid"))
+	if !ok {
+		return
+	}
+	if participant.Role != database.ChatParticipantRoleOwner {
+		http.Error(w, "Only the chat owner can remove participants", http.StatusForbidden)
+		return
+	}
+
+	var member database.User
+	if err := DB.First(&member, "uuid = ?", r.PathValue("user_uuid")).Error; err != nil {
+		http.Error(w, "Participant not found", http.StatusNotFound)
+		return
+	}
+	if member.ID == user.ID {
+		http.Error(w, "Use leave to remove yourself", http.StatusBadRequest)
+		return
+	}
+	if _, err := database.GetChatParticipant(DB, chat.ID, member.ID); err != nil {
+		http.Error(w, "Participant not found", http.StatusNotFound)
+		return
+	}
+
+	if err := database.RemoveChatParticipant(DB, chat.ID, member.ID); err != nil {
+		http.Error(w, "Failed to remove participant", http.StatusInternalServerError)
+		return
+	}
+
+	publishParticipantEvent(DB, ch, chat, ch.MessageHandler.ParticipantLeft(chat.UUID, member.UUID, user.UUID), member)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Participant removed"))
+}
+
+// SetBotTriggerPolicy updates when bots in a group chat reply.
+//
+//	@Summary      Update bot trigger policy
+//	@Description  Set to "mention" to only trigger @-mentioned bots, or "always" to trigger every bot on each human message.
+//	@Tags         chats
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        request body UpdateBotTriggerPolicy true "New policy"
+//	@Success      200  {object}  ListedParticipants
+//	@Failure      400  {string}  string "Invalid bot_trigger_policy"
+//	@Failure      403  {string}  string "Forbidden"
+//	@Router       /api/v1/chats/{chat_uuid}/bot-trigger-policy [post]
+func (h *ChatsHandler) SetBotTriggerPolicy(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	var data UpdateBotTriggerPolicy
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	policy := database.NormalizeChatBotTriggerPolicy(data.BotTriggerPolicy)
+	if policy == "" {
+		http.Error(w, "Invalid bot_trigger_policy", http.StatusBadRequest)
+		return
+	}
+
+	chat, participant, ok := findParticipantGroupChat(w, DB, user, r.PathValue("chat_uuid"))
+	if !ok {
+		return
+	}
+	if participant.Role != database.ChatParticipantRoleOwner {
+		http.Error(w, "Only the chat owner can change the bot trigger policy", http.StatusForbidden)
+		return
+	}
+
+	if err := DB.Model(&chat).Update("bot_trigger_policy", policy).Error; err != nil {
+		http.Error(w, "Failed to update bot trigger policy", http.StatusInternalServerError)
+		return
+	}
+
+	participants, err := database.ListChatParticipants(DB, chat.ID)
+	if err != nil {
+		http.Error(w, "Failed to load participants", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListedParticipants{
+		ChatUUID:         chat.UUID,
+		BotTriggerPolicy: policy,
+		Rows:             participants,
+	})
+}
@@ -0,0 +1,190 @@
+package chats
+
+import (
+	wsapi "backend/api/websocket"
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ReceiptStatusDelivered = "delivered"
+	ReceiptStatusRead      = "read"
+)
+
+type MarkPositionRequest struct {
+	// MessageUUID defaults to the chat's latest message.
+	MessageUUID string `json:"message_uuid,omitempty"`
+}
+
+type ReceiptResponse struct {
+	ChatUUID    string `json:"chat_uuid"`
+	MessageUUID string `json:"message_uuid"`
+	Status      string `json:"status"`
+	// Changed is false when the messages were already delivered or read.
+	Changed bool `json:"changed"`
+}
+
+// publishBotTyping shows or hides the bots' typing indicators, they are shown
+// while the bots' replies are queued.
+func publishBotTyping(DB *gorm.DB, ch *wsapi.WebSocketHandler, chat database.Chat, bots []database.User, typing bool) {
+	for _, bot := range bots {
+		publishParticipantEvent(DB, ch, chat, ch.MessageHandler.Typing(chat.UUID, bot.UUID, typing, database.BotTypingTimeout))
+	}
+}
+
+// markChatPosition moves the user's delivered or read position in a chat.
+func markChatPosition(w http.ResponseWriter, r *http.Request, status string) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	ch, err := util.GetWebsocket(r)
+	if err != nil {
+		http.Error(w, "Unable to get websocket", http.StatusBadRequest)
+		return
+	}
+
+	var data MarkPositionRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var chat database.Chat
+	if err := DB.Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", r.PathValue("chat_uuid")).
+		First(&chat).Error; err != nil {
+		http.Error(w, "Chat not found", http.StatusNotFound)
+		return
+	}
+
+	var message database.Message
+	query := DB.Select("id", "uuid").Where("chat_id = ?", chat.ID)
+	if data.MessageUUID != "" {
+		query = query.Where("uuid = ?", data.MessageUUID)
+	} else if chat.LatestMessageId != nil {
+		query = query.Where("id = ?", *chat.LatestMessageId)
+	} else {
+		http.Error(w, "Chat has no messages", http.StatusNotFound)
+		return
+	}
+	if err := query.First(&message).Error; err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	changed := false
+	if status == ReceiptStatusRead {
+		changed, err = database.MarkChatReadUpTo(DB, chat.ID, user.ID, message.ID, now)
+	} else {
+		changed, err = database.MarkChatDelivered(DB, chat.ID, user.ID, message.ID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update receipts", http.StatusInternalServerError)
+		return
+	}
+	if changed {
+		publishParticipantEvent(DB, ch, chat, ch.MessageHandler.MessageReceipt(chat.UUID, user.UUID, message.UUID, status, true, now.String()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReceiptResponse{
+		ChatUUID:    chat.UUID,
+		MessageUUID: message.UUID,
+		Status:      status,
+		Changed:     changed,
+	})
+}
+
+// MarkChatRead marks the messages of a chat read up to a message.
+//
+//	@Summary      Mark chat read
+//	@Description  Mark the messages of the chat read up to and including the message, the latest message by default. The chat's participants get a message_receipt event.
+//	@Tags         chats
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        request body MarkPositionRequest false "Message to read up to"
+//	@Success      200 {object} chats.ReceiptResponse
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/read [post]
+func (h *ChatsHandler) MarkChatRead(w http.ResponseWriter, r *http.Request) {
+	markChatPosition(w, r, ReceiptStatusRead)
+}
+
+// MarkChatDelivered marks the messages of a chat delivered up to a message.
+//
+//	@Summary      Mark chat delivered
+//	@Description  Tell the senders your client received the messages of the chat up to and including the message, the latest message by default. The chat's participants get a message_receipt event.
+//	@Tags         chats
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        request body MarkPositionRequest false "Message received up to"
+//	@Success      200 {object} chats.ReceiptResponse
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/delivered [post]
+func (h *ChatsHandler) MarkChatDelivered(w http.ResponseWriter, r *http.Request) {
+	markChatPosition(w, r, ReceiptStatusDelivered)
+}
+
+// MarkMessageRead marks a single message read.
+//
+//	@Summary      Mark message read
+//	@Description  Mark a single message read without reading the messages before it. The chat's participants get a message_receipt event.
+//	@Tags         messages
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Message UUID"
+//	@Success      200 {object} chats.ReceiptResponse
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/read [post]
+func (h *ChatsHandler) MarkMessageRead(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	ch, err := util.GetWebsocket(r)
+	if err != nil {
+		http.Error(w, "Unable to get websocket", http.StatusBadRequest)
+		return
+	}
+
+	chat, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	changed, err := database.MarkMessageRead(DB, user.ID, message, now)
+	if err != nil {
+		http.Error(w, "Failed to update receipts", http.StatusInternalServerError)
+		return
+	}
+	if changed {
+		publishParticipantEvent(DB, ch, chat, ch.MessageHandler.MessageReceipt(chat.UUID, user.UUID, message.UUID, ReceiptStatusRead, false, now.String()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReceiptResponse{
+		ChatUUID:    chat.UUID,
+		MessageUUID: message.UUID,
+		Status:      ReceiptStatusRead,
+		Changed:     changed,
+	})
+}
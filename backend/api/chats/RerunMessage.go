@@ -15,8 +15,9 @@ type RerunMessageResponse struct {
 	ChatUUID          string `json:"chat_uuid"`
 	SourceMessageUUID string `json:"source_message_uuid"`
 	ResentMessageUUID string `json:"resent_message_uuid"`
-	DeletedCount      int64  `json:"deleted_count"`
-	Enqueued          bool   `json:"enqueued"`
+	// DeletedCount is the number of messages moved to an inactive branch.
+	DeletedCount int64 `json:"deleted_count"`
+	Enqueued     bool  `json:"enqueued"`
 }
 
 func getChatCounterparty(chat database.Chat, user database.User) (database.User, bool) {
@@ -30,9 +31,10 @@ func getChatCounterparty(chat database.Chat, user database.User) (database.User,
 }
 
 // RerunMessage rewinds a bot chat to a user message and retriggers bot reply.
+// The rewound messages are kept as an inactive branch next to the new one.
 //
 //	@Summary      Rerun from message
-//	@Description  Delete the selected user message and newer messages, recreate that user message, and enqueue a bot reply.
+//	@Description  Move the selected user message and newer messages to an inactive branch, recreate that user message as their sibling, and enqueue a bot reply.
 //	@Tags         messages
 //	@Accept       json
 //	@Produce      json
@@ -69,20 +71,25 @@ func (h *ChatsHandler) RerunMessage(w http.ResponseWriter, r *http.Request) {
 	var chat database.Chat
 	if err := DB.Preload("User1").
 		Preload("User2").
-		Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUUID, user.ID, user.ID).
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUUID).
 		First(&chat).Error; err != nil {
 		http.Error(w, "Chat not found", http.StatusNotFound)
 		return
 	}
 
-	botUser, ok := getChatCounterparty(chat, *user)
-	if !ok {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-	if !botUser.IsAutomated {
-		http.Error(w, "Rerun is only available in chats with bots", http.StatusConflict)
-		return
+	var botUser database.User
+	if !isGroupChat(chat) {
+		counterparty, ok := getChatCounterparty(chat, *user)
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !counterparty.IsAutomated {
+			http.Error(w, "Rerun is only available in chats with bots", http.StatusConflict)
+			return
+		}
+		botUser = counterparty
 	}
 
 	var sourceMessage database.Message
@@ -99,7 +106,24 @@ func (h *ChatsHandler) RerunMessage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Only your own messages can be rerun", http.StatusForbidden)
 		return
 	}
-	if sourceMessage.ReceiverId != botUser.ID {
+	// Group chats rerun every bot the original message triggered.
+	bots := []database.User{botUser}
+	if isGroupChat(chat) {
+		messageText := ""
+		if sourceMessage.Text != nil {
+			messageText = *sourceMessage.Text
+		}
+		_, triggeredBots, resolveErr := resolveMessageRecipients(DB, chat, *user, messageText)
+		if resolveErr != nil {
+			http.Error(w, "Failed to resolve chat participants", http.StatusInternalServerError)
+			return
+		}
+		if len(triggeredBots) == 0 {
+			http.Error(w, "Message did not trigger any bot", http.StatusConflict)
+			return
+		}
+		bots = triggeredBots
+	} else if sourceMessage.ReceiverId != botUser.ID {
 		http.Error(w, "Message is not addressed to the bot", http.StatusConflict)
 		return
 	}
@@ -108,24 +132,25 @@ func (h *ChatsHandler) RerunMessage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Event messages cannot be rerun", http.StatusConflict)
 		return
 	}
+	if sourceMessage.Inactive {
+		http.Error(w, "Switch to the message's branch before rerunning it", http.StatusConflict)
+		return
+	}
 
 	var resentMessage database.Message
 	var deletedCount int64
 	err = DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Model(&database.Message{}).
-			Where("chat_id = ? AND id >= ? AND deleted_at IS NULL", chat.ID, sourceMessage.ID).
-			Count(&deletedCount).Error; err != nil {
+		// The source message and its answers stay as an inactive branch
+		deactivated, err := database.DeactivateMessagesFrom(tx, chat.ID, sourceMessage.ID)
+		if err != nil {
 			return err
 		}
+		deletedCount = int64(len(deactivated))
 
 		if deletedCount == 0 {
 			return gorm.ErrRecordNotFound
 		}
 
-		if err := tx.Where("chat_id = ? AND id >= ?", chat.ID, sourceMessage.ID).Delete(&database.Message{}).Error; err != nil {
-			return err
-		}
-
 		messageText := ""
 		if sourceMessage.Text != nil {
 			messageText = *sourceMessage.Text
@@ -133,6 +158,7 @@ func (h *ChatsHandler) RerunMessage(w http.ResponseWriter, r *http.Request) {
 
 		resentMessage = database.Message{
 			ChatId:     chat.ID,
+			ParentId:   sourceMessage.ParentId,
 			SenderId:   sourceMessage.SenderId,
 			ReceiverId: sourceMessage.ReceiverId,
 			DataType:   sourceMessage.DataType,
@@ -161,13 +187,25 @@ func (h *ChatsHandler) RerunMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, enqueueErr := workqueue.EnqueueBotReply(queueClient, queueInspector, workqueue.BotReplyPayload{
-		ChatUUID:    chatUUID,
-		MessageUUID: resentMessage.UUID,
-		BotUserID:   botUser.ID,
-	}); enqueueErr != nil {
-		http.Error(w, "Failed to enqueue bot reply", http.StatusInternalServerError)
-		return
+	for _, bot := range bots {
+		payload := workqueue.BotReplyPayload{
+			ChatUUID:    chatUUID,
+			MessageUUID: resentMessage.UUID,
+			BotUserID:   bot.ID,
+		}
+		var enqueueErr error
+		if isGroupChat(chat) {
+			_, enqueueErr = workqueue.EnqueueGroupBotReply(queueClient, queueInspector, payload)
+		} else {
+			_, enqueueErr = workqueue.EnqueueBotReply(queueClient, queueInspector, payload)
+		}
+		if enqueueErr != nil {
+			http.Error(w, "Failed to enqueue bot reply", http.StatusInternalServerError)
+			return
+		}
+	}
+	if ch, err := util.GetWebsocket(r); err == nil {
+		publishBotTyping(DB, ch, chat, bots, true)
 	}
 
 	response := RerunMessageResponse{
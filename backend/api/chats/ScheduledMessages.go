@@ -0,0 +1,433 @@
+package chats
+
+import (
+	wsapi "backend/api/websocket"
+	"backend/database"
+	"backend/server/util"
+	"backend/workqueue"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"gorm.io/gorm"
+)
+
+type ScheduleMessageRequest struct {
+	SendMessage
+	// SendAt is an RFC 3339 time, DelaySeconds is used when it is empty.
+	SendAt       string `json:"send_at,omitempty"`
+	DelaySeconds int    `json:"delay_seconds,omitempty"`
+}
+
+type RescheduleMessageRequest struct {
+	SendAt       string `json:"send_at,omitempty"`
+	DelaySeconds int    `json:"delay_seconds,omitempty"`
+}
+
+type ListedScheduledMessage struct {
+	UUID     string `json:"uuid"`
+	ChatUUID string `json:"chat_uuid"`
+	Text     string `json:"text"`
+	ReplyTo  string `json:"reply_to,omitempty"`
+	SendAt   string `json:"send_at"`
+	Status   string `json:"status"`
+	// MessageUUID is the delivered message.
+	MessageUUID string `json:"message_uuid,omitempty"`
+	SentAt      string `json:"sent_at,omitempty"`
+	Error       string `json:"error,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type PaginatedScheduledMessages struct {
+	database.Pagination
+	Rows []ListedScheduledMessage `json:"rows"`
+}
+
+// resolveSendAt reads the delivery time of a schedule request.
+func resolveSendAt(sendAt string, delaySeconds int, now time.Time) (time.Time, error) {
+	var at time.Time
+	switch {
+	case sendAt != "":
+		parsed, err := time.Parse(time.RFC3339, sendAt)
+		if err != nil {
+			return at, fmt.Errorf("send_at must be an RFC 3339 time")
+		}
+		at = parsed
+	case delaySeconds > 0:
+		at = now.Add(time.Duration(delaySeconds) * time.Second)
+	default:
+		return at, fmt.Errorf("send_at or delay_seconds is required")
+	}
+	if err := database.ValidateSendAt(at, now); err != nil {
+		return at, err
+	}
+	return at.UTC(), nil
+}
+
+// validateScheduledMessage rejects messages SendChatMessage would reject for
+// reasons known when scheduling, so users learn about them right away.
+func validateScheduledMessage(DB *gorm.DB, chat database.Chat, user database.User, data SendMessage) error {
+	if data.ReplyTo != "" {
+		if _, err := database.FindReplyTarget(DB, chat.ID, data.ReplyTo); err != nil {
+			if errors.Is(err, database.ErrReplyToNotFound) {
+				return &SendMessageError{Status: http.StatusBadRequest, Message: "Replied to message not found"}
+			}
+			return err
+		}
+	}
+	if data.Attachments != nil {
+		for _, attachment := range *data.Attachments {
+			if _, err := findAttachableFile(DB, attachment.FileID, user.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func convertScheduledMessage(scheduled database.ScheduledMessage) ListedScheduledMessage {
+	var data SendMessage
+	_ = json.Unmarshal(scheduled.Payload, &data)
+	listed := ListedScheduledMessage{
+		UUID:      scheduled.UUID,
+		ChatUUID:  scheduled.Chat.UUID,
+		Text:      data.Text,
+		ReplyTo:   data.ReplyTo,
+		SendAt:    scheduled.SendAt.UTC().Format(time.RFC3339),
+		Status:    scheduled.Status,
+		Error:     scheduled.Error,
+		CreatedAt: scheduled.CreatedAt.String(),
+	}
+	if scheduled.SentAt != nil {
+		listed.SentAt = scheduled.SentAt.UTC().Format(time.RFC3339)
+	}
+	if scheduled.Message != nil {
+		listed.MessageUUID = scheduled.Message.UUID
+	}
+	return listed
+}
+
+// findOwnScheduledMessage loads a scheduled message of the user, writing the
+// error response when it is not found.
+func findOwnScheduledMessage(w http.ResponseWriter, DB *gorm.DB, user *database.User, scheduledUUID string) (database.ScheduledMessage, bool) {
+	var scheduled database.ScheduledMessage
+	if err := DB.Preload("Chat").
+		Preload("Message").
+		Where("uuid = ? AND sender_id = ?", scheduledUUID, user.ID).
+		First(&scheduled).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Scheduled message not found", http.StatusNotFound)
+			return scheduled, false
+		}
+		http.Error(w, "Failed to load scheduled message", http.StatusInternalServerError)
+		return scheduled, false
+	}
+	return scheduled, true
+}
+
+// ScheduleMessage queues a message for later delivery.
+//
+//	@Summary      Schedule a message
+//	@Description  Queue a message for delivery at send_at, or delay_seconds from now. It is delivered like a message sent at that time, bots addressed by it reply then. Reply targets and attachments are checked right away and again on delivery.
+//	@Tags         messages
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        request body ScheduleMessageRequest true "Message and delivery time"
+//	@Success      200 {object} chats.ListedScheduledMessage
+//	@Failure      400 {string} string "Invalid request"
+//	@Failure      403 {string} string "Forbidden"
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/schedule [post]
+func (h *ChatsHandler) ScheduleMessage(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	queueClient, err := util.GetAsynqClient(r)
+	if err != nil {
+		http.Error(w, "Async queue unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	var data ScheduleMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	sendAt, err := resolveSendAt(data.SendAt, data.DelaySeconds, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var chat database.Chat
+	if err := DB.Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", r.PathValue("chat_uuid")).
+		First(&chat).Error; err != nil {
+		http.Error(w, "Chat not found", http.StatusNotFound)
+		return
+	}
+	if err := validateScheduledMessage(DB, chat, *user, data.SendMessage); err != nil {
+		writeSendMessageError(w, err)
+		return
+	}
+
+	payload, err := json.Marshal(data.SendMessage)
+	if err != nil {
+		http.Error(w, "Invalid message", http.StatusBadRequest)
+		return
+	}
+	scheduled := database.ScheduledMessage{
+		ChatId:   chat.ID,
+		Chat:     chat,
+		SenderId: user.ID,
+		Payload:  payload,
+		SendAt:   sendAt,
+		Status:   database.ScheduledMessagePending,
+	}
+	if err := DB.Omit("Chat").Create(&scheduled).Error; err != nil {
+		http.Error(w, "Failed to schedule message", http.StatusInternalServerError)
+		return
+	}
+	scheduled.TaskID = workqueue.ScheduledMessageTaskID(scheduled.UUID, sendAt)
+	if _, err := workqueue.EnqueueScheduledMessage(queueClient, workqueue.ScheduledMessagePayload{ScheduledMessageUUID: scheduled.UUID}, sendAt); err != nil {
+		DB.Unscoped().Delete(&scheduled)
+		http.Error(w, "Failed to schedule message", http.StatusInternalServerError)
+		return
+	}
+	if err := DB.Model(&scheduled).Update("task_id", scheduled.TaskID).Error; err != nil {
+		http.Error(w, "Failed to schedule message", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(convertScheduledMessage(scheduled))
+}
+
+// ListScheduledMessages lists the messages the user scheduled.
+//
+//	@Summary      List scheduled messages
+//	@Description  List the messages you scheduled, next delivery first. Filter by chat and status (pending, sending, sent, cancelled or failed).
+//	@Tags         messages
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid query string false "Chat UUID"
+//	@Param        status    query string false "Status"
+//	@Param        page      query int    false "Page number"  default(1)
+//	@Param        limit     query int    false "Page size"    default(40)
+//	@Success      200 {object} chats.PaginatedScheduledMessages
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/scheduled [get]
+func (h *ChatsHandler) ListScheduledMessages(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	pagination := database.Pagination{Page: 1, Limit: 40, Sort: "send_at asc"}
+	if pageParam := r.URL.Query().Get("page"); pageParam != "" {
+		if page, err := strconv.Atoi(pageParam); err == nil && page > 0 {
+			pagination.Page = page
+		}
+	}
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if limit, err := strconv.Atoi(limitParam); err == nil && limit > 0 {
+			pagination.Limit = limit
+		}
+	}
+
+	query := DB.Model(&database.ScheduledMessage{}).Where("sender_id = ?", user.ID)
+	if chatUUID := r.URL.Query().Get("chat_uuid"); chatUUID != "" {
+		var chat database.Chat
+		if err := DB.Scopes(database.ChatParticipantScope(user.ID)).Where("uuid = ?", chatUUID).First(&chat).Error; err != nil {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+		query = query.Where("chat_id = ?", chat.ID)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var scheduled []database.ScheduledMessage
+	if err := query.Scopes(database.Paginate(&scheduled, &pagination, query.Session(&gorm.Session{}))).
+		Preload("Chat").
+		Preload("Message").
+		Find(&scheduled).Error; err != nil {
+		http.Error(w, "Couldn't find scheduled messages", http.StatusInternalServerError)
+		return
+	}
+
+	rows := make([]ListedScheduledMessage, len(scheduled))
+	for i, message := range scheduled {
+		rows[i] = convertScheduledMessage(message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PaginatedScheduledMessages{
+		Pagination: pagination,
+		Rows:       rows,
+	})
+}
+
+// CancelScheduledMessage cancels a pending scheduled message.
+//
+//	@Summary      Cancel a scheduled message
+//	@Description  Cancel a scheduled message that was not delivered yet.
+//	@Tags         messages
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        scheduled_uuid path string true "Scheduled message UUID"
+//	@Success      200 {object} chats.ListedScheduledMessage
+//	@Failure      404 {string} string "Not found"
+//	@Failure      409 {string} string "Already delivered or cancelled"
+//	@Router       /api/v1/chats/scheduled/{scheduled_uuid} [delete]
+func (h *ChatsHandler) CancelScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	scheduled, ok := findOwnScheduledMessage(w, DB, user, r.PathValue("scheduled_uuid"))
+	if !ok {
+		return
+	}
+	err = database.CancelScheduledMessage(DB, &scheduled)
+	if errors.Is(err, database.ErrScheduledMessageNotPending) {
+		http.Error(w, "Scheduled message is no longer pending", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to cancel scheduled message", http.StatusInternalServerError)
+		return
+	}
+	// The delivery task skips cancelled messages, removing it only saves the run
+	if queueInspector, err := util.GetAsynqInspector(r); err == nil {
+		workqueue.CancelScheduledMessageTask(queueInspector, scheduled.TaskID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(convertScheduledMessage(scheduled))
+}
+
+// RescheduleMessage moves a pending scheduled message to another time.
+//
+//	@Summary      Reschedule a message
+//	@Description  Move a scheduled message that was not delivered yet to send_at, or delay_seconds from now.
+//	@Tags         messages
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        scheduled_uuid path string true "Scheduled message UUID"
+//	@Param        request body RescheduleMessageRequest true "New delivery time"
+//	@Success      200 {object} chats.ListedScheduledMessage
+//	@Failure      400 {string} string "Invalid request"
+//	@Failure      404 {string} string "Not found"
+//	@Failure      409 {string} string "Already delivered or cancelled"
+//	@Router       /api/v1/chats/scheduled/{scheduled_uuid} [patch]
+func (h *ChatsHandler) RescheduleMessage(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	queueClient, clientErr := util.GetAsynqClient(r)
+	queueInspector, inspectorErr := util.GetAsynqInspector(r)
+	if clientErr != nil || inspectorErr != nil {
+		http.Error(w, "Async queue unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	var data RescheduleMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	sendAt, err := resolveSendAt(data.SendAt, data.DelaySeconds, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scheduled, ok := findOwnScheduledMessage(w, DB, user, r.PathValue("scheduled_uuid"))
+	if !ok {
+		return
+	}
+	if scheduled.Status != database.ScheduledMessagePending {
+		http.Error(w, "Scheduled message is no longer pending", http.StatusConflict)
+		return
+	}
+
+	// The new task is enqueued first so a failure keeps the old schedule intact
+	previousTaskID := scheduled.TaskID
+	taskID := workqueue.ScheduledMessageTaskID(scheduled.UUID, sendAt)
+	if taskID == previousTaskID {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(convertScheduledMessage(scheduled))
+		return
+	}
+	if _, err := workqueue.EnqueueScheduledMessage(queueClient, workqueue.ScheduledMessagePayload{ScheduledMessageUUID: scheduled.UUID}, sendAt); err != nil {
+		http.Error(w, "Failed to reschedule message", http.StatusInternalServerError)
+		return
+	}
+	err = database.RescheduleMessage(DB, &scheduled, sendAt, taskID)
+	if err != nil {
+		workqueue.CancelScheduledMessageTask(queueInspector, taskID)
+		if errors.Is(err, database.ErrScheduledMessageNotPending) {
+			http.Error(w, "Scheduled message is no longer pending", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to reschedule message", http.StatusInternalServerError)
+		return
+	}
+	workqueue.CancelScheduledMessageTask(queueInspector, previousTaskID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(convertScheduledMessage(scheduled))
+}
+
+// DeliverScheduledMessage sends a claimed scheduled message through
+// SendChatMessage as if its sender sent it now and returns the created message.
+func DeliverScheduledMessage(DB *gorm.DB, ch *wsapi.WebSocketHandler, queueClient *asynq.Client, queueInspector *asynq.Inspector, scheduled database.ScheduledMessage) (*database.Message, error) {
+	var data SendMessage
+	if err := json.Unmarshal(scheduled.Payload, &data); err != nil {
+		return nil, fmt.Errorf("invalid scheduled message: %w", err)
+	}
+	return SendChatMessageAs(DB, ch, queueClient, queueInspector, scheduled.ChatId, scheduled.Sender, data)
+}
+
+// SendChatMessageAs sends a message of sender into a chat outside of a
+// request, for messages the server sends on the user's behalf.
+func SendChatMessageAs(DB *gorm.DB, ch *wsapi.WebSocketHandler, queueClient *asynq.Client, queueInspector *asynq.Inspector, chatID uint, sender database.User, data SendMessage) (*database.Message, error) {
+	var chat database.Chat
+	if err := DB.Preload("User1").
+		Preload("User2").
+		Preload("SharedConfig").
+		Preload("LatestMessage").
+		Scopes(database.ChatParticipantScope(sender.ID)).
+		Where("id = ?", chatID).
+		First(&chat).Error; err != nil {
+		return nil, fmt.Errorf("sender is no longer a participant of the chat")
+	}
+
+	listed, err := SendChatMessage(DB, ch, queueClient, queueInspector, chat, sender, data)
+	if err != nil {
+		return nil, err
+	}
+	var message database.Message
+	if err := DB.Where("uuid = ?", listed.UUID).First(&message).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
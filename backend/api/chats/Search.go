@@ -0,0 +1,166 @@
+package chats
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const maxSearchLimit = 100
+
+type MessageSearchPage struct {
+	Query      string                      `json:"query"`
+	Limit      int                         `json:"limit"`
+	Page       int                         `json:"page"`
+	Total      int64                       `json:"total"`
+	TotalPages int                         `json:"total_pages"`
+	Rows       []database.MessageSearchHit `json:"rows"`
+}
+
+// parseSearchTime reads a YYYY-MM-DD day or an RFC 3339 timestamp. With
+// endOfDay a day is read as the start of the following day, so it can be
+// used as an exclusive upper bound that includes the whole day.
+func parseSearchTime(value string, endOfDay bool) (*time.Time, error) {
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		if endOfDay {
+			day = day.AddDate(0, 0, 1)
+		}
+		return &day, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+func findSearchUser(DB *gorm.DB, userUUID string) (*database.User, error) {
+	var target database.User
+	if err := DB.First(&target, "uuid = ?", userUUID).Error; err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// SearchMessages runs a full-text search over the messages of the user's chats.
+//
+//	@Summary      Search messages
+//	@Description  Full-text search over the messages the user can read, best matches first. Snippets are HTML escaped with matches wrapped in <mark> tags.
+//	@Tags         messages
+//	@Produce      json
+//	@Param        q               query  string  true   "Search query"
+//	@Param        chat            query  string  false  "Only messages of this chat UUID"
+//	@Param        sender          query  string  false  "Only messages sent by this user UUID"
+//	@Param        bot             query  string  false  "Only messages of chats with this bot user UUID"
+//	@Param        from            query  string  false  "First day (YYYY-MM-DD) or time (RFC 3339)"
+//	@Param        to              query  string  false  "Last day (YYYY-MM-DD, inclusive) or time (RFC 3339, exclusive)"
+//	@Param        has_attachment  query  bool    false  "Only messages with attachments"
+//	@Param        page            query  int     false  "Page number"  default(1)
+//	@Param        limit           query  int     false  "Page size"    default(20)
+//	@Success      200 {object} chats.MessageSearchPage "Search results"
+//	@Failure      400 {string} string "Invalid query"
+//	@Failure      404 {string} string "Chat or user not found"
+//	@Failure      500 {string} string "Internal server error"
+//	@Router       /api/v1/chats/search [get]
+func (h *ChatsHandler) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := database.MessageSearchFilter{
+		Query:  strings.TrimSpace(query.Get("q")),
+		UserId: user.ID,
+	}
+	if filter.Query == "" {
+		http.Error(w, "Missing search query", http.StatusBadRequest)
+		return
+	}
+
+	page, limit := 1, 20
+	if pageParam := query.Get("page"); pageParam != "" {
+		if parsed, err := strconv.Atoi(pageParam); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	if limitParam := query.Get("limit"); limitParam != "" {
+		if parsed, err := strconv.Atoi(limitParam); err == nil && parsed > 0 {
+			limit = min(parsed, maxSearchLimit)
+		}
+	}
+	filter.Limit = limit
+	filter.Offset = (page - 1) * limit
+
+	if chatUUID := strings.TrimSpace(query.Get("chat")); chatUUID != "" {
+		var chat database.Chat
+		if err := DB.Scopes(database.ChatParticipantScope(user.ID)).Where("uuid = ?", chatUUID).First(&chat).Error; err != nil {
+			http.Error(w, "chat not found", http.StatusNotFound)
+			return
+		}
+		filter.ChatId = chat.ID
+	}
+	if senderUUID := strings.TrimSpace(query.Get("sender")); senderUUID != "" {
+		sender, err := findSearchUser(DB, senderUUID)
+		if err != nil {
+			http.Error(w, "sender not found", http.StatusNotFound)
+			return
+		}
+		filter.SenderId = sender.ID
+	}
+	if botUUID := strings.TrimSpace(query.Get("bot")); botUUID != "" {
+		bot, err := findSearchUser(DB, botUUID)
+		if err != nil || !bot.IsAutomated {
+			http.Error(w, "bot not found", http.StatusNotFound)
+			return
+		}
+		filter.BotUserId = bot.ID
+	}
+	if from := strings.TrimSpace(query.Get("from")); from != "" {
+		if filter.From, err = parseSearchTime(from, false); err != nil {
+			http.Error(w, "from must be a YYYY-MM-DD day or an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	if to := strings.TrimSpace(query.Get("to")); to != "" {
+		if filter.To, err = parseSearchTime(to, true); err != nil {
+			http.Error(w, "to must be a YYYY-MM-DD day or an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	if hasAttachment := query.Get("has_attachment"); hasAttachment != "" {
+		if filter.HasAttachment, err = strconv.ParseBool(hasAttachment); err != nil {
+			http.Error(w, "has_attachment must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	hits, total, err := database.SearchMessages(DB, filter)
+	if errors.Is(err, database.ErrEmptySearchQuery) {
+		http.Error(w, "Missing search query", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MessageSearchPage{
+		Query:      filter.Query,
+		Limit:      limit,
+		Page:       page,
+		Total:      total,
+		TotalPages: int(math.Ceil(float64(total) / float64(limit))),
+		Rows:       hits,
+	})
+}
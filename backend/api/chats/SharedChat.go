@@ -251,7 +251,7 @@ func (h *ChatsHandler) ListSharedInteractionMessages(w http.ResponseWriter, r *h
 
 	var messages []database.Message
 	q := DB.Scopes(database.Paginate(&messages, &pagination, DB)).
-		Where("chat_id = ?", chat.ID).
+		Where("chat_id = ? AND inactive = ?", chat.ID, false).
 		Where("deleted_at IS NULL").
 		Preload("Sender").
 		Find(&messages)
@@ -277,7 +277,7 @@ func (h *ChatsHandler) ListSharedInteractionMessages(w http.ResponseWriter, r *h
 
 func findOwnedChat(DB *gorm.DB, userID uint, chatUUID string) (database.Chat, error) {
 	var chat database.Chat
-	err := DB.Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUUID, userID, userID).First(&chat).Error
+	err := DB.Scopes(database.ChatOwnerScope(userID)).Where("uuid = ?", chatUUID).First(&chat).Error
 	return chat, err
 }
 
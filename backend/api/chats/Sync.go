@@ -0,0 +1,132 @@
+package chats
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type SyncedMessage struct {
+	ChatUUID string `json:"chat_uuid"`
+	// Inactive messages are off the chat's active branch.
+	Inactive bool `json:"inactive,omitempty"`
+	ListedMessage
+}
+
+type DeletedMessage struct {
+	UUID     string `json:"uuid"`
+	ChatUUID string `json:"chat_uuid"`
+}
+
+// RemovedChat is a chat the user left or was removed from.
+type RemovedChat struct {
+	ChatUUID  string    `json:"chat_uuid"`
+	RemovedAt time.Time `json:"removed_at"`
+}
+
+type SyncResponse struct {
+	// Token is passed as ?token= to the next sync.
+	Token           string           `json:"token"`
+	HasMore         bool             `json:"has_more"`
+	Chats           []ListedChat     `json:"chats"`
+	DeletedChats    []string         `json:"deleted_chats"`
+	RemovedChats    []RemovedChat    `json:"removed_chats"`
+	Messages        []SyncedMessage  `json:"messages"`
+	DeletedMessages []DeletedMessage `json:"deleted_messages"`
+}
+
+// Sync returns the chats and messages created, edited or deleted since a sync
+// token, and the chats the user left or was removed from.
+//
+//	@Summary      Incremental sync
+//	@Description  Return the chats and messages of the user created, edited or deleted since the sync token, and the chats the user left or was removed from, oldest changes first. Without a token everything is synced. Keep syncing with the returned token while has_more is set.
+//	@Tags         chats
+//	@Produce      json
+//	@Param        token  query  string  false  "Sync token of the previous sync"
+//	@Param        limit  query  int     false  "Maximum changed chats and messages each"  default(200)
+//	@Success      200 {object} chats.SyncResponse "Changes since the token"
+//	@Failure      400 {string} string "Invalid sync token"
+//	@Failure      500 {string} string "Internal server error"
+//	@Router       /api/v1/chats/sync [get]
+func (h *ChatsHandler) Sync(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	token, err := database.DecodeSyncToken(r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := database.DefaultSyncLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if parsed, err := strconv.Atoi(limitParam); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	changes, err := database.SyncChangesSince(DB, user.ID, token, limit, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to sync", http.StatusInternalServerError)
+		return
+	}
+
+	chatIDs := make([]uint, 0, len(changes.Messages))
+	for _, message := range changes.Messages {
+		chatIDs = append(chatIDs, message.ChatId)
+	}
+	for _, removal := range changes.Removals {
+		chatIDs = append(chatIDs, removal.ChatId)
+	}
+	chatUUIDs, err := database.ChatUUIDsByID(DB, chatIDs)
+	if err != nil {
+		http.Error(w, "Failed to sync", http.StatusInternalServerError)
+		return
+	}
+	replyToUUIDs, err := database.ReplyToUUIDs(DB, changes.Messages)
+	if err != nil {
+		http.Error(w, "Failed to sync", http.StatusInternalServerError)
+		return
+	}
+
+	response := SyncResponse{
+		Token:           changes.Token.Encode(),
+		HasMore:         changes.HasMore,
+		Chats:           []ListedChat{},
+		DeletedChats:    []string{},
+		RemovedChats:    []RemovedChat{},
+		Messages:        []SyncedMessage{},
+		DeletedMessages: []DeletedMessage{},
+	}
+	for _, chat := range changes.Chats {
+		if chat.DeletedAt.Valid {
+			response.DeletedChats = append(response.DeletedChats, chat.UUID)
+			continue
+		}
+		response.Chats = append(response.Chats, convertChatToListedChat(user, chat))
+	}
+	for _, removal := range changes.Removals {
+		response.RemovedChats = append(response.RemovedChats, RemovedChat{ChatUUID: chatUUIDs[removal.ChatId], RemovedAt: removal.DeletedAt.Time})
+	}
+	for _, message := range changes.Messages {
+		if message.DeletedAt.Valid {
+			response.DeletedMessages = append(response.DeletedMessages, DeletedMessage{UUID: message.UUID, ChatUUID: chatUUIDs[message.ChatId]})
+			continue
+		}
+		synced := SyncedMessage{
+			ChatUUID:      chatUUIDs[message.ChatId],
+			Inactive:      message.Inactive,
+			ListedMessage: convertMessageToListedMessage(message),
+		}
+		synced.ReplyToUUID = replyToUUIDs[message.ID]
+		response.Messages = append(response.Messages, synced)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
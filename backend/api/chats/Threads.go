@@ -0,0 +1,145 @@
+package chats
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// ListThread lists the replies to a message.
+//
+//	@Summary      List message thread
+//	@Description  List the replies to a message of the chat's active branch, oldest first.
+//	@Tags         messages
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        message_uuid path string true "Message UUID"
+//	@Param        page  query  int  false  "Page number"  default(1)
+//	@Param        limit query  int  false  "Page size"     default(40)
+//	@Success      200 {object} chats.ListedMessagesPage
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/messages/{message_uuid}/thread [get]
+func (h *ChatsHandler) ListThread(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	pagination := database.Pagination{Page: 1, Limit: 40, Sort: "id asc"}
+	if pageParam := r.URL.Query().Get("page"); pageParam != "" {
+		if page, err := strconv.Atoi(pageParam); err == nil && page > 0 {
+			pagination.Page = page
+		}
+	}
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if limit, err := strconv.Atoi(limitParam); err == nil && limit > 0 {
+			pagination.Limit = limit
+		}
+	}
+
+	chat, message, ok := findVisibleChatMessage(w, DB, user, r.PathValue("chat_uuid"), r.PathValue("message_uuid"))
+	if !ok {
+		return
+	}
+
+	query := DB.Model(&database.Message{}).Where("chat_id = ? AND reply_to_id = ? AND inactive = ?", chat.ID, message.ID, false)
+	if !isGroupChat(chat) {
+		query = query.Where("receiver_id = ? OR sender_id = ?", user.ID, user.ID)
+	}
+	var replies []database.Message
+	if err := query.Scopes(database.Paginate(&replies, &pagination, query.Session(&gorm.Session{}))).
+		Preload("Sender").
+		Find(&replies).Error; err != nil {
+		http.Error(w, "Couldn't find replies", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := convertChatMessages(DB, chat.ID, replies)
+	if err != nil {
+		http.Error(w, "Couldn't load message details", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListedMessagesPage{
+		Limit:      pagination.Limit,
+		Page:       pagination.Page,
+		TotalPages: pagination.TotalPages,
+		Rows:       rows,
+	})
+}
+
+// ListThreads lists the messages of a chat that have replies.
+//
+//	@Summary      List chat threads
+//	@Description  List the messages of the chat's active branch that were replied to, newest first, with their reply counts.
+//	@Tags         messages
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        page  query  int  false  "Page number"  default(1)
+//	@Param        limit query  int  false  "Page size"     default(40)
+//	@Success      200 {object} chats.ListedMessagesPage
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/threads [get]
+func (h *ChatsHandler) ListThreads(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	pagination := database.Pagination{Page: 1, Limit: 40}
+	if pageParam := r.URL.Query().Get("page"); pageParam != "" {
+		if page, err := strconv.Atoi(pageParam); err == nil && page > 0 {
+			pagination.Page = page
+		}
+	}
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if limit, err := strconv.Atoi(limitParam); err == nil && limit > 0 {
+			pagination.Limit = limit
+		}
+	}
+
+	var chat database.Chat
+	if err := DB.Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", r.PathValue("chat_uuid")).
+		First(&chat).Error; err != nil {
+		http.Error(w, "Chat not found", http.StatusNotFound)
+		return
+	}
+
+	query := DB.Model(&database.Message{}).
+		Where("chat_id = ? AND inactive = ?", chat.ID, false).
+		Where("id IN (SELECT reply_to_id FROM messages WHERE chat_id = ? AND inactive = ? AND deleted_at IS NULL)", chat.ID, false)
+	if !isGroupChat(chat) {
+		query = query.Where("receiver_id = ? OR sender_id = ?", user.ID, user.ID)
+	}
+	var roots []database.Message
+	if err := query.Scopes(database.Paginate(&roots, &pagination, query.Session(&gorm.Session{}))).
+		Preload("Sender").
+		Find(&roots).Error; err != nil {
+		http.Error(w, "Couldn't find threads", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := convertChatMessages(DB, chat.ID, roots)
+	if err != nil {
+		http.Error(w, "Couldn't load message details", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListedMessagesPage{
+		Limit:      pagination.Limit,
+		Page:       pagination.Page,
+		TotalPages: pagination.TotalPages,
+		Rows:       rows,
+	})
+}
@@ -0,0 +1,121 @@
+package chats
+
+import (
+	"backend/database"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEditMessageRecordsRevisions(t *testing.T) {
+	DB := setupChatsTestDB(t)
+	owner := createUserForChatsTest(t, DB, "edit-owner", false)
+	member := createUserForChatsTest(t, DB, "edit-member", false)
+	chatUUID := createGroupForTest(t, DB, owner, member)
+
+	req := newParticipantsTestRequest(t, DB, owner, "POST", "/api/v1/chats/"+chatUUID+"/messages/send", SendMessage{Text: "helo team"}, map[string]string{"chat_uuid": chatUUID})
+	rr := httptest.NewRecorder()
+	(&ChatsHandler{}).MessageSend(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var sent ListedMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &sent); err != nil {
+		t.Fatalf("failed to decode message: %v", err)
+	}
+	pathValues := map[string]string{"chat_uuid": chatUUID, "message_uuid": sent.UUID}
+
+	req = newParticipantsTestRequest(t, DB, member, "POST", "/edit", EditMessageRequest{Text: "not mine"}, pathValues)
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).EditMessage(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected editing another user's message to be forbidden, got %d", rr.Code)
+	}
+
+	req = newParticipantsTestRequest(t, DB, owner, "POST", "/edit", EditMessageRequest{Text: "hello team"}, pathValues)
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).EditMessage(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var edited EditMessageResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &edited); err != nil {
+		t.Fatalf("failed to decode edit: %v", err)
+	}
+	if edited.Revision != 2 || !edited.Message.Edited || edited.Message.Text != "hello team" || edited.Enqueued {
+		t.Fatalf("unexpected edit response %+v", edited)
+	}
+
+	req = newParticipantsTestRequest(t, DB, owner, "POST", "/edit", EditMessageRequest{Text: "hello team"}, pathValues)
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).EditMessage(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected an unchanged edit to conflict, got %d", rr.Code)
+	}
+
+	req = newParticipantsTestRequest(t, DB, member, "GET", "/revisions", nil, pathValues)
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).ListMessageRevisions(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var revisions []ListedMessageRevision
+	if err := json.Unmarshal(rr.Body.Bytes(), &revisions); err != nil {
+		t.Fatalf("failed to decode revisions: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Text != "helo team" || revisions[1].Text != "hello team" || revisions[1].EditorUUID != owner.UUID {
+		t.Fatalf("unexpected revisions %+v", revisions)
+	}
+}
+
+func TestEditReplyBotsFollowsReplacePolicy(t *testing.T) {
+	DB := setupChatsTestDB(t)
+	owner := createUserForChatsTest(t, DB, "edit-policy-owner", false)
+	bot := createUserForChatsTest(t, DB, "edit-policy-bot", false)
+	bot.IsAutomated = true
+	if err := DB.Save(bot).Error; err != nil {
+		t.Fatalf("failed to mark bot user automated: %v", err)
+	}
+
+	chat := database.Chat{User1Id: owner.ID, User2Id: bot.ID}
+	if err := DB.Create(&chat).Error; err != nil {
+		t.Fatalf("failed to create chat: %v", err)
+	}
+	config := database.SharedChatConfig{ChatId: chat.ID, ConfigData: json.RawMessage(`{"edit_reply_policy":"replace"}`)}
+	if err := DB.Create(&config).Error; err != nil {
+		t.Fatalf("failed to create config: %v", err)
+	}
+	if err := DB.Model(&chat).Update("shared_config_id", config.ID).Error; err != nil {
+		t.Fatalf("failed to link config: %v", err)
+	}
+	if err := DB.Preload("User1").Preload("User2").Preload("SharedConfig").First(&chat, chat.ID).Error; err != nil {
+		t.Fatalf("failed to reload chat: %v", err)
+	}
+
+	createMessage := func(sender, receiver *database.User, text string) database.Message {
+		message := database.Message{ChatId: chat.ID, SenderId: sender.ID, ReceiverId: receiver.ID, Text: &text}
+		if err := DB.Create(&message).Error; err != nil {
+			t.Fatalf("failed to create message: %v", err)
+		}
+		return message
+	}
+	question := createMessage(owner, bot, "first question")
+	createMessage(bot, owner, "first answer")
+
+	bots, err := editReplyBots(DB, chat, *owner, question, "better question")
+	if err != nil || len(bots) != 1 || bots[0].ID != bot.ID {
+		t.Fatalf("expected the bot to reply to the edited last message, got %+v (%v)", bots, err)
+	}
+
+	createMessage(owner, bot, "second question")
+	bots, err = editReplyBots(DB, chat, *owner, question, "better question")
+	if err != nil || len(bots) != 0 {
+		t.Fatalf("expected no reply to an edit of an older message, got %+v (%v)", bots, err)
+	}
+
+	chat.SharedConfig.ConfigData = json.RawMessage(`{"edit_reply_policy":"keep"}`)
+	if policy := database.EditReplyPolicy(chat.SharedConfig.ConfigData); policy != database.EditReplyPolicyKeep {
+		t.Fatalf("expected the keep policy, got %q", policy)
+	}
+}
@@ -0,0 +1,313 @@
+package chats
+
+import (
+	"backend/api/websocket"
+	"backend/database"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func newParticipantsTestRequest(t *testing.T, DB *gorm.DB, user *database.User, method, target string, payload interface{}, pathValues map[string]string) *http.Request {
+	t.Helper()
+	var body []byte
+	if payload != nil {
+		body, _ = json.Marshal(payload)
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	for key, value := range pathValues {
+		req.SetPathValue(key, value)
+	}
+	ctx := context.WithValue(req.Context(), "db", DB)
+	ctx = context.WithValue(ctx, "user", user)
+	ctx = context.WithValue(ctx, "websocket", websocket.NewWebSocketHandler())
+	return req.WithContext(ctx)
+}
+
+func createGroupForTest(t *testing.T, DB *gorm.DB, owner *database.User, members ...*database.User) string {
+	t.Helper()
+	contactTokens := []string{}
+	for _, member := range members {
+		contactTokens = append(contactTokens, member.ContactToken)
+	}
+	req := newParticipantsTestRequest(t, DB, owner, "POST", "/api/v1/chats/groups/create", CreateGroupChat{
+		Title:         "team",
+		ContactTokens: contactTokens,
+	}, nil)
+	rr := httptest.NewRecorder()
+	(&ChatsHandler{}).CreateGroup(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var listed ListedChat
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("failed to decode group chat: %v", err)
+	}
+	if listed.BotTriggerPolicy != database.ChatBotTriggerMention {
+		t.Fatalf("expected default mention policy, got %q", listed.BotTriggerPolicy)
+	}
+	if len(listed.Participants) != len(members)+1 {
+		t.Fatalf("expected %d participants, got %d", len(members)+1, len(listed.Participants))
+	}
+	return listed.UUID
+}
+
+func TestGroupChatMessagesAreVisibleToAllParticipants(t *testing.T) {
+	DB := setupChatsTestDB(t)
+	owner := createUserForChatsTest(t, DB, "group-owner", false)
+	member := createUserForChatsTest(t, DB, "group-member", false)
+	outsider := createUserForChatsTest(t, DB, "group-outsider", false)
+	chatUUID := createGroupForTest(t, DB, owner, member)
+
+	req := newParticipantsTestRequest(t, DB, owner, "POST", "/api/v1/chats/"+chatUUID+"/messages/send", SendMessage{Text: "hello team"}, map[string]string{"chat_uuid": chatUUID})
+	rr := httptest.NewRecorder()
+	(&ChatsHandler{}).MessageSend(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = newParticipantsTestRequest(t, DB, member, "GET", "/api/v1/chats/"+chatUUID+"/messages/list", nil, map[string]string{"chat_uuid": chatUUID})
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).ListMessages(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var page ListedMessagesPage
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("failed to decode messages: %v", err)
+	}
+	if len(page.Rows) != 1 || page.Rows[0].Text != "hello team" {
+		t.Fatalf("expected member to see the group message, got %+v", page.Rows)
+	}
+
+	req = newParticipantsTestRequest(t, DB, outsider, "GET", "/api/v1/chats/"+chatUUID+"/messages/list", nil, map[string]string{"chat_uuid": chatUUID})
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).ListMessages(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected outsider to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestGroupChatTriggersOnlyMentionedBots(t *testing.T) {
+	DB := setupChatsTestDB(t)
+	owner := createUserForChatsTest(t, DB, "mention-owner", false)
+	helper := createUserForChatsTest(t, DB, "helperbot", false)
+	writer := createUserForChatsTest(t, DB, "writerbot", false)
+	for _, bot := range []*database.User{helper, writer} {
+		bot.IsAutomated = true
+		if err := DB.Save(bot).Error; err != nil {
+			t.Fatalf("failed to mark bot user automated: %v", err)
+		}
+	}
+	chatUUID := createGroupForTest(t, DB, owner, helper, writer)
+
+	var chat database.Chat
+	if err := DB.First(&chat, "uuid = ?", chatUUID).Error; err != nil {
+		t.Fatalf("failed to load chat: %v", err)
+	}
+
+	receivers, bots, err := resolveMessageRecipients(DB, chat, *owner, "thanks @HelperBot.")
+	if err != nil {
+		t.Fatalf("resolveMessageRecipients failed: %v", err)
+	}
+	if len(receivers) != 2 {
+		t.Fatalf("expected both bots as receivers, got %d", len(receivers))
+	}
+	if len(bots) != 1 || bots[0].ID != helper.ID {
+		t.Fatalf("expected only the mentioned bot to be triggered, got %+v", bots)
+	}
+
+	_, bots, err = resolveMessageRecipients(DB, chat, *helper, "@writerbot over to you")
+	if err != nil {
+		t.Fatalf("resolveMessageRecipients failed: %v", err)
+	}
+	if len(bots) != 0 {
+		t.Fatalf("expected bot messages not to trigger other bots, got %d", len(bots))
+	}
+
+	// Replies of a bot stream to every human in the group
+	humans, err := HumanRecipientUUIDs(DB, chatUUID, *helper)
+	if err != nil || len(humans) != 1 || humans[0] != owner.UUID {
+		t.Fatalf("expected the owner to receive the bot's reply, got %v (%v)", humans, err)
+	}
+
+	chat.BotTriggerPolicy = database.ChatBotTriggerAlways
+	_, bots, err = resolveMessageRecipients(DB, chat, *owner, "no mention")
+	if err != nil {
+		t.Fatalf("resolveMessageRecipients failed: %v", err)
+	}
+	if len(bots) != 2 {
+		t.Fatalf("expected always policy to trigger both bots, got %d", len(bots))
+	}
+}
+
+func TestGroupChatKickAndLeave(t *testing.T) {
+	DB := setupChatsTestDB(t)
+	owner := createUserForChatsTest(t, DB, "kick-owner", false)
+	member := createUserForChatsTest(t, DB, "kick-member", false)
+	other := createUserForChatsTest(t, DB, "kick-other", false)
+	chatUUID := createGroupForTest(t, DB, owner, member, other)
+
+	req := newParticipantsTestRequest(t, DB, member, "POST", "/kick", nil, map[string]string{"chat_uuid": chatUUID, "user_uuid": other.UUID})
+	rr := httptest.NewRecorder()
+	(&ChatsHandler{}).KickParticipant(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected non-owner kick to be forbidden, got %d", rr.Code)
+	}
+
+	req = newParticipantsTestRequest(t, DB, owner, "POST", "/kick", nil, map[string]string{"chat_uuid": chatUUID, "user_uuid": other.UUID})
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).KickParticipant(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected owner kick to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = newParticipantsTestRequest(t, DB, owner, "POST", "/leave", nil, map[string]string{"chat_uuid": chatUUID})
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).LeaveChat(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected leave to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var chat database.Chat
+	if err := DB.First(&chat, "uuid = ?", chatUUID).Error; err != nil {
+		t.Fatalf("failed to load chat: %v", err)
+	}
+	participants, err := database.ListChatParticipants(DB, chat.ID)
+	if err != nil {
+		t.Fatalf("failed to list participants: %v", err)
+	}
+	if len(participants) != 1 || participants[0].UserId != member.ID {
+		t.Fatalf("expected only the remaining member, got %+v", participants)
+	}
+	if participants[0].Role != database.ChatParticipantRoleOwner {
+		t.Fatalf("expected ownership to pass to remaining member, got %q", participants[0].Role)
+	}
+
+	// Only the current owner may publish the group, not its creator who left
+	req = newParticipantsTestRequest(t, DB, owner, "POST", "/publish", nil, map[string]string{"chat_uuid": chatUUID})
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).Publish(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected former owner to lose ownership, got %d", rr.Code)
+	}
+	req = newParticipantsTestRequest(t, DB, member, "POST", "/publish", nil, map[string]string{"chat_uuid": chatUUID})
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).Publish(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected new owner to publish, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = newParticipantsTestRequest(t, DB, owner, "POST", "/join", JoinChat{ContactToken: owner.ContactToken}, map[string]string{"chat_uuid": chatUUID})
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).JoinChat(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected former participant to lose access, got %d", rr.Code)
+	}
+
+	req = newParticipantsTestRequest(t, DB, member, "POST", "/join", JoinChat{ContactToken: owner.ContactToken}, map[string]string{"chat_uuid": chatUUID})
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).JoinChat(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected member to re-add former owner, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestGroupChatAttachmentsRequireShareAccess(t *testing.T) {
+	DB := setupChatsTestDB(t)
+	owner := createUserForChatsTest(t, DB, "attach-owner", false)
+	viewer := createUserForChatsTest(t, DB, "attach-viewer", false)
+	editor := createUserForChatsTest(t, DB, "attach-editor", false)
+	member := createUserForChatsTest(t, DB, "attach-member", false)
+	chatUUID := createGroupForTest(t, DB, owner, viewer, editor, member)
+
+	file := database.UploadedFile{FileID: "attached-file", FileName: "notes.txt", Size: 4, MIMEType: "text/plain", StorageURL: "uploads/notes.txt", OwnerID: owner.ID}
+	if err := DB.Create(&file).Error; err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if err := database.ShareFile(DB, &file, []uint{viewer.ID}, database.FilePermissionView, time.Now()); err != nil {
+		t.Fatalf("failed to share file: %v", err)
+	}
+	if err := database.ShareFile(DB, &file, []uint{editor.ID}, database.FilePermissionEdit, time.Now()); err != nil {
+		t.Fatalf("failed to share file: %v", err)
+	}
+	message := SendMessage{Text: "see attached", Attachments: &[]FileAttachment{{FileID: file.FileID}}}
+	pathValues := map[string]string{"chat_uuid": chatUUID}
+
+	// Viewers may read the file but not share it with the chat
+	req := newParticipantsTestRequest(t, DB, viewer, "POST", "/send", message, pathValues)
+	rr := httptest.NewRecorder()
+	(&ChatsHandler{}).MessageSend(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected viewers to not attach the file, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var chat database.Chat
+	if err := DB.First(&chat, "uuid = ?", chatUUID).Error; err != nil {
+		t.Fatalf("failed to load chat: %v", err)
+	}
+	var sendErr *SendMessageError
+	if err := validateScheduledMessage(DB, chat, *viewer, message); !errors.As(err, &sendErr) || sendErr.Status != http.StatusForbidden {
+		t.Fatalf("expected viewers to not schedule the attachment, got %v", err)
+	}
+	if permission, _ := database.FilePermissionOf(DB, &file, member.ID); permission != "" {
+		t.Fatalf("expected the member to have no access, got %q", permission)
+	}
+
+	req = newParticipantsTestRequest(t, DB, editor, "POST", "/send", message, pathValues)
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).MessageSend(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected editors to attach the file, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if permission, _ := database.FilePermissionOf(DB, &file, member.ID); permission != database.FilePermissionView {
+		t.Fatalf("expected the attachment to be shared with the member, got %q", permission)
+	}
+}
+
+func TestPurgingAChatReleasesItsAttachments(t *testing.T) {
+	DB := setupChatsTestDB(t)
+	owner := createUserForChatsTest(t, DB, "purge-owner", false)
+	member := createUserForChatsTest(t, DB, "purge-member", false)
+	chatUUID := createGroupForTest(t, DB, owner, member)
+
+	file := database.UploadedFile{FileID: "purged-attachment", FileName: "notes.txt", Size: 4, MIMEType: "text/plain", StorageURL: "uploads/notes.txt", OwnerID: owner.ID}
+	if err := DB.Create(&file).Error; err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	message := SendMessage{Text: "see attached", Attachments: &[]FileAttachment{{FileID: file.FileID}}}
+	req := newParticipantsTestRequest(t, DB, owner, "POST", "/send", message, map[string]string{"chat_uuid": chatUUID})
+	rr := httptest.NewRecorder()
+	(&ChatsHandler{}).MessageSend(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the attachment to be sent, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if permission, _ := database.FilePermissionOf(DB, &file, member.ID); permission != database.FilePermissionView {
+		t.Fatalf("expected the attachment to be shared with the member, got %q", permission)
+	}
+
+	if _, err := database.SetRetentionPolicy(DB, database.RetentionScopeGlobal, nil, "", database.RetentionPolicyLimits{MessageDays: 1, DeletedDays: 1}); err != nil {
+		t.Fatalf("failed to set retention policy: %v", err)
+	}
+	plan, err := database.PlanRetentionPurge(DB, time.Now().AddDate(0, 0, 10))
+	if err != nil {
+		t.Fatalf("failed to plan purge: %v", err)
+	}
+	if len(plan.ChatIDs) != 1 || len(plan.Uploads) != 1 || plan.Uploads[0].ID != file.ID {
+		t.Fatalf("expected the chat and its attachment to be purged, got %d chats and %+v", len(plan.ChatIDs), plan.Uploads)
+	}
+	if err := database.ExecuteRetentionPurge(DB, plan); err != nil {
+		t.Fatalf("failed to purge: %v", err)
+	}
+	var grants int64
+	DB.Model(&database.FileAccess{}).Where("uploaded_file_id = ?", file.ID).Count(&grants)
+	if err := DB.Unscoped().First(&database.UploadedFile{}, file.ID).Error; err == nil || grants != 0 {
+		t.Fatalf("expected the attachment and its grants to be deleted, %d grants left (%v)", grants, err)
+	}
+}
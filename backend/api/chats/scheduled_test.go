@@ -0,0 +1,111 @@
+package chats
+
+import (
+	"backend/api/websocket"
+	"backend/database"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResolveSendAt(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if at, err := resolveSendAt("", 90, now); err != nil || !at.Equal(now.Add(90*time.Second)) {
+		t.Fatalf("expected a delay to be added to now, got %v (%v)", at, err)
+	}
+	if at, err := resolveSendAt("2026-03-01T14:00:00+01:00", 0, now); err != nil || !at.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected send_at to be parsed, got %v (%v)", at, err)
+	}
+	if _, err := resolveSendAt("2026-03-01T11:00:00Z", 0, now); !errors.Is(err, database.ErrSendAtInPast) {
+		t.Fatalf("expected a past send_at to be rejected, got %v", err)
+	}
+	if _, err := resolveSendAt("", 0, now); err == nil {
+		t.Fatalf("expected a missing delivery time to be rejected")
+	}
+}
+
+func TestDeliverScheduledMessageSendsOnceDue(t *testing.T) {
+	DB := setupChatsTestDB(t)
+	owner := createUserForChatsTest(t, DB, "scheduled-owner", false)
+	member := createUserForChatsTest(t, DB, "scheduled-member", false)
+	chatUUID := createGroupForTest(t, DB, owner, member)
+
+	req := newParticipantsTestRequest(t, DB, owner, "POST", "/schedule", ScheduleMessageRequest{
+		SendMessage:  SendMessage{Text: "later"},
+		DelaySeconds: 60,
+	}, map[string]string{"chat_uuid": chatUUID})
+	rr := httptest.NewRecorder()
+	(&ChatsHandler{}).ScheduleMessage(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected scheduling without a queue to fail, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var chat database.Chat
+	if err := DB.Where("uuid = ?", chatUUID).First(&chat).Error; err != nil {
+		t.Fatalf("failed to load chat: %v", err)
+	}
+	payload, _ := json.Marshal(SendMessage{Text: "good morning"})
+	now := time.Now()
+	scheduled := database.ScheduledMessage{
+		ChatId:   chat.ID,
+		SenderId: owner.ID,
+		Payload:  payload,
+		SendAt:   now.Add(time.Hour),
+		Status:   database.ScheduledMessagePending,
+	}
+	if err := DB.Create(&scheduled).Error; err != nil {
+		t.Fatalf("failed to create scheduled message: %v", err)
+	}
+
+	if _, err := database.ClaimScheduledMessage(DB, scheduled.UUID, now); !errors.Is(err, database.ErrScheduledMessageNotPending) {
+		t.Fatalf("expected a message that is not due to stay pending, got %v", err)
+	}
+	if err := database.RescheduleMessage(DB, &scheduled, now.Add(-time.Second), "task"); err != nil {
+		t.Fatalf("failed to reschedule: %v", err)
+	}
+	claimed, err := database.ClaimScheduledMessage(DB, scheduled.UUID, now)
+	if err != nil {
+		t.Fatalf("expected the due message to be claimed, got %v", err)
+	}
+	if _, err := database.ClaimScheduledMessage(DB, scheduled.UUID, now); !errors.Is(err, database.ErrScheduledMessageNotPending) {
+		t.Fatalf("expected a claimed message to not be claimed again, got %v", err)
+	}
+	// A delivery that crashed after claiming is retried once the claim timed out
+	later := now.Add(database.ScheduledMessageClaimTimeout + time.Second)
+	if claimed, err = database.ClaimScheduledMessage(DB, scheduled.UUID, later); err != nil || claimed.Status != database.ScheduledMessageSending {
+		t.Fatalf("expected the timed out claim to be reclaimed, got %v", err)
+	}
+	if _, err := database.ClaimScheduledMessage(DB, scheduled.UUID, later); !errors.Is(err, database.ErrScheduledMessageNotPending) {
+		t.Fatalf("expected the reclaimed message to not be claimed again, got %v", err)
+	}
+	if err := database.CancelScheduledMessage(DB, claimed); !errors.Is(err, database.ErrScheduledMessageNotPending) {
+		t.Fatalf("expected a claimed message to not be cancellable, got %v", err)
+	}
+
+	message, err := DeliverScheduledMessage(DB, websocket.NewWebSocketHandler(), nil, nil, *claimed)
+	if err != nil {
+		t.Fatalf("failed to deliver scheduled message: %v", err)
+	}
+	if message.SenderId != owner.ID || message.Text == nil || *message.Text != "good morning" {
+		t.Fatalf("unexpected delivered message %+v", message)
+	}
+	if err := database.FinishScheduledMessage(DB, claimed, &message.ID, nil, time.Now()); err != nil {
+		t.Fatalf("failed to finish scheduled message: %v", err)
+	}
+
+	var reloaded database.ScheduledMessage
+	if err := DB.Preload("Chat").Preload("Message").First(&reloaded, scheduled.ID).Error; err != nil {
+		t.Fatalf("failed to reload scheduled message: %v", err)
+	}
+	listed := convertScheduledMessage(reloaded)
+	if listed.Status != database.ScheduledMessageSent || listed.MessageUUID != message.UUID || listed.ChatUUID != chatUUID || listed.Text != "good morning" {
+		t.Fatalf("unexpected scheduled message %+v", listed)
+	}
+	var latest database.Chat
+	if err := DB.First(&latest, chat.ID).Error; err != nil || latest.LatestMessageId == nil || *latest.LatestMessageId != message.ID {
+		t.Fatalf("expected the delivered message to be the chat's latest, got %+v (%v)", latest.LatestMessageId, err)
+	}
+}
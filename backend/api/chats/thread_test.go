@@ -0,0 +1,73 @@
+package chats
+
+import (
+	"backend/database"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRepliesQuoteMessageAndFormThreads(t *testing.T) {
+	DB := setupChatsTestDB(t)
+	owner := createUserForChatsTest(t, DB, "thread-owner", false)
+	member := createUserForChatsTest(t, DB, "thread-member", false)
+	chatUUID := createGroupForTest(t, DB, owner, member)
+	chatPath := map[string]string{"chat_uuid": chatUUID}
+
+	send := func(sender *database.User, data SendMessage) ListedMessage {
+		req := newParticipantsTestRequest(t, DB, sender, "POST", "/send", data, chatPath)
+		rr := httptest.NewRecorder()
+		(&ChatsHandler{}).MessageSend(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var sent ListedMessage
+		if err := json.Unmarshal(rr.Body.Bytes(), &sent); err != nil {
+			t.Fatalf("failed to decode message: %v", err)
+		}
+		return sent
+	}
+	root := send(owner, SendMessage{Text: "lunch at noon?"})
+	send(owner, SendMessage{Text: "unrelated"})
+	reply := send(member, SendMessage{Text: "works for me", ReplyTo: root.UUID})
+	if reply.ReplyToUUID != root.UUID {
+		t.Fatalf("expected the reply to reference %s, got %q", root.UUID, reply.ReplyToUUID)
+	}
+	quote, ok := (*reply.MetaData)[database.ReplyToMetaKey].(map[string]interface{})
+	if !ok || quote["text"] != "lunch at noon?" || quote["sender_uuid"] != owner.UUID {
+		t.Fatalf("expected the quoted message in the meta data, got %+v", reply.MetaData)
+	}
+
+	req := newParticipantsTestRequest(t, DB, member, "POST", "/send", SendMessage{Text: "?", ReplyTo: "missing"}, chatPath)
+	rr := httptest.NewRecorder()
+	(&ChatsHandler{}).MessageSend(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected replying to an unknown message to fail, got %d", rr.Code)
+	}
+
+	req = newParticipantsTestRequest(t, DB, owner, "GET", "/thread", nil, map[string]string{"chat_uuid": chatUUID, "message_uuid": root.UUID})
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).ListThread(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var thread ListedMessagesPage
+	if err := json.Unmarshal(rr.Body.Bytes(), &thread); err != nil {
+		t.Fatalf("failed to decode thread: %v", err)
+	}
+	if len(thread.Rows) != 1 || thread.Rows[0].UUID != reply.UUID || thread.TotalPages != 1 {
+		t.Fatalf("unexpected thread %+v", thread)
+	}
+
+	req = newParticipantsTestRequest(t, DB, owner, "GET", "/threads", nil, chatPath)
+	rr = httptest.NewRecorder()
+	(&ChatsHandler{}).ListThreads(rr, req)
+	var threads ListedMessagesPage
+	if err := json.Unmarshal(rr.Body.Bytes(), &threads); err != nil {
+		t.Fatalf("failed to decode threads: %v", err)
+	}
+	if len(threads.Rows) != 1 || threads.Rows[0].UUID != root.UUID || threads.Rows[0].ReplyCount != 1 {
+		t.Fatalf("unexpected threads %+v", threads)
+	}
+}
@@ -243,21 +243,29 @@ func (h *ContactsHander) GetContactByChatUUID(w http.ResponseWriter, r *http.Req
 
 	// First find the chat and ensure the current user has access to it
 	var chat database.Chat
-	if err := DB.Where("uuid = ? AND (user1_id = ? OR user2_id = ?)",
-		chatUUID, currentUser.ID, currentUser.ID).
-		Preload("User1").
-		Preload("User2").
+	if err := DB.Scopes(database.ChatParticipantScope(currentUser.ID)).
+		Where("uuid = ?", chatUUID).
 		First(&chat).Error; err != nil {
 		http.Error(w, "Chat not found", http.StatusNotFound)
 		return
 	}
 
-	// Determine which user is the contact (the other party in the chat)
+	// The contact is the other party in the chat, the first other participant of a group
+	participants, err := database.ListChatParticipants(DB, chat.ID)
+	if err != nil {
+		http.Error(w, "Failed to load chat participants", http.StatusInternalServerError)
+		return
+	}
 	var contactUser database.User
-	if chat.User1Id == currentUser.ID {
-		contactUser = chat.User2
-	} else {
-		contactUser = chat.User1
+	for _, participant := range participants {
+		if participant.UserId != currentUser.ID {
+			contactUser = participant.User
+			break
+		}
+	}
+	if contactUser.ID == 0 {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return
 	}
 
 	// Get the contact's public profile
@@ -0,0 +1,400 @@
+package exports
+
+import (
+	wsapi "backend/api/websocket"
+	"backend/database"
+	"backend/server/util"
+	"backend/storage"
+	"backend/workqueue"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// ExportSyncMessageLimit is the most messages a chat may have to be
+	// exported in the request, larger chats are exported by the worker.
+	ExportSyncMessageLimit = 200
+)
+
+type ExportChatRequest struct {
+	// Format is "markdown", "html" or "json".
+	Format string `json:"format"`
+	// Async exports the chat in the worker regardless of its size.
+	Async bool `json:"async"`
+}
+
+type ListedChatExport struct {
+	UUID       string `json:"uuid"`
+	ChatUUID   string `json:"chat_uuid"`
+	Format     string `json:"format"`
+	Status     string `json:"status"`
+	FileName   string `json:"file_name,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	Error      string `json:"error,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+}
+
+type PaginatedChatExports struct {
+	database.Pagination
+	Rows []ListedChatExport `json:"rows"`
+}
+
+func convertChatExport(export database.ChatExport) ListedChatExport {
+	listed := ListedChatExport{
+		UUID:      export.UUID,
+		ChatUUID:  export.Chat.UUID,
+		Format:    export.Format,
+		Status:    export.Status,
+		FileName:  export.FileName,
+		Size:      export.Size,
+		Error:     export.Error,
+		CreatedAt: export.CreatedAt.String(),
+	}
+	if export.FinishedAt != nil {
+		listed.FinishedAt = export.FinishedAt.String()
+		if export.Status == database.ChatExportReady {
+			listed.ExpiresAt = export.FinishedAt.Add(database.ChatExportTTL).String()
+		}
+	}
+	return listed
+}
+
+func findOwnChatExport(w http.ResponseWriter, DB *gorm.DB, user *database.User, exportUUID string) (database.ChatExport, bool) {
+	var export database.ChatExport
+	if err := DB.Preload("Chat").
+		Where("uuid = ? AND owner_id = ?", exportUUID, user.ID).
+		First(&export).Error; err != nil {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return export, false
+	}
+	return export, true
+}
+
+// RunChatExport renders a claimed export into the default storage backend,
+// so any instance can serve the download, records the outcome and notifies
+// the owner over the websocket.
+func RunChatExport(DB *gorm.DB, ch *wsapi.WebSocketHandler, export *database.ChatExport) error {
+	ctx := context.Background()
+	storageURL, fileName, size, renderErr := renderChatExportFile(ctx, DB, export)
+	if renderErr != nil {
+		log.Printf("Failed to export chat %s: %v", export.Chat.UUID, renderErr)
+	}
+	if err := database.FinishChatExport(DB, export, storageURL, fileName, size, renderErr, time.Now()); err != nil {
+		if storageURL != "" {
+			storage.Delete(ctx, storageURL)
+		}
+		return err
+	}
+	if ch != nil {
+		ch.MessageHandler.SendMessage(ch, export.Owner.UUID, ch.MessageHandler.ChatExport(
+			export.UUID,
+			export.Chat.UUID,
+			export.Format,
+			export.Status,
+			export.Error,
+		))
+	}
+	return nil
+}
+
+// renderChatExportFile renders an export into a temporary file first, the
+// storage backends need its size up front.
+func renderChatExportFile(ctx context.Context, DB *gorm.DB, export *database.ChatExport) (string, string, int64, error) {
+	archive, err := LoadChatArchive(DB, export.Chat, export.Owner, time.Now())
+	if err != nil {
+		return "", "", 0, err
+	}
+	file, err := os.CreateTemp("", "chat-export-*")
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if err := RenderChatArchive(file, archive, export.Format); err != nil {
+		return "", "", 0, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", "", 0, err
+	}
+
+	fileName := ExportFileName(archive, export.Format)
+	key := path.Join("exports", export.UUID+path.Ext(fileName))
+	storageURL, err := storage.Default().Put(ctx, key, file, size, ExportContentType(export.Format))
+	if err != nil {
+		return "", "", 0, err
+	}
+	return storageURL, fileName, size, nil
+}
+
+// ExportChat exports a chat to Markdown, HTML or a JSON archive.
+//
+//	@Summary      Export a chat
+//	@Description  Render the active branch of a chat, with reasoning, tool calls, confirmable-action results and attachments, to "markdown", self-contained "html" or a "json" archive (zip of chat.json and the attached files). Chats with up to 200 messages are returned directly; larger chats, or any chat with async set, are exported in the background and a "chat_export" websocket event tells you when the export can be downloaded.
+//	@Tags         chats
+//	@Accept       json
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        chat_uuid path string true "Chat UUID"
+//	@Param        request body ExportChatRequest true "Export format"
+//	@Success      200 {file} file
+//	@Success      202 {object} exports.ListedChatExport
+//	@Failure      400 {string} string "Invalid request"
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/chats/{chat_uuid}/export [post]
+func (h *ExportsHandler) ExportChat(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	var data ExportChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	data.Format = strings.ToLower(strings.TrimSpace(data.Format))
+	if !database.IsValidChatExportFormat(data.Format) {
+		http.Error(w, "format must be markdown, html or json", http.StatusBadRequest)
+		return
+	}
+
+	var chat database.Chat
+	if err := DB.Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", r.PathValue("chat_uuid")).
+		First(&chat).Error; err != nil {
+		http.Error(w, "Chat not found", http.StatusNotFound)
+		return
+	}
+
+	var messageCount int64
+	if err := DB.Model(&database.Message{}).Where("chat_id = ? AND inactive = ?", chat.ID, false).Count(&messageCount).Error; err != nil {
+		http.Error(w, "Failed to count messages", http.StatusInternalServerError)
+		return
+	}
+
+	if !data.Async && messageCount <= ExportSyncMessageLimit {
+		archive, err := LoadChatArchive(DB, chat, *user, time.Now())
+		if err != nil {
+			http.Error(w, "Failed to load chat", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ExportContentType(data.Format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", ExportFileName(archive, data.Format)))
+		if err := RenderChatArchive(w, archive, data.Format); err != nil {
+			log.Printf("Failed to export chat %s: %v", chat.UUID, err)
+		}
+		return
+	}
+
+	queueClient, err := util.GetAsynqClient(r)
+	if err != nil {
+		http.Error(w, "Async queue unavailable", http.StatusInternalServerError)
+		return
+	}
+	export := database.ChatExport{
+		OwnerId: user.ID,
+		ChatId:  chat.ID,
+		Format:  data.Format,
+		Status:  database.ChatExportPending,
+	}
+	if err := DB.Create(&export).Error; err != nil {
+		http.Error(w, "Failed to create export", http.StatusInternalServerError)
+		return
+	}
+	export.Chat = chat
+	if _, err := workqueue.EnqueueChatExport(queueClient, workqueue.ChatExportPayload{ExportUUID: export.UUID}); err != nil {
+		DB.Unscoped().Delete(&export)
+		http.Error(w, "Failed to schedule export", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(convertChatExport(export))
+}
+
+// ListExports lists your chat exports, newest first.
+//
+//	@Summary      List chat exports
+//	@Description  List your chat exports, newest first, optionally of one chat
+//	@Tags         chats
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        page query int false "Page number"
+//	@Param        limit query int false "Page size"
+//	@Param        chat_uuid query string false "Only exports of this chat"
+//	@Success      200 {object} exports.PaginatedChatExports
+//	@Router       /api/v1/exports [get]
+func (h *ExportsHandler) ListExports(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	pagination := database.Pagination{Page: 1, Limit: 20, Sort: "id desc"}
+	if pageParam := r.URL.Query().Get("page"); pageParam != "" {
+		if page, err := strconv.Atoi(pageParam); err == nil && page > 0 {
+			pagination.Page = page
+		}
+	}
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if limit, err := strconv.Atoi(limitParam); err == nil && limit > 0 {
+			pagination.Limit = limit
+		}
+	}
+
+	query := DB.Model(&database.ChatExport{}).Where("owner_id = ?", user.ID)
+	if chatUUID := r.URL.Query().Get("chat_uuid"); chatUUID != "" {
+		query = query.Where("chat_id IN (SELECT id FROM chats WHERE uuid = ?)", chatUUID)
+	}
+
+	var exports []database.ChatExport
+	if err := query.Scopes(database.Paginate(&exports, &pagination, query.Session(&gorm.Session{}))).
+		Preload("Chat").
+		Find(&exports).Error; err != nil {
+		http.Error(w, "Couldn't find exports", http.StatusInternalServerError)
+		return
+	}
+
+	rows := make([]ListedChatExport, len(exports))
+	for i, export := range exports {
+		rows[i] = convertChatExport(export)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PaginatedChatExports{Pagination: pagination, Rows: rows})
+}
+
+// GetExport returns the status of one of your chat exports.
+//
+//	@Summary      Get a chat export
+//	@Tags         chats
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        export_uuid path string true "Export UUID"
+//	@Success      200 {object} exports.ListedChatExport
+//	@Failure      404 {string} string "Not found"
+//	@Router       /api/v1/exports/{export_uuid} [get]
+func (h *ExportsHandler) GetExport(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	export, ok := findOwnChatExport(w, DB, user, r.PathValue("export_uuid"))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(convertChatExport(export))
+}
+
+// DownloadExport downloads a ready chat export.
+//
+//	@Summary      Download a chat export
+//	@Tags         chats
+//	@Produce      octet-stream
+//	@Security     SessionAuth
+//	@Param        export_uuid path string true "Export UUID"
+//	@Success      200 {file} file
+//	@Failure      404 {string} string "Not found"
+//	@Failure      409 {string} string "Export not ready"
+//	@Failure      410 {string} string "Export expired"
+//	@Router       /api/v1/exports/{export_uuid}/download [get]
+func (h *ExportsHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	export, ok := findOwnChatExport(w, DB, user, r.PathValue("export_uuid"))
+	if !ok {
+		return
+	}
+	if export.Status != database.ChatExportReady {
+		http.Error(w, "Export not ready", http.StatusConflict)
+		return
+	}
+	if export.Expired(time.Now()) {
+		http.Error(w, "Export expired", http.StatusGone)
+		return
+	}
+	content, err := storage.Open(r.Context(), export.StorageURL)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "Export expired", http.StatusGone)
+		return
+	} else if err != nil {
+		http.Error(w, "Unable to read export", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", ExportContentType(export.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", export.FileName))
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, export.FileName, *export.FinishedAt, seeker)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(export.Size, 10))
+	io.Copy(w, content)
+}
+
+// DeleteExport deletes one of your chat exports and its file.
+//
+//	@Summary      Delete a chat export
+//	@Tags         chats
+//	@Security     SessionAuth
+//	@Param        export_uuid path string true "Export UUID"
+//	@Success      204
+//	@Failure      404 {string} string "Not found"
+//	@Failure      409 {string} string "Export is running"
+//	@Router       /api/v1/exports/{export_uuid} [delete]
+func (h *ExportsHandler) DeleteExport(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	export, ok := findOwnChatExport(w, DB, user, r.PathValue("export_uuid"))
+	if !ok {
+		return
+	}
+	if export.Status == database.ChatExportRunning {
+		http.Error(w, "Export is running", http.StatusConflict)
+		return
+	}
+	if err := DB.Unscoped().Delete(&export).Error; err != nil {
+		http.Error(w, "Failed to delete export", http.StatusInternalServerError)
+		return
+	}
+	if export.StorageURL != "" {
+		if err := storage.Delete(r.Context(), export.StorageURL); err != nil {
+			log.Printf("Failed to delete the file of chat export %s: %v", export.UUID, err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
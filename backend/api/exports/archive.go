@@ -0,0 +1,275 @@
+package exports
+
+import (
+	"backend/database"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ChatArchive is an exported chat. JSON archives store it as chat.json.
+type ChatArchive struct {
+	UUID         string               `json:"uuid"`
+	Title        string               `json:"title"`
+	ChatType     string               `json:"chat_type"`
+	CreatedAt    time.Time            `json:"created_at"`
+	ExportedAt   time.Time            `json:"exported_at"`
+	Participants []ArchiveParticipant `json:"participants"`
+	Messages     []ArchiveMessage     `json:"messages"`
+}
+
+type ArchiveParticipant struct {
+	UUID  string `json:"uuid"`
+	Name  string `json:"name"`
+	IsBot bool   `json:"is_bot"`
+}
+
+type ArchiveMessage struct {
+	UUID        string              `json:"uuid"`
+	SenderUUID  string              `json:"sender_uuid"`
+	SenderName  string              `json:"sender_name"`
+	SenderIsBot bool                `json:"sender_is_bot"`
+	DataType    string              `json:"data_type"`
+	SentAt      time.Time           `json:"sent_at"`
+	EditedAt    *time.Time          `json:"edited_at,omitempty"`
+	ReplyToUUID string              `json:"reply_to_uuid,omitempty"`
+	Text        string              `json:"text"`
+	Reasoning   []string            `json:"reasoning,omitempty"`
+	ToolCalls   []ArchiveToolCall   `json:"tool_calls,omitempty"`
+	Actions     []ArchiveAction     `json:"confirmable_actions,omitempty"`
+	Attachments []ArchiveAttachment `json:"attachments,omitempty"`
+}
+
+type ArchiveToolCall struct {
+	ID        string      `json:"id,omitempty"`
+	Name      string      `json:"name"`
+	Arguments interface{} `json:"arguments,omitempty"`
+	Result    string      `json:"result,omitempty"`
+	Status    string      `json:"status,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// ArchiveAction is a confirmable action a bot suggested and its outcome.
+type ArchiveAction struct {
+	ActionID   string      `json:"action_id"`
+	TargetTool string      `json:"target_tool_name"`
+	Status     string      `json:"status"`
+	Input      interface{} `json:"input,omitempty"`
+	Result     string      `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	ApprovedAt string      `json:"approved_at,omitempty"`
+	ExecutedAt string      `json:"executed_at,omitempty"`
+}
+
+type ArchiveAttachment struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	// Path is the file inside a JSON archive, empty when the file is not
+	// included because it is gone or the exporting user has no access to it.
+	Path string `json:"path,omitempty"`
+
+	storageURL string
+}
+
+// archiveString renders a loosely typed tool value as text.
+func archiveString(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	default:
+		encoded, err := json.Marshal(typed)
+		if err != nil {
+			return ""
+		}
+		return string(encoded)
+	}
+}
+
+func archiveToolCalls(message database.Message) []ArchiveToolCall {
+	if message.ToolCalls == nil {
+		return nil
+	}
+	calls := make([]ArchiveToolCall, 0, len(*message.ToolCalls))
+	for _, raw := range *message.ToolCalls {
+		var toolCall map[string]interface{}
+		if err := json.Unmarshal(raw, &toolCall); err != nil {
+			continue
+		}
+		calls = append(calls, ArchiveToolCall{
+			ID:        archiveString(toolCall["id"]),
+			Name:      archiveString(toolCall["name"]),
+			Arguments: toolCall["arguments"],
+			Result:    archiveString(toolCall["result"]),
+			Status:    archiveString(toolCall["status"]),
+			Error:     archiveString(toolCall["error"]),
+		})
+	}
+	return calls
+}
+
+type archiveMetaData struct {
+	Attachments []struct {
+		FileID      string `json:"file_id"`
+		DisplayName string `json:"display_name"`
+		FileName    string `json:"file_name"`
+	} `json:"attachments"`
+	ConfirmableActions []map[string]interface{} `json:"confirmable_actions"`
+}
+
+// LoadChatArchive loads the active branch of a chat for user. Attachments
+// the user cannot access are listed without their file.
+func LoadChatArchive(DB *gorm.DB, chat database.Chat, user database.User, now time.Time) (*ChatArchive, error) {
+	participants, err := database.ListChatParticipants(DB, chat.ID)
+	if err != nil {
+		return nil, err
+	}
+	var messages []database.Message
+	if err := DB.Preload("Sender").
+		Where("chat_id = ? AND inactive = ?", chat.ID, false).
+		Order("id asc").
+		Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	archive := &ChatArchive{
+		UUID:         chat.UUID,
+		Title:        chat.Title,
+		ChatType:     chat.ChatType,
+		CreatedAt:    chat.CreatedAt,
+		ExportedAt:   now,
+		Participants: make([]ArchiveParticipant, 0, len(participants)),
+		Messages:     make([]ArchiveMessage, 0, len(messages)),
+	}
+	names := []string{}
+	for _, participant := range participants {
+		archive.Participants = append(archive.Participants, ArchiveParticipant{
+			UUID:  participant.User.UUID,
+			Name:  participant.User.Name,
+			IsBot: participant.User.IsAutomated,
+		})
+		if participant.UserId != user.ID {
+			names = append(names, participant.User.Name)
+		}
+	}
+	if archive.Title == "" {
+		archive.Title = "Chat with " + strings.Join(names, ", ")
+	}
+
+	uuids := map[uint]string{}
+	missingReplies := []uint{}
+	for _, message := range messages {
+		uuids[message.ID] = message.UUID
+	}
+	for _, message := range messages {
+		if message.ReplyToId != nil && uuids[*message.ReplyToId] == "" {
+			missingReplies = append(missingReplies, *message.ReplyToId)
+		}
+	}
+	if len(missingReplies) > 0 {
+		var replied []database.Message
+		if err := DB.Unscoped().Select("id, uuid").Where("id IN ?", missingReplies).Find(&replied).Error; err != nil {
+			return nil, err
+		}
+		for _, message := range replied {
+			uuids[message.ID] = message.UUID
+		}
+	}
+
+	fileIDs := []string{}
+	for _, message := range messages {
+		text := ""
+		if message.Text != nil {
+			text = *message.Text
+		}
+		archived := ArchiveMessage{
+			UUID:        message.UUID,
+			SenderUUID:  message.Sender.UUID,
+			SenderName:  message.Sender.Name,
+			SenderIsBot: message.Sender.IsAutomated,
+			DataType:    message.DataType,
+			SentAt:      message.CreatedAt,
+			EditedAt:    message.EditedAt,
+			Text:        text,
+			ToolCalls:   archiveToolCalls(message),
+		}
+		if message.ReplyToId != nil {
+			archived.ReplyToUUID = uuids[*message.ReplyToId]
+		}
+		if message.Reasoning != nil {
+			archived.Reasoning = *message.Reasoning
+		}
+
+		var meta archiveMetaData
+		if len(message.MetaData) > 0 {
+			_ = json.Unmarshal(message.MetaData, &meta)
+		}
+		for _, attachment := range meta.Attachments {
+			if attachment.FileID == "" {
+				continue
+			}
+			name := attachment.FileName
+			if name == "" {
+				name = attachment.DisplayName
+			}
+			archived.Attachments = append(archived.Attachments, ArchiveAttachment{FileID: attachment.FileID, FileName: name})
+			fileIDs = append(fileIDs, attachment.FileID)
+		}
+		for _, action := range meta.ConfirmableActions {
+			archived.Actions = append(archived.Actions, ArchiveAction{
+				ActionID:   archiveString(action["action_id"]),
+				TargetTool: archiveString(action["target_tool_name"]),
+				Status:     archiveString(action["status"]),
+				Input:      action["input"],
+				Result:     archiveString(action["result"]),
+				Error:      archiveString(action["execution_error"]),
+				ApprovedAt: archiveString(action["approved_at"]),
+				ExecutedAt: archiveString(action["executed_at"]),
+			})
+		}
+		archive.Messages = append(archive.Messages, archived)
+	}
+
+	if len(fileIDs) == 0 {
+		return archive, nil
+	}
+	var files []database.UploadedFile
+	if err := DB.Where("file_id IN ?", fileIDs).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	var shared []uint
+	if err := DB.Model(&database.FileAccess{}).Where("user_id = ?", user.ID).Pluck("uploaded_file_id", &shared).Error; err != nil {
+		return nil, err
+	}
+	sharedWithUser := map[uint]bool{}
+	for _, id := range shared {
+		sharedWithUser[id] = true
+	}
+	byFileID := map[string]database.UploadedFile{}
+	for _, file := range files {
+		byFileID[file.FileID] = file
+	}
+	for i := range archive.Messages {
+		for j := range archive.Messages[i].Attachments {
+			attachment := &archive.Messages[i].Attachments[j]
+			file, ok := byFileID[attachment.FileID]
+			if !ok {
+				continue
+			}
+			if attachment.FileName == "" {
+				attachment.FileName = file.FileName
+			}
+			attachment.MimeType = file.MIMEType
+			attachment.Size = file.Size
+			if file.OwnerID == user.ID || sharedWithUser[file.ID] {
+				attachment.storageURL = file.StorageURL
+			}
+		}
+	}
+	return archive, nil
+}
@@ -0,0 +1,197 @@
+package exports
+
+import (
+	"archive/zip"
+	"backend/database"
+	"backend/server/util"
+	"backend/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func setupExportsTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return database.SetupDatabase(database.DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "exports_test.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+}
+
+func createUserForExportsTest(t *testing.T, DB *gorm.DB, name string) *database.User {
+	t.Helper()
+	err, user := util.CreateUser(DB, name, "Passw0rd!", false)
+	if err != nil {
+		t.Fatalf("failed to create user %q: %v", name, err)
+	}
+	return user
+}
+
+func newExportsTestRequest(DB *gorm.DB, user *database.User, method string, payload interface{}, pathValues map[string]string) *http.Request {
+	var body []byte
+	if payload != nil {
+		body, _ = json.Marshal(payload)
+	}
+	req := httptest.NewRequest(method, "/api/v1/exports", bytes.NewReader(body))
+	for key, value := range pathValues {
+		req.SetPathValue(key, value)
+	}
+	ctx := context.WithValue(req.Context(), "db", DB)
+	ctx = context.WithValue(ctx, "user", user)
+	return req.WithContext(ctx)
+}
+
+func TestExportChatRendersEveryFormat(t *testing.T) {
+	t.Chdir(t.TempDir())
+	DB := setupExportsTestDB(t)
+	owner := createUserForExportsTest(t, DB, "export-owner")
+	other := createUserForExportsTest(t, DB, "export-other")
+	bot := createUserForExportsTest(t, DB, "export-bot")
+	if err := DB.Model(bot).Update("is_automated", true).Error; err != nil {
+		t.Fatalf("failed to mark bot: %v", err)
+	}
+
+	chat := database.Chat{User1Id: owner.ID, User2Id: bot.ID, ChatType: "interaction"}
+	if err := DB.Create(&chat).Error; err != nil {
+		t.Fatalf("failed to create chat: %v", err)
+	}
+	if err := os.WriteFile("pic.png", []byte("png-bytes"), 0644); err != nil {
+		t.Fatalf("failed to write upload: %v", err)
+	}
+	upload := database.UploadedFile{FileID: "file-1", FileName: "pic.png", Size: 9, MIMEType: "image/png", StorageURL: "pic.png", OwnerID: owner.ID}
+	if err := DB.Create(&upload).Error; err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+
+	question := "What is <script>alert(1)</script>?"
+	answer := "It is a script tag."
+	reasoning := []string{"The user asks about HTML."}
+	toolCalls := []json.RawMessage{json.RawMessage(`{"id":"call-1","name":"search","arguments":{"query":"script tag"},"result":"found 3 pages","status":"succeeded"}`)}
+	messages := []database.Message{
+		{
+			ChatId: chat.ID, SenderId: owner.ID, ReceiverId: bot.ID, Text: &question,
+			MetaData: json.RawMessage(`{"attachments":[{"file_id":"file-1","display_name":"pic.png"}]}`),
+		},
+		{
+			ChatId: chat.ID, SenderId: bot.ID, ReceiverId: owner.ID, Text: &answer, Reasoning: &reasoning, ToolCalls: &toolCalls,
+			MetaData: json.RawMessage(`{"confirmable_actions":[{"action_id":"call-2","target_tool_name":"send_email","status":"executed","input":{"to":"a@example.invalid"},"result":"email sent"}]}`),
+		},
+	}
+	for i := range messages {
+		if err := DB.Create(&messages[i]).Error; err != nil {
+			t.Fatalf("failed to create message: %v", err)
+		}
+	}
+
+	h := &ExportsHandler{}
+	pathValues := map[string]string{"chat_uuid": chat.UUID}
+	export := func(user *database.User, format string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ExportChat(rr, newExportsTestRequest(DB, user, "POST", ExportChatRequest{Format: format}, pathValues))
+		return rr
+	}
+
+	rr := export(owner, "markdown")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/markdown") {
+		t.Fatalf("expected a markdown export, got %d: %s", rr.Code, rr.Body.String())
+	}
+	markdown := rr.Body.String()
+	for _, want := range []string{"# Chat with export-bot", "The user asks about HTML.", "**Tool call `search`** (succeeded)", "found 3 pages", "**Action `send_email`** (executed)", "email sent", "- pic.png (image/png, 9 B)"} {
+		if !strings.Contains(markdown, want) {
+			t.Fatalf("expected the markdown export to contain %q, got:\n%s", want, markdown)
+		}
+	}
+
+	rr = export(owner, "html")
+	page := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(page, "data:image/png;base64,cG5nLWJ5dGVz") || !strings.Contains(page, "&lt;script&gt;") || strings.Contains(page, "<script>alert") {
+		t.Fatalf("expected a self-contained and escaped html export, got %d:\n%s", rr.Code, page)
+	}
+
+	rr = export(owner, "json")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("expected a zip export, got %d: %s", rr.Code, rr.Body.String())
+	}
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("failed to open zip: %v", err)
+	}
+	entries := map[string][]byte{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", file.Name, err)
+		}
+		entries[file.Name], _ = io.ReadAll(reader)
+		reader.Close()
+	}
+	if string(entries["files/file-1/pic.png"]) != "png-bytes" {
+		t.Fatalf("expected the attachment in the zip, got entries %v", entries)
+	}
+	var exported ChatArchive
+	if err := json.Unmarshal(entries["chat.json"], &exported); err != nil {
+		t.Fatalf("failed to decode chat.json: %v", err)
+	}
+	if len(exported.Messages) != 2 || exported.Messages[0].Attachments[0].Path != "files/file-1/pic.png" || exported.Messages[1].Actions[0].Result != "email sent" {
+		t.Fatalf("unexpected chat.json %+v", exported)
+	}
+
+	if rr := export(other, "markdown"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected other users to not export the chat, got %d", rr.Code)
+	}
+	if rr := export(owner, "pdf"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown formats to be rejected, got %d", rr.Code)
+	}
+
+	// Large chats are rendered by the worker
+	pending := database.ChatExport{OwnerId: owner.ID, ChatId: chat.ID, Format: database.ChatExportFormatHTML, Status: database.ChatExportPending}
+	if err := DB.Create(&pending).Error; err != nil {
+		t.Fatalf("failed to create export: %v", err)
+	}
+	claimed, err := database.ClaimChatExport(DB, pending.UUID)
+	if err != nil {
+		t.Fatalf("failed to claim export: %v", err)
+	}
+	if _, err := database.ClaimChatExport(DB, pending.UUID); err != database.ErrChatExportNotPending {
+		t.Fatalf("expected an export to be claimed once, got %v", err)
+	}
+	if err := RunChatExport(DB, nil, claimed); err != nil || claimed.Status != database.ChatExportReady {
+		t.Fatalf("expected the export to be ready, got %q (%v)", claimed.Status, err)
+	}
+
+	exportPath := map[string]string{"export_uuid": pending.UUID}
+	rr = httptest.NewRecorder()
+	h.DownloadExport(rr, newExportsTestRequest(DB, other, "GET", nil, exportPath))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected other users to not download the export, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.DownloadExport(rr, newExportsTestRequest(DB, owner, "GET", nil, exportPath))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "It is a script tag.") {
+		t.Fatalf("expected the rendered export, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Exports live in the storage backend and are removed with the export
+	if !strings.Contains(claimed.StorageURL, "exports/"+pending.UUID) {
+		t.Fatalf("expected the export in storage, got %q", claimed.StorageURL)
+	}
+	rr = httptest.NewRecorder()
+	h.DeleteExport(rr, newExportsTestRequest(DB, owner, "DELETE", nil, exportPath))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected the export to be deleted, got %d", rr.Code)
+	}
+	if _, err := storage.Open(context.Background(), claimed.StorageURL); err != storage.ErrNotFound {
+		t.Fatalf("expected the stored export to be removed, got %v", err)
+	}
+}
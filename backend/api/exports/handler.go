@@ -0,0 +1,3 @@
+package exports
+
+type ExportsHandler struct{}
@@ -0,0 +1,323 @@
+package exports
+
+import (
+	"archive/zip"
+	"backend/database"
+	"backend/storage"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// inlineImageLimit caps the images embedded into HTML exports, larger
+// images are only listed.
+const inlineImageLimit = 5 << 20
+
+// ExportFileName is the download name of a chat rendered to format.
+func ExportFileName(archive *ChatArchive, format string) string {
+	name := fmt.Sprintf("chat-%s-%s", archive.UUID, archive.ExportedAt.UTC().Format("20060102-150405"))
+	switch format {
+	case database.ChatExportFormatMarkdown:
+		return name + ".md"
+	case database.ChatExportFormatHTML:
+		return name + ".html"
+	default:
+		return name + ".zip"
+	}
+}
+
+func ExportContentType(format string) string {
+	switch format {
+	case database.ChatExportFormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	case database.ChatExportFormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/zip"
+	}
+}
+
+// RenderChatArchive writes archive to w in format.
+func RenderChatArchive(w io.Writer, archive *ChatArchive, format string) error {
+	switch format {
+	case database.ChatExportFormatMarkdown:
+		return RenderMarkdown(w, archive)
+	case database.ChatExportFormatHTML:
+		return RenderHTML(w, archive)
+	case database.ChatExportFormatJSON:
+		return WriteJSONArchive(w, archive)
+	}
+	return fmt.Errorf("unsupported export format %q", format)
+}
+
+func exportTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// templateTime formats the time and time pointer fields of an archive.
+func templateTime(value interface{}) string {
+	switch typed := value.(type) {
+	case time.Time:
+		return exportTime(typed)
+	case *time.Time:
+		if typed != nil {
+			return exportTime(*typed)
+		}
+	}
+	return ""
+}
+
+func exportSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// exportJSON renders a tool value as indented JSON, strings as they are.
+func exportJSON(value interface{}) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+	if value == nil {
+		return ""
+	}
+	encoded, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// markdownFence returns a code fence longer than any backtick run in text.
+func markdownFence(text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fence
+}
+
+func writeMarkdownBlock(b *bytes.Buffer, language, text string) {
+	fence := markdownFence(text)
+	fmt.Fprintf(b, "%s%s\n%s\n%s\n\n", fence, language, strings.TrimRight(text, "\n"), fence)
+}
+
+// RenderMarkdown renders archive as a Markdown document.
+func RenderMarkdown(w io.Writer, archive *ChatArchive) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s\n\n", archive.Title)
+	names := make([]string, len(archive.Participants))
+	for i, participant := range archive.Participants {
+		names[i] = participant.Name
+		if participant.IsBot {
+			names[i] += " (bot)"
+		}
+	}
+	fmt.Fprintf(&b, "- Chat: `%s` (%s)\n", archive.UUID, archive.ChatType)
+	fmt.Fprintf(&b, "- Participants: %s\n", strings.Join(names, ", "))
+	fmt.Fprintf(&b, "- Created: %s\n", exportTime(archive.CreatedAt))
+	fmt.Fprintf(&b, "- Exported: %s\n", exportTime(archive.ExportedAt))
+
+	for _, message := range archive.Messages {
+		b.WriteString("\n---\n\n")
+		if message.DataType == "event" {
+			fmt.Fprintf(&b, "_%s · %s_\n", message.Text, exportTime(message.SentAt))
+			continue
+		}
+		fmt.Fprintf(&b, "### %s · %s\n\n", message.SenderName, exportTime(message.SentAt))
+		if message.ReplyToUUID != "" {
+			fmt.Fprintf(&b, "_In reply to `%s`_\n\n", message.ReplyToUUID)
+		}
+		if len(message.Reasoning) > 0 {
+			b.WriteString("<details>\n<summary>Reasoning</summary>\n\n")
+			for _, step := range message.Reasoning {
+				fmt.Fprintf(&b, "%s\n\n", strings.TrimSpace(step))
+			}
+			b.WriteString("</details>\n\n")
+		}
+		if message.Text != "" {
+			fmt.Fprintf(&b, "%s\n\n", strings.TrimRight(message.Text, "\n"))
+		}
+		if message.EditedAt != nil {
+			fmt.Fprintf(&b, "_Edited %s_\n\n", exportTime(*message.EditedAt))
+		}
+		for _, call := range message.ToolCalls {
+			status := ""
+			if call.Status != "" {
+				status = " (" + call.Status + ")"
+			}
+			fmt.Fprintf(&b, "**Tool call `%s`**%s\n\n", call.Name, status)
+			if arguments := exportJSON(call.Arguments); arguments != "" {
+				writeMarkdownBlock(&b, "json", arguments)
+			}
+			if call.Result != "" {
+				b.WriteString("Result:\n\n")
+				writeMarkdownBlock(&b, "", call.Result)
+			}
+			if call.Error != "" {
+				fmt.Fprintf(&b, "Error: %s\n\n", call.Error)
+			}
+		}
+		for _, action := range message.Actions {
+			fmt.Fprintf(&b, "**Action `%s`** (%s)\n\n", action.TargetTool, action.Status)
+			if input := exportJSON(action.Input); input != "" {
+				writeMarkdownBlock(&b, "json", input)
+			}
+			if action.Result != "" {
+				b.WriteString("Result:\n\n")
+				writeMarkdownBlock(&b, "", action.Result)
+			}
+			if action.Error != "" {
+				fmt.Fprintf(&b, "Error: %s\n\n", action.Error)
+			}
+		}
+		if len(message.Attachments) > 0 {
+			b.WriteString("Attachments:\n\n")
+			for _, attachment := range message.Attachments {
+				fmt.Fprintf(&b, "- %s", attachment.FileName)
+				if attachment.MimeType != "" {
+					fmt.Fprintf(&b, " (%s, %s)", attachment.MimeType, exportSize(attachment.Size))
+				}
+				b.WriteString("\n")
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// inlineImage returns an image attachment as a data URI, empty when it is
+// no image, too large or not available.
+func inlineImage(attachment ArchiveAttachment) template.URL {
+	if attachment.storageURL == "" || !strings.HasPrefix(attachment.MimeType, "image/") || attachment.Size > inlineImageLimit {
+		return ""
+	}
+	data, err := storage.ReadAll(context.Background(), attachment.storageURL)
+	if err != nil {
+		return ""
+	}
+	return template.URL("data:" + attachment.MimeType + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+var htmlExportTemplate = template.Must(template.New("chat").Funcs(template.FuncMap{
+	"time":        templateTime,
+	"size":        exportSize,
+	"json":        exportJSON,
+	"inlineImage": inlineImage,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:48rem;margin:2rem auto;padding:0 1rem;color:#1f2328;line-height:1.5}
+header{border-bottom:1px solid #d0d7de;margin-bottom:1rem}
+.meta{color:#59636e;font-size:.875rem}
+.message{border:1px solid #d0d7de;border-radius:.5rem;padding:.75rem 1rem;margin:1rem 0}
+.message.bot{background:#f6f8fa}
+.event{color:#59636e;font-style:italic;text-align:center;margin:1rem 0}
+.sender{font-weight:600}
+.text{white-space:pre-wrap;word-wrap:break-word}
+pre{background:#eff2f5;padding:.5rem;border-radius:.25rem;overflow-x:auto;white-space:pre-wrap}
+.tool{border-left:3px solid #8c959f;padding-left:.75rem;margin:.5rem 0}
+img{max-width:100%;border-radius:.25rem}
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+<p class="meta">{{.ChatType}} chat {{.UUID}} with {{range $i, $p := .Participants}}{{if $i}}, {{end}}{{$p.Name}}{{if $p.IsBot}} (bot){{end}}{{end}}<br>
+Created {{time .CreatedAt}} · Exported {{time .ExportedAt}}</p>
+</header>
+{{range .Messages}}{{if eq .DataType "event"}}<p class="event">{{.Text}} · {{time .SentAt}}</p>
+{{else}}<article class="message{{if .SenderIsBot}} bot{{end}}" id="{{.UUID}}">
+<p class="meta"><span class="sender">{{.SenderName}}</span> · {{time .SentAt}}{{if .EditedAt}} · edited {{time .EditedAt}}{{end}}{{if .ReplyToUUID}} · <a href="#{{.ReplyToUUID}}">in reply</a>{{end}}</p>
+{{if .Reasoning}}<details><summary>Reasoning</summary>{{range .Reasoning}}<p class="text">{{.}}</p>{{end}}</details>
+{{end}}{{if .Text}}<div class="text">{{.Text}}</div>
+{{end}}{{range .ToolCalls}}<div class="tool"><p><strong>Tool call <code>{{.Name}}</code></strong>{{if .Status}} ({{.Status}}){{end}}</p>
+{{with json .Arguments}}<pre>{{.}}</pre>{{end}}{{if .Result}}<p>Result</p><pre>{{.Result}}</pre>{{end}}{{if .Error}}<p>Error: {{.Error}}</p>{{end}}</div>
+{{end}}{{range .Actions}}<div class="tool"><p><strong>Action <code>{{.TargetTool}}</code></strong> ({{.Status}})</p>
+{{with json .Input}}<pre>{{.}}</pre>{{end}}{{if .Result}}<p>Result</p><pre>{{.Result}}</pre>{{end}}{{if .Error}}<p>Error: {{.Error}}</p>{{end}}</div>
+{{end}}{{range .Attachments}}<div class="attachment">{{with inlineImage .}}<img src="{{.}}" alt="">{{end}}<p class="meta">{{.FileName}}{{if .MimeType}} ({{.MimeType}}, {{size .Size}}){{end}}</p></div>
+{{end}}</article>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// RenderHTML renders archive as a single HTML page with its images embedded.
+func RenderHTML(w io.Writer, archive *ChatArchive) error {
+	return htmlExportTemplate.Execute(w, archive)
+}
+
+// archiveFileName keeps a file name usable inside a zip.
+func archiveFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, path.Base(name))
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// WriteJSONArchive writes archive as a zip of chat.json and the attached
+// files under files/<file id>/.
+func WriteJSONArchive(w io.Writer, archive *ChatArchive) error {
+	zipWriter := zip.NewWriter(w)
+	included := map[string]string{}
+	for i := range archive.Messages {
+		for j := range archive.Messages[i].Attachments {
+			attachment := &archive.Messages[i].Attachments[j]
+			if attachment.storageURL == "" {
+				continue
+			}
+			if existing, ok := included[attachment.FileID]; ok {
+				attachment.Path = existing
+				continue
+			}
+			file, err := storage.Open(context.Background(), attachment.storageURL)
+			if err != nil {
+				continue
+			}
+			name := path.Join("files", attachment.FileID, archiveFileName(attachment.FileName))
+			entry, err := zipWriter.Create(name)
+			if err == nil {
+				_, err = io.Copy(entry, file)
+			}
+			file.Close()
+			if err != nil {
+				return err
+			}
+			attachment.Path = name
+			included[attachment.FileID] = name
+		}
+	}
+
+	entry, err := zipWriter.Create("chat.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(archive); err != nil {
+		return err
+	}
+	return zipWriter.Close()
+}
@@ -2,19 +2,24 @@ package files
 
 import (
 	"backend/database"
+	"backend/extract"
+	"backend/imaging"
 	"backend/server/util"
+	"backend/storage"
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type FilesHandler struct{}
@@ -26,6 +31,7 @@ type FileUploadResponse struct {
 	MimeType     string    `json:"mime_type"`
 	UploadedAt   time.Time `json:"uploaded_at"`
 	OpenAIFileID string    `json:"openai_file_id,omitempty"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
 }
 
 type FileAttachment struct {
@@ -42,6 +48,22 @@ type OpenAIUploadResponse struct {
 	Purpose   string `json:"purpose"`
 }
 
+// allowedUploadTypes are the file types that can be attached to messages
+var allowedUploadTypes = map[string]bool{
+	"image/jpeg":         true,
+	"image/png":          true,
+	"image/gif":          true,
+	"image/webp":         true,
+	"application/pdf":    true,
+	"text/plain":         true,
+	"text/markdown":      true,
+	"text/csv":           true,
+	"application/msword": true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": true,
+	"application/vnd.ms-excel": true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": true,
+}
+
 // UploadFile handles file uploads for chat attachments
 func (h *FilesHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	DB, user, err := util.GetDBAndUser(r)
@@ -75,59 +97,52 @@ func (h *FilesHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate file type
-	allowedTypes := map[string]bool{
-		"image/jpeg":         true,
-		"image/png":          true,
-		"image/gif":          true,
-		"image/webp":         true,
-		"application/pdf":    true,
-		"text/plain":         true,
-		"application/msword": true,
-		"application/vnd.openxmlformats-officedocument.wordprocessingml.document": true,
-		"application/vnd.ms-excel": true,
-		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": true,
-	}
-
-	if !allowedTypes[header.Header.Get("Content-Type")] {
+	if !allowedUploadTypes[header.Header.Get("Content-Type")] {
 		http.Error(w, "File type not allowed", http.StatusBadRequest)
 		return
 	}
 
 	// Generate unique file ID
 	fileID := uuid.New().String()
-
-	// Create uploads directory if it doesn't exist
-	uploadsDir := "./uploads"
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		http.Error(w, "Unable to create uploads directory", http.StatusInternalServerError)
-		return
-	}
-
-	// Create file path
-	filePath := filepath.Join(uploadsDir, fileID)
-
-	// Create the file on disk
-	dst, err := os.Create(filePath)
-	if err != nil {
-		http.Error(w, "Unable to create file on server", http.StatusInternalServerError)
-		return
+	contentType := header.Header.Get("Content-Type")
+
+	// Images are stored without their EXIF data and get a thumbnail
+	var upload io.ReadSeeker = file
+	size := header.Size
+	var thumbnailStorageURL string
+	if imaging.Supported(contentType) {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "Unable to read file", http.StatusBadRequest)
+			return
+		}
+		processed, err := processImage(fileID, data, contentType)
+		if err != nil {
+			http.Error(w, "Invalid image", http.StatusBadRequest)
+			return
+		}
+		upload, size = bytes.NewReader(processed.data), int64(len(processed.data))
+		thumbnailStorageURL = storeThumbnail(r.Context(), fileID, processed)
 	}
-	defer dst.Close()
 
-	// Copy the uploaded file to the destination
-	_, err = io.Copy(dst, file)
+	// Store the file in the configured storage backend
+	storageURL, err := storage.Default().Put(r.Context(), fileID, upload, size, contentType)
 	if err != nil {
+		if thumbnailStorageURL != "" {
+			storage.Delete(r.Context(), thumbnailStorageURL)
+		}
 		http.Error(w, "Unable to save file", http.StatusInternalServerError)
 		return
 	}
 
-	// Close the file so we can read it again for OpenAI upload
-	dst.Close()
-
 	// Upload to OpenAI if requested
 	var openAIFileID string
 	if reuploadToOpenAI {
-		openAIFileID, err = h.uploadToOpenAI(filePath, header.Filename, header.Header.Get("Content-Type"))
+		// Rewind the upload, storing it consumed it
+		_, err = upload.Seek(0, io.SeekStart)
+		if err == nil {
+			openAIFileID, err = h.uploadToOpenAI(upload, header.Filename, contentType)
+		}
 		if err != nil {
 			fmt.Printf("Error uploading to OpenAI: %v\n", err)
 			// Don't fail the upload if OpenAI upload fails
@@ -136,12 +151,13 @@ func (h *FilesHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 
 	// Create database record
 	uploadedFile := database.UploadedFile{
-		FileID:     fileID,
-		FileName:   header.Filename,
-		Size:       header.Size,
-		MIMEType:   header.Header.Get("Content-Type"),
-		StorageURL: filePath,
-		OwnerID:    user.ID,
+		FileID:              fileID,
+		FileName:            header.Filename,
+		Size:                size,
+		MIMEType:            contentType,
+		StorageURL:          storageURL,
+		ThumbnailStorageURL: thumbnailStorageURL,
+		OwnerID:             user.ID,
 	}
 
 	// Add OpenAI file ID to metadata if available
@@ -155,19 +171,36 @@ func (h *FilesHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 
 	if err := DB.Create(&uploadedFile).Error; err != nil {
 		// Clean up file if database save fails
-		os.Remove(filePath)
+		deleteStoredFile(r.Context(), &uploadedFile)
 		http.Error(w, "Unable to save file record", http.StatusInternalServerError)
 		return
 	}
 
+	// Extract the text of documents now so bots don't have to on every message
+	if extract.Supported(uploadedFile.MIMEType) {
+		_, err = upload.Seek(0, io.SeekStart)
+		var data []byte
+		if err == nil {
+			data, err = io.ReadAll(upload)
+		}
+		if err == nil {
+			_, err = extract.CacheText(DB, &uploadedFile, data)
+		}
+		if err != nil {
+			log.Printf("Error extracting text of %s: %v", fileID, err)
+			// The text is extracted again when a bot needs it
+		}
+	}
+
 	// Return success response
 	response := FileUploadResponse{
 		FileID:       fileID,
 		FileName:     header.Filename,
-		Size:         header.Size,
-		MimeType:     header.Header.Get("Content-Type"),
+		Size:         size,
+		MimeType:     contentType,
 		UploadedAt:   uploadedFile.CreatedAt,
 		OpenAIFileID: openAIFileID,
+		ThumbnailURL: thumbnailURL(&uploadedFile),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -175,20 +208,13 @@ func (h *FilesHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 }
 
 // uploadToOpenAI uploads a file to OpenAI's files API
-func (h *FilesHandler) uploadToOpenAI(filePath, fileName, contentType string) (string, error) {
+func (h *FilesHandler) uploadToOpenAI(file io.Reader, fileName, contentType string) (string, error) {
 	// Get OpenAI API key from environment
 	openAIKey := os.Getenv("OPENAI_API_KEY")
 	if openAIKey == "" {
 		return "", fmt.Errorf("OPENAI_API_KEY not set")
 	}
 
-	// Read the file
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", fmt.Errorf("error opening file: %w", err)
-	}
-	defer file.Close()
-
 	// Create multipart form data
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
@@ -243,6 +269,20 @@ func (h *FilesHandler) uploadToOpenAI(filePath, fileName, contentType string) (s
 	return openAIResp.ID, nil
 }
 
+// findAccessibleFile loads a file the user may read, answering 404 for
+// unknown files and 403 for files the user has no access to
+func findAccessibleFile(w http.ResponseWriter, DB *gorm.DB, fileID string, user *database.User) (*database.UploadedFile, string, bool) {
+	uploadedFile, permission, err := database.FindAccessibleFile(DB, fileID, user.ID)
+	if errors.Is(err, database.ErrFileAccessDenied) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return nil, "", false
+	} else if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	return uploadedFile, permission, true
+}
+
 // GetFile serves uploaded files
 func (h *FilesHandler) GetFile(w http.ResponseWriter, r *http.Request) {
 	DB, user, err := util.GetDBAndUser(r)
@@ -257,34 +297,34 @@ func (h *FilesHandler) GetFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get file record
-	var uploadedFile database.UploadedFile
-	if err := DB.Where("file_id = ?", fileID).First(&uploadedFile).Error; err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
+	// Get file record the user owns or was given access to
+	uploadedFile, _, ok := findAccessibleFile(w, DB, fileID, user)
+	if !ok {
 		return
 	}
 
-	// Check if user has access to the file
-	if uploadedFile.OwnerID != user.ID {
-		// Check if file is shared with user
-		var fileAccess database.FileAccess
-		if err := DB.Where("uploaded_file_id = ? AND user_id = ?", uploadedFile.ID, user.ID).First(&fileAccess).Error; err != nil {
-			http.Error(w, "Access denied", http.StatusForbidden)
-			return
-		}
-	}
-
-	// Check if file exists on disk
-	if _, err := os.Stat(uploadedFile.StorageURL); os.IsNotExist(err) {
-		http.Error(w, "File not found on disk", http.StatusNotFound)
+	// Open the file in its storage backend
+	content, err := storage.Open(r.Context(), uploadedFile.StorageURL)
+	if err == storage.ErrNotFound {
+		http.Error(w, "File not found in storage", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Unable to read file", http.StatusInternalServerError)
 		return
 	}
+	defer content.Close()
 
 	// Serve the file
 	w.Header().Set("Content-Type", uploadedFile.MIMEType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", uploadedFile.FileName))
 	w.Header().Set("Cache-Control", "private, max-age=604800, immutable")
-	http.ServeFile(w, r, uploadedFile.StorageURL)
+	// Local files can be seeked to answer range requests
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, uploadedFile.FileName, uploadedFile.CreatedAt, seeker)
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", uploadedFile.Size))
+	io.Copy(w, content)
 }
 
 // DeleteFile allows users to delete their own files
@@ -314,14 +354,14 @@ func (h *FilesHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete file from disk
-	if err := os.Remove(uploadedFile.StorageURL); err != nil {
+	// Delete file from storage
+	if err := deleteStoredFile(r.Context(), &uploadedFile); err != nil {
 		// Log error but continue with database cleanup
-		fmt.Printf("Error deleting file from disk: %v\n", err)
+		fmt.Printf("Error deleting file from storage: %v\n", err)
 	}
 
-	// Delete from database
-	if err := DB.Delete(&uploadedFile).Error; err != nil {
+	// Delete from database, the file is no longer shared with anyone
+	if err := database.DeleteUploadedFile(DB, &uploadedFile); err != nil {
 		http.Error(w, "Unable to delete file record", http.StatusInternalServerError)
 		return
 	}
@@ -344,23 +384,12 @@ func (h *FilesHandler) GetFileInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get file record
-	var uploadedFile database.UploadedFile
-	if err := DB.Where("file_id = ?", fileID).First(&uploadedFile).Error; err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
+	// Get file record the user owns or was given access to
+	uploadedFile, _, ok := findAccessibleFile(w, DB, fileID, user)
+	if !ok {
 		return
 	}
 
-	// Check if user has access to the file
-	if uploadedFile.OwnerID != user.ID {
-		// Check if file is shared with user
-		var fileAccess database.FileAccess
-		if err := DB.Where("uploaded_file_id = ? AND user_id = ?", uploadedFile.ID, user.ID).First(&fileAccess).Error; err != nil {
-			http.Error(w, "Access denied", http.StatusForbidden)
-			return
-		}
-	}
-
 	// Parse metadata to get OpenAI file ID
 	var metadata map[string]interface{}
 	var openAIFileID string
@@ -380,6 +409,7 @@ func (h *FilesHandler) GetFileInfo(w http.ResponseWriter, r *http.Request) {
 		MimeType:     uploadedFile.MIMEType,
 		UploadedAt:   uploadedFile.CreatedAt,
 		OpenAIFileID: openAIFileID,
+		ThumbnailURL: thumbnailURL(uploadedFile),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -400,32 +430,18 @@ func (h *FilesHandler) GetFileData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get file record
-	var uploadedFile database.UploadedFile
-	if err := DB.Where("file_id = ?", fileID).First(&uploadedFile).Error; err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-
-	// Check if user has access to the file
-	if uploadedFile.OwnerID != user.ID {
-		// Check if file is shared with user
-		var fileAccess database.FileAccess
-		if err := DB.Where("uploaded_file_id = ? AND user_id = ?", uploadedFile.ID, user.ID).First(&fileAccess).Error; err != nil {
-			http.Error(w, "Access denied", http.StatusForbidden)
-			return
-		}
-	}
-
-	// Check if file exists on disk
-	if _, err := os.Stat(uploadedFile.StorageURL); os.IsNotExist(err) {
-		http.Error(w, "File not found on disk", http.StatusNotFound)
+	// Get file record the user owns or was given access to
+	uploadedFile, _, ok := findAccessibleFile(w, DB, fileID, user)
+	if !ok {
 		return
 	}
 
 	// Read the file content
-	fileBytes, err := os.ReadFile(uploadedFile.StorageURL)
-	if err != nil {
+	fileBytes, err := storage.ReadAll(r.Context(), uploadedFile.StorageURL)
+	if err == storage.ErrNotFound {
+		http.Error(w, "File not found in storage", http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, "Unable to read file", http.StatusInternalServerError)
 		return
 	}
@@ -444,3 +460,38 @@ func (h *FilesHandler) GetFileData(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+type FileTextResponse struct {
+	FileID string `json:"file_id"`
+	Text   string `json:"text"`
+}
+
+// GetFileText returns the text extracted from a document, for models that
+// can't read the file itself
+func (h *FilesHandler) GetFileText(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	uploadedFile, _, ok := findAccessibleFile(w, DB, r.PathValue("file_id"), user)
+	if !ok {
+		return
+	}
+
+	text, err := extract.FileText(r.Context(), DB, uploadedFile)
+	if errors.Is(err, extract.ErrUnsupported) {
+		http.Error(w, "Text extraction not supported for this file type", http.StatusUnsupportedMediaType)
+		return
+	} else if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "File not found in storage", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Unable to extract text", http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FileTextResponse{FileID: uploadedFile.FileID, Text: text})
+}
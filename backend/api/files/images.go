@@ -0,0 +1,147 @@
+package files
+
+import (
+	"backend/database"
+	"backend/imaging"
+	"backend/server/util"
+	"backend/storage"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+)
+
+// maxProcessedImageSize limits the images stripped and thumbnailed in memory,
+// the metadata of larger ones is stripped while streaming them to storage
+const maxProcessedImageSize = 32 << 20
+
+// errInvalidImage rejects uploads whose metadata can't be stripped, they are
+// never stored with it
+var errInvalidImage = errors.New("invalid image")
+
+// processedImage is an uploaded image without its metadata and its thumbnail
+type processedImage struct {
+	data          []byte
+	thumbnail     []byte
+	thumbnailType string
+}
+
+// processImage strips the metadata of an uploaded image and renders its
+// thumbnail. Images whose metadata can't be stripped are rejected with
+// errInvalidImage, those that fail to render are kept without a thumbnail.
+func processImage(fileID string, data []byte, mimeType string) (processedImage, error) {
+	stripped, err := imaging.StripMetadata(data, mimeType)
+	if err != nil {
+		return processedImage{}, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	processed := processedImage{data: stripped}
+
+	thumbnail, thumbnailType, err := imaging.Thumbnail(processed.data, mimeType)
+	if err != nil {
+		if err != imaging.ErrUnsupported {
+			log.Printf("Failed to render the thumbnail of image %s: %v", fileID, err)
+		}
+		return processed, nil
+	}
+	processed.thumbnail, processed.thumbnailType = thumbnail, thumbnailType
+	return processed, nil
+}
+
+// stripImageFile streams an image too large to process in memory into a
+// temporary file without its metadata, the caller removes the file
+func stripImageFile(src io.ReadSeeker, dir string, mimeType string) (*os.File, int64, error) {
+	stripped, err := os.CreateTemp(dir, "stripped-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := imaging.StripMetadataTo(stripped, src, mimeType)
+	if err == nil {
+		_, err = stripped.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		stripped.Close()
+		os.Remove(stripped.Name())
+		return nil, 0, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	return stripped, size, nil
+}
+
+// storeThumbnail stores a thumbnail next to its file, returning its storage
+// URL or an empty string when there is none
+func storeThumbnail(ctx context.Context, fileID string, processed processedImage) string {
+	if len(processed.thumbnail) == 0 {
+		return ""
+	}
+	// The extension tells GetFileThumbnail the content type
+	extension := ".png"
+	if processed.thumbnailType == imaging.MimeJPEG {
+		extension = ".jpg"
+	}
+	key := fileID + "-thumbnail" + extension
+	storageURL, err := storage.Default().Put(ctx, key, bytes.NewReader(processed.thumbnail), int64(len(processed.thumbnail)), processed.thumbnailType)
+	if err != nil {
+		log.Printf("Failed to store the thumbnail of image %s: %v", fileID, err)
+		return ""
+	}
+	return storageURL
+}
+
+// deleteStoredFile removes a file and its thumbnail from storage
+func deleteStoredFile(ctx context.Context, uploadedFile *database.UploadedFile) error {
+	if uploadedFile.ThumbnailStorageURL != "" {
+		if err := storage.Delete(ctx, uploadedFile.ThumbnailStorageURL); err != nil {
+			log.Printf("Failed to delete the thumbnail of %s: %v", uploadedFile.FileID, err)
+		}
+	}
+	return storage.Delete(ctx, uploadedFile.StorageURL)
+}
+
+func thumbnailURL(uploadedFile *database.UploadedFile) string {
+	if uploadedFile.ThumbnailStorageURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("/api/v1/files/%s/thumbnail", uploadedFile.FileID)
+}
+
+// GetFileThumbnail serves the chat preview of an image
+func (h *FilesHandler) GetFileThumbnail(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	uploadedFile, _, ok := findAccessibleFile(w, DB, r.PathValue("file_id"), user)
+	if !ok {
+		return
+	}
+	if uploadedFile.ThumbnailStorageURL == "" {
+		http.Error(w, "File has no thumbnail", http.StatusNotFound)
+		return
+	}
+
+	content, err := storage.Open(r.Context(), uploadedFile.ThumbnailStorageURL)
+	if err == storage.ErrNotFound {
+		http.Error(w, "Thumbnail not found in storage", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Unable to read thumbnail", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	name := path.Base(uploadedFile.ThumbnailStorageURL)
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+	w.Header().Set("Cache-Control", "private, max-age=604800, immutable")
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, uploadedFile.CreatedAt, seeker)
+		return
+	}
+	io.Copy(w, content)
+}
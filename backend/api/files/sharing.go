@@ -0,0 +1,220 @@
+package files
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ShareFileRequest struct {
+	// UserUUIDs and the participants of ChatUUID are given access
+	UserUUIDs  []string `json:"user_uuids,omitempty"`
+	ChatUUID   string   `json:"chat_uuid,omitempty"`
+	Permission string   `json:"permission,omitempty"`
+}
+
+type FileGrantResponse struct {
+	UserUUID   string    `json:"user_uuid"`
+	UserName   string    `json:"user_name"`
+	Permission string    `json:"permission"`
+	SharedAt   time.Time `json:"shared_at"`
+}
+
+type FileAccessResponse struct {
+	FileID     string              `json:"file_id"`
+	Permission string              `json:"permission"`
+	Grants     []FileGrantResponse `json:"grants"`
+}
+
+type SharedFileResponse struct {
+	FileUploadResponse
+	OwnerUUID  string    `json:"owner_uuid"`
+	OwnerName  string    `json:"owner_name"`
+	Permission string    `json:"permission"`
+	SharedAt   time.Time `json:"shared_at"`
+}
+
+// ListSharedWithMe lists the files other users shared with the user
+func (h *FilesHandler) ListSharedWithMe(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	shared, err := database.ListFilesSharedWith(DB, user.ID)
+	if err != nil {
+		http.Error(w, "Unable to list shared files", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]SharedFileResponse, 0, len(shared))
+	for _, file := range shared {
+		response = append(response, SharedFileResponse{
+			FileUploadResponse: FileUploadResponse{
+				FileID:       file.FileID,
+				FileName:     file.FileName,
+				Size:         file.Size,
+				MimeType:     file.MIMEType,
+				UploadedAt:   file.CreatedAt,
+				ThumbnailURL: thumbnailURL(&file.UploadedFile),
+			},
+			OwnerUUID:  file.Owner.UUID,
+			OwnerName:  file.Owner.Name,
+			Permission: file.Permission,
+			SharedAt:   file.SharedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetFileAccess lists who a file is shared with, visible to its owner and editors
+func (h *FilesHandler) GetFileAccess(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	uploadedFile, permission, ok := findAccessibleFile(w, DB, r.PathValue("file_id"), user)
+	if !ok {
+		return
+	}
+	if !database.CanShareFile(permission) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	writeFileAccess(w, DB, uploadedFile, permission)
+}
+
+// ShareFile gives users, or all participants of a chat the user takes part
+// in, view or edit access to a file. Sharing again replaces the permission.
+func (h *FilesHandler) ShareFile(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	uploadedFile, permission, ok := findAccessibleFile(w, DB, r.PathValue("file_id"), user)
+	if !ok {
+		return
+	}
+	if !database.CanShareFile(permission) {
+		http.Error(w, "Only the owner and editors can share a file", http.StatusForbidden)
+		return
+	}
+
+	var data ShareFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.Permission == "" {
+		data.Permission = database.FilePermissionView
+	}
+	if !database.IsValidFilePermission(data.Permission) {
+		http.Error(w, "permission must be \"view\" or \"edit\"", http.StatusBadRequest)
+		return
+	}
+	if len(data.UserUUIDs) == 0 && data.ChatUUID == "" {
+		http.Error(w, "user_uuids or chat_uuid required", http.StatusBadRequest)
+		return
+	}
+
+	userIDs := []uint{}
+	for _, userUUID := range data.UserUUIDs {
+		var target database.User
+		if err := DB.Where("uuid = ?", userUUID).First(&target).Error; err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		userIDs = append(userIDs, target.ID)
+	}
+	if data.ChatUUID != "" {
+		var chat database.Chat
+		if err := DB.Scopes(database.ChatParticipantScope(user.ID)).Where("chats.uuid = ?", data.ChatUUID).First(&chat).Error; err != nil {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+		participants, err := database.ListChatParticipants(DB, chat.ID)
+		if err != nil {
+			http.Error(w, "Unable to list chat participants", http.StatusInternalServerError)
+			return
+		}
+		for _, participant := range participants {
+			userIDs = append(userIDs, participant.UserId)
+		}
+	}
+
+	if err := database.ShareFile(DB, uploadedFile, userIDs, data.Permission, time.Now()); err != nil {
+		http.Error(w, "Unable to share file", http.StatusInternalServerError)
+		return
+	}
+
+	writeFileAccess(w, DB, uploadedFile, permission)
+}
+
+// RevokeFileAccess removes the access of a user to a file. The owner and
+// editors can revoke anyone's access, users can always give up their own.
+func (h *FilesHandler) RevokeFileAccess(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	uploadedFile, permission, ok := findAccessibleFile(w, DB, r.PathValue("file_id"), user)
+	if !ok {
+		return
+	}
+
+	var target database.User
+	if err := DB.Where("uuid = ?", r.PathValue("user_uuid")).First(&target).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if target.ID == uploadedFile.OwnerID {
+		http.Error(w, "The owner's access cannot be revoked", http.StatusBadRequest)
+		return
+	}
+	if target.ID != user.ID && !database.CanShareFile(permission) {
+		http.Error(w, "Only the owner and editors can revoke access", http.StatusForbidden)
+		return
+	}
+
+	if err := database.RevokeFileAccess(DB, uploadedFile, target.ID); err != nil {
+		http.Error(w, "Unable to revoke access", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeFileAccess responds with the grants of a file
+func writeFileAccess(w http.ResponseWriter, DB *gorm.DB, uploadedFile *database.UploadedFile, permission string) {
+	grants, err := database.ListFileGrants(DB, uploadedFile)
+	if err != nil {
+		http.Error(w, "Unable to list file access", http.StatusInternalServerError)
+		return
+	}
+
+	response := FileAccessResponse{FileID: uploadedFile.FileID, Permission: permission, Grants: make([]FileGrantResponse, 0, len(grants))}
+	for _, grant := range grants {
+		response.Grants = append(response.Grants, FileGrantResponse{
+			UserUUID:   grant.User.UUID,
+			UserName:   grant.User.Name,
+			Permission: grant.Permission,
+			SharedAt:   grant.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
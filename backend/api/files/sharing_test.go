@@ -0,0 +1,146 @@
+package files
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func newSharingTestRequest(DB *gorm.DB, user *database.User, method string, pathValues map[string]string, body interface{}) *http.Request {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, "/api/v1/files", bytes.NewReader(payload))
+	for key, value := range pathValues {
+		req.SetPathValue(key, value)
+	}
+	ctx := context.WithValue(req.Context(), "db", DB)
+	ctx = context.WithValue(ctx, "user", user)
+	return req.WithContext(ctx)
+}
+
+func TestFileSharingPermissions(t *testing.T) {
+	DB := database.SetupDatabase(database.DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "sharing_test.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+	users := map[string]*database.User{}
+	for _, name := range []string{"owner", "editor", "viewer", "member", "stranger"} {
+		err, user := util.CreateUser(DB, "share-"+name, "Passw0rd!", false)
+		if err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		users[name] = user
+	}
+	file := database.UploadedFile{FileID: "shared-file", FileName: "report.pdf", Size: 4, MIMEType: "application/pdf", StorageURL: "uploads/report.pdf", OwnerID: users["owner"].ID}
+	if err := DB.Create(&file).Error; err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	chat := database.Chat{User1Id: users["owner"].ID, User2Id: users["member"].ID}
+	if err := DB.Create(&chat).Error; err != nil {
+		t.Fatalf("failed to create chat: %v", err)
+	}
+
+	h := &FilesHandler{}
+	serve := func(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	share := func(user *database.User, data ShareFileRequest) *httptest.ResponseRecorder {
+		return serve(h.ShareFile, newSharingTestRequest(DB, user, "POST", map[string]string{"file_id": file.FileID}, data))
+	}
+	info := func(user *database.User) int {
+		return serve(h.GetFileInfo, newSharingTestRequest(DB, user, "GET", map[string]string{"file_id": file.FileID}, nil)).Code
+	}
+	revoke := func(user, target *database.User) int {
+		pathValues := map[string]string{"file_id": file.FileID, "user_uuid": target.UUID}
+		return serve(h.RevokeFileAccess, newSharingTestRequest(DB, user, "DELETE", pathValues, nil)).Code
+	}
+
+	if code := info(users["stranger"]); code != http.StatusForbidden {
+		t.Fatalf("expected users without access to be denied, got %d", code)
+	}
+	if rr := share(users["owner"], ShareFileRequest{UserUUIDs: []string{users["viewer"].UUID}, Permission: "admin"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown permissions to be rejected, got %d", rr.Code)
+	}
+	if rr := share(users["owner"], ShareFileRequest{UserUUIDs: []string{users["editor"].UUID}, Permission: database.FilePermissionEdit}); rr.Code != http.StatusOK {
+		t.Fatalf("expected the owner to share the file, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := share(users["editor"], ShareFileRequest{UserUUIDs: []string{users["viewer"].UUID}}); rr.Code != http.StatusOK {
+		t.Fatalf("expected editors to share the file, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := share(users["viewer"], ShareFileRequest{UserUUIDs: []string{users["stranger"].UUID}}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected viewers to not share the file, got %d", rr.Code)
+	}
+	if rr := share(users["editor"], ShareFileRequest{ChatUUID: chat.UUID}); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected chats the user is not part of to be rejected, got %d", rr.Code)
+	}
+	if rr := share(users["owner"], ShareFileRequest{ChatUUID: chat.UUID}); rr.Code != http.StatusOK {
+		t.Fatalf("expected the file to be shared with the chat, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if code := info(users["member"]); code != http.StatusOK {
+		t.Fatalf("expected chat participants to read the file, got %d", code)
+	}
+
+	rr := serve(h.GetFileAccess, newSharingTestRequest(DB, users["owner"], "GET", map[string]string{"file_id": file.FileID}, nil))
+	var access FileAccessResponse
+	if err := json.NewDecoder(rr.Body).Decode(&access); err != nil {
+		t.Fatalf("failed to decode access: %v", err)
+	}
+	if access.Permission != database.FilePermissionOwner || len(access.Grants) != 3 {
+		t.Fatalf("expected three grants, got %+v", access)
+	}
+	if rr := serve(h.GetFileAccess, newSharingTestRequest(DB, users["viewer"], "GET", map[string]string{"file_id": file.FileID}, nil)); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected viewers to not list the grants, got %d", rr.Code)
+	}
+
+	rr = serve(h.ListSharedWithMe, newSharingTestRequest(DB, users["viewer"], "GET", nil, nil))
+	var shared []SharedFileResponse
+	if err := json.NewDecoder(rr.Body).Decode(&shared); err != nil {
+		t.Fatalf("failed to decode shared files: %v", err)
+	}
+	if len(shared) != 1 || shared[0].FileID != file.FileID || shared[0].Permission != database.FilePermissionView || shared[0].OwnerUUID != users["owner"].UUID {
+		t.Fatalf("expected the file to be shared with the viewer, got %+v", shared)
+	}
+
+	if code := revoke(users["viewer"], users["member"]); code != http.StatusForbidden {
+		t.Fatalf("expected viewers to not revoke other users, got %d", code)
+	}
+	if code := revoke(users["editor"], users["owner"]); code != http.StatusBadRequest {
+		t.Fatalf("expected the owner's access to be kept, got %d", code)
+	}
+	if code := revoke(users["editor"], users["member"]); code != http.StatusNoContent {
+		t.Fatalf("expected editors to revoke access, got %d", code)
+	}
+	if code := revoke(users["viewer"], users["viewer"]); code != http.StatusNoContent {
+		t.Fatalf("expected users to give up their own access, got %d", code)
+	}
+	for _, name := range []string{"member", "viewer"} {
+		if code := info(users[name]); code != http.StatusForbidden {
+			t.Fatalf("expected %s to lose access, got %d", name, code)
+		}
+	}
+
+	// Attaching the file again never downgrades an existing grant
+	if err := database.GrantFileViewAccess(DB, &file, []uint{users["editor"].ID, users["member"].ID}, file.CreatedAt); err != nil {
+		t.Fatalf("failed to grant view access: %v", err)
+	}
+	if permission, _ := database.FilePermissionOf(DB, &file, users["editor"].ID); permission != database.FilePermissionEdit {
+		t.Fatalf("expected the editor to keep edit access, got %q", permission)
+	}
+	if permission, _ := database.FilePermissionOf(DB, &file, users["member"].ID); permission != database.FilePermissionView {
+		t.Fatalf("expected the member to get view access, got %q", permission)
+	}
+}
@@ -0,0 +1,414 @@
+package files
+
+import (
+	"backend/database"
+	"backend/imaging"
+	"backend/server/util"
+	"backend/storage"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,termination,expiration"
+)
+
+// TusUploadsDir keeps the partial files of resumable uploads until their last
+// chunk arrives.
+var TusUploadsDir = "./tus-uploads"
+
+// recordingUploadTypes can only be uploaded resumably, they are usually too
+// large for a single request.
+var recordingUploadTypes = map[string]bool{
+	"audio/mpeg":      true,
+	"audio/mp4":       true,
+	"audio/ogg":       true,
+	"audio/wav":       true,
+	"audio/webm":      true,
+	"video/mp4":       true,
+	"video/quicktime": true,
+	"video/webm":      true,
+}
+
+// setTusHeaders sets the headers every tus response carries
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", TusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// checkTusVersion rejects requests of other tus protocol versions
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		w.Header().Set("Tus-Version", TusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header, comma separated keys
+// each followed by an optional base64 encoded value
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 {
+			continue
+		}
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value of %q", parts[0])
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, nil
+}
+
+// loadTusUpload loads an upload of the requesting user, answering 404 for
+// unknown or foreign uploads and 410 for expired ones
+func loadTusUpload(w http.ResponseWriter, r *http.Request) (*database.TusUpload, bool) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var upload database.TusUpload
+	if err := DB.Where("uuid = ? AND owner_id = ?", r.PathValue("upload_id"), user.ID).First(&upload).Error; err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+	if upload.Expired(time.Now()) {
+		http.Error(w, "Upload expired", http.StatusGone)
+		return nil, false
+	}
+	return &upload, true
+}
+
+// TusOptions describes the tus protocol support and the upload size limit of
+// the requesting user
+func (h *FilesHandler) TusOptions(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	maxSize, err := database.MaxUploadSize(DB, user.ID)
+	if err != nil {
+		http.Error(w, "Unable to get upload limit", http.StatusInternalServerError)
+		return
+	}
+
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", TusVersion)
+	w.Header().Set("Tus-Extension", TusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusCreate creates a resumable upload of Upload-Length bytes. The filename
+// and filetype of the Upload-Metadata header name the file and its type.
+func (h *FilesHandler) TusCreate(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+	setTusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Upload-Length header required", http.StatusBadRequest)
+		return
+	}
+	maxSize, err := database.MaxUploadSize(DB, user.ID)
+	if err != nil {
+		http.Error(w, "Unable to get upload limit", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	if length > maxSize {
+		http.Error(w, fmt.Sprintf("File too large. Maximum size is %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+	mimeType := metadata["filetype"]
+	if mimeType == "" {
+		mimeType = metadata["type"]
+	}
+	if fileName == "" || mimeType == "" {
+		http.Error(w, "filename and filetype metadata required", http.StatusBadRequest)
+		return
+	}
+	if !allowedUploadTypes[mimeType] && !recordingUploadTypes[mimeType] {
+		http.Error(w, "File type not allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if err := os.MkdirAll(TusUploadsDir, 0755); err != nil {
+		http.Error(w, "Unable to create uploads directory", http.StatusInternalServerError)
+		return
+	}
+	partial, err := os.CreateTemp(TusUploadsDir, "upload-*")
+	if err != nil {
+		http.Error(w, "Unable to create upload", http.StatusInternalServerError)
+		return
+	}
+	partial.Close()
+
+	upload := database.TusUpload{
+		OwnerId:     user.ID,
+		FileName:    filepath.Base(fileName),
+		MIMEType:    mimeType,
+		Length:      length,
+		PartialPath: partial.Name(),
+		ExpiresAt:   time.Now().Add(database.TusUploadTTL),
+	}
+	if err := DB.Create(&upload).Error; err != nil {
+		os.Remove(partial.Name())
+		http.Error(w, "Unable to save upload record", http.StatusInternalServerError)
+		return
+	}
+
+	// Empty files are complete right away
+	if length == 0 {
+		if err := h.completeTusUpload(r, &upload); err != nil {
+			log.Printf("Failed to complete upload %s: %v", upload.UUID, err)
+			if errors.Is(err, errInvalidImage) {
+				http.Error(w, "Invalid image", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Unable to save file", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Location", "/api/v1/uploads/"+upload.UUID)
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// TusHead returns how many bytes of an upload were received
+func (h *FilesHandler) TusHead(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	upload, ok := loadTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// TusPatch appends a chunk at Upload-Offset. The bytes received before a
+// connection drops are kept, so clients resume from the offset HEAD returns.
+// With the last chunk the file is moved to storage and can be attached.
+func (h *FilesHandler) TusPatch(w http.ResponseWriter, r *http.Request) {
+	DB, err := util.GetDB(r)
+	if err != nil {
+		http.Error(w, "Unable to get database", http.StatusBadRequest)
+		return
+	}
+	setTusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	upload, ok := loadTusUpload(w, r)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Upload-Offset header required", http.StatusBadRequest)
+		return
+	}
+	if offset != upload.Offset || upload.CompletedAt != nil {
+		http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+
+	partial, err := os.OpenFile(upload.PartialPath, os.O_WRONLY, 0644)
+	if err != nil {
+		http.Error(w, "Upload is not available on this server", http.StatusNotFound)
+		return
+	}
+	remaining := upload.Length - upload.Offset
+	written, copyErr := io.Copy(io.NewOffsetWriter(partial, upload.Offset), io.LimitReader(r.Body, remaining))
+	if copyErr == nil && written == remaining {
+		// A chunk must not exceed the declared length
+		var extra [1]byte
+		if n, _ := r.Body.Read(extra[:]); n > 0 {
+			partial.Truncate(upload.Offset)
+			partial.Close()
+			http.Error(w, "Chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	if err := partial.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	if written > 0 {
+		if err := database.AdvanceTusUpload(DB, upload, upload.Offset+written, time.Now()); errors.Is(err, database.ErrTusOffsetMismatch) {
+			http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "Unable to save upload progress", http.StatusInternalServerError)
+			return
+		}
+	}
+	if copyErr != nil {
+		// The client went away, it resumes from the stored offset
+		http.Error(w, "Unable to read chunk", http.StatusBadRequest)
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		if err := h.completeTusUpload(r, upload); err != nil {
+			log.Printf("Failed to complete upload %s: %v", upload.UUID, err)
+			if errors.Is(err, errInvalidImage) {
+				http.Error(w, "Invalid image", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Unable to save file", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusTerminate cancels an upload and removes what was received of it
+func (h *FilesHandler) TusTerminate(w http.ResponseWriter, r *http.Request) {
+	DB, err := util.GetDB(r)
+	if err != nil {
+		http.Error(w, "Unable to get database", http.StatusBadRequest)
+		return
+	}
+	setTusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+	upload, ok := loadTusUpload(w, r)
+	if !ok {
+		return
+	}
+	if upload.CompletedAt != nil {
+		http.Error(w, "Upload is complete, delete the file instead", http.StatusConflict)
+		return
+	}
+
+	if err := DB.Unscoped().Delete(upload).Error; err != nil {
+		http.Error(w, "Unable to delete upload", http.StatusInternalServerError)
+		return
+	}
+	if err := os.Remove(upload.PartialPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove partial upload %s: %v", upload.UUID, err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// completeTusUpload moves a fully received upload to storage and records it
+// as an uploaded file
+func (h *FilesHandler) completeTusUpload(r *http.Request, upload *database.TusUpload) error {
+	DB, err := util.GetDB(r)
+	if err != nil {
+		return err
+	}
+	partialPath := upload.PartialPath
+	partial, err := os.Open(partialPath)
+	if err != nil {
+		return err
+	}
+	defer partial.Close()
+
+	// Images lose their metadata, those small enough to process in memory also
+	// get a thumbnail
+	var content io.Reader = partial
+	size := upload.Length
+	var processed *processedImage
+	if imaging.Supported(upload.MIMEType) && upload.Length <= maxProcessedImageSize {
+		data, err := io.ReadAll(partial)
+		if err != nil {
+			return err
+		}
+		result, err := processImage(upload.UUID, data, upload.MIMEType)
+		if err != nil {
+			return err
+		}
+		processed = &result
+		content, size = bytes.NewReader(result.data), int64(len(result.data))
+	} else if imaging.Supported(upload.MIMEType) {
+		stripped, strippedSize, err := stripImageFile(partial, filepath.Dir(partialPath), upload.MIMEType)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(stripped.Name())
+		defer stripped.Close()
+		content, size = stripped, strippedSize
+	}
+
+	storageURL, err := storage.Default().Put(r.Context(), upload.UUID, content, size, upload.MIMEType)
+	if err != nil {
+		return err
+	}
+	uploadedFile, err := database.CompleteTusUpload(DB, upload, storageURL, time.Now())
+	if err != nil {
+		storage.Delete(r.Context(), storageURL)
+		return err
+	}
+	if size != upload.Length || processed != nil {
+		updates := map[string]interface{}{"size": size}
+		if processed != nil {
+			if thumbnailStorageURL := storeThumbnail(r.Context(), upload.UUID, *processed); thumbnailStorageURL != "" {
+				updates["thumbnail_storage_url"] = thumbnailStorageURL
+			}
+		}
+		if err := DB.Model(uploadedFile).Updates(updates).Error; err != nil {
+			log.Printf("Failed to record the processed image %s: %v", upload.UUID, err)
+		}
+	}
+	partial.Close()
+	if err := os.Remove(partialPath); err != nil {
+		log.Printf("Failed to remove partial upload %s: %v", upload.UUID, err)
+	}
+	return nil
+}
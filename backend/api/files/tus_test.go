@@ -0,0 +1,152 @@
+package files
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"backend/storage"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func newTusTestRequest(DB *gorm.DB, user *database.User, method, uploadID string, headers map[string]string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, "/api/v1/uploads", bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", TusVersion)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if uploadID != "" {
+		req.SetPathValue("upload_id", uploadID)
+	}
+	ctx := context.WithValue(req.Context(), "db", DB)
+	ctx = context.WithValue(ctx, "user", user)
+	return req.WithContext(ctx)
+}
+
+func tusMetadata(name, mimeType string) string {
+	return "filename " + base64.StdEncoding.EncodeToString([]byte(name)) + ",filetype " + base64.StdEncoding.EncodeToString([]byte(mimeType))
+}
+
+func TestTusUploadResumesAndCompletes(t *testing.T) {
+	t.Chdir(t.TempDir())
+	DB := database.SetupDatabase(database.DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "tus_test.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+	err, owner := util.CreateUser(DB, "tus-owner", "Passw0rd!", false)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	err, other := util.CreateUser(DB, "tus-other", "Passw0rd!", false)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if _, err := database.SetUploadLimit(DB, owner.ID, 16); err != nil {
+		t.Fatalf("failed to set upload limit: %v", err)
+	}
+
+	h := &FilesHandler{}
+	serve := func(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	create := func(length, metadata string) *httptest.ResponseRecorder {
+		return serve(h.TusCreate, newTusTestRequest(DB, owner, "POST", "", map[string]string{"Upload-Length": length, "Upload-Metadata": metadata}, nil))
+	}
+	patch := func(user *database.User, uploadID, offset string, chunk string) *httptest.ResponseRecorder {
+		headers := map[string]string{"Upload-Offset": offset, "Content-Type": "application/offset+octet-stream"}
+		return serve(h.TusPatch, newTusTestRequest(DB, user, "PATCH", uploadID, headers, []byte(chunk)))
+	}
+
+	rr := serve(h.TusOptions, newTusTestRequest(DB, owner, "OPTIONS", "", nil, nil))
+	if rr.Header().Get("Tus-Max-Size") != "16" || !strings.Contains(rr.Header().Get("Tus-Extension"), "termination") {
+		t.Fatalf("expected the per-user limit and extensions, got %v", rr.Header())
+	}
+	if rr := create("17", tusMetadata("big.pdf", "application/pdf")); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected uploads above the user limit to be rejected, got %d", rr.Code)
+	}
+	if rr := create("10", tusMetadata("tool.exe", "application/x-msdownload")); rr.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected disallowed types to be rejected, got %d", rr.Code)
+	}
+	req := newTusTestRequest(DB, owner, "POST", "", map[string]string{"Upload-Length": "10", "Upload-Metadata": tusMetadata("a.mp3", "audio/mpeg")}, nil)
+	req.Header.Set("Tus-Resumable", "0.2.2")
+	if rr := serve(h.TusCreate, req); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected other protocol versions to be rejected, got %d", rr.Code)
+	}
+
+	rr = create("16", tusMetadata("voice memo.mp3", "audio/mpeg"))
+	if rr.Code != http.StatusCreated || rr.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("expected the upload to be created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	uploadID := strings.TrimPrefix(rr.Header().Get("Location"), "/api/v1/uploads/")
+
+	if rr := patch(owner, uploadID, "0", "first-chu"); rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != "9" {
+		t.Fatalf("expected the first chunk to be stored, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := patch(owner, uploadID, "0", "first-chu"); rr.Code != http.StatusConflict {
+		t.Fatalf("expected a stale offset to conflict, got %d", rr.Code)
+	}
+	if rr := patch(other, uploadID, "9", "nk-rest"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected other users to not see the upload, got %d", rr.Code)
+	}
+	if rr := patch(owner, uploadID, "9", "nk-rest-and-more"); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected chunks beyond the length to be rejected, got %d", rr.Code)
+	}
+
+	rr = serve(h.TusHead, newTusTestRequest(DB, owner, "HEAD", uploadID, nil, nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Upload-Offset") != "9" || rr.Header().Get("Upload-Length") != "16" {
+		t.Fatalf("expected to resume from offset 9, got %d %v", rr.Code, rr.Header())
+	}
+	if rr := patch(owner, uploadID, "9", "nk-rest"); rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != "16" {
+		t.Fatalf("expected the last chunk to complete the upload, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var file database.UploadedFile
+	if err := DB.Where("file_id = ?", uploadID).First(&file).Error; err != nil {
+		t.Fatalf("expected the completed upload to be an uploaded file: %v", err)
+	}
+	if file.OwnerID != owner.ID || file.FileName != "voice memo.mp3" || file.MIMEType != "audio/mpeg" || file.Size != 16 {
+		t.Fatalf("unexpected uploaded file %+v", file)
+	}
+	data, err := storage.ReadAll(context.Background(), file.StorageURL)
+	if err != nil || string(data) != "first-chunk-rest" {
+		t.Fatalf("expected the stored file to hold both chunks, got %q (%v)", data, err)
+	}
+	if rr := serve(h.TusTerminate, newTusTestRequest(DB, owner, "DELETE", uploadID, nil, nil)); rr.Code != http.StatusConflict {
+		t.Fatalf("expected completed uploads to not be terminated, got %d", rr.Code)
+	}
+
+	// Terminated and expired uploads are gone
+	rr = create("4", tusMetadata("notes.txt", "text/plain"))
+	terminated := strings.TrimPrefix(rr.Header().Get("Location"), "/api/v1/uploads/")
+	if rr := serve(h.TusTerminate, newTusTestRequest(DB, owner, "DELETE", terminated, nil, nil)); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected the upload to be terminated, got %d", rr.Code)
+	}
+	if rr := serve(h.TusHead, newTusTestRequest(DB, owner, "HEAD", terminated, nil, nil)); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected a terminated upload to be gone, got %d", rr.Code)
+	}
+
+	rr = create("4", tusMetadata("notes.txt", "text/plain"))
+	stale := strings.TrimPrefix(rr.Header().Get("Location"), "/api/v1/uploads/")
+	if err := DB.Model(&database.TusUpload{}).Where("uuid = ?", stale).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("failed to expire upload: %v", err)
+	}
+	if rr := patch(owner, stale, "0", "note"); rr.Code != http.StatusGone {
+		t.Fatalf("expected an expired upload to be gone, got %d", rr.Code)
+	}
+	expired, err := database.ListExpiredTusUploads(DB, time.Now())
+	if err != nil || len(expired) != 1 || expired[0].UUID != stale {
+		t.Fatalf("expected only the stale upload to be expired, got %+v (%v)", expired, err)
+	}
+}
@@ -149,6 +149,9 @@ func validateConfiguration(modelID string, raw json.RawMessage) (json.RawMessage
 			return nil, errors.New("configuration.model must match model_id")
 		}
 	}
+	if _, err := database.ModelPricingFromConfig(cfg); err != nil {
+		return nil, err
+	}
 	cfg["model"] = modelID
 	normalized, err := json.Marshal(cfg)
 	if err != nil {
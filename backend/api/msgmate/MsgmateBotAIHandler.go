@@ -5,12 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 	"regexp"
 	"strings"
 	"time"
 
+	"backend/api/chats"
 	wsapi "backend/api/websocket"
+	"backend/database"
 	client "github.com/msgmate-io/go-client-integration/goclient"
 )
 
@@ -125,7 +126,7 @@ func (aih *AIHandlerImpl) GenerateResponse(ctx context.Context, message wsapi.Ne
 		}
 	}
 
-	endpoint := mapGetOrDefault[string](configMap, "endpoint", "http://localai:8080")
+	endpoint := mapGetOrDefault[string](configMap, "endpoint", localAIEndpoint)
 	backend := mapGetOrDefault[string](configMap, "backend", "deepinfra")
 	model := mapGetOrDefault[string](configMap, "model", "meta-llama-3.1-8b-instruct")
 	reasoning := mapGetOrDefault[bool](configMap, "reasoning", false)
@@ -146,30 +147,9 @@ func (aih *AIHandlerImpl) GenerateResponse(ctx context.Context, message wsapi.Ne
 		toolCallMaxFailed = int64(DefaultToolCallMaxFailed)
 	}
 
-	if backend == "litellm" {
-		litellmHost := strings.TrimSpace(os.Getenv("LITELLM_API_HOST"))
-		if litellmHost != "" {
-			endpoint = litellmHost
-		}
-		endpoint = strings.TrimSpace(endpoint)
-		endpoint = strings.TrimRight(endpoint, "/")
-		if endpoint == "" {
-			return fmt.Errorf("missing API host for litellm provider")
-		}
-	}
-
-	if backend == "anthropic" {
-		anthropicHost := strings.TrimSpace(os.Getenv("ANTHROPIC_API_HOST"))
-		if anthropicHost != "" {
-			endpoint = anthropicHost
-		} else if strings.TrimSpace(endpoint) == "" {
-			endpoint = "https://api.anthropic.com/v1"
-		}
-		endpoint = strings.TrimSpace(endpoint)
-		endpoint = strings.TrimRight(endpoint, "/")
-		if endpoint == "" {
-			return fmt.Errorf("missing API host for anthropic provider")
-		}
+	endpoint, err = ProviderForBackend(backend).ResolveEndpoint(endpoint)
+	if err != nil {
+		return err
 	}
 
 	// Check for skip-core tag
@@ -178,15 +158,16 @@ func (aih *AIHandlerImpl) GenerateResponse(ctx context.Context, message wsapi.Ne
 		return aih.executeToolsOnly(ctx, message, tools, toolInit, dynamicTools, mcpTools)
 	}
 
-	// Load the past messages
-	err, paginatedMessages := aih.botContext.Client.GetMessages(message.Content.ChatUUID, 1, context)
+	// Load the past messages, with a database they are trimmed to the model's token budget below
+	historyLimit := context
+	if aih.botContext.DB != nil && historyLimit < historyFetchLimit {
+		historyLimit = historyFetchLimit
+	}
+	err, paginatedMessages := aih.botContext.Client.GetMessages(message.Content.ChatUUID, 1, historyLimit)
 	if err != nil {
 		return err
 	}
 
-	// Build OpenAI messages
-	openAiMessages := aih.buildOpenAIMessages(&paginatedMessages, message, systemPrompt, backend)
-
 	// Setup tools
 	toolsData, toolMap, interactionStartTools, interactionCompleteTools := aih.setupTools(tools, toolInit, dynamicTools, mcpTools)
 
@@ -201,24 +182,78 @@ func (aih *AIHandlerImpl) GenerateResponse(ctx context.Context, message wsapi.Ne
 		log.Printf("Found interaction_complete tools: %v", interactionCompleteTools)
 	}
 
-	// Stream chat completion
-	chunks, usage, toolCalls, errs := streamChatCompletion(
-		endpoint,
-		model,
-		backend,
-		int(toolCallMaxTotal),
-		int(toolCallMaxFailed),
-		openAiMessages,
-		toolsData,
-		toolMap,
-		aih.botContext.Client.GetApiKey(backend),
-		interactionStartTools,
-		interactionCompleteTools,
-		GetGlobalMsgmateHandler(),
-	)
+	options := CompletionOptions{
+		Reasoning:      reasoning,
+		MaxTokens:      int(mapGetOrDefault[float64](configMap, "max_tokens", 0)),
+		ThinkingBudget: int(mapGetOrDefault[float64](configMap, "thinking_budget", 0)),
+	}
+	attempts := aih.resolveModelAttempts(configMap, ModelAttempt{Backend: backend, Endpoint: endpoint, Model: model})
+	systemPrompt, paginatedMessages = aih.fitHistory(ctx, message, configMap, attempts[0], systemPrompt, toolsData, options, paginatedMessages)
+	answered := &answeredModel{}
+	// Attachments are converted per backend and image size, so fallbacks to another provider rebuild the history
+	messagesByBackend := map[string][]map[string]interface{}{}
+
+	// Stream chat completion, falling back to the next model on failures
+	chunks, usage, toolCalls, errs := streamWithFallback(ctx, attempts, retryPolicyFromConfig(configMap), answered, func(attempt ModelAttempt, firstTry bool) (<-chan string, <-chan *TokenUsage, <-chan ToolCall, <-chan error) {
+		maxImageDimension := aih.maxImageDimension(configMap, attempt)
+		messagesKey := fmt.Sprintf("%s/%d", attempt.Backend, maxImageDimension)
+		openAiMessages, ok := messagesByBackend[messagesKey]
+		if !ok {
+			openAiMessages = aih.buildOpenAIMessages(&paginatedMessages, message, systemPrompt, attempt.Backend, maxImageDimension)
+			messagesByBackend[messagesKey] = openAiMessages
+		}
+		// interaction_start tools already ran with the first try
+		startTools := interactionStartTools
+		if !firstTry {
+			startTools = nil
+		}
+		return streamChatCompletion(
+			ctx,
+			attempt.Endpoint,
+			attempt.Model,
+			attempt.Backend,
+			int(toolCallMaxTotal),
+			int(toolCallMaxFailed),
+			openAiMessages,
+			toolsData,
+			toolMap,
+			aih.providerAPIKey(attempt.Backend),
+			options,
+			startTools,
+			interactionCompleteTools,
+			GetGlobalMsgmateHandler(),
+		)
+	})
 
 	// Process the streaming response
-	return aih.processStreamingResponse(ctx, message, chunks, usage, toolCalls, errs, startTime, thinkingTime, thinkingStart, reasoning)
+	return aih.processStreamingResponse(ctx, message, chunks, usage, toolCalls, errs, startTime, thinkingTime, thinkingStart, reasoning, answered)
+}
+
+// providerAPIKey returns the API key for backend. Keys of providers the bot
+// client does not know about come from the runtime config.
+func (aih *AIHandlerImpl) providerAPIKey(backend string) string {
+	if apiKey := aih.botContext.Client.GetApiKey(backend); apiKey != "" {
+		return apiKey
+	}
+	return database.ProviderAPIKey(backend)
+}
+
+// maxImageDimension returns the longest side images are downscaled to for a
+// model: the chat's "max_image_dimension", then the model config's, then the
+// provider's default.
+func (aih *AIHandlerImpl) maxImageDimension(configMap map[string]interface{}, attempt ModelAttempt) int {
+	dimension := int(mapGetOrDefault[float64](configMap, "max_image_dimension", 0))
+	if dimension <= 0 && aih.botContext.DB != nil {
+		resolved, err := database.ResolveModelMaxImageDimension(aih.botContext.DB, attempt.ModelConfigUUID, attempt.Model)
+		if err != nil {
+			log.Printf("Failed to resolve the max image dimension of %s: %v", attempt.Model, err)
+		}
+		dimension = resolved
+	}
+	if dimension <= 0 {
+		dimension = providerMaxImageDimension(attempt.Backend)
+	}
+	return dimension
 }
 
 // ProcessCommand processes bot commands (like /pong, /loop)
@@ -246,7 +281,7 @@ func (aih *AIHandlerImpl) ProcessCommand(ctx context.Context, command string, me
 }
 
 // buildOpenAIMessages builds the OpenAI messages array from chat history
-func (aih *AIHandlerImpl) buildOpenAIMessages(paginatedMessages *client.PaginatedMessages, message wsapi.NewMessage, systemPrompt, backend string) []map[string]interface{} {
+func (aih *AIHandlerImpl) buildOpenAIMessages(paginatedMessages *client.PaginatedMessages, message wsapi.NewMessage, systemPrompt, backend string, maxImageDimension int) []map[string]interface{} {
 	openAiMessages := []map[string]interface{}{}
 	currentMessageIncluded := false
 
@@ -356,8 +391,9 @@ func (aih *AIHandlerImpl) buildOpenAIMessages(paginatedMessages *client.Paginate
 				openAiMessages = append(openAiMessages, map[string]interface{}{"role": "assistant", "content": msg.Text})
 			}
 		} else {
-			// Handle user messages with potential attachments
-			contentArray := aih.processMessageAttachments(msg.Text, attachments, backend)
+			// Handle user messages with potential attachments, replies include the quoted message
+			text := withReplyQuote(msg.Text, msg.MetaData, aih.botContext.Client.User.UUID)
+			contentArray := aih.processMessageAttachments(text, attachments, backend, maxImageDimension)
 			openAiMessages = append(openAiMessages, map[string]interface{}{
 				"role":    "user",
 				"content": contentArray,
@@ -381,7 +417,8 @@ func (aih *AIHandlerImpl) buildOpenAIMessages(paginatedMessages *client.Paginate
 			}
 		}
 		if strings.TrimSpace(message.Content.Text) != "" || len(attachments) > 0 {
-			contentArray := aih.processCurrentMessageAttachments(message.Content.Text, attachments, backend)
+			text := withReplyQuote(message.Content.Text, message.Content.MetaData, aih.botContext.Client.User.UUID)
+			contentArray := aih.processCurrentMessageAttachments(text, attachments, backend, maxImageDimension)
 			openAiMessages = append(openAiMessages, map[string]interface{}{
 				"role":    "user",
 				"content": contentArray,
@@ -393,7 +430,7 @@ func (aih *AIHandlerImpl) buildOpenAIMessages(paginatedMessages *client.Paginate
 }
 
 // processMessageAttachments processes attachments for historical messages
-func (aih *AIHandlerImpl) processMessageAttachments(text string, attachments []interface{}, backend string) interface{} {
+func (aih *AIHandlerImpl) processMessageAttachments(text string, attachments []interface{}, backend string, maxImageDimension int) interface{} {
 	if len(attachments) > 0 {
 		// Create content array with text and file references
 		contentArray := []map[string]interface{}{}
@@ -408,6 +445,7 @@ func (aih *AIHandlerImpl) processMessageAttachments(text string, attachments []i
 
 		// Process file attachments
 		fileHandler := NewFileHandler(aih.botContext)
+		fileHandler.MaxImageDimension = maxImageDimension
 		processedAttachments, err := fileHandler.ProcessAttachments(attachments, backend)
 		if err != nil {
 			log.Printf("Error processing attachments: %v", err)
@@ -422,7 +460,7 @@ func (aih *AIHandlerImpl) processMessageAttachments(text string, attachments []i
 }
 
 // processCurrentMessageAttachments processes attachments for the current message
-func (aih *AIHandlerImpl) processCurrentMessageAttachments(text string, attachments []interface{}, backend string) interface{} {
+func (aih *AIHandlerImpl) processCurrentMessageAttachments(text string, attachments []interface{}, backend string, maxImageDimension int) interface{} {
 	if len(attachments) > 0 {
 		// Create content array with text and file references
 		contentArray := []map[string]interface{}{}
@@ -437,6 +475,7 @@ func (aih *AIHandlerImpl) processCurrentMessageAttachments(text string, attachme
 
 		// Process file attachments
 		fileHandler := NewFileHandler(aih.botContext)
+		fileHandler.MaxImageDimension = maxImageDimension
 		processedAttachments, err := fileHandler.ProcessAttachments(attachments, backend)
 		if err != nil {
 			log.Printf("Error processing attachments: %v", err)
@@ -534,11 +573,7 @@ func (aih *AIHandlerImpl) setupTools(tools []string, toolInit map[string]interfa
 }
 
 // processStreamingResponse processes the streaming response from the AI
-func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message wsapi.NewMessage, chunks <-chan string, usage <-chan *struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
-}, toolCalls <-chan ToolCall, errs <-chan error, startTime time.Time, thinkingTime time.Duration, thinkingStart time.Time, reasoning bool) error {
+func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message wsapi.NewMessage, chunks <-chan string, usage <-chan *TokenUsage, toolCalls <-chan ToolCall, errs <-chan error, startTime time.Time, thinkingTime time.Duration, thinkingStart time.Time, reasoning bool, answered *answeredModel) error {
 	var allToolCalls []interface{}
 	var fullText, thoughtBuffer, currentThoughtStep strings.Builder
 	var reasoningEntries []string
@@ -549,16 +584,16 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 	var hadToolCall bool
 	var currentBuffer strings.Builder
 	partialSessionID := fmt.Sprintf("%s-%d", message.Content.ChatUUID, time.Now().UnixNano())
+	receivers := aih.partialReceivers(message)
 	thinkTagPattern := regexp.MustCompile(`(?is)<think>(.*?)</think>`)
-	var tokenUsage *struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	}
+	var tokenUsage *TokenUsage
+	// Tool loops report usage once per provider round, the reply costs their sum
+	var replyCost float64
+	var replyPriced bool
 
-	aih.botContext.WSHandler.MessageHandler.SendMessage(
+	aih.botContext.WSHandler.MessageHandler.SendMessageToMany(
 		aih.botContext.WSHandler,
-		message.Content.SenderUUID,
+		receivers,
 		aih.botContext.WSHandler.MessageHandler.StartPartialMessage(
 			message.Content.ChatUUID,
 			message.Content.SenderUUID,
@@ -566,14 +601,15 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 		),
 	)
 
-	finalizePartial := func() {
-		aih.botContext.WSHandler.MessageHandler.SendMessage(
+	finalizePartial := func(state string) {
+		aih.botContext.WSHandler.MessageHandler.SendMessageToMany(
 			aih.botContext.WSHandler,
-			message.Content.SenderUUID,
-			aih.botContext.WSHandler.MessageHandler.EndPartialMessage(
+			receivers,
+			aih.botContext.WSHandler.MessageHandler.EndPartialMessageWithState(
 				message.Content.ChatUUID,
 				message.Content.SenderUUID,
 				partialSessionID,
+				state,
 			),
 		)
 	}
@@ -668,7 +704,13 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 		// If we're still thinking when finishing, add the final thinking time
 		totalTime := time.Since(startTime)
 
-		finalizePartial()
+		state := ReplyStateFinished
+		if isCancelled {
+			state = ReplyStateInterrupted
+		} else if streamErr != nil {
+			state = ReplyStateFailed
+		}
+		finalizePartial(state)
 
 		text, extractedThoughts := extractThinkSections(fullText.String())
 		appendThoughtEntries(extractedThoughts)
@@ -695,6 +737,7 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 			"total_time": totalTime.Round(time.Millisecond).String(),
 			"cancelled":  isCancelled,
 			"finished":   true,
+			"state":      state,
 		}
 		if streamErr != nil {
 			metadata["error"] = true
@@ -707,6 +750,12 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 		if tokenUsage != nil {
 			metadata["token_usage"] = tokenUsage
 		}
+		if replyPriced {
+			metadata["cost"] = replyCost
+		}
+		if answeredBy := answered.metadata(); answeredBy != nil {
+			metadata["answered_by"] = answeredBy
+		}
 		if reasoning {
 			metadata["thinking_time"] = thinkingTime.Round(time.Millisecond).String()
 			if len(thinkingSteps) > 0 {
@@ -745,9 +794,9 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 		totalTime := time.Since(startTime)
 		if reasoning {
 			thinkingElapsed := currentThinkingDuration()
-			aih.botContext.WSHandler.MessageHandler.SendMessage(
+			aih.botContext.WSHandler.MessageHandler.SendMessageToMany(
 				aih.botContext.WSHandler,
-				message.Content.SenderUUID,
+				receivers,
 				aih.botContext.WSHandler.MessageHandler.NewPartialMessage(
 					message.Content.ChatUUID,
 					message.Content.SenderUUID,
@@ -766,9 +815,9 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 			return
 		}
 
-		aih.botContext.WSHandler.MessageHandler.SendMessage(
+		aih.botContext.WSHandler.MessageHandler.SendMessageToMany(
 			aih.botContext.WSHandler,
-			message.Content.SenderUUID,
+			receivers,
 			aih.botContext.WSHandler.MessageHandler.NewPartialMessage(
 				message.Content.ChatUUID,
 				message.Content.SenderUUID,
@@ -793,9 +842,9 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 		currentThoughtStep.WriteString(chunk)
 		totalTime := time.Since(startTime)
 		thinkingElapsed := currentThinkingDuration()
-		aih.botContext.WSHandler.MessageHandler.SendMessage(
+		aih.botContext.WSHandler.MessageHandler.SendMessageToMany(
 			aih.botContext.WSHandler,
-			message.Content.SenderUUID,
+			receivers,
 			aih.botContext.WSHandler.MessageHandler.NewPartialMessage(
 				message.Content.ChatUUID,
 				message.Content.SenderUUID,
@@ -887,6 +936,10 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 				usage = nil
 			} else {
 				tokenUsage = usageInfo
+				if cost, priced := aih.recordTokenUsage(message, usageInfo, answered); priced {
+					replyCost += cost
+					replyPriced = true
+				}
 			}
 		case toolCall, ok := <-toolCalls:
 			if !ok {
@@ -966,9 +1019,9 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 				if len(confirmableActions) > 0 {
 					partialMeta["confirmable_actions"] = confirmableActions
 				}
-				aih.botContext.WSHandler.MessageHandler.SendMessage(
+				aih.botContext.WSHandler.MessageHandler.SendMessageToMany(
 					aih.botContext.WSHandler,
-					message.Content.SenderUUID,
+					receivers,
 					aih.botContext.WSHandler.MessageHandler.NewPartialMessage(
 						message.Content.ChatUUID,
 						message.Content.SenderUUID,
@@ -982,6 +1035,12 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 				)
 			}
 		case err, ok := <-errs:
+			if ok && err != nil && ctx.Err() != nil {
+				// The stream stopped because the reply was interrupted, not because it failed.
+				log.Printf("Cancellation received. Stopping response for chat %s\n", message.Content.ChatUUID)
+				sendFinalMessage(true, nil)
+				return ctx.Err()
+			}
 			if ok && err != nil {
 				log.Printf("streamChatCompletion error: %v", err)
 				sendFinalMessage(false, err)
@@ -1019,6 +1078,20 @@ func (aih *AIHandlerImpl) processStreamingResponse(ctx context.Context, message
 }
 
 // hasSkipCoreTag checks if the tags contain "skip-core"
+// partialReceivers returns who sees the partial messages of a reply: every
+// human participant of the chat, or the sender of message when the bot runs
+// without a database.
+func (aih *AIHandlerImpl) partialReceivers(message wsapi.NewMessage) []string {
+	if aih.botContext.DB != nil {
+		receivers, err := chats.HumanRecipientUUIDs(aih.botContext.DB, message.Content.ChatUUID, aih.botContext.BotUser)
+		if err == nil {
+			return receivers
+		}
+		log.Printf("Failed to resolve the participants of chat %s: %v", message.Content.ChatUUID, err)
+	}
+	return []string{message.Content.SenderUUID}
+}
+
 func (aih *AIHandlerImpl) hasSkipCoreTag(tags []string) bool {
 	for _, tag := range tags {
 		if tag == "skip-core" {
@@ -1032,6 +1105,7 @@ func (aih *AIHandlerImpl) hasSkipCoreTag(tags []string) bool {
 func (aih *AIHandlerImpl) executeToolsOnly(ctx context.Context, message wsapi.NewMessage, tools []string, toolInit map[string]interface{}, dynamicTools map[string]interface{}, mcpTools map[string]interface{}) error {
 	startTime := time.Now()
 	partialSessionID := fmt.Sprintf("%s-skip-core-%d", message.Content.ChatUUID, time.Now().UnixNano())
+	receivers := aih.partialReceivers(message)
 
 	// Setup tools
 	_, toolMap, interactionStartTools, interactionCompleteTools := aih.setupTools(tools, toolInit, dynamicTools, mcpTools)
@@ -1048,9 +1122,9 @@ func (aih *AIHandlerImpl) executeToolsOnly(ctx context.Context, message wsapi.Ne
 	}
 
 	// Send start message
-	aih.botContext.WSHandler.MessageHandler.SendMessage(
+	aih.botContext.WSHandler.MessageHandler.SendMessageToMany(
 		aih.botContext.WSHandler,
-		message.Content.SenderUUID,
+		receivers,
 		aih.botContext.WSHandler.MessageHandler.StartPartialMessage(
 			message.Content.ChatUUID,
 			message.Content.SenderUUID,
@@ -1073,9 +1147,9 @@ func (aih *AIHandlerImpl) executeToolsOnly(ctx context.Context, message wsapi.Ne
 	}
 
 	// Send end message
-	aih.botContext.WSHandler.MessageHandler.SendMessage(
+	aih.botContext.WSHandler.MessageHandler.SendMessageToMany(
 		aih.botContext.WSHandler,
-		message.Content.SenderUUID,
+		receivers,
 		aih.botContext.WSHandler.MessageHandler.EndPartialMessage(
 			message.Content.ChatUUID,
 			message.Content.SenderUUID,
@@ -1101,7 +1175,7 @@ func (aih *AIHandlerImpl) executeToolsOnly(ctx context.Context, message wsapi.Ne
 }
 
 // executeTool executes a single tool
-func (aih *AIHandlerImpl) executeTool(_ context.Context, toolName string, toolMap map[string]Tool, message wsapi.NewMessage, partialSessionID string) error {
+func (aih *AIHandlerImpl) executeTool(ctx context.Context, toolName string, toolMap map[string]Tool, message wsapi.NewMessage, partialSessionID string) error {
 	// Find the tool in the tool map
 	tool, exists := toolMap[toolName]
 	if !exists {
@@ -1117,19 +1191,20 @@ func (aih *AIHandlerImpl) executeTool(_ context.Context, toolName string, toolMa
 	}
 
 	// Execute the tool
-	result, err := tool.RunTool(toolCall.ToolInput)
+	result, err := runToolWithContext(ctx, tool, toolCall.ToolInput)
 	if err != nil {
 		return fmt.Errorf("error executing tool %s: %w", toolName, err)
 	}
 
 	// Update the tool call with the result
 	toolCall.Result = result
+	receivers := aih.partialReceivers(message)
 
 	// Send tool execution message
 	totalTime := time.Since(time.Now())
-	aih.botContext.WSHandler.MessageHandler.SendMessage(
+	aih.botContext.WSHandler.MessageHandler.SendMessageToMany(
 		aih.botContext.WSHandler,
-		message.Content.SenderUUID,
+		receivers,
 		aih.botContext.WSHandler.MessageHandler.NewPartialMessage(
 			message.Content.ChatUUID,
 			message.Content.SenderUUID,
@@ -8,10 +8,18 @@ import (
 	"sync"
 
 	client "github.com/msgmate-io/go-client-integration/goclient"
+	"gorm.io/gorm"
 )
 
 var ErrResponseAlreadySent = errors.New("ai response already sent")
 
+// Reply states reported by end_partial_message events and the final message metadata.
+const (
+	ReplyStateFinished    = "finished"
+	ReplyStateInterrupted = "interrupted"
+	ReplyStateFailed      = "failed"
+)
+
 // BotConfig represents the configuration for a Msgmate bot
 type BotConfig struct {
 	Host     string
@@ -27,6 +35,8 @@ type BotContext struct {
 	WSHandler    *wsapi.WebSocketHandler
 	ChatCanceler *ChatCanceler
 	SessionMu    sync.Mutex
+	// DB resolves model fallback chains, replies run without fallbacks when nil.
+	DB *gorm.DB
 }
 
 // AIHandler defines the interface for AI response generation
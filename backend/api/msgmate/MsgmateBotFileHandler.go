@@ -1,7 +1,12 @@
 package msgmate
 
 import (
+	"backend/database"
+	"backend/extract"
+	"backend/imaging"
+	"backend/storage"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -15,6 +20,9 @@ import (
 // FileHandlerImpl implements the FileHandler interface
 type FileHandlerImpl struct {
 	botContext *BotContext
+	// MaxImageDimension is the longest side images are downscaled to,
+	// DefaultMaxImageDimension when unset
+	MaxImageDimension int
 }
 
 // NewFileHandler creates a new file handler
@@ -41,6 +49,7 @@ func (fh *FileHandlerImpl) ProcessAttachments(attachments []interface{}, backend
 						log.Printf("Error retrieving image data for %s: %v", fileID, err)
 						continue
 					}
+					base64Data, contentType = fh.downscaleImage(fileID, base64Data, contentType)
 
 					contentArray = append(contentArray, map[string]interface{}{
 						"type": "image_url",
@@ -74,9 +83,40 @@ func (fh *FileHandlerImpl) ProcessAttachments(attachments []interface{}, backend
 								"file_id": openAIFileID,
 							},
 						})
+					} else if providerSupportsDocument(backend, mimeType) {
+						// Providers with native document support read them from base64 document blocks
+						base64Data, _, err := fh.RetrieveFileData(fileID)
+						if err != nil {
+							log.Printf("Error retrieving document data for %s: %v", fileID, err)
+							continue
+						}
+
+						contentArray = append(contentArray, map[string]interface{}{
+							"type": "document",
+							"source": map[string]interface{}{
+								"type":       "base64",
+								"media_type": mimeType,
+								"data":       base64Data,
+							},
+						})
+					} else if extract.Supported(mimeType) {
+						// Backends without file inputs read the extracted text instead
+						text, err := fh.ExtractedText(fileID)
+						if err != nil {
+							log.Printf("Error extracting text of %s: %v", fileID, err)
+							continue
+						}
+
+						fileName, _ := attMap["file_name"].(string)
+						if fileName == "" {
+							fileName = fileID
+						}
+						contentArray = append(contentArray, map[string]interface{}{
+							"type": "text",
+							"text": fmt.Sprintf("Content of the attached file %s:\n\n%s", fileName, text),
+						})
 					} else {
-						// For non-OpenAI backends, skip file attachments for now
-						log.Printf("File attachments not supported for backend %s, skipping file %s", backend, fileID)
+						log.Printf("File attachments of type %s not supported for backend %s, skipping file %s", mimeType, backend, fileID)
 					}
 				}
 			}
@@ -86,8 +126,34 @@ func (fh *FileHandlerImpl) ProcessAttachments(attachments []interface{}, backend
 	return contentArray, nil
 }
 
+// downscaleImage shrinks a base64 encoded image to the maximum dimension of
+// the model, images that can't be resized are sent as they are
+func (fh *FileHandlerImpl) downscaleImage(fileID, base64Data, contentType string) (string, string) {
+	maxDimension := fh.MaxImageDimension
+	if maxDimension <= 0 {
+		maxDimension = DefaultMaxImageDimension
+	}
+	data, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		return base64Data, contentType
+	}
+	resized, resizedType, err := imaging.Downscale(data, contentType, maxDimension)
+	if err != nil {
+		if err != imaging.ErrUnsupported {
+			log.Printf("Error downscaling image %s: %v", fileID, err)
+		}
+		return base64Data, contentType
+	}
+	return base64.StdEncoding.EncodeToString(resized), resizedType
+}
+
 // RetrieveFileData retrieves file data by ID
 func (fh *FileHandlerImpl) RetrieveFileData(fileID string) (string, string, error) {
+	// Bots with database access read the file from storage directly
+	if fh.botContext.DB != nil {
+		return fh.readStoredFile(fileID)
+	}
+
 	// Create request to download the file
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/files/%s", fh.botContext.Client.GetHost(), fileID), nil)
 	if err != nil {
@@ -125,6 +191,64 @@ func (fh *FileHandlerImpl) RetrieveFileData(fileID string) (string, string, erro
 	return base64Data, contentType, nil
 }
 
+// readStoredFile reads a file the bot user owns or was given access to from
+// its storage backend
+func (fh *FileHandlerImpl) readStoredFile(fileID string) (string, string, error) {
+	uploadedFile, _, err := database.FindAccessibleFile(fh.botContext.DB, fileID, fh.botContext.BotUser.ID)
+	if err != nil {
+		return "", "", fmt.Errorf("error finding file %s: %w", fileID, err)
+	}
+
+	fileData, err := storage.ReadAll(context.Background(), uploadedFile.StorageURL)
+	if err != nil {
+		return "", "", fmt.Errorf("error reading file data: %w", err)
+	}
+
+	contentType := uploadedFile.MIMEType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return base64.StdEncoding.EncodeToString(fileData), contentType, nil
+}
+
+// ExtractedText returns the text extracted from a document the bot user can access
+func (fh *FileHandlerImpl) ExtractedText(fileID string) (string, error) {
+	// Bots with database access extract and cache the text themselves
+	if fh.botContext.DB != nil {
+		uploadedFile, _, err := database.FindAccessibleFile(fh.botContext.DB, fileID, fh.botContext.BotUser.ID)
+		if err != nil {
+			return "", fmt.Errorf("error finding file %s: %w", fileID, err)
+		}
+		return extract.FileText(context.Background(), fh.botContext.DB, uploadedFile)
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/files/%s/text", fh.botContext.Client.GetHost(), fileID), nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Cookie", fmt.Sprintf("session_id=%s", fh.botContext.Client.GetSessionId()))
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error getting file text: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("file text request failed with status: %d", resp.StatusCode)
+	}
+
+	var fileText struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&fileText); err != nil {
+		return "", fmt.Errorf("error decoding file text: %w", err)
+	}
+	return fileText.Text, nil
+}
+
 // UploadToOpenAI uploads a file to OpenAI's API
 func (fh *FileHandlerImpl) UploadToOpenAI(fileID, mimeType string) (string, error) {
 	// Get OpenAI API key
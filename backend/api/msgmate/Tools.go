@@ -3,6 +3,7 @@ package msgmate
 import (
 	_ "backend/api/msgmate/externaltools"
 	tooldefs "backend/api/msgmate/tools"
+	"context"
 	"encoding/json"
 	"strings"
 	"sync"
@@ -30,6 +31,41 @@ type Tool interface {
 	SetInitData(data interface{})
 }
 
+// ContextTool is implemented by tools that can abort their work when the
+// bot reply they run for is interrupted.
+type ContextTool interface {
+	RunToolContext(ctx context.Context, input interface{}) (string, error)
+}
+
+// runToolWithContext runs a tool and returns as soon as ctx is cancelled.
+// Tools that do not implement ContextTool finish in the background and
+// their result is discarded.
+func runToolWithContext(ctx context.Context, tool Tool, input interface{}) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if contextTool, ok := tool.(ContextTool); ok {
+		return contextTool.RunToolContext(ctx, input)
+	}
+
+	type runResult struct {
+		result string
+		err    error
+	}
+	done := make(chan runResult, 1)
+	go func() {
+		result, err := tool.RunTool(input)
+		done <- runResult{result: result, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.result, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
 type ToolConstructor func() Tool
 
 var (
@@ -0,0 +1,654 @@
+package msgmate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	anthropicAPIVersion             = "2023-06-01"
+	anthropicDefaultMaxTokens       = 8192
+	anthropicDefaultThinkingBudget  = 4096
+	anthropicMinThinkingBudget      = 1024
+	anthropicThinkingOutputReserved = 1024
+)
+
+// anthropicProvider streams from the native Anthropic Messages API
+// ({endpoint}/messages). The OpenAI-style messages and tools used across the
+// bot pipeline are converted on the way out, and the streamed events are mapped
+// back onto the same chunk, usage and tool channels.
+type anthropicProvider struct{}
+
+func (p *anthropicProvider) Name() string {
+	return "anthropic"
+}
+
+func (p *anthropicProvider) ResolveEndpoint(configured string) (string, error) {
+	return resolveProviderEndpoint(p.Name(), configured, "ANTHROPIC_API_HOST", "https://api.anthropic.com/v1")
+}
+
+func (p *anthropicProvider) SupportsDocument(mimeType string) bool {
+	return mimeType == "application/pdf"
+}
+
+// MaxImageDimension is the size above which Anthropic downscales images,
+// sending larger ones only adds latency.
+func (p *anthropicProvider) MaxImageDimension() int {
+	return 1568
+}
+
+func (p *anthropicProvider) StreamRound(ctx context.Context, request ProviderRequest, stream ProviderStream) (*toolCallResult, error) {
+	requestBody := buildAnthropicRequest(request.Model, normalizeMessagesForBackend(request.Messages, p.Name()), request.Tools, request.Options)
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/messages", request.Endpoint), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("x-api-key", request.APIKey)
+	req.Header.Set("anthropic-version", anthropicAPIVersion)
+
+	client := &http.Client{Timeout: 300 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, &ProviderStatusError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+
+	return processAnthropicStreamingResponseReader(ctx, bufio.NewReader(resp.Body), stream.ToolMap, stream.ExecutedToolResults, stream.Chunks, stream.Usage, stream.ToolCalls)
+}
+
+// ListModels reads the {endpoint}/models listing of the Models API.
+func (p *anthropicProvider) ListModels(ctx context.Context, endpoint, apiKey string) ([]ProviderModel, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/models?limit=1000", endpoint), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("anthropic-version", anthropicAPIVersion)
+
+	var listing struct {
+		Data []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+		} `json:"data"`
+	}
+	if err := fetchProviderJSON(req, &listing); err != nil {
+		return nil, err
+	}
+
+	models := make([]ProviderModel, 0, len(listing.Data))
+	for _, model := range listing.Data {
+		models = append(models, ProviderModel{ID: model.ID, Name: model.DisplayName})
+	}
+	return models, nil
+}
+
+func buildAnthropicRequest(model string, messages []map[string]interface{}, tools []interface{}, options CompletionOptions) map[string]interface{} {
+	system, anthropicMessages := convertAnthropicMessages(messages)
+
+	maxTokens := options.MaxTokens
+	if maxTokens < 1 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+
+	requestBody := map[string]interface{}{
+		"model":      model,
+		"messages":   anthropicMessages,
+		"max_tokens": maxTokens,
+		"stream":     true,
+	}
+	if system != "" {
+		requestBody["system"] = system
+	}
+
+	if options.Reasoning {
+		budget := options.ThinkingBudget
+		if budget < 1 {
+			budget = anthropicDefaultThinkingBudget
+		}
+		if budget < anthropicMinThinkingBudget {
+			budget = anthropicMinThinkingBudget
+		}
+		// The thinking budget counts towards max_tokens, keep room for the answer.
+		if maxTokens < budget+anthropicThinkingOutputReserved {
+			requestBody["max_tokens"] = budget + anthropicThinkingOutputReserved
+		}
+		requestBody["thinking"] = map[string]interface{}{
+			"type":          "enabled",
+			"budget_tokens": budget,
+		}
+	}
+
+	if anthropicTools := convertAnthropicTools(tools); len(anthropicTools) > 0 {
+		requestBody["tools"] = anthropicTools
+		// The tool loop executes one tool per round, so ask for one at a time.
+		requestBody["tool_choice"] = map[string]interface{}{
+			"type":                      "auto",
+			"disable_parallel_tool_use": true,
+		}
+	}
+
+	return requestBody
+}
+
+// convertAnthropicMessages splits off the system prompt and converts the
+// remaining OpenAI-style messages into Anthropic turns. Tool calls become
+// tool_use blocks, tool results become tool_result blocks in a user turn, and
+// consecutive turns of the same role are merged.
+func convertAnthropicMessages(messages []map[string]interface{}) (string, []map[string]interface{}) {
+	systemParts := []string{}
+	converted := []map[string]interface{}{}
+
+	appendTurn := func(role string, blocks []interface{}) {
+		if len(blocks) == 0 {
+			return
+		}
+		if len(converted) > 0 {
+			last := converted[len(converted)-1]
+			if last["role"] == role {
+				last["content"] = append(last["content"].([]interface{}), blocks...)
+				return
+			}
+		}
+		converted = append(converted, map[string]interface{}{
+			"role":    role,
+			"content": blocks,
+		})
+	}
+
+	for _, message := range messages {
+		role, _ := message["role"].(string)
+		switch role {
+		case "system":
+			if text := anthropicContentText(message["content"]); strings.TrimSpace(text) != "" {
+				systemParts = append(systemParts, text)
+			}
+		case "user":
+			appendTurn("user", convertAnthropicContent(message["content"]))
+		case "assistant":
+			if nativeBlocks, ok := message[providerContentKey].([]interface{}); ok && len(nativeBlocks) > 0 {
+				appendTurn("assistant", nativeBlocks)
+				continue
+			}
+			blocks := convertAnthropicContent(message["content"])
+			if toolCalls, ok := message["tool_calls"].([]map[string]interface{}); ok {
+				for _, toolCall := range toolCalls {
+					if block := anthropicToolUseBlock(toolCall); block != nil {
+						blocks = append(blocks, block)
+					}
+				}
+			}
+			appendTurn("assistant", blocks)
+		case "tool":
+			toolCallID, _ := message["tool_call_id"].(string)
+			if toolCallID == "" {
+				continue
+			}
+			appendTurn("user", []interface{}{map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": toolCallID,
+				"content":     anthropicContentText(message["content"]),
+			}})
+		}
+	}
+
+	return strings.Join(systemParts, "\n\n"), converted
+}
+
+func anthropicToolUseBlock(toolCall map[string]interface{}) map[string]interface{} {
+	id, _ := toolCall["id"].(string)
+	function, _ := toolCall["function"].(map[string]interface{})
+	name, _ := function["name"].(string)
+	if id == "" || name == "" {
+		return nil
+	}
+
+	input := map[string]interface{}{}
+	if arguments, ok := function["arguments"].(string); ok && strings.TrimSpace(arguments) != "" {
+		if err := json.Unmarshal([]byte(arguments), &input); err != nil {
+			log.Printf("Warning: dropping unparsable arguments of tool call %s: %v", id, err)
+			input = map[string]interface{}{}
+		}
+	}
+
+	return map[string]interface{}{
+		"type":  "tool_use",
+		"id":    id,
+		"name":  name,
+		"input": input,
+	}
+}
+
+// convertAnthropicContent converts a plain string or an OpenAI-style content
+// array into Anthropic content blocks. Data URL images become base64 image
+// blocks, native Anthropic blocks pass through and unsupported parts are dropped.
+func convertAnthropicContent(content interface{}) []interface{} {
+	blocks := []interface{}{}
+
+	var parts []map[string]interface{}
+	switch typed := content.(type) {
+	case string:
+		if strings.TrimSpace(typed) != "" {
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": typed})
+		}
+		return blocks
+	case []map[string]interface{}:
+		parts = typed
+	case []interface{}:
+		for _, rawPart := range typed {
+			if part, ok := rawPart.(map[string]interface{}); ok {
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	for _, part := range parts {
+		partType, _ := part["type"].(string)
+		switch partType {
+		case "text":
+			if text, _ := part["text"].(string); strings.TrimSpace(text) != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+			}
+		case "image_url":
+			imageURL, _ := part["image_url"].(map[string]interface{})
+			url, _ := imageURL["url"].(string)
+			if block := anthropicImageBlock(url); block != nil {
+				blocks = append(blocks, block)
+			}
+		case "image", "document":
+			blocks = append(blocks, part)
+		default:
+			log.Printf("Skipping content part of type %q unsupported by anthropic", partType)
+		}
+	}
+
+	return blocks
+}
+
+func anthropicImageBlock(url string) map[string]interface{} {
+	if strings.HasPrefix(url, "data:") {
+		header, data, found := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+		mediaType, isBase64 := strings.CutSuffix(header, ";base64")
+		if !found || !isBase64 || mediaType == "" {
+			return nil
+		}
+		return map[string]interface{}{
+			"type": "image",
+			"source": map[string]interface{}{
+				"type":       "base64",
+				"media_type": mediaType,
+				"data":       data,
+			},
+		}
+	}
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return map[string]interface{}{
+			"type": "image",
+			"source": map[string]interface{}{
+				"type": "url",
+				"url":  url,
+			},
+		}
+	}
+	return nil
+}
+
+func anthropicContentText(content interface{}) string {
+	if text, ok := content.(string); ok {
+		return text
+	}
+	texts := []string{}
+	for _, rawBlock := range convertAnthropicContent(content) {
+		block, _ := rawBlock.(map[string]interface{})
+		if text, ok := block["text"].(string); ok {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// convertAnthropicTools converts OpenAI function tool definitions into Anthropic tools.
+func convertAnthropicTools(tools []interface{}) []map[string]interface{} {
+	converted := make([]map[string]interface{}, 0, len(tools))
+	for _, rawTool := range tools {
+		encoded, err := json.Marshal(rawTool)
+		if err != nil {
+			continue
+		}
+		var tool struct {
+			Function struct {
+				Name        string                 `json:"name"`
+				Description string                 `json:"description"`
+				Parameters  map[string]interface{} `json:"parameters"`
+			} `json:"function"`
+		}
+		if err := json.Unmarshal(encoded, &tool); err != nil || tool.Function.Name == "" {
+			continue
+		}
+
+		inputSchema := tool.Function.Parameters
+		if len(inputSchema) == 0 {
+			inputSchema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		converted = append(converted, map[string]interface{}{
+			"name":         tool.Function.Name,
+			"description":  tool.Function.Description,
+			"input_schema": inputSchema,
+		})
+	}
+	return converted
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message *struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock *struct {
+		Type      string `json:"type"`
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		Text      string `json:"text"`
+		Thinking  string `json:"thinking"`
+		Signature string `json:"signature"`
+		Data      string `json:"data"`
+	} `json:"content_block"`
+	Delta *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type anthropicStreamBlock struct {
+	blockType string
+	id        string
+	name      string
+	text      strings.Builder
+	thinking  strings.Builder
+	signature string
+	data      string
+	input     strings.Builder
+}
+
+// native returns the block as it has to be sent back in a later request.
+func (b *anthropicStreamBlock) native(toolInput interface{}) map[string]interface{} {
+	switch b.blockType {
+	case "thinking":
+		return map[string]interface{}{"type": "thinking", "thinking": b.thinking.String(), "signature": b.signature}
+	case "redacted_thinking":
+		return map[string]interface{}{"type": "redacted_thinking", "data": b.data}
+	case "tool_use":
+		return map[string]interface{}{"type": "tool_use", "id": b.id, "name": b.name, "input": toolInput}
+	case "text":
+		if strings.TrimSpace(b.text.String()) == "" {
+			return nil
+		}
+		return map[string]interface{}{"type": "text", "text": b.text.String()}
+	default:
+		return nil
+	}
+}
+
+// processAnthropicStreamingResponseReader consumes Messages API server-sent
+// events. Text deltas are forwarded as chunks, thinking blocks are wrapped in
+// <think> tags so they end up in the message reasoning like for other backends,
+// and the first complete tool_use block is executed.
+func processAnthropicStreamingResponseReader(
+	ctx context.Context,
+	reader *bufio.Reader,
+	toolMap map[string]Tool,
+	executedToolResults map[string]string,
+	chunkChan chan<- string,
+	usageChan chan<- *TokenUsage,
+	toolChan chan<- ToolCall,
+) (*toolCallResult, error) {
+	result := &toolCallResult{}
+	blocks := map[int]*anthropicStreamBlock{}
+	blockOrder := []int{}
+	var aiResponseBuilder strings.Builder
+	var inputTokens, cachedInputTokens, outputTokens int
+	toolExecuted := false
+
+	sendChunk := func(chunk string) error {
+		if chunk == "" {
+			return nil
+		}
+		return sendOrCancel(ctx, chunkChan, chunk)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed reading response: %w", err)
+		}
+
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" {
+			continue
+		}
+
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				usage := event.Message.Usage
+				inputTokens = usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+				cachedInputTokens = usage.CacheReadInputTokens
+				outputTokens = usage.OutputTokens
+			}
+		case "content_block_start":
+			if event.ContentBlock == nil {
+				continue
+			}
+			block := &anthropicStreamBlock{
+				blockType: event.ContentBlock.Type,
+				id:        event.ContentBlock.ID,
+				name:      event.ContentBlock.Name,
+				signature: event.ContentBlock.Signature,
+				data:      event.ContentBlock.Data,
+			}
+			block.text.WriteString(event.ContentBlock.Text)
+			block.thinking.WriteString(event.ContentBlock.Thinking)
+			blocks[event.Index] = block
+			blockOrder = append(blockOrder, event.Index)
+
+			switch block.blockType {
+			case "thinking":
+				if err := sendChunk("<think>" + event.ContentBlock.Thinking); err != nil {
+					return nil, err
+				}
+			case "text":
+				aiResponseBuilder.WriteString(event.ContentBlock.Text)
+				if err := sendChunk(event.ContentBlock.Text); err != nil {
+					return nil, err
+				}
+			}
+		case "content_block_delta":
+			block, ok := blocks[event.Index]
+			if !ok || event.Delta == nil {
+				continue
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				block.text.WriteString(event.Delta.Text)
+				aiResponseBuilder.WriteString(event.Delta.Text)
+				if err := sendChunk(event.Delta.Text); err != nil {
+					return nil, err
+				}
+			case "thinking_delta":
+				block.thinking.WriteString(event.Delta.Thinking)
+				if err := sendChunk(event.Delta.Thinking); err != nil {
+					return nil, err
+				}
+			case "signature_delta":
+				block.signature += event.Delta.Signature
+			case "input_json_delta":
+				block.input.WriteString(event.Delta.PartialJSON)
+			}
+		case "content_block_stop":
+			block, ok := blocks[event.Index]
+			if !ok {
+				continue
+			}
+			switch block.blockType {
+			case "thinking":
+				if err := sendChunk("</think>"); err != nil {
+					return nil, err
+				}
+			case "tool_use":
+				if toolExecuted {
+					log.Printf("Warning: ignoring additional tool_use block %s (%s) in the same turn", block.id, block.name)
+					continue
+				}
+				executed, err := executeAnthropicToolUse(ctx, block, blocks, blockOrder, toolMap, executedToolResults, toolChan, result)
+				if err != nil {
+					return nil, err
+				}
+				toolExecuted = executed
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				if event.Usage.InputTokens > 0 {
+					inputTokens = event.Usage.InputTokens + event.Usage.CacheCreationInputTokens + event.Usage.CacheReadInputTokens
+					cachedInputTokens = event.Usage.CacheReadInputTokens
+				}
+				outputTokens = event.Usage.OutputTokens
+			}
+		case "error":
+			if event.Error != nil {
+				return nil, fmt.Errorf("anthropic stream error: %s: %s", event.Error.Type, event.Error.Message)
+			}
+			return nil, fmt.Errorf("anthropic stream error: %s", data)
+		}
+
+		if event.Type == "message_stop" {
+			break
+		}
+	}
+
+	if inputTokens > 0 || outputTokens > 0 {
+		usage := &TokenUsage{
+			PromptTokens:       inputTokens,
+			CompletionTokens:   outputTokens,
+			TotalTokens:        inputTokens + outputTokens,
+			CachedPromptTokens: cachedInputTokens,
+		}
+		if err := sendOrCancel(ctx, usageChan, usage); err != nil {
+			return nil, err
+		}
+	}
+
+	result.aiResponse = aiResponseBuilder.String()
+	return result, nil
+}
+
+// executeAnthropicToolUse runs a completed tool_use block and records the
+// native assistant turn that requested it on result, so the follow-up request
+// can replay thinking blocks together with their signatures.
+func executeAnthropicToolUse(
+	ctx context.Context,
+	block *anthropicStreamBlock,
+	blocks map[int]*anthropicStreamBlock,
+	blockOrder []int,
+	toolMap map[string]Tool,
+	executedToolResults map[string]string,
+	toolChan chan<- ToolCall,
+	result *toolCallResult,
+) (bool, error) {
+	tool, exists := toolMap[block.name]
+	if !exists {
+		log.Printf("Warning: Tool '%s' not found in toolMap", block.name)
+		return false, nil
+	}
+
+	arguments := strings.TrimSpace(block.input.String())
+	if arguments == "" {
+		arguments = "{}"
+	}
+	toolInput, parseErr := tool.ParseArguments(arguments)
+	if parseErr != nil {
+		log.Printf("Warning: failed to parse arguments of tool '%s': %v", block.name, parseErr)
+		return false, nil
+	}
+
+	var nativeInput interface{} = map[string]interface{}{}
+	_ = json.Unmarshal([]byte(arguments), &nativeInput)
+
+	assistantContent := []interface{}{}
+	for _, index := range blockOrder {
+		candidate := blocks[index]
+		if candidate.blockType == "tool_use" && candidate != block {
+			continue
+		}
+		if native := candidate.native(nativeInput); native != nil {
+			assistantContent = append(assistantContent, native)
+		}
+		if candidate == block {
+			break
+		}
+	}
+	result.assistantContent = assistantContent
+
+	fmt.Printf("\n=== EXECUTING TOOL: %s ===\n", block.name)
+	fmt.Printf("Tool ID: %s\n", block.id)
+	fmt.Printf("Arguments: %s\n", arguments)
+
+	if err := runStreamedToolCall(ctx, tool, block.id, block.name, arguments, toolInput, executedToolResults, toolChan, result); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,252 @@
+package msgmate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type clockTestingTool struct {
+	BaseTool
+	mu     sync.Mutex
+	inputs []interface{}
+}
+
+func (t *clockTestingTool) RunTool(input interface{}) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inputs = append(t.inputs, input)
+	return "12:00 UTC", nil
+}
+
+func newClockTestingTool() *clockTestingTool {
+	return &clockTestingTool{BaseTool: BaseTool{
+		ToolName:        "get_clock_testing",
+		ToolType:        "function",
+		ToolDescription: "Returns the current time",
+		ToolInputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"timezone": map[string]interface{}{"type": "string"},
+			},
+		},
+	}}
+}
+
+// newAnthropicStandIn replays recorded Messages API streams: the tool_use
+// recording for the first round and the final answer once a tool_result is sent.
+func newAnthropicStandIn(t *testing.T) (*httptest.Server, *[]map[string]interface{}) {
+	t.Helper()
+	toolUseStream, err := os.ReadFile("testdata/anthropic_tool_use.sse")
+	if err != nil {
+		t.Fatalf("failed to read recording: %v", err)
+	}
+	toolResultStream, err := os.ReadFile("testdata/anthropic_tool_result.sse")
+	if err != nil {
+		t.Fatalf("failed to read recording: %v", err)
+	}
+
+	var mu sync.Mutex
+	requests := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/messages" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("x-api-key") != "test-key" {
+			t.Errorf("expected x-api-key header, got %q", r.Header.Get("x-api-key"))
+		}
+		if r.Header.Get("anthropic-version") != anthropicAPIVersion {
+			t.Errorf("expected anthropic-version header, got %q", r.Header.Get("anthropic-version"))
+		}
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, body)
+		mu.Unlock()
+
+		recording := toolUseStream
+		encoded, _ := json.Marshal(body["messages"])
+		if strings.Contains(string(encoded), `"tool_result"`) {
+			recording = toolResultStream
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(recording)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestStreamChatCompletionAnthropicNativeToolLoop(t *testing.T) {
+	server, requests := newAnthropicStandIn(t)
+
+	tool := newClockTestingTool()
+	toolMap := map[string]Tool{tool.GetToolFunctionName(): tool}
+	messages := []map[string]interface{}{
+		{"role": "system", "content": "You are a clock."},
+		{"role": "user", "content": []map[string]interface{}{
+			{"type": "text", "text": "What time is it?"},
+			{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64,iVBORw0KGgo="}},
+			{"type": "document", "source": map[string]interface{}{"type": "base64", "media_type": "application/pdf", "data": "JVBERi0x"}},
+		}},
+	}
+
+	chunks, usage, toolCalls, errs := streamChatCompletion(
+		context.Background(),
+		server.URL+"/v1",
+		"claude-sonnet-4-5",
+		"anthropic",
+		0,
+		0,
+		messages,
+		[]interface{}{tool.ConstructTool()},
+		toolMap,
+		"test-key",
+		CompletionOptions{Reasoning: true, ThinkingBudget: 2048},
+		nil,
+		nil,
+		nil,
+	)
+
+	var text strings.Builder
+	var totalTokens int
+	var calls []ToolCall
+	for chunks != nil || usage != nil || toolCalls != nil || errs != nil {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				chunks = nil
+				continue
+			}
+			text.WriteString(chunk)
+		case usageInfo, ok := <-usage:
+			if !ok {
+				usage = nil
+				continue
+			}
+			totalTokens += usageInfo.TotalTokens
+		case toolCall, ok := <-toolCalls:
+			if !ok {
+				toolCalls = nil
+				continue
+			}
+			calls = append(calls, toolCall)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			t.Fatalf("unexpected stream error: %v", err)
+		}
+	}
+
+	wantText := "<think>The user asks for the time. I should call the clock tool.</think>Let me check the clock.It is 12:00 in UTC."
+	if text.String() != wantText {
+		t.Fatalf("unexpected streamed text:\n got %q\nwant %q", text.String(), wantText)
+	}
+	if totalTokens != 412+89+530+11 {
+		t.Fatalf("unexpected total tokens %d", totalTokens)
+	}
+	if len(calls) != 2 || calls[0].Status != ToolCallStatusOngoing || calls[1].Status != ToolCallStatusSucceeded {
+		t.Fatalf("expected ongoing and succeeded tool call updates, got %+v", calls)
+	}
+	if calls[1].Id != "toolu_01T1x1fJ34qAmk2tNTrN7Up6" || calls[1].Result != "12:00 UTC" {
+		t.Fatalf("unexpected tool call result %+v", calls[1])
+	}
+	if input, _ := tool.inputs[0].(map[string]interface{}); input["timezone"] != "UTC" {
+		t.Fatalf("expected tool to receive streamed input, got %#v", tool.inputs[0])
+	}
+
+	if len(*requests) != 2 {
+		t.Fatalf("expected two rounds, got %d", len(*requests))
+	}
+	first := (*requests)[0]
+	if first["system"] != "You are a clock." || first["stream"] != true {
+		t.Fatalf("unexpected request envelope %#v", first)
+	}
+	thinking, _ := first["thinking"].(map[string]interface{})
+	if thinking["type"] != "enabled" || thinking["budget_tokens"] != float64(2048) {
+		t.Fatalf("expected extended thinking to be enabled, got %#v", first["thinking"])
+	}
+	tools, _ := first["tools"].([]interface{})
+	if len(tools) != 1 || tools[0].(map[string]interface{})["name"] != "get_clock_testing" {
+		t.Fatalf("expected native tool definition, got %#v", first["tools"])
+	}
+	firstMessages, _ := first["messages"].([]interface{})
+	userContent := firstMessages[0].(map[string]interface{})["content"].([]interface{})
+	if len(userContent) != 3 ||
+		userContent[1].(map[string]interface{})["type"] != "image" ||
+		userContent[2].(map[string]interface{})["type"] != "document" {
+		t.Fatalf("expected text, image and document blocks, got %#v", userContent)
+	}
+
+	second, _ := (*requests)[1]["messages"].([]interface{})
+	if len(second) != 3 {
+		t.Fatalf("expected user, assistant and tool_result turns, got %#v", second)
+	}
+	assistant := second[1].(map[string]interface{})
+	assistantBlocks := assistant["content"].([]interface{})
+	if assistant["role"] != "assistant" || len(assistantBlocks) != 3 {
+		t.Fatalf("expected replayed assistant turn, got %#v", assistant)
+	}
+	thinkingBlock := assistantBlocks[0].(map[string]interface{})
+	if thinkingBlock["type"] != "thinking" || thinkingBlock["signature"] != "EqQBCgIYAhIM1gbcDa9GJwZA2b3hGgxBdjrkzLoky3dl1pkiMOYds" {
+		t.Fatalf("expected signed thinking block to be replayed, got %#v", thinkingBlock)
+	}
+	toolUse := assistantBlocks[2].(map[string]interface{})
+	if toolUse["type"] != "tool_use" || toolUse["input"].(map[string]interface{})["timezone"] != "UTC" {
+		t.Fatalf("expected tool_use block, got %#v", toolUse)
+	}
+	toolResult := second[2].(map[string]interface{})["content"].([]interface{})[0].(map[string]interface{})
+	if toolResult["type"] != "tool_result" || toolResult["tool_use_id"] != "toolu_01T1x1fJ34qAmk2tNTrN7Up6" || toolResult["content"] != "12:00 UTC" {
+		t.Fatalf("expected tool_result block, got %#v", toolResult)
+	}
+}
+
+func TestConvertAnthropicMessagesMapsHistoricalToolCalls(t *testing.T) {
+	system, messages := convertAnthropicMessages([]map[string]interface{}{
+		{"role": "system", "content": "sys"},
+		{"role": "user", "content": "hello"},
+		{"role": "assistant", "content": "", "tool_calls": []map[string]interface{}{{
+			"type": "function",
+			"id":   "call_1",
+			"function": map[string]interface{}{
+				"name":      "get_clock_testing",
+				"arguments": `{"timezone":"UTC"}`,
+			},
+		}}},
+		{"role": "tool", "tool_call_id": "call_1", "content": "12:00 UTC"},
+		{"role": "assistant", "content": "It is noon."},
+		{"role": "user", "content": "thanks"},
+		{"role": "user", "content": "bye"},
+	})
+
+	if system != "sys" {
+		t.Fatalf("expected system prompt to be lifted, got %q", system)
+	}
+	roles := []string{}
+	for _, message := range messages {
+		roles = append(roles, message["role"].(string))
+	}
+	if strings.Join(roles, ",") != "user,assistant,user,assistant,user" {
+		t.Fatalf("expected alternating merged turns, got %v", roles)
+	}
+	if blocks := messages[4]["content"].([]interface{}); len(blocks) != 2 {
+		t.Fatalf("expected consecutive user messages to merge, got %#v", blocks)
+	}
+	toolUse := messages[1]["content"].([]interface{})[0].(map[string]interface{})
+	if toolUse["type"] != "tool_use" || toolUse["id"] != "call_1" {
+		t.Fatalf("expected tool_use block, got %#v", toolUse)
+	}
+}
@@ -3,6 +3,7 @@ package msgmate
 import (
 	"backend/database"
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -83,14 +84,11 @@ func TestProcessStreamingResponseReader_ExecutesDynamicRESTToolCall(t *testing.T
 	}, "\n")
 
 	chunkChan := make(chan string, 4)
-	usageChan := make(chan *struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	}, 2)
+	usageChan := make(chan *TokenUsage, 2)
 	toolChan := make(chan ToolCall, 2)
 
 	result, err := processStreamingResponseReader(
+		context.Background(),
 		bufio.NewReader(strings.NewReader(sse)),
 		toolMap,
 		map[string]string{},
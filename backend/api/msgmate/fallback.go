@@ -0,0 +1,357 @@
+package msgmate
+
+import (
+	"backend/database"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFallbackMaxRetries = 2
+	defaultFallbackBaseDelay  = time.Second
+	defaultFallbackMaxDelay   = 10 * time.Second
+)
+
+// transientErrorMarkers classify provider errors that carry no status code,
+// like connection failures or error events inside an already started stream.
+var transientErrorMarkers = []string{
+	"timeout",
+	"connection refused",
+	"connection reset",
+	"no such host",
+	"rate limit",
+	"rate_limit",
+	"overloaded",
+	"unavailable",
+}
+
+// ProviderStatusError is returned when a provider answers with a non-200 status.
+type ProviderStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ProviderStatusError) Error() string {
+	return fmt.Sprintf("non-200 response: %d %s", e.StatusCode, e.Body)
+}
+
+// IsTransientProviderError reports whether a failed request is worth
+// retrying: rate limits, server errors, timeouts and unreachable hosts.
+func IsTransientProviderError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *ProviderStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == 408 || statusErr.StatusCode == 429 || statusErr.StatusCode >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	errLower := strings.ToLower(err.Error())
+	for _, marker := range transientErrorMarkers {
+		if strings.Contains(errLower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelAttempt is one model of a reply's fallback chain.
+type ModelAttempt struct {
+	ModelConfigUUID string
+	Backend         string
+	Endpoint        string
+	Model           string
+}
+
+type retryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// delay returns the exponential backoff before the given retry, starting at 1.
+func (p retryPolicy) delay(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+func retryPolicyFromConfig(configMap map[string]interface{}) retryPolicy {
+	policy := retryPolicy{
+		MaxRetries: DefaultFallbackMaxRetries,
+		BaseDelay:  defaultFallbackBaseDelay,
+		MaxDelay:   defaultFallbackMaxDelay,
+	}
+	if maxRetries, ok := configMap["fallback_max_retries"].(float64); ok && maxRetries >= 0 {
+		policy.MaxRetries = int(maxRetries)
+	}
+	return policy
+}
+
+// answeredModel records which attempt of the fallback chain produced the reply.
+type answeredModel struct {
+	mu       sync.Mutex
+	attempt  *ModelAttempt
+	index    int
+	attempts int
+}
+
+func (a *answeredModel) set(attempt ModelAttempt, index, attempts int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.attempt = &attempt
+	a.index = index
+	a.attempts = attempts
+}
+
+// current returns the attempt that answered, if any.
+func (a *answeredModel) current() (ModelAttempt, bool) {
+	if a == nil {
+		return ModelAttempt{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.attempt == nil {
+		return ModelAttempt{}, false
+	}
+	return *a.attempt, true
+}
+
+// metadata describes the answering model for the message metadata, or nil
+// when no model answered.
+func (a *answeredModel) metadata() map[string]interface{} {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.attempt == nil {
+		return nil
+	}
+	answeredBy := map[string]interface{}{
+		"backend":  a.attempt.Backend,
+		"model":    a.attempt.Model,
+		"fallback": a.index > 0,
+		"attempts": a.attempts,
+	}
+	if a.attempt.ModelConfigUUID != "" {
+		answeredBy["model_config_uuid"] = a.attempt.ModelConfigUUID
+	}
+	return answeredBy
+}
+
+// resolveModelAttempts returns the chat's model followed by its fallback chain.
+// The chain comes from the chat config ("fallback_models", usually inherited
+// from the bot runtime config) or else from the model config of the chat's
+// model. Entries are model config UUIDs or model ids.
+func (aih *AIHandlerImpl) resolveModelAttempts(configMap map[string]interface{}, primary ModelAttempt) []ModelAttempt {
+	attempts := []ModelAttempt{primary}
+	if aih.botContext.DB == nil {
+		return attempts
+	}
+
+	configured := []string{}
+	if rawFallbacks, ok := configMap["fallback_models"].([]interface{}); ok {
+		for _, rawFallback := range rawFallbacks {
+			if fallback, ok := rawFallback.(string); ok {
+				configured = append(configured, fallback)
+			}
+		}
+	}
+
+	fallbacks, err := database.ResolveFallbackModelConfigs(aih.botContext.DB, primary.Model, configured)
+	if err != nil {
+		log.Printf("Failed to resolve fallback models for %s: %v", primary.Model, err)
+		return attempts
+	}
+
+	for _, fallback := range fallbacks {
+		cfg := fallback.ConfigurationMap()
+		backend := mapGetOrDefault[string](cfg, "backend", "")
+		model := mapGetOrDefault[string](cfg, "model", fallback.ModelID)
+		if backend == "" || model == "" {
+			log.Printf("Skipping fallback model %s without backend or model", fallback.UUID)
+			continue
+		}
+		endpoint, err := ProviderForBackend(backend).ResolveEndpoint(mapGetOrDefault[string](cfg, "endpoint", ""))
+		if err != nil {
+			log.Printf("Skipping fallback model %s: %v", fallback.UUID, err)
+			continue
+		}
+		attempts = append(attempts, ModelAttempt{
+			ModelConfigUUID: fallback.UUID,
+			Backend:         backend,
+			Endpoint:        endpoint,
+			Model:           model,
+		})
+	}
+	return attempts
+}
+
+type completionStarter func(attempt ModelAttempt, firstTry bool) (<-chan string, <-chan *TokenUsage, <-chan ToolCall, <-chan error)
+
+// streamWithFallback streams the first attempt that starts answering. An
+// attempt that fails before emitting anything is retried with exponential
+// backoff while the error is transient, then the next attempt is tried. Once
+// an attempt emitted output it is committed to, later errors are forwarded.
+func streamWithFallback(
+	ctx context.Context,
+	attempts []ModelAttempt,
+	policy retryPolicy,
+	answered *answeredModel,
+	start completionStarter,
+) (<-chan string, <-chan *TokenUsage, <-chan ToolCall, <-chan error) {
+	chunkChan := make(chan string)
+	usageChan := make(chan *TokenUsage)
+	toolChan := make(chan ToolCall)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(chunkChan)
+		defer close(usageChan)
+		defer close(toolChan)
+		defer close(errChan)
+
+		var lastErr error
+		totalAttempts := 0
+		for index, attempt := range attempts {
+			for retry := 0; ; retry++ {
+				if err := ctx.Err(); err != nil {
+					errChan <- err
+					return
+				}
+				if retry > 0 {
+					delay := policy.delay(retry)
+					log.Printf("Retrying %s/%s in %s after: %v", attempt.Backend, attempt.Model, delay, lastErr)
+					if err := sleepWithContext(ctx, delay); err != nil {
+						errChan <- err
+						return
+					}
+				}
+
+				totalAttempts++
+				chunks, usage, toolCalls, errs := start(attempt, totalAttempts == 1)
+				committed, err := forwardCompletion(ctx, chunks, usage, toolCalls, errs, chunkChan, usageChan, toolChan, func() {
+					answered.set(attempt, index, totalAttempts)
+				})
+				if committed {
+					if err != nil {
+						errChan <- err
+					}
+					return
+				}
+				if err == nil {
+					// The attempt ended without any output, which is still an answer.
+					answered.set(attempt, index, totalAttempts)
+					return
+				}
+
+				lastErr = err
+				if ctx.Err() != nil || !IsTransientProviderError(err) || retry >= policy.MaxRetries {
+					break
+				}
+			}
+			if ctx.Err() == nil && index < len(attempts)-1 {
+				log.Printf("Model %s/%s failed, falling back to the next model: %v", attempt.Backend, attempt.Model, lastErr)
+			}
+		}
+		errChan <- lastErr
+	}()
+
+	return chunkChan, usageChan, toolChan, errChan
+}
+
+// forwardCompletion forwards one attempt's stream. It reports whether any
+// output was forwarded and the error the attempt ended with. onCommit is
+// called right before the first output is forwarded.
+func forwardCompletion(
+	ctx context.Context,
+	chunks <-chan string,
+	usage <-chan *TokenUsage,
+	toolCalls <-chan ToolCall,
+	errs <-chan error,
+	chunkChan chan<- string,
+	usageChan chan<- *TokenUsage,
+	toolChan chan<- ToolCall,
+	onCommit func(),
+) (bool, error) {
+	committed := false
+	commit := func() {
+		if !committed {
+			committed = true
+			onCommit()
+		}
+	}
+
+	var streamErr error
+	for chunks != nil || usage != nil || toolCalls != nil || errs != nil {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				chunks = nil
+				continue
+			}
+			commit()
+			if err := sendOrCancel(ctx, chunkChan, chunk); err != nil {
+				return committed, err
+			}
+		case usageInfo, ok := <-usage:
+			if !ok {
+				usage = nil
+				continue
+			}
+			commit()
+			if err := sendOrCancel(ctx, usageChan, usageInfo); err != nil {
+				return committed, err
+			}
+		case toolCall, ok := <-toolCalls:
+			if !ok {
+				toolCalls = nil
+				continue
+			}
+			commit()
+			if err := sendOrCancel(ctx, toolChan, toolCall); err != nil {
+				return committed, err
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			if streamErr == nil {
+				streamErr = err
+			}
+		}
+	}
+	return committed, streamErr
+}
+
+func sleepWithContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
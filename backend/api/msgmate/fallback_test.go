@@ -0,0 +1,142 @@
+package msgmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newStatusStandIn(t *testing.T, status int) (*httptest.Server, *int32) {
+	t.Helper()
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		http.Error(w, http.StatusText(status), status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func newAnsweringStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"choices":[{"delta":{"content":"Hello from the fallback."}}],"usage":{"prompt_tokens":3,"completion_tokens":4,"total_tokens":7}}` + "\n\n" + "data: [DONE]\n\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func runFallbackChain(t *testing.T, attempts []ModelAttempt, answered *answeredModel) (completionOutput, []bool) {
+	t.Helper()
+	firstTries := []bool{}
+	policy := retryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	chunks, usage, toolCalls, errs := streamWithFallback(context.Background(), attempts, policy, answered, func(attempt ModelAttempt, firstTry bool) (<-chan string, <-chan *TokenUsage, <-chan ToolCall, <-chan error) {
+		firstTries = append(firstTries, firstTry)
+		return streamChatCompletion(context.Background(), attempt.Endpoint, attempt.Model, attempt.Backend, 0, 0,
+			[]map[string]interface{}{{"role": "user", "content": "hi"}}, nil, map[string]Tool{}, "key", CompletionOptions{}, nil, nil, nil)
+	})
+	return drainCompletion(t, chunks, usage, toolCalls, errs), firstTries
+}
+
+func TestStreamWithFallbackRetriesTransientErrorsThenFallsBack(t *testing.T) {
+	overloaded, hits := newStatusStandIn(t, http.StatusServiceUnavailable)
+	fallback := newAnsweringStandIn(t)
+
+	answered := &answeredModel{}
+	output, firstTries := runFallbackChain(t, []ModelAttempt{
+		{Backend: "openai", Endpoint: overloaded.URL, Model: "primary"},
+		{ModelConfigUUID: "fallback-uuid", Backend: "localai", Endpoint: fallback.URL, Model: "secondary"},
+	}, answered)
+
+	if output.text != "Hello from the fallback." || output.totalTokens != 7 {
+		t.Fatalf("unexpected output %+v", output)
+	}
+	if atomic.LoadInt32(hits) != 3 {
+		t.Fatalf("expected the primary to be tried once and retried twice, got %d requests", atomic.LoadInt32(hits))
+	}
+	if len(firstTries) != 4 || !firstTries[0] || firstTries[1] || firstTries[3] {
+		t.Fatalf("expected only the first try to be marked, got %v", firstTries)
+	}
+	answeredBy := answered.metadata()
+	if answeredBy["model"] != "secondary" || answeredBy["model_config_uuid"] != "fallback-uuid" || answeredBy["fallback"] != true || answeredBy["attempts"] != 4 {
+		t.Fatalf("unexpected answered_by metadata %#v", answeredBy)
+	}
+}
+
+func TestStreamWithFallbackSkipsRetriesForPermanentErrors(t *testing.T) {
+	rejecting, rejectingHits := newStatusStandIn(t, http.StatusBadRequest)
+	unauthorized, unauthorizedHits := newStatusStandIn(t, http.StatusUnauthorized)
+
+	answered := &answeredModel{}
+	policy := retryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	attempts := []ModelAttempt{
+		{Backend: "openai", Endpoint: rejecting.URL, Model: "primary"},
+		{Backend: "openai", Endpoint: unauthorized.URL, Model: "secondary"},
+	}
+	chunks, usage, toolCalls, errs := streamWithFallback(context.Background(), attempts, policy, answered, func(attempt ModelAttempt, firstTry bool) (<-chan string, <-chan *TokenUsage, <-chan ToolCall, <-chan error) {
+		return streamChatCompletion(context.Background(), attempt.Endpoint, attempt.Model, attempt.Backend, 0, 0,
+			[]map[string]interface{}{{"role": "user", "content": "hi"}}, nil, map[string]Tool{}, "key", CompletionOptions{}, nil, nil, nil)
+	})
+
+	var lastErr error
+	for chunks != nil || usage != nil || toolCalls != nil || errs != nil {
+		select {
+		case _, ok := <-chunks:
+			if !ok {
+				chunks = nil
+			}
+		case _, ok := <-usage:
+			if !ok {
+				usage = nil
+			}
+		case _, ok := <-toolCalls:
+			if !ok {
+				toolCalls = nil
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			lastErr = err
+		}
+	}
+
+	var statusErr *ProviderStatusError
+	if !errors.As(lastErr, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the last model's error, got %v", lastErr)
+	}
+	if atomic.LoadInt32(rejectingHits) != 1 || atomic.LoadInt32(unauthorizedHits) != 1 {
+		t.Fatalf("expected one request per model, got %d and %d", atomic.LoadInt32(rejectingHits), atomic.LoadInt32(unauthorizedHits))
+	}
+	if answered.metadata() != nil {
+		t.Fatalf("no model should be recorded as answering")
+	}
+}
+
+func TestIsTransientProviderError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&ProviderStatusError{StatusCode: 429}, true},
+		{fmt.Errorf("request failed: %w", &ProviderStatusError{StatusCode: 502}), true},
+		{&ProviderStatusError{StatusCode: 401}, false},
+		{errors.New("request failed: dial tcp: connection refused"), true},
+		{errors.New("anthropic stream error: overloaded_error: Overloaded"), true},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{errors.New("exceeded maximum number of tool calls (5)"), false},
+	}
+	for _, tc := range cases {
+		if got := IsTransientProviderError(tc.err); got != tc.want {
+			t.Errorf("IsTransientProviderError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,658 @@
+package msgmate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// geminiSchemaKeys are the JSON schema keywords accepted in Gemini function
+// declarations, everything else is dropped from tool parameters.
+var geminiSchemaKeys = map[string]bool{
+	"type":        true,
+	"format":      true,
+	"title":       true,
+	"description": true,
+	"nullable":    true,
+	"enum":        true,
+	"properties":  true,
+	"required":    true,
+	"items":       true,
+	"minItems":    true,
+	"maxItems":    true,
+	"minimum":     true,
+	"maximum":     true,
+	"minLength":   true,
+	"maxLength":   true,
+	"pattern":     true,
+	"anyOf":       true,
+}
+
+// geminiProvider streams from the Google Gemini API
+// ({endpoint}/models/{model}:streamGenerateContent).
+type geminiProvider struct{}
+
+func (p *geminiProvider) Name() string {
+	return "gemini"
+}
+
+func (p *geminiProvider) ResolveEndpoint(configured string) (string, error) {
+	return resolveProviderEndpoint(p.Name(), configured, "GEMINI_API_HOST", "https://generativelanguage.googleapis.com/v1beta")
+}
+
+func (p *geminiProvider) SupportsDocument(mimeType string) bool {
+	return mimeType == "application/pdf"
+}
+
+func (p *geminiProvider) StreamRound(ctx context.Context, request ProviderRequest, stream ProviderStream) (*toolCallResult, error) {
+	requestBody := buildGeminiRequest(request.Messages, request.Tools, request.Options)
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	model := strings.TrimPrefix(strings.TrimSpace(request.Model), "models/")
+	requestURL := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", request.Endpoint, url.PathEscape(model))
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("x-goog-api-key", request.APIKey)
+
+	client := &http.Client{Timeout: 300 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, &ProviderStatusError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+
+	return processGeminiStreamingResponseReader(ctx, bufio.NewReader(resp.Body), stream)
+}
+
+// ListModels pages through {endpoint}/models and keeps the models that
+// support generateContent.
+func (p *geminiProvider) ListModels(ctx context.Context, endpoint, apiKey string) ([]ProviderModel, error) {
+	models := []ProviderModel{}
+	pageToken := ""
+	for {
+		query := url.Values{"pageSize": {"1000"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/models?%s", endpoint, query.Encode()), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("x-goog-api-key", apiKey)
+
+		var listing struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				DisplayName                string   `json:"displayName"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := fetchProviderJSON(req, &listing); err != nil {
+			return nil, err
+		}
+
+		for _, model := range listing.Models {
+			for _, method := range model.SupportedGenerationMethods {
+				if method == "generateContent" {
+					models = append(models, ProviderModel{ID: strings.TrimPrefix(model.Name, "models/"), Name: model.DisplayName})
+					break
+				}
+			}
+		}
+
+		if listing.NextPageToken == "" {
+			return models, nil
+		}
+		pageToken = listing.NextPageToken
+	}
+}
+
+func buildGeminiRequest(messages []map[string]interface{}, tools []interface{}, options CompletionOptions) map[string]interface{} {
+	system, contents := convertGeminiMessages(messages)
+
+	requestBody := map[string]interface{}{
+		"contents": contents,
+	}
+	if system != "" {
+		requestBody["systemInstruction"] = map[string]interface{}{
+			"parts": []interface{}{map[string]interface{}{"text": system}},
+		}
+	}
+	if declarations := convertGeminiTools(tools); len(declarations) > 0 {
+		requestBody["tools"] = []interface{}{map[string]interface{}{"functionDeclarations": declarations}}
+	}
+
+	generationConfig := map[string]interface{}{}
+	if options.MaxTokens > 0 {
+		generationConfig["maxOutputTokens"] = options.MaxTokens
+	}
+	if options.Reasoning {
+		thinkingConfig := map[string]interface{}{"includeThoughts": true}
+		// Without a budget the model decides how long to think.
+		if options.ThinkingBudget > 0 {
+			thinkingConfig["thinkingBudget"] = options.ThinkingBudget
+		}
+		generationConfig["thinkingConfig"] = thinkingConfig
+	}
+	if len(generationConfig) > 0 {
+		requestBody["generationConfig"] = generationConfig
+	}
+
+	return requestBody
+}
+
+// convertGeminiMessages splits off the system prompt and converts the
+// remaining OpenAI-style messages into Gemini contents. Tool calls become
+// functionCall parts, tool results become functionResponse parts in a user
+// turn, and consecutive turns of the same role are merged.
+func convertGeminiMessages(messages []map[string]interface{}) (string, []map[string]interface{}) {
+	systemParts := []string{}
+	contents := []map[string]interface{}{}
+	// Gemini answers tool results by function name, not by call id.
+	toolNames := map[string]string{}
+
+	appendTurn := func(role string, parts []interface{}) {
+		if len(parts) == 0 {
+			return
+		}
+		if len(contents) > 0 {
+			last := contents[len(contents)-1]
+			if last["role"] == role {
+				last["parts"] = append(last["parts"].([]interface{}), parts...)
+				return
+			}
+		}
+		contents = append(contents, map[string]interface{}{
+			"role":  role,
+			"parts": parts,
+		})
+	}
+
+	for _, message := range messages {
+		role, _ := message["role"].(string)
+		switch role {
+		case "system":
+			if text := geminiContentText(message["content"]); strings.TrimSpace(text) != "" {
+				systemParts = append(systemParts, text)
+			}
+		case "user":
+			appendTurn("user", convertGeminiContent(message["content"]))
+		case "assistant":
+			toolCalls, _ := message["tool_calls"].([]map[string]interface{})
+			for _, toolCall := range toolCalls {
+				id, _ := toolCall["id"].(string)
+				function, _ := toolCall["function"].(map[string]interface{})
+				if name, _ := function["name"].(string); id != "" && name != "" {
+					toolNames[id] = name
+				}
+			}
+
+			if nativeParts, ok := message[providerContentKey].([]interface{}); ok && len(nativeParts) > 0 {
+				appendTurn("model", nativeParts)
+				continue
+			}
+			parts := convertGeminiContent(message["content"])
+			for _, toolCall := range toolCalls {
+				if part := geminiFunctionCallPart(toolCall); part != nil {
+					parts = append(parts, part)
+				}
+			}
+			appendTurn("model", parts)
+		case "tool":
+			toolCallID, _ := message["tool_call_id"].(string)
+			name := toolNames[toolCallID]
+			if name == "" {
+				log.Printf("Warning: dropping result of unknown tool call %q for gemini", toolCallID)
+				continue
+			}
+			appendTurn("user", []interface{}{map[string]interface{}{
+				"functionResponse": map[string]interface{}{
+					"name":     name,
+					"response": map[string]interface{}{"result": geminiContentText(message["content"])},
+				},
+			}})
+		}
+	}
+
+	return strings.Join(systemParts, "\n\n"), contents
+}
+
+func geminiFunctionCallPart(toolCall map[string]interface{}) map[string]interface{} {
+	function, _ := toolCall["function"].(map[string]interface{})
+	name, _ := function["name"].(string)
+	if name == "" {
+		return nil
+	}
+
+	args := map[string]interface{}{}
+	if arguments, ok := function["arguments"].(string); ok && strings.TrimSpace(arguments) != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			log.Printf("Warning: dropping unparsable arguments of tool call %s: %v", name, err)
+			args = map[string]interface{}{}
+		}
+	}
+
+	return map[string]interface{}{
+		"functionCall": map[string]interface{}{
+			"name": name,
+			"args": args,
+		},
+	}
+}
+
+// convertGeminiContent converts a plain string or an OpenAI-style content
+// array into Gemini parts. Data URL images and base64 document blocks become
+// inlineData parts, unsupported parts are dropped.
+func convertGeminiContent(content interface{}) []interface{} {
+	parts := []interface{}{}
+
+	var contentParts []map[string]interface{}
+	switch typed := content.(type) {
+	case string:
+		if strings.TrimSpace(typed) != "" {
+			parts = append(parts, map[string]interface{}{"text": typed})
+		}
+		return parts
+	case []map[string]interface{}:
+		contentParts = typed
+	case []interface{}:
+		for _, rawPart := range typed {
+			if part, ok := rawPart.(map[string]interface{}); ok {
+				contentParts = append(contentParts, part)
+			}
+		}
+	}
+
+	for _, part := range contentParts {
+		partType, _ := part["type"].(string)
+		switch partType {
+		case "text":
+			if text, _ := part["text"].(string); strings.TrimSpace(text) != "" {
+				parts = append(parts, map[string]interface{}{"text": text})
+			}
+		case "image_url":
+			imageURL, _ := part["image_url"].(map[string]interface{})
+			dataURL, _ := imageURL["url"].(string)
+			header, data, found := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+			mimeType, isBase64 := strings.CutSuffix(header, ";base64")
+			if !strings.HasPrefix(dataURL, "data:") || !found || !isBase64 || mimeType == "" {
+				log.Printf("Skipping image that is not a base64 data URL, unsupported by gemini")
+				continue
+			}
+			parts = append(parts, geminiInlineData(mimeType, data))
+		case "image", "document":
+			source, _ := part["source"].(map[string]interface{})
+			mimeType, _ := source["media_type"].(string)
+			data, _ := source["data"].(string)
+			if source["type"] != "base64" || mimeType == "" || data == "" {
+				continue
+			}
+			parts = append(parts, geminiInlineData(mimeType, data))
+		default:
+			log.Printf("Skipping content part of type %q unsupported by gemini", partType)
+		}
+	}
+
+	return parts
+}
+
+func geminiInlineData(mimeType, data string) map[string]interface{} {
+	return map[string]interface{}{
+		"inlineData": map[string]interface{}{
+			"mimeType": mimeType,
+			"data":     data,
+		},
+	}
+}
+
+func geminiContentText(content interface{}) string {
+	if text, ok := content.(string); ok {
+		return text
+	}
+	texts := []string{}
+	for _, rawPart := range convertGeminiContent(content) {
+		part, _ := rawPart.(map[string]interface{})
+		if text, ok := part["text"].(string); ok {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// convertGeminiTools converts OpenAI function tool definitions into Gemini
+// function declarations.
+func convertGeminiTools(tools []interface{}) []map[string]interface{} {
+	declarations := make([]map[string]interface{}, 0, len(tools))
+	for _, rawTool := range tools {
+		encoded, err := json.Marshal(rawTool)
+		if err != nil {
+			continue
+		}
+		var tool struct {
+			Function struct {
+				Name        string                 `json:"name"`
+				Description string                 `json:"description"`
+				Parameters  map[string]interface{} `json:"parameters"`
+			} `json:"function"`
+		}
+		if err := json.Unmarshal(encoded, &tool); err != nil || tool.Function.Name == "" {
+			continue
+		}
+
+		declaration := map[string]interface{}{
+			"name":        tool.Function.Name,
+			"description": tool.Function.Description,
+		}
+		// Gemini rejects object schemas without properties.
+		if properties, _ := tool.Function.Parameters["properties"].(map[string]interface{}); len(properties) > 0 {
+			declaration["parameters"] = sanitizeGeminiSchema(tool.Function.Parameters)
+		}
+		declarations = append(declarations, declaration)
+	}
+	return declarations
+}
+
+// sanitizeGeminiSchema keeps the schema keywords Gemini understands and turns
+// ["type", "null"] unions into nullable types.
+func sanitizeGeminiSchema(schema map[string]interface{}) map[string]interface{} {
+	sanitized := map[string]interface{}{}
+	for key, value := range schema {
+		if !geminiSchemaKeys[key] {
+			continue
+		}
+		switch key {
+		case "type":
+			if types, ok := value.([]interface{}); ok {
+				for _, candidate := range types {
+					if candidate == "null" {
+						sanitized["nullable"] = true
+					} else if _, set := sanitized["type"]; !set {
+						sanitized["type"] = candidate
+					}
+				}
+				continue
+			}
+			sanitized[key] = value
+		case "properties":
+			properties, _ := value.(map[string]interface{})
+			sanitizedProperties := map[string]interface{}{}
+			for name, rawProperty := range properties {
+				if property, ok := rawProperty.(map[string]interface{}); ok {
+					sanitizedProperties[name] = sanitizeGeminiSchema(property)
+				}
+			}
+			sanitized[key] = sanitizedProperties
+		case "items":
+			if items, ok := value.(map[string]interface{}); ok {
+				sanitized[key] = sanitizeGeminiSchema(items)
+			}
+		case "anyOf":
+			variants, _ := value.([]interface{})
+			sanitizedVariants := make([]interface{}, 0, len(variants))
+			for _, rawVariant := range variants {
+				if variant, ok := rawVariant.(map[string]interface{}); ok {
+					sanitizedVariants = append(sanitizedVariants, sanitizeGeminiSchema(variant))
+				}
+			}
+			sanitized[key] = sanitizedVariants
+		default:
+			sanitized[key] = value
+		}
+	}
+	return sanitized
+}
+
+type geminiPart struct {
+	Text             string `json:"text"`
+	Thought          bool   `json:"thought"`
+	ThoughtSignature string `json:"thoughtSignature"`
+	FunctionCall     *struct {
+		ID   string                 `json:"id"`
+		Name string                 `json:"name"`
+		Args map[string]interface{} `json:"args"`
+	} `json:"functionCall"`
+}
+
+type geminiStreamChunk struct {
+	Candidates []struct {
+		Content struct {
+			Parts []geminiPart `json:"parts"`
+		} `json:"content"`
+		FinishReason string `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+		TotalTokenCount         int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// processGeminiStreamingResponseReader consumes streamGenerateContent
+// server-sent events. Thought parts are wrapped in <think> tags, text parts are
+// forwarded as chunks and the first function call is executed. Every chunk
+// carries the usage so far, only the last one is reported.
+func processGeminiStreamingResponseReader(ctx context.Context, reader *bufio.Reader, stream ProviderStream) (*toolCallResult, error) {
+	result := &toolCallResult{}
+	var aiResponseBuilder strings.Builder
+	var usage *TokenUsage
+	// assistantContent keeps the non-thought parts, their thought signatures
+	// have to be sent back with the function response.
+	assistantContent := []interface{}{}
+	thinking := false
+	toolExecuted := false
+
+	sendChunk := func(chunk string) error {
+		if chunk == "" {
+			return nil
+		}
+		return sendOrCancel(ctx, stream.Chunks, chunk)
+	}
+	endThinking := func() error {
+		if !thinking {
+			return nil
+		}
+		thinking = false
+		return sendChunk("</think>")
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed reading response: %w", err)
+		}
+
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" {
+			continue
+		}
+
+		var chunk geminiStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return nil, fmt.Errorf("gemini stream error: %d %s: %s", chunk.Error.Code, chunk.Error.Status, chunk.Error.Message)
+		}
+		if chunk.UsageMetadata != nil {
+			completionTokens := chunk.UsageMetadata.CandidatesTokenCount + chunk.UsageMetadata.ThoughtsTokenCount
+			usage = &TokenUsage{
+				PromptTokens:       chunk.UsageMetadata.PromptTokenCount,
+				CompletionTokens:   completionTokens,
+				TotalTokens:        chunk.UsageMetadata.PromptTokenCount + completionTokens,
+				CachedPromptTokens: chunk.UsageMetadata.CachedContentTokenCount,
+			}
+		}
+		if len(chunk.Candidates) == 0 {
+			continue
+		}
+
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			if part.Thought {
+				if !thinking {
+					thinking = true
+					if err := sendChunk("<think>"); err != nil {
+						return nil, err
+					}
+				}
+				if err := sendChunk(part.Text); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := endThinking(); err != nil {
+				return nil, err
+			}
+
+			if part.FunctionCall == nil {
+				if part.Text == "" && part.ThoughtSignature == "" {
+					continue
+				}
+				assistantContent = appendGeminiTextPart(assistantContent, part)
+				aiResponseBuilder.WriteString(part.Text)
+				if err := sendChunk(part.Text); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			if toolExecuted {
+				log.Printf("Warning: ignoring additional function call %s in the same turn", part.FunctionCall.Name)
+				continue
+			}
+			executed, err := executeGeminiFunctionCall(ctx, part, assistantContent, stream, result)
+			if err != nil {
+				return nil, err
+			}
+			toolExecuted = executed
+		}
+	}
+
+	if err := endThinking(); err != nil {
+		return nil, err
+	}
+	if usage != nil {
+		if err := sendOrCancel(ctx, stream.Usage, usage); err != nil {
+			return nil, err
+		}
+	}
+
+	result.aiResponse = aiResponseBuilder.String()
+	return result, nil
+}
+
+// appendGeminiTextPart merges streamed text into the previous text part unless
+// a thought signature has to stay attached to its own part.
+func appendGeminiTextPart(parts []interface{}, part geminiPart) []interface{} {
+	if len(parts) > 0 && part.ThoughtSignature == "" {
+		if last, ok := parts[len(parts)-1].(map[string]interface{}); ok {
+			if text, isText := last["text"].(string); isText {
+				last["text"] = text + part.Text
+				return parts
+			}
+		}
+	}
+	native := map[string]interface{}{"text": part.Text}
+	if part.ThoughtSignature != "" {
+		native["thoughtSignature"] = part.ThoughtSignature
+	}
+	return append(parts, native)
+}
+
+// executeGeminiFunctionCall runs a streamed function call and records the
+// model turn that requested it on result. Gemini does not always assign call
+// ids, so one is generated for the tool loop when missing.
+func executeGeminiFunctionCall(
+	ctx context.Context,
+	part geminiPart,
+	assistantContent []interface{},
+	stream ProviderStream,
+	result *toolCallResult,
+) (bool, error) {
+	name := part.FunctionCall.Name
+	tool, exists := stream.ToolMap[name]
+	if !exists {
+		log.Printf("Warning: Tool '%s' not found in toolMap", name)
+		return false, nil
+	}
+
+	args := part.FunctionCall.Args
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	encodedArgs, err := json.Marshal(args)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal function call arguments: %w", err)
+	}
+	arguments := string(encodedArgs)
+	toolInput, parseErr := tool.ParseArguments(arguments)
+	if parseErr != nil {
+		log.Printf("Warning: failed to parse arguments of tool '%s': %v", name, parseErr)
+		return false, nil
+	}
+
+	id := part.FunctionCall.ID
+	if id == "" {
+		id = fmt.Sprintf("gemini-call-%d", time.Now().UnixNano())
+	}
+
+	functionCall := map[string]interface{}{"name": name, "args": args}
+	if part.FunctionCall.ID != "" {
+		functionCall["id"] = part.FunctionCall.ID
+	}
+	nativeCall := map[string]interface{}{"functionCall": functionCall}
+	if part.ThoughtSignature != "" {
+		nativeCall["thoughtSignature"] = part.ThoughtSignature
+	}
+	result.assistantContent = append(append([]interface{}{}, assistantContent...), nativeCall)
+
+	fmt.Printf("\n=== EXECUTING TOOL: %s ===\n", name)
+	fmt.Printf("Tool ID: %s\n", id)
+	fmt.Printf("Arguments: %s\n", arguments)
+
+	if err := runStreamedToolCall(ctx, tool, id, name, arguments, toolInput, stream.ExecutedToolResults, stream.ToolCalls, result); err != nil {
+		return false, err
+	}
+	return true, nil
+}
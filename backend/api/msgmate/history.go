@@ -0,0 +1,300 @@
+package msgmate
+
+import (
+	wsapi "backend/api/websocket"
+	"backend/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	client "github.com/msgmate-io/go-client-integration/goclient"
+)
+
+const (
+	// DefaultContextLength is the context window assumed for models without
+	// a "context_length" in their chat or model configuration.
+	DefaultContextLength = 8192
+	// historyFetchLimit is the number of messages loaded to fill the token
+	// budget, the "context" message count still applies when it is larger.
+	historyFetchLimit         = 100
+	defaultReplyTokenReserve  = 1024
+	messageTokenOverhead      = 4
+	attachmentTokenEstimate   = 1000
+	summaryTranscriptMaxChars = 4000
+	summaryTimeout            = 60 * time.Second
+)
+
+const historySummaryPrompt = "You maintain the running summary of a conversation between a user and an assistant. " +
+	"Merge the new messages into the summary so far. Keep facts, decisions, open questions, names and " +
+	"preferences that later turns may rely on, drop small talk. Answer with the updated summary only."
+
+var summaryThinkPattern = regexp.MustCompile(`(?is)<think>.*?</think>`)
+
+// estimateTokens approximates the tokens of a text at four characters per
+// token, close enough for budgeting without a tokenizer per model.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+func estimateListedMessageTokens(msg client.ListedMessage) int {
+	tokens := messageTokenOverhead + estimateTokens(msg.Text)
+	if msg.ToolCalls != nil && len(*msg.ToolCalls) > 0 {
+		if encoded, err := json.Marshal(*msg.ToolCalls); err == nil {
+			tokens += estimateTokens(string(encoded))
+		}
+	}
+	if msg.MetaData != nil {
+		if attachments, ok := (*msg.MetaData)["attachments"].([]interface{}); ok {
+			tokens += len(attachments) * attachmentTokenEstimate
+		}
+		if quote, ok := replyQuote(msg.MetaData); ok {
+			quotedText, _ := quote["text"].(string)
+			tokens += estimateTokens(quotedText)
+		}
+	}
+	return tokens
+}
+
+// historySelection splits listed messages, newest first like the messages API
+// lists them, into the recent ones sent verbatim and the older ones that do
+// not fit the token budget and were not summarized yet.
+type historySelection struct {
+	Recent   []client.ListedMessage
+	Overflow []client.ListedMessage
+}
+
+// selectHistory fills the token budget with the newest messages. Messages up
+// to summarizedUntilUUID are covered by the running summary and left out. The
+// newest message is always kept, even when it alone exceeds the budget.
+func selectHistory(rows []client.ListedMessage, budget int, summarizedUntilUUID string) historySelection {
+	selection := historySelection{}
+	used := 0
+	full := false
+	for i, msg := range rows {
+		if summarizedUntilUUID != "" && msg.UUID == summarizedUntilUUID {
+			break
+		}
+		if msg.DataType == "event" {
+			if !full {
+				selection.Recent = append(selection.Recent, msg)
+			}
+			continue
+		}
+
+		tokens := estimateListedMessageTokens(msg)
+		if !full && (i == 0 || used+tokens <= budget) {
+			selection.Recent = append(selection.Recent, msg)
+			used += tokens
+			continue
+		}
+		full = true
+		selection.Overflow = append(selection.Overflow, msg)
+	}
+	return selection
+}
+
+// historyTokenBudget is the part of the model's context window left for the
+// history once the system prompt, the tools and the reply are accounted for.
+func (aih *AIHandlerImpl) historyTokenBudget(configMap map[string]interface{}, attempt ModelAttempt, systemPrompt string, toolsData []interface{}, options CompletionOptions) int {
+	contextLength := int(mapGetOrDefault[float64](configMap, "context_length", 0))
+	if contextLength <= 0 {
+		resolved, err := database.ResolveModelContextLength(aih.botContext.DB, attempt.ModelConfigUUID, attempt.Model)
+		if err != nil {
+			log.Printf("Failed to resolve the context length of %s: %v", attempt.Model, err)
+		}
+		contextLength = resolved
+	}
+	if contextLength <= 0 {
+		contextLength = DefaultContextLength
+	}
+
+	reserve := options.MaxTokens
+	if reserve <= 0 {
+		reserve = defaultReplyTokenReserve
+	}
+	reserve += options.ThinkingBudget
+
+	budget := contextLength - reserve - estimateTokens(systemPrompt)
+	if len(toolsData) > 0 {
+		if encoded, err := json.Marshal(toolsData); err == nil {
+			budget -= estimateTokens(string(encoded))
+		}
+	}
+	if budget < 0 {
+		return 0
+	}
+	return budget
+}
+
+// fitHistory trims the loaded history to the token budget of the model and
+// rolls the messages that no longer fit into the chat's running summary,
+// which is appended to the returned system prompt. Without a database the
+// history is sent as loaded.
+func (aih *AIHandlerImpl) fitHistory(
+	ctx context.Context,
+	message wsapi.NewMessage,
+	configMap map[string]interface{},
+	attempt ModelAttempt,
+	systemPrompt string,
+	toolsData []interface{},
+	options CompletionOptions,
+	paginatedMessages client.PaginatedMessages,
+) (string, client.PaginatedMessages) {
+	DB := aih.botContext.DB
+	if DB == nil {
+		return systemPrompt, paginatedMessages
+	}
+
+	summaryText := ""
+	summarizedUntilUUID := ""
+	summary, err := database.GetChatSummary(DB, message.Content.ChatUUID)
+	if err != nil {
+		log.Printf("Failed to load the summary of chat %s: %v", message.Content.ChatUUID, err)
+	} else if summary != nil {
+		summaryText = summary.Text
+		summarizedUntilUUID = summary.SummarizedUntilUUID
+	}
+
+	budget := aih.historyTokenBudget(configMap, attempt, systemPrompt, toolsData, options) - estimateTokens(summaryText)
+	selection := selectHistory(paginatedMessages.Rows, budget, summarizedUntilUUID)
+
+	if len(selection.Overflow) > 0 && mapGetOrDefault[bool](configMap, "summarize_history", true) {
+		updated, err := aih.summarizeHistory(ctx, message, attempt, summaryText, selection.Overflow)
+		if err != nil {
+			log.Printf("Failed to summarize %d messages of chat %s: %v", len(selection.Overflow), message.Content.ChatUUID, err)
+		} else if err := database.SaveChatSummary(DB, message.Content.ChatUUID, updated, selection.Overflow[0].UUID, len(selection.Overflow)); err != nil {
+			log.Printf("Failed to save the summary of chat %s: %v", message.Content.ChatUUID, err)
+		} else {
+			summaryText = updated
+		}
+	}
+
+	if summaryText != "" {
+		systemPrompt += "\n\nSummary of the earlier conversation:\n" + summaryText
+	}
+	paginatedMessages.Rows = selection.Recent
+	return systemPrompt, paginatedMessages
+}
+
+// summarizeHistory merges messages, newest first, into the previous summary
+// with the chat's own model. The usage is accounted like a reply.
+func (aih *AIHandlerImpl) summarizeHistory(ctx context.Context, message wsapi.NewMessage, attempt ModelAttempt, previous string, messages []client.ListedMessage) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+	defer cancel()
+
+	transcript := buildSummaryTranscript(messages, aih.botContext.Client.User.UUID)
+	summary, usage, err := runSummaryCompletion(ctx, attempt, aih.providerAPIKey(attempt.Backend), previous, transcript)
+	if usage != nil {
+		answered := &answeredModel{}
+		answered.set(attempt, 0, 1)
+		aih.recordTokenUsage(message, usage, answered)
+	}
+	return summary, err
+}
+
+// buildSummaryTranscript renders messages, newest first, as an oldest first
+// plain text transcript. Long messages are cut.
+func buildSummaryTranscript(messages []client.ListedMessage, botUUID string) string {
+	var transcript strings.Builder
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		speaker := "User"
+		if msg.SenderUUID == botUUID {
+			speaker = "Assistant"
+		}
+
+		text := strings.TrimSpace(msg.Text)
+		if utf8.RuneCountInString(text) > summaryTranscriptMaxChars {
+			text = string([]rune(text)[:summaryTranscriptMaxChars]) + "…"
+		}
+		if msg.MetaData != nil {
+			if attachments, ok := (*msg.MetaData)["attachments"].([]interface{}); ok && len(attachments) > 0 {
+				text = strings.TrimSpace(fmt.Sprintf("%s [%d attachment(s)]", text, len(attachments)))
+			}
+		}
+		if msg.ToolCalls != nil {
+			for _, rawToolCall := range *msg.ToolCalls {
+				if toolCall, ok := rawToolCall.(map[string]interface{}); ok {
+					if name, _ := toolCall["name"].(string); name != "" {
+						text = strings.TrimSpace(fmt.Sprintf("%s [used tool %s]", text, name))
+					}
+				}
+			}
+		}
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", speaker, text)
+	}
+	return transcript.String()
+}
+
+// runSummaryCompletion runs a single tool-less completion round that merges
+// the transcript into the previous summary.
+func runSummaryCompletion(ctx context.Context, attempt ModelAttempt, apiKey, previous, transcript string) (string, *TokenUsage, error) {
+	if strings.TrimSpace(previous) == "" {
+		previous = "(none yet)"
+	}
+	request := ProviderRequest{
+		Endpoint: attempt.Endpoint,
+		Model:    attempt.Model,
+		APIKey:   apiKey,
+		Messages: []map[string]interface{}{
+			{"role": "system", "content": historySummaryPrompt},
+			{"role": "user", "content": fmt.Sprintf("Summary so far:\n%s\n\nNew messages:\n%s", previous, transcript)},
+		},
+	}
+
+	chunks := make(chan string)
+	usage := make(chan *TokenUsage)
+	toolCalls := make(chan ToolCall)
+	var lastUsage *TokenUsage
+	drained := make(chan struct{})
+	go func(chunks <-chan string, usage <-chan *TokenUsage, toolCalls <-chan ToolCall) {
+		defer close(drained)
+		for chunks != nil || usage != nil || toolCalls != nil {
+			select {
+			case _, ok := <-chunks:
+				if !ok {
+					chunks = nil
+				}
+			case usageInfo, ok := <-usage:
+				if !ok {
+					usage = nil
+					continue
+				}
+				lastUsage = usageInfo
+			case _, ok := <-toolCalls:
+				if !ok {
+					toolCalls = nil
+				}
+			}
+		}
+	}(chunks, usage, toolCalls)
+
+	result, err := ProviderForBackend(attempt.Backend).StreamRound(ctx, request, ProviderStream{
+		ToolMap:             map[string]Tool{},
+		ExecutedToolResults: map[string]string{},
+		Chunks:              chunks,
+		Usage:               usage,
+		ToolCalls:           toolCalls,
+	})
+	close(chunks)
+	close(usage)
+	close(toolCalls)
+	<-drained
+
+	if err != nil {
+		return "", lastUsage, err
+	}
+	summary := strings.TrimSpace(summaryThinkPattern.ReplaceAllString(result.aiResponse, ""))
+	if summary == "" {
+		return "", lastUsage, fmt.Errorf("empty summary from %s/%s", attempt.Backend, attempt.Model)
+	}
+	return summary, lastUsage, nil
+}
@@ -0,0 +1,104 @@
+package msgmate
+
+import (
+	wsapi "backend/api/websocket"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	client "github.com/msgmate-io/go-client-integration/goclient"
+)
+
+func TestSelectHistoryFillsTokenBudgetNewestFirst(t *testing.T) {
+	rows := []client.ListedMessage{
+		{UUID: "m5", SenderUUID: "user", Text: "newest question"},
+		{UUID: "m4", SenderUUID: "bot", Text: strings.Repeat("a", 80)},
+		{UUID: "m3", DataType: "event", Text: "joined"},
+		{UUID: "m2", SenderUUID: "user", Text: strings.Repeat("b", 400)},
+		{UUID: "m1", SenderUUID: "bot", Text: "oldest"},
+	}
+
+	// m5 and m4 take 8 + 24 tokens, m2 alone would need 104
+	selection := selectHistory(rows, 50, "")
+	if got := listedUUIDs(selection.Recent); got != "m5,m4,m3" {
+		t.Fatalf("unexpected recent messages %s", got)
+	}
+	if got := listedUUIDs(selection.Overflow); got != "m2,m1" {
+		t.Fatalf("unexpected overflow %s", got)
+	}
+
+	selection = selectHistory(rows, 50, "m2")
+	if got := listedUUIDs(selection.Overflow); got != "" {
+		t.Fatalf("expected summarized messages to be left out, got overflow %s", got)
+	}
+
+	selection = selectHistory(rows, 0, "")
+	if got := listedUUIDs(selection.Recent); got != "m5" {
+		t.Fatalf("expected the newest message to be kept over budget, got %s", got)
+	}
+}
+
+func listedUUIDs(rows []client.ListedMessage) string {
+	uuids := []string{}
+	for _, row := range rows {
+		uuids = append(uuids, row.UUID)
+	}
+	return strings.Join(uuids, ",")
+}
+
+func TestRunSummaryCompletionMergesTranscriptIntoSummary(t *testing.T) {
+	server, requests := recordingStandIn(t, func(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"choices":[{"delta":{"content":"<think>merging</think>The user is Ada and plans a trip to Rome."}}],"usage":{"prompt_tokens":120,"completion_tokens":12,"total_tokens":132}}` + "\n\n" + "data: [DONE]\n\n"))
+	})
+
+	metaData := map[string]interface{}{"attachments": []interface{}{map[string]interface{}{"file_id": "f1"}}}
+	transcript := buildSummaryTranscript([]client.ListedMessage{
+		{SenderUUID: "bot", Text: "Rome is lovely in spring."},
+		{SenderUUID: "user", Text: "I'm planning a trip to Rome.", MetaData: &metaData},
+	}, "bot")
+	if transcript != "User: I'm planning a trip to Rome. [1 attachment(s)]\nAssistant: Rome is lovely in spring.\n" {
+		t.Fatalf("unexpected transcript %q", transcript)
+	}
+
+	attempt := ModelAttempt{Backend: "openai", Endpoint: server.URL, Model: "gpt-test"}
+	summary, usage, err := runSummaryCompletion(context.Background(), attempt, "key", "The user is Ada.", transcript)
+	if err != nil {
+		t.Fatalf("summary completion failed: %v", err)
+	}
+	if summary != "The user is Ada and plans a trip to Rome." {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+	if usage == nil || usage.TotalTokens != 132 {
+		t.Fatalf("expected the summary usage to be reported, got %+v", usage)
+	}
+	if len(*requests) != 1 || !bodyContains((*requests)[0], "Summary so far:\\nThe user is Ada.") || bodyContains((*requests)[0], `"tools"`) {
+		t.Fatalf("unexpected summary request %+v", *requests)
+	}
+}
+
+func TestBuildOpenAIMessagesQuotesRepliedMessage(t *testing.T) {
+	aih := &AIHandlerImpl{botContext: &BotContext{Client: &client.Client{User: client.User{UUID: "bot"}}}}
+	quote := map[string]interface{}{"reply_to": map[string]interface{}{
+		"message_uuid": "m1",
+		"sender_uuid":  "bot",
+		"text":         "Paris is the capital.\nIt has 2M people.",
+	}}
+	paginated := client.PaginatedMessages{Rows: []client.ListedMessage{
+		{UUID: "m2", SenderUUID: "user", Text: "how many?", MetaData: &quote},
+		{UUID: "m1", SenderUUID: "bot", Text: "Paris is the capital.\nIt has 2M people."},
+	}}
+	message := wsapi.NewMessage{}
+	message.Content.Text = "how many?"
+	message.Content.MetaData = &quote
+
+	messages := aih.buildOpenAIMessages(&paginated, message, "system", "openai", DefaultMaxImageDimension)
+	if len(messages) != 3 {
+		t.Fatalf("expected system, assistant and user messages, got %+v", messages)
+	}
+	want := "Replying to your earlier message:\n> Paris is the capital.\n> It has 2M people.\n\nhow many?"
+	if content := messages[2]["content"]; content != want {
+		t.Fatalf("expected the reply to quote the bot message, got %q", content)
+	}
+}
@@ -0,0 +1,384 @@
+package msgmate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ollamaProvider streams from a local Ollama server through its native
+// {endpoint}/api/chat API, so no hosted service or API key is needed.
+type ollamaProvider struct{}
+
+func (p *ollamaProvider) Name() string {
+	return "ollama"
+}
+
+// ResolveEndpoint also accepts OLLAMA_HOST values without a scheme, the way
+// the ollama CLI does.
+func (p *ollamaProvider) ResolveEndpoint(configured string) (string, error) {
+	endpoint, err := resolveProviderEndpoint(p.Name(), configured, "OLLAMA_HOST", "http://localhost:11434")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "http://" + endpoint
+	}
+	return endpoint, nil
+}
+
+func (p *ollamaProvider) StreamRound(ctx context.Context, request ProviderRequest, stream ProviderStream) (*toolCallResult, error) {
+	requestBody := map[string]interface{}{
+		"model":    request.Model,
+		"messages": convertOllamaMessages(request.Messages),
+		"stream":   true,
+	}
+	if len(request.Tools) > 0 {
+		requestBody["tools"] = request.Tools
+	}
+	if request.Options.Reasoning {
+		requestBody["think"] = true
+	}
+	if request.Options.MaxTokens > 0 {
+		requestBody["options"] = map[string]interface{}{"num_predict": request.Options.MaxTokens}
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/chat", request.Endpoint), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	// Ollama itself has no authentication, the key is for proxies in front of it.
+	if request.APIKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", request.APIKey))
+	}
+
+	client := &http.Client{Timeout: 300 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, &ProviderStatusError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+
+	return processOllamaStreamingResponseReader(ctx, bufio.NewReader(resp.Body), stream)
+}
+
+// ListModels lists the locally pulled models from {endpoint}/api/tags.
+func (p *ollamaProvider) ListModels(ctx context.Context, endpoint, apiKey string) ([]ProviderModel, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/tags", endpoint), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	}
+
+	var listing struct {
+		Models []struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		} `json:"models"`
+	}
+	if err := fetchProviderJSON(req, &listing); err != nil {
+		return nil, err
+	}
+
+	models := make([]ProviderModel, 0, len(listing.Models))
+	for _, model := range listing.Models {
+		id := model.Model
+		if id == "" {
+			id = model.Name
+		}
+		models = append(models, ProviderModel{ID: id, Name: model.Name})
+	}
+	return models, nil
+}
+
+// convertOllamaMessages converts OpenAI-style messages into Ollama chat
+// messages. Content arrays are flattened into text plus base64 images, tool
+// call arguments are sent as objects and tool results carry the tool name.
+func convertOllamaMessages(messages []map[string]interface{}) []map[string]interface{} {
+	converted := make([]map[string]interface{}, 0, len(messages))
+	toolNames := map[string]string{}
+
+	for _, message := range messages {
+		role, _ := message["role"].(string)
+		text, images := convertOllamaContent(message["content"])
+		ollamaMessage := map[string]interface{}{
+			"role":    role,
+			"content": text,
+		}
+		if len(images) > 0 {
+			ollamaMessage["images"] = images
+		}
+
+		switch role {
+		case "assistant":
+			toolCalls, _ := message["tool_calls"].([]map[string]interface{})
+			ollamaToolCalls := []map[string]interface{}{}
+			for _, toolCall := range toolCalls {
+				id, _ := toolCall["id"].(string)
+				function, _ := toolCall["function"].(map[string]interface{})
+				name, _ := function["name"].(string)
+				if name == "" {
+					continue
+				}
+				toolNames[id] = name
+
+				arguments := map[string]interface{}{}
+				if rawArguments, ok := function["arguments"].(string); ok && strings.TrimSpace(rawArguments) != "" {
+					if err := json.Unmarshal([]byte(rawArguments), &arguments); err != nil {
+						log.Printf("Warning: dropping unparsable arguments of tool call %s: %v", id, err)
+						arguments = map[string]interface{}{}
+					}
+				}
+				ollamaToolCalls = append(ollamaToolCalls, map[string]interface{}{
+					"function": map[string]interface{}{
+						"name":      name,
+						"arguments": arguments,
+					},
+				})
+			}
+			if len(ollamaToolCalls) > 0 {
+				ollamaMessage["tool_calls"] = ollamaToolCalls
+			}
+		case "tool":
+			toolCallID, _ := message["tool_call_id"].(string)
+			if name := toolNames[toolCallID]; name != "" {
+				ollamaMessage["tool_name"] = name
+			}
+		}
+
+		converted = append(converted, ollamaMessage)
+	}
+
+	return converted
+}
+
+func convertOllamaContent(content interface{}) (string, []string) {
+	var parts []map[string]interface{}
+	switch typed := content.(type) {
+	case string:
+		return typed, nil
+	case []map[string]interface{}:
+		parts = typed
+	case []interface{}:
+		for _, rawPart := range typed {
+			if part, ok := rawPart.(map[string]interface{}); ok {
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	texts := []string{}
+	images := []string{}
+	for _, part := range parts {
+		partType, _ := part["type"].(string)
+		switch partType {
+		case "text":
+			if text, _ := part["text"].(string); strings.TrimSpace(text) != "" {
+				texts = append(texts, text)
+			}
+		case "image_url":
+			imageURL, _ := part["image_url"].(map[string]interface{})
+			url, _ := imageURL["url"].(string)
+			_, data, found := strings.Cut(url, ";base64,")
+			if !strings.HasPrefix(url, "data:") || !found {
+				log.Printf("Skipping image that is not a base64 data URL, unsupported by ollama")
+				continue
+			}
+			images = append(images, data)
+		default:
+			log.Printf("Skipping content part of type %q unsupported by ollama", partType)
+		}
+	}
+
+	return strings.Join(texts, "\n"), images
+}
+
+type ollamaStreamChunk struct {
+	Message struct {
+		Content   string `json:"content"`
+		Thinking  string `json:"thinking"`
+		ToolCalls []struct {
+			Function struct {
+				Name      string                 `json:"name"`
+				Arguments map[string]interface{} `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// processOllamaStreamingResponseReader consumes the newline delimited JSON
+// stream of /api/chat. Thinking is wrapped in <think> tags, content is
+// forwarded as chunks and the first tool call is executed. Token counts are
+// only reported with the final chunk.
+func processOllamaStreamingResponseReader(ctx context.Context, reader *bufio.Reader, stream ProviderStream) (*toolCallResult, error) {
+	result := &toolCallResult{}
+	var aiResponseBuilder strings.Builder
+	thinking := false
+	toolExecuted := false
+
+	sendChunk := func(chunk string) error {
+		if chunk == "" {
+			return nil
+		}
+		return sendOrCancel(ctx, stream.Chunks, chunk)
+	}
+	endThinking := func() error {
+		if !thinking {
+			return nil
+		}
+		thinking = false
+		return sendChunk("</think>")
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, fmt.Errorf("failed reading response: %w", err)
+		}
+
+		if data := strings.TrimSpace(line); data != "" {
+			var chunk ollamaStreamChunk
+			if unmarshalErr := json.Unmarshal([]byte(data), &chunk); unmarshalErr != nil {
+				return nil, fmt.Errorf("failed to unmarshal chunk: %w", unmarshalErr)
+			}
+			if chunk.Error != "" {
+				return nil, fmt.Errorf("ollama stream error: %s", chunk.Error)
+			}
+
+			if chunk.Message.Thinking != "" {
+				if !thinking {
+					thinking = true
+					if sendErr := sendChunk("<think>"); sendErr != nil {
+						return nil, sendErr
+					}
+				}
+				if sendErr := sendChunk(chunk.Message.Thinking); sendErr != nil {
+					return nil, sendErr
+				}
+			}
+			if chunk.Message.Content != "" || len(chunk.Message.ToolCalls) > 0 {
+				if endErr := endThinking(); endErr != nil {
+					return nil, endErr
+				}
+			}
+			if chunk.Message.Content != "" {
+				aiResponseBuilder.WriteString(chunk.Message.Content)
+				if sendErr := sendChunk(chunk.Message.Content); sendErr != nil {
+					return nil, sendErr
+				}
+			}
+
+			for _, toolCall := range chunk.Message.ToolCalls {
+				if toolExecuted {
+					log.Printf("Warning: ignoring additional tool call %s in the same turn", toolCall.Function.Name)
+					continue
+				}
+				executed, execErr := executeOllamaToolCall(ctx, toolCall.Function.Name, toolCall.Function.Arguments, stream, result)
+				if execErr != nil {
+					return nil, execErr
+				}
+				toolExecuted = executed
+			}
+
+			if chunk.Done {
+				if endErr := endThinking(); endErr != nil {
+					return nil, endErr
+				}
+				if chunk.PromptEvalCount > 0 || chunk.EvalCount > 0 {
+					usage := &TokenUsage{
+						PromptTokens:     chunk.PromptEvalCount,
+						CompletionTokens: chunk.EvalCount,
+						TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+					}
+					if sendErr := sendOrCancel(ctx, stream.Usage, usage); sendErr != nil {
+						return nil, sendErr
+					}
+				}
+				break
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if err := endThinking(); err != nil {
+		return nil, err
+	}
+
+	result.aiResponse = aiResponseBuilder.String()
+	return result, nil
+}
+
+// executeOllamaToolCall runs a streamed tool call. Ollama does not assign call
+// ids, so one is generated for the tool loop.
+func executeOllamaToolCall(
+	ctx context.Context,
+	name string,
+	args map[string]interface{},
+	stream ProviderStream,
+	result *toolCallResult,
+) (bool, error) {
+	tool, exists := stream.ToolMap[name]
+	if !exists {
+		log.Printf("Warning: Tool '%s' not found in toolMap", name)
+		return false, nil
+	}
+
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	encodedArgs, err := json.Marshal(args)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal tool call arguments: %w", err)
+	}
+	arguments := string(encodedArgs)
+	toolInput, parseErr := tool.ParseArguments(arguments)
+	if parseErr != nil {
+		log.Printf("Warning: failed to parse arguments of tool '%s': %v", name, parseErr)
+		return false, nil
+	}
+
+	id := fmt.Sprintf("ollama-call-%d", time.Now().UnixNano())
+
+	fmt.Printf("\n=== EXECUTING TOOL: %s ===\n", name)
+	fmt.Printf("Tool ID: %s\n", id)
+	fmt.Printf("Arguments: %s\n", arguments)
+
+	if err := runStreamedToolCall(ctx, tool, id, name, arguments, toolInput, stream.ExecutedToolResults, stream.ToolCalls, result); err != nil {
+		return false, err
+	}
+	return true, nil
+}
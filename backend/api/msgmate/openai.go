@@ -3,6 +3,7 @@ package msgmate
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -238,7 +239,11 @@ func toolRequest(host string, model string, backend string, messages []map[strin
 //  1. chunks: a channel of text chunks that arrive from the stream
 //  2. usage: a channel for usage information (if any occur)
 //  3. errs: a channel for errors (if any occur)
+//
+// Cancelling ctx aborts the upstream request and any running tool, and the
+// stream ends with ctx's error.
 func streamChatCompletion(
+	ctx context.Context,
 	host string,
 	model string,
 	backend string,
@@ -248,20 +253,13 @@ func streamChatCompletion(
 	tools []interface{},
 	toolMap map[string]Tool,
 	apiKey string,
+	options CompletionOptions,
 	interactionStartTools []string,
 	interactionCompleteTools []string,
 	handler *MsgmateHandler,
-) (<-chan string, <-chan *struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
-}, <-chan ToolCall, <-chan error) {
+) (<-chan string, <-chan *TokenUsage, <-chan ToolCall, <-chan error) {
 	chunkChan := make(chan string)
-	usageChan := make(chan *struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	})
+	usageChan := make(chan *TokenUsage)
 	toolChan := make(chan ToolCall)
 	errChan := make(chan error, 1)
 
@@ -287,7 +285,7 @@ func streamChatCompletion(
 						continue
 					}
 
-					toolResult, err := tool.RunTool(map[string]interface{}{})
+					toolResult, err := runToolWithContext(ctx, tool, map[string]interface{}{})
 					if err != nil {
 						log.Printf("Error executing interaction_start tool %s: %v", actualToolName, err)
 						continue
@@ -323,6 +321,10 @@ func streamChatCompletion(
 		aiResponseComplete := false
 
 		for {
+			if err := ctx.Err(); err != nil {
+				errChan <- err
+				return
+			}
 			if totalToolCalls >= toolCallMaxTotal {
 				errChan <- fmt.Errorf("exceeded maximum number of tool calls (%d)", toolCallMaxTotal)
 				return
@@ -336,7 +338,7 @@ func streamChatCompletion(
 			fmt.Println("\n=== STARTING NEW REQUEST ROUND ===")
 			fmt.Printf("Current tool-call counts: total=%d/%d failed=%d/%d\n", totalToolCalls, toolCallMaxTotal, failedToolCalls, toolCallMaxFailed)
 			toolCallResult, err := processStreamingRequest(
-				host, model, backend, currentMessages, tools, toolMap, apiKey,
+				ctx, host, model, backend, currentMessages, tools, toolMap, apiKey, options,
 				executedToolResults,
 				chunkChan, usageChan, toolChan, errChan,
 			)
@@ -417,7 +419,7 @@ func streamChatCompletion(
 							completionData["tool_call_max_total"] = toolCallMaxTotal
 							completionData["tool_call_max_failed"] = toolCallMaxFailed
 
-							toolResult, err := tool.RunTool(completionData)
+							toolResult, err := runToolWithContext(ctx, tool, completionData)
 							if err != nil {
 								log.Printf("Error executing interaction_complete tool %s: %v", actualToolName, err)
 								continue
@@ -484,6 +486,9 @@ func streamChatCompletion(
 					},
 				},
 			}
+			if len(toolCallResult.assistantContent) > 0 {
+				toolsCallMessage[providerContentKey] = toolCallResult.assistantContent
+			}
 			currentMessages = append(currentMessages, toolsCallMessage)
 
 			// Add tool result to messages and continue conversation
@@ -519,43 +524,74 @@ type toolCallResult struct {
 	error             string
 	err               error
 	aiResponse        string
+	// assistantContent holds the provider-native content blocks of the turn
+	// that requested the tool, for backends that must replay them verbatim.
+	assistantContent []interface{}
 }
 
+// processStreamingRequest runs one round of the conversation against the
+// provider registered for backend.
 func processStreamingRequest(
+	ctx context.Context,
 	host, model, backend string,
 	messages []map[string]interface{},
 	tools []interface{},
 	toolMap map[string]Tool,
 	apiKey string,
+	options CompletionOptions,
 	executedToolResults map[string]string,
 	chunkChan chan<- string,
-	usageChan chan<- *struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	},
+	usageChan chan<- *TokenUsage,
 	toolChan chan<- ToolCall,
 	errChan chan<- error,
 ) (*toolCallResult, error) {
-	if backend == "testbackend" {
-		reader, err := buildTestBackendStreamingReader(messages, toolMap)
-		if err != nil {
-			return nil, err
-		}
-		return processStreamingResponseReader(reader, toolMap, executedToolResults, chunkChan, usageChan, toolChan)
+	request := ProviderRequest{
+		Endpoint: host,
+		Model:    model,
+		APIKey:   apiKey,
+		Messages: messages,
+		Tools:    tools,
+		Options:  options,
 	}
+	stream := ProviderStream{
+		ToolMap:             toolMap,
+		ExecutedToolResults: executedToolResults,
+		Chunks:              chunkChan,
+		Usage:               usageChan,
+		ToolCalls:           toolChan,
+	}
+	return ProviderForBackend(backend).StreamRound(ctx, request, stream)
+}
 
-	normalizedMessages := normalizeMessagesForBackend(messages, backend)
+// openAICompatibleProvider streams from servers implementing the OpenAI
+// chat completions API, which covers OpenAI itself, hosted inference
+// providers, litellm and most self-hosted model servers.
+type openAICompatibleProvider struct {
+	name            string
+	defaultEndpoint string
+	hostEnv         string
+	// includeUsage asks for a final usage chunk, which not every server accepts.
+	includeUsage bool
+}
+
+func (p *openAICompatibleProvider) Name() string {
+	return p.name
+}
 
+func (p *openAICompatibleProvider) ResolveEndpoint(configured string) (string, error) {
+	return resolveProviderEndpoint(p.name, configured, p.hostEnv, p.defaultEndpoint)
+}
+
+func (p *openAICompatibleProvider) StreamRound(ctx context.Context, request ProviderRequest, stream ProviderStream) (*toolCallResult, error) {
 	requestBody := map[string]interface{}{
-		"model":    model,
-		"messages": normalizedMessages,
+		"model":    request.Model,
+		"messages": normalizeMessagesForBackend(request.Messages, p.name),
 		"stream":   true,
 	}
-	if len(tools) > 0 {
-		requestBody["tools"] = tools
+	if len(request.Tools) > 0 {
+		requestBody["tools"] = request.Tools
 	}
-	if backend == "openai" {
+	if p.includeUsage {
 		requestBody["stream_options"] = map[string]interface{}{"include_usage": true}
 	}
 
@@ -565,13 +601,13 @@ func processStreamingRequest(
 		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/chat/completions", host), bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/chat/completions", request.Endpoint), bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", request.APIKey))
 
 	client := &http.Client{Timeout: 300 * time.Second}
 	resp, err := client.Do(req)
@@ -582,11 +618,83 @@ func processStreamingRequest(
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("non-200 response: %d %s", resp.StatusCode, string(bodyBytes))
+		return nil, &ProviderStatusError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
 	}
 
 	reader := bufio.NewReader(resp.Body)
-	return processStreamingResponseReader(reader, toolMap, executedToolResults, chunkChan, usageChan, toolChan)
+	return processStreamingResponseReader(ctx, reader, stream.ToolMap, stream.ExecutedToolResults, stream.Chunks, stream.Usage, stream.ToolCalls)
+}
+
+// ListModels reads the OpenAI-style {endpoint}/models listing.
+func (p *openAICompatibleProvider) ListModels(ctx context.Context, endpoint, apiKey string) ([]ProviderModel, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/models", endpoint), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	}
+
+	var listing struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := fetchProviderJSON(req, &listing); err != nil {
+		return nil, err
+	}
+
+	models := make([]ProviderModel, 0, len(listing.Data))
+	for _, model := range listing.Data {
+		models = append(models, ProviderModel{ID: model.ID})
+	}
+	return models, nil
+}
+
+// testBackendProvider replays canned OpenAI-style streams for end-to-end tests
+// without a model server.
+type testBackendProvider struct{}
+
+func (p *testBackendProvider) Name() string {
+	return "testbackend"
+}
+
+func (p *testBackendProvider) ResolveEndpoint(configured string) (string, error) {
+	return strings.TrimSpace(configured), nil
+}
+
+func (p *testBackendProvider) StreamRound(ctx context.Context, request ProviderRequest, stream ProviderStream) (*toolCallResult, error) {
+	reader, err := buildTestBackendStreamingReader(request.Messages, stream.ToolMap)
+	if err != nil {
+		return nil, err
+	}
+	return processStreamingResponseReader(ctx, reader, stream.ToolMap, stream.ExecutedToolResults, stream.Chunks, stream.Usage, stream.ToolCalls)
+}
+
+func (p *testBackendProvider) ListModels(ctx context.Context, endpoint, apiKey string) ([]ProviderModel, error) {
+	return []ProviderModel{{ID: "testbackend", Name: "Test backend"}}, nil
+}
+
+// fetchProviderJSON performs a model discovery request and decodes the JSON
+// response into out.
+func fetchProviderJSON(req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return &ProviderStatusError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
 }
 
 func normalizeMessagesForBackend(messages []map[string]interface{}, backend string) []map[string]interface{} {
@@ -617,16 +725,47 @@ func normalizeMessagesForBackend(messages []map[string]interface{}, backend stri
 	return normalized
 }
 
+// sendOrCancel delivers v unless ctx is cancelled first, so the stream does not
+// block once the consumer has stopped reading.
+func sendOrCancel[T any](ctx context.Context, ch chan<- T, v T) error {
+	select {
+	case ch <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// openAIUsage is the usage object of OpenAI-compatible streams, cached
+// prompt tokens are reported in its prompt_tokens_details.
+type openAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+func (u *openAIUsage) tokenUsage() *TokenUsage {
+	usage := &TokenUsage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+	if u.PromptTokensDetails != nil {
+		usage.CachedPromptTokens = u.PromptTokensDetails.CachedTokens
+	}
+	return usage
+}
+
 func processStreamingResponseReader(
+	ctx context.Context,
 	reader *bufio.Reader,
 	toolMap map[string]Tool,
 	executedToolResults map[string]string,
 	chunkChan chan<- string,
-	usageChan chan<- *struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	},
+	usageChan chan<- *TokenUsage,
 	toolChan chan<- ToolCall,
 ) (*toolCallResult, error) {
 	result := &toolCallResult{}
@@ -639,10 +778,16 @@ func processStreamingResponseReader(
 	var aiResponseBuilder strings.Builder
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		line, err := reader.ReadString('\n')
 		if err == io.EOF {
 			break
 		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed reading response: %w", err)
 		}
@@ -663,11 +808,7 @@ func processStreamingResponseReader(
 					ToolCalls []interface{} `json:"tool_calls"`
 				} `json:"delta"`
 			} `json:"choices"`
-			Usage *struct {
-				PromptTokens     int `json:"prompt_tokens"`
-				CompletionTokens int `json:"completion_tokens"`
-				TotalTokens      int `json:"total_tokens"`
-			} `json:"usage"`
+			Usage *openAIUsage `json:"usage"`
 		}
 
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
@@ -675,7 +816,9 @@ func processStreamingResponseReader(
 		}
 
 		if chunk.Usage != nil {
-			usageChan <- chunk.Usage
+			if err := sendOrCancel(ctx, usageChan, chunk.Usage.tokenUsage()); err != nil {
+				return nil, err
+			}
 		}
 
 		if len(chunk.Choices) == 0 {
@@ -684,7 +827,9 @@ func processStreamingResponseReader(
 		delta := chunk.Choices[0].Delta
 
 		if delta.Content != "" {
-			chunkChan <- delta.Content
+			if err := sendOrCancel(ctx, chunkChan, delta.Content); err != nil {
+				return nil, err
+			}
 			aiResponseBuilder.WriteString(delta.Content)
 		}
 
@@ -739,103 +884,130 @@ func processStreamingResponseReader(
 			fmt.Printf("Tool ID: %s\n", currentToolCall.id)
 			fmt.Printf("Arguments: %s\n", currentToolCall.arguments)
 
-			contentSignature := fmt.Sprintf("%s:%s", currentToolCall.name, strings.TrimSpace(currentToolCall.arguments))
-			if cachedResult, alreadyExecuted := executedToolResults[contentSignature]; alreadyExecuted {
-				log.Printf("Warning: Duplicate tool call detected with content signature: %s, reusing prior result", contentSignature)
-
-				status := ToolCallStatusSucceeded
-				toolErr := ""
-				if strings.Contains(strings.ToLower(cachedResult), " failed with error:") {
-					status = ToolCallStatusFailed
-					toolErr = "duplicate tool call reused prior failure result"
-				}
+			if err := runStreamedToolCall(ctx, tool, currentToolCall.id, currentToolCall.name, currentToolCall.arguments, toolInput, executedToolResults, toolChan, result); err != nil {
+				return nil, err
+			}
 
-				result.usedTool = true
-				result.id = currentToolCall.id
-				result.toolName = currentToolCall.name
-				result.arguments = currentToolCall.arguments
-				result.result = cachedResult
-				result.status = status
-				result.error = toolErr
+			break
+		}
+	}
 
-				toolChan <- ToolCall{
-					ToolName:  currentToolCall.name,
-					ToolInput: toolInput,
-					Id:        currentToolCall.id,
-					Result:    cachedResult,
-					Status:    status,
-					Error:     toolErr,
-				}
-				break
-			}
+	result.aiResponse = aiResponseBuilder.String()
+	return result, nil
+}
 
-			result.usedTool = true
-			result.id = currentToolCall.id
-			result.toolName = currentToolCall.name
-			result.arguments = currentToolCall.arguments
-
-			toolChan <- ToolCall{
-				ToolName:  currentToolCall.name,
-				ToolInput: toolInput,
-				Id:        currentToolCall.id,
-				Result:    "",
-				Status:    ToolCallStatusOngoing,
-			}
+// runStreamedToolCall executes a fully streamed tool call, reports its progress
+// on toolChan and records the outcome on result. Repeated calls with the same
+// arguments reuse the earlier result instead of running the tool again.
+func runStreamedToolCall(
+	ctx context.Context,
+	tool Tool,
+	id, name, arguments string,
+	toolInput interface{},
+	executedToolResults map[string]string,
+	toolChan chan<- ToolCall,
+	result *toolCallResult,
+) error {
+	contentSignature := fmt.Sprintf("%s:%s", name, strings.TrimSpace(arguments))
+	if cachedResult, alreadyExecuted := executedToolResults[contentSignature]; alreadyExecuted {
+		log.Printf("Warning: Duplicate tool call detected with content signature: %s, reusing prior result", contentSignature)
+
+		status := ToolCallStatusSucceeded
+		toolErr := ""
+		if strings.Contains(strings.ToLower(cachedResult), " failed with error:") {
+			status = ToolCallStatusFailed
+			toolErr = "duplicate tool call reused prior failure result"
+		}
 
-			var toolResult string
-			status := ToolCallStatusSucceeded
-			toolErr := ""
-			if tool.GetRequiresConfirmation() {
-				continueAfterExecute := tool.GetStopOnFirstConfirmableToolCall()
-				executedResult, runErr := tool.RunTool(toolInput)
-				if runErr == nil && isConfirmActionPayload(executedResult) {
-					toolResult = executedResult
-				} else {
-					toolResult = buildConfirmationSuggestion(tool.GetToolName(), toolInput, continueAfterExecute)
-				}
-				status = ToolCallStatusPendingConfirmation
+		result.usedTool = true
+		result.id = id
+		result.toolName = name
+		result.arguments = arguments
+		result.result = cachedResult
+		result.status = status
+		result.error = toolErr
+
+		return sendOrCancel(ctx, toolChan, ToolCall{
+			ToolName:  name,
+			ToolInput: toolInput,
+			Id:        id,
+			Result:    cachedResult,
+			Status:    status,
+			Error:     toolErr,
+		})
+	}
 
-				modelResult := toolResult
-				if blockMessage := strings.TrimSpace(tool.GetConfirmationBlockMessage()); blockMessage != "" {
-					modelResult = blockMessage
-				}
-				result.result = modelResult
-				if tool.GetStopOnFirstConfirmableToolCall() {
-					result.stopAfterTool = true
-				}
-			} else {
-				executedResult, runErr := tool.RunTool(toolInput)
-				if runErr != nil {
-					log.Printf("Error executing tool %s: %v", currentToolCall.name, runErr)
-					toolResult = buildToolErrorPlaceholder(currentToolCall.name, runErr)
-					result.result = toolResult
-					status = ToolCallStatusFailed
-					toolErr = runErr.Error()
-				} else {
-					toolResult = executedResult
-					result.result = toolResult
-					status = ToolCallStatusSucceeded
-				}
-			}
+	result.usedTool = true
+	result.id = id
+	result.toolName = name
+	result.arguments = arguments
+
+	if err := sendOrCancel(ctx, toolChan, ToolCall{
+		ToolName:  name,
+		ToolInput: toolInput,
+		Id:        id,
+		Result:    "",
+		Status:    ToolCallStatusOngoing,
+	}); err != nil {
+		return err
+	}
 
-			toolChan <- ToolCall{
-				ToolName:  currentToolCall.name,
-				ToolInput: toolInput,
-				Id:        currentToolCall.id,
-				Result:    toolResult,
-				Status:    status,
-				Error:     toolErr,
-			}
-			result.status = status
-			result.error = toolErr
-			executedToolResults[contentSignature] = toolResult
+	var toolResult string
+	status := ToolCallStatusSucceeded
+	toolErr := ""
+	if tool.GetRequiresConfirmation() {
+		continueAfterExecute := tool.GetStopOnFirstConfirmableToolCall()
+		executedResult, runErr := runToolWithContext(ctx, tool, toolInput)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if runErr == nil && isConfirmActionPayload(executedResult) {
+			toolResult = executedResult
+		} else {
+			toolResult = buildConfirmationSuggestion(tool.GetToolName(), toolInput, continueAfterExecute)
+		}
+		status = ToolCallStatusPendingConfirmation
 
-			break
+		modelResult := toolResult
+		if blockMessage := strings.TrimSpace(tool.GetConfirmationBlockMessage()); blockMessage != "" {
+			modelResult = blockMessage
+		}
+		result.result = modelResult
+		if tool.GetStopOnFirstConfirmableToolCall() {
+			result.stopAfterTool = true
+		}
+	} else {
+		executedResult, runErr := runToolWithContext(ctx, tool, toolInput)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if runErr != nil {
+			log.Printf("Error executing tool %s: %v", name, runErr)
+			toolResult = buildToolErrorPlaceholder(name, runErr)
+			result.result = toolResult
+			status = ToolCallStatusFailed
+			toolErr = runErr.Error()
+		} else {
+			toolResult = executedResult
+			result.result = toolResult
+			status = ToolCallStatusSucceeded
 		}
 	}
 
-	result.aiResponse = aiResponseBuilder.String()
-	return result, nil
+	if err := sendOrCancel(ctx, toolChan, ToolCall{
+		ToolName:  name,
+		ToolInput: toolInput,
+		Id:        id,
+		Result:    toolResult,
+		Status:    status,
+		Error:     toolErr,
+	}); err != nil {
+		return err
+	}
+	result.status = status
+	result.error = toolErr
+	executedToolResults[contentSignature] = toolResult
+	return nil
 }
 
 func buildTestBackendStreamingReader(
@@ -0,0 +1,197 @@
+package msgmate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// providerContentKey stores the provider-native content of an assistant turn
+// on an OpenAI-style message, so thinking signatures survive the tool loop.
+const providerContentKey = "provider_content"
+
+// TokenUsage is the usage report streamed by every provider, it matches the
+// usage object of OpenAI-compatible responses. CachedPromptTokens is the part
+// of PromptTokens the provider read from its prompt cache.
+type TokenUsage struct {
+	PromptTokens       int `json:"prompt_tokens"`
+	CompletionTokens   int `json:"completion_tokens"`
+	TotalTokens        int `json:"total_tokens"`
+	CachedPromptTokens int `json:"cached_prompt_tokens,omitempty"`
+}
+
+// CompletionOptions carries per-chat generation settings that not every backend uses.
+type CompletionOptions struct {
+	Reasoning      bool
+	MaxTokens      int
+	ThinkingBudget int
+}
+
+// ProviderRequest is one round of the conversation sent to a provider. Messages
+// and tools use the OpenAI chat format shared across the bot pipeline.
+type ProviderRequest struct {
+	Endpoint string
+	Model    string
+	APIKey   string
+	Messages []map[string]interface{}
+	Tools    []interface{}
+	Options  CompletionOptions
+}
+
+// ProviderStream holds the tools of the reply and the channels a provider
+// streams text chunks, usage and tool call updates onto.
+type ProviderStream struct {
+	ToolMap             map[string]Tool
+	ExecutedToolResults map[string]string
+	Chunks              chan<- string
+	Usage               chan<- *TokenUsage
+	ToolCalls           chan<- ToolCall
+}
+
+// ProviderModel is a model reported by a provider's model discovery.
+type ProviderModel struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// Provider is an LLM backend the bot can stream replies from.
+//
+// StreamRound runs a single request of the tool loop: it streams the answer,
+// executes at most one requested tool and reports it on the returned result.
+// The surrounding loop in streamChatCompletion appends the tool call and its
+// result to the history and calls StreamRound again.
+type Provider interface {
+	Name() string
+	ResolveEndpoint(configured string) (string, error)
+	StreamRound(ctx context.Context, request ProviderRequest, stream ProviderStream) (*toolCallResult, error)
+	ListModels(ctx context.Context, endpoint, apiKey string) ([]ProviderModel, error)
+}
+
+// DefaultMaxImageDimension is the longest side images are downscaled to for
+// models that don't configure "max_image_dimension".
+const DefaultMaxImageDimension = 2048
+
+// imageProvider is implemented by providers that accept smaller images than
+// DefaultMaxImageDimension without downscaling them on their side.
+type imageProvider interface {
+	MaxImageDimension() int
+}
+
+// documentProvider is implemented by providers that accept file attachments
+// of the given mime type as inline base64 documents.
+type documentProvider interface {
+	SupportsDocument(mimeType string) bool
+}
+
+var (
+	providers   = map[string]Provider{}
+	providersMu sync.RWMutex
+)
+
+func init() {
+	registerProvider(&openAICompatibleProvider{name: "openai", defaultEndpoint: "https://api.openai.com/v1", includeUsage: true})
+	registerProvider(&openAICompatibleProvider{name: "deepinfra", defaultEndpoint: "https://api.deepinfra.com/v1/openai"})
+	registerProvider(&openAICompatibleProvider{name: "groq", defaultEndpoint: "https://api.groq.com/openai/v1"})
+	registerProvider(&openAICompatibleProvider{name: "litellm", hostEnv: "LITELLM_API_HOST"})
+	registerProvider(&anthropicProvider{})
+	registerProvider(&geminiProvider{})
+	registerProvider(&ollamaProvider{})
+	registerProvider(&testBackendProvider{})
+}
+
+func normalizeProviderName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func registerProvider(provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if provider == nil {
+		panic("provider cannot be nil")
+	}
+	name := normalizeProviderName(provider.Name())
+	if name == "" {
+		panic("provider name cannot be empty")
+	}
+	if _, exists := providers[name]; exists {
+		panic("provider already registered for: " + name)
+	}
+	providers[name] = provider
+}
+
+// GetProvider returns the registered provider for name.
+func GetProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	provider, ok := providers[normalizeProviderName(name)]
+	return provider, ok
+}
+
+// ListProviders returns the names of all registered providers, sorted.
+func ListProviders() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// localAIEndpoint is the self-hosted gateway chat configs without an
+// "endpoint" key have always been sent to, whatever their backend. An empty
+// "endpoint" lets the provider pick its own API host instead.
+const localAIEndpoint = "http://localai:8080"
+
+// ProviderForBackend returns the provider for a chat's configured backend.
+// Unknown backends are treated as OpenAI-compatible servers, which is how
+// self-hosted endpoints like localai were always addressed; without an
+// endpoint they default to the localai host.
+func ProviderForBackend(backend string) Provider {
+	if provider, ok := GetProvider(backend); ok {
+		return provider
+	}
+	return &openAICompatibleProvider{name: normalizeProviderName(backend), defaultEndpoint: localAIEndpoint}
+}
+
+// resolveProviderEndpoint picks the API host from hostEnv, then the configured
+// endpoint, then fallback, and strips trailing slashes.
+func resolveProviderEndpoint(name, configured, hostEnv, fallback string) (string, error) {
+	endpoint := strings.TrimSpace(configured)
+	if hostEnv != "" {
+		if host := strings.TrimSpace(os.Getenv(hostEnv)); host != "" {
+			endpoint = host
+		}
+	}
+	if endpoint == "" {
+		endpoint = fallback
+	}
+	endpoint = strings.TrimRight(strings.TrimSpace(endpoint), "/")
+	if endpoint == "" {
+		return "", fmt.Errorf("missing API host for %s provider", name)
+	}
+	return endpoint, nil
+}
+
+func providerSupportsDocument(backend, mimeType string) bool {
+	provider, ok := GetProvider(backend)
+	if !ok {
+		return false
+	}
+	documents, ok := provider.(documentProvider)
+	return ok && documents.SupportsDocument(mimeType)
+}
+
+func providerMaxImageDimension(backend string) int {
+	if provider, ok := GetProvider(backend); ok {
+		if images, ok := provider.(imageProvider); ok {
+			return images.MaxImageDimension()
+		}
+	}
+	return DefaultMaxImageDimension
+}
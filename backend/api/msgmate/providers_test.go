@@ -0,0 +1,289 @@
+package msgmate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type completionOutput struct {
+	text        string
+	totalTokens int
+	calls       []ToolCall
+}
+
+func drainCompletion(t *testing.T, chunks <-chan string, usage <-chan *TokenUsage, toolCalls <-chan ToolCall, errs <-chan error) completionOutput {
+	t.Helper()
+	var output completionOutput
+	var text strings.Builder
+	for chunks != nil || usage != nil || toolCalls != nil || errs != nil {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				chunks = nil
+				continue
+			}
+			text.WriteString(chunk)
+		case usageInfo, ok := <-usage:
+			if !ok {
+				usage = nil
+				continue
+			}
+			output.totalTokens += usageInfo.TotalTokens
+		case toolCall, ok := <-toolCalls:
+			if !ok {
+				toolCalls = nil
+				continue
+			}
+			output.calls = append(output.calls, toolCall)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			t.Fatalf("unexpected stream error: %v", err)
+		}
+	}
+	output.text = text.String()
+	return output
+}
+
+// recordingStandIn answers every request with respond and keeps the decoded request bodies.
+func recordingStandIn(t *testing.T, respond func(w http.ResponseWriter, r *http.Request, body map[string]interface{})) (*httptest.Server, *[]map[string]interface{}) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("failed to decode request: %v", err)
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			requests = append(requests, body)
+			mu.Unlock()
+		}
+		respond(w, r, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func bodyContains(body map[string]interface{}, marker string) bool {
+	encoded, _ := json.Marshal(body)
+	return strings.Contains(string(encoded), marker)
+}
+
+func TestStreamChatCompletionGeminiFunctionCallLoop(t *testing.T) {
+	server, requests := recordingStandIn(t, func(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+		if r.URL.Path != "/v1beta/models/gemini-2.5-flash:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.String())
+		}
+		if r.Header.Get("x-goog-api-key") != "test-key" {
+			t.Errorf("expected x-goog-api-key header, got %q", r.Header.Get("x-goog-api-key"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		if bodyContains(body, `"functionResponse"`) {
+			w.Write([]byte(`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"It is 12:00 "}]}}]}` + "\n\n" +
+				`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"in UTC."}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":60,"candidatesTokenCount":7,"totalTokenCount":67}}` + "\n\n"))
+			return
+		}
+		w.Write([]byte(`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"The user wants the time.","thought":true}]}}],"usageMetadata":{"promptTokenCount":40,"thoughtsTokenCount":5,"totalTokenCount":45}}` + "\n\n" +
+			`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_clock_testing","args":{"timezone":"UTC"}},"thoughtSignature":"c2lnbmF0dXJl"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":40,"candidatesTokenCount":10,"thoughtsTokenCount":5,"totalTokenCount":55}}` + "\n\n"))
+	})
+
+	tool := newClockTestingTool()
+	toolMap := map[string]Tool{tool.GetToolFunctionName(): tool}
+	endpoint, err := (&geminiProvider{}).ResolveEndpoint(server.URL + "/v1beta/")
+	if err != nil {
+		t.Fatalf("failed to resolve endpoint: %v", err)
+	}
+
+	chunks, usage, toolCalls, errs := streamChatCompletion(
+		context.Background(),
+		endpoint,
+		"models/gemini-2.5-flash",
+		"gemini",
+		0,
+		0,
+		[]map[string]interface{}{
+			{"role": "system", "content": "You are a clock."},
+			{"role": "user", "content": []map[string]interface{}{
+				{"type": "text", "text": "What time is it?"},
+				{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64,iVBORw0KGgo="}},
+			}},
+		},
+		[]interface{}{tool.ConstructTool()},
+		toolMap,
+		"test-key",
+		CompletionOptions{Reasoning: true, ThinkingBudget: 512},
+		nil,
+		nil,
+		nil,
+	)
+	output := drainCompletion(t, chunks, usage, toolCalls, errs)
+
+	if output.text != "<think>The user wants the time.</think>It is 12:00 in UTC." {
+		t.Fatalf("unexpected streamed text %q", output.text)
+	}
+	if output.totalTokens != 55+67 {
+		t.Fatalf("expected only the last usage of each round, got %d", output.totalTokens)
+	}
+	if len(output.calls) != 2 || output.calls[1].Status != ToolCallStatusSucceeded || output.calls[1].Result != "12:00 UTC" {
+		t.Fatalf("unexpected tool call updates %+v", output.calls)
+	}
+
+	if len(*requests) != 2 {
+		t.Fatalf("expected two rounds, got %d", len(*requests))
+	}
+	first := (*requests)[0]
+	system := first["systemInstruction"].(map[string]interface{})["parts"].([]interface{})[0].(map[string]interface{})
+	if system["text"] != "You are a clock." {
+		t.Fatalf("expected system instruction, got %#v", first["systemInstruction"])
+	}
+	thinkingConfig := first["generationConfig"].(map[string]interface{})["thinkingConfig"].(map[string]interface{})
+	if thinkingConfig["includeThoughts"] != true || thinkingConfig["thinkingBudget"] != float64(512) {
+		t.Fatalf("expected thinking config, got %#v", thinkingConfig)
+	}
+	declarations := first["tools"].([]interface{})[0].(map[string]interface{})["functionDeclarations"].([]interface{})
+	if declarations[0].(map[string]interface{})["name"] != "get_clock_testing" {
+		t.Fatalf("expected function declaration, got %#v", declarations)
+	}
+	userParts := first["contents"].([]interface{})[0].(map[string]interface{})["parts"].([]interface{})
+	if len(userParts) != 2 || userParts[1].(map[string]interface{})["inlineData"] == nil {
+		t.Fatalf("expected text and inline image parts, got %#v", userParts)
+	}
+
+	second := (*requests)[1]["contents"].([]interface{})
+	if len(second) != 3 {
+		t.Fatalf("expected user, model and function response turns, got %#v", second)
+	}
+	modelPart := second[1].(map[string]interface{})["parts"].([]interface{})[0].(map[string]interface{})
+	if modelPart["thoughtSignature"] != "c2lnbmF0dXJl" || modelPart["functionCall"] == nil {
+		t.Fatalf("expected signed function call to be replayed, got %#v", modelPart)
+	}
+	functionResponse := second[2].(map[string]interface{})["parts"].([]interface{})[0].(map[string]interface{})["functionResponse"].(map[string]interface{})
+	if functionResponse["name"] != "get_clock_testing" || functionResponse["response"].(map[string]interface{})["result"] != "12:00 UTC" {
+		t.Fatalf("expected function response, got %#v", functionResponse)
+	}
+}
+
+func TestStreamChatCompletionOllamaToolLoopAndDiscovery(t *testing.T) {
+	server, requests := recordingStandIn(t, func(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"models":[{"name":"qwen3:8b","model":"qwen3:8b"},{"name":"llama3.2:latest","model":"llama3.2:latest"}]}`))
+		case "/api/chat":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			if bodyContains(body, `"tool_name"`) {
+				w.Write([]byte(`{"message":{"role":"assistant","content":"It is 12:00 UTC."},"done":false}` + "\n" +
+					`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":50,"eval_count":6}` + "\n"))
+				return
+			}
+			w.Write([]byte(`{"message":{"role":"assistant","content":"","thinking":"Need the clock."},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_clock_testing","arguments":{"timezone":"UTC"}}}]},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":30,"eval_count":9}` + "\n"))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	})
+
+	t.Setenv("OLLAMA_HOST", strings.TrimPrefix(server.URL, "http://"))
+	provider := ProviderForBackend("Ollama")
+	endpoint, err := provider.ResolveEndpoint("")
+	if err != nil || endpoint != server.URL {
+		t.Fatalf("expected OLLAMA_HOST endpoint %q, got %q (%v)", server.URL, endpoint, err)
+	}
+
+	models, err := provider.ListModels(context.Background(), endpoint, "")
+	if err != nil {
+		t.Fatalf("model discovery failed: %v", err)
+	}
+	if len(models) != 2 || models[0].ID != "qwen3:8b" {
+		t.Fatalf("unexpected discovered models %+v", models)
+	}
+
+	tool := newClockTestingTool()
+	toolMap := map[string]Tool{tool.GetToolFunctionName(): tool}
+	chunks, usage, toolCalls, errs := streamChatCompletion(
+		context.Background(),
+		endpoint,
+		"qwen3:8b",
+		"ollama",
+		0,
+		0,
+		[]map[string]interface{}{{"role": "user", "content": "What time is it?"}},
+		[]interface{}{tool.ConstructTool()},
+		toolMap,
+		"",
+		CompletionOptions{Reasoning: true, MaxTokens: 256},
+		nil,
+		nil,
+		nil,
+	)
+	output := drainCompletion(t, chunks, usage, toolCalls, errs)
+
+	if output.text != "<think>Need the clock.</think>It is 12:00 UTC." {
+		t.Fatalf("unexpected streamed text %q", output.text)
+	}
+	if output.totalTokens != 39+56 {
+		t.Fatalf("unexpected total tokens %d", output.totalTokens)
+	}
+	if len(output.calls) != 2 || output.calls[1].Result != "12:00 UTC" {
+		t.Fatalf("unexpected tool call updates %+v", output.calls)
+	}
+
+	chatRequests := *requests
+	if len(chatRequests) != 2 {
+		t.Fatalf("expected two chat rounds, got %d", len(chatRequests))
+	}
+	if chatRequests[0]["think"] != true || chatRequests[0]["options"].(map[string]interface{})["num_predict"] != float64(256) {
+		t.Fatalf("unexpected request options %#v", chatRequests[0])
+	}
+	messages := chatRequests[1]["messages"].([]interface{})
+	assistantCall := messages[1].(map[string]interface{})["tool_calls"].([]interface{})[0].(map[string]interface{})["function"].(map[string]interface{})
+	if assistantCall["arguments"].(map[string]interface{})["timezone"] != "UTC" {
+		t.Fatalf("expected tool call arguments as an object, got %#v", assistantCall)
+	}
+	toolMessage := messages[2].(map[string]interface{})
+	if toolMessage["role"] != "tool" || toolMessage["tool_name"] != "get_clock_testing" || toolMessage["content"] != "12:00 UTC" {
+		t.Fatalf("expected tool result message, got %#v", toolMessage)
+	}
+}
+
+func TestProviderForBackendFallsBackToOpenAICompatible(t *testing.T) {
+	for _, name := range []string{"openai", "anthropic", "gemini", "ollama", "litellm"} {
+		if _, ok := GetProvider(name); !ok {
+			t.Fatalf("expected provider %q to be registered", name)
+		}
+	}
+
+	provider := ProviderForBackend("localai")
+	if _, ok := provider.(*openAICompatibleProvider); !ok || provider.Name() != "localai" {
+		t.Fatalf("expected openai-compatible fallback, got %#v", provider)
+	}
+	endpoint, err := provider.ResolveEndpoint("http://localai:8080/")
+	if err != nil || endpoint != "http://localai:8080" {
+		t.Fatalf("unexpected endpoint %q (%v)", endpoint, err)
+	}
+	if endpoint, err := ProviderForBackend("localai").ResolveEndpoint(""); err != nil || endpoint != "http://localai:8080" {
+		t.Fatalf("expected unknown backends to default to localai, got %q (%v)", endpoint, err)
+	}
+	// Known providers resolve their own default when no endpoint is configured
+	if endpoint, err := ProviderForBackend("gemini").ResolveEndpoint(""); err != nil || endpoint != "https://generativelanguage.googleapis.com/v1beta" {
+		t.Fatalf("expected the gemini default endpoint, got %q (%v)", endpoint, err)
+	}
+
+	t.Setenv("LITELLM_API_HOST", "")
+	if _, err := ProviderForBackend("litellm").ResolveEndpoint(" "); err == nil {
+		t.Fatalf("expected missing litellm host to fail")
+	}
+}
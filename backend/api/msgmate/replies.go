@@ -0,0 +1,48 @@
+package msgmate
+
+import (
+	"backend/database"
+	"strings"
+)
+
+// replyQuote returns the quote stored with a reply in its meta data.
+func replyQuote(metaData *map[string]interface{}) (map[string]interface{}, bool) {
+	if metaData == nil {
+		return nil, false
+	}
+	quote, ok := (*metaData)[database.ReplyToMetaKey].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	if text, _ := quote["text"].(string); strings.TrimSpace(text) == "" {
+		return nil, false
+	}
+	return quote, true
+}
+
+// withReplyQuote prefixes the text of a reply with the message it quotes, so
+// the model knows which earlier answer the user refers to even when it is no
+// longer part of the history.
+func withReplyQuote(text string, metaData *map[string]interface{}, botUUID string) string {
+	quote, ok := replyQuote(metaData)
+	if !ok {
+		return text
+	}
+	quotedText, _ := quote["text"].(string)
+	header := "Replying to an earlier message:"
+	if senderUUID, _ := quote["sender_uuid"].(string); senderUUID != "" && senderUUID == botUUID {
+		header = "Replying to your earlier message:"
+	}
+
+	var builder strings.Builder
+	builder.WriteString(header)
+	builder.WriteString("\n")
+	for _, line := range strings.Split(strings.TrimSpace(quotedText), "\n") {
+		builder.WriteString("> ")
+		builder.WriteString(line)
+		builder.WriteString("\n")
+	}
+	builder.WriteString("\n")
+	builder.WriteString(text)
+	return builder.String()
+}
@@ -0,0 +1,51 @@
+package msgmate
+
+import (
+	wsapi "backend/api/websocket"
+	"backend/database"
+	"log"
+)
+
+// recordTokenUsage writes the usage of one completion to the token usage
+// ledger, priced with the model config of the answering model. It returns
+// the cost and whether the model has prices. Replies without a database,
+// like the standalone bot, are not accounted.
+func (aih *AIHandlerImpl) recordTokenUsage(message wsapi.NewMessage, usage *TokenUsage, answered *answeredModel) (float64, bool) {
+	DB := aih.botContext.DB
+	if DB == nil || usage == nil {
+		return 0, false
+	}
+
+	entry := database.TokenUsageEntry{
+		BotUserId:          aih.botContext.BotUser.ID,
+		ChatUUID:           message.Content.ChatUUID,
+		PromptTokens:       int64(usage.PromptTokens),
+		CompletionTokens:   int64(usage.CompletionTokens),
+		TotalTokens:        int64(usage.TotalTokens),
+		CachedPromptTokens: int64(usage.CachedPromptTokens),
+	}
+
+	priced := false
+	if attempt, ok := answered.current(); ok {
+		entry.Backend = attempt.Backend
+		entry.ModelName = attempt.Model
+		pricing, err := database.ResolveModelPricing(DB, attempt.ModelConfigUUID, attempt.Model)
+		if err != nil {
+			log.Printf("Failed to resolve pricing of %s/%s: %v", attempt.Backend, attempt.Model, err)
+		}
+		if !pricing.IsZero() {
+			entry.Cost = pricing.Cost(entry.PromptTokens, entry.CachedPromptTokens, entry.CompletionTokens)
+			priced = true
+		}
+	}
+
+	var sender database.User
+	if err := DB.Select("id").Where("uuid = ?", message.Content.SenderUUID).First(&sender).Error; err == nil {
+		entry.UserId = sender.ID
+	}
+
+	if err := database.RecordTokenUsage(DB, entry); err != nil {
+		log.Printf("Failed to record token usage for chat %s: %v", message.Content.ChatUUID, err)
+	}
+	return entry.Cost, priced
+}
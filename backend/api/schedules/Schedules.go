@@ -0,0 +1,535 @@
+package schedules
+
+import (
+	"backend/api/bots"
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ScheduleDTO struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+	// Either BotUUID or ChatUUID is set.
+	BotUUID         string                 `json:"bot_uuid,omitempty"`
+	BotName         string                 `json:"bot_name,omitempty"`
+	ChatUUID        string                 `json:"chat_uuid,omitempty"`
+	Message         string                 `json:"message"`
+	ConfigOverrides map[string]interface{} `json:"config_overrides,omitempty"`
+	Cron            string                 `json:"cron"`
+	TimeZone        string                 `json:"time_zone"`
+	Paused          bool                   `json:"paused"`
+	NextRunAt       *time.Time             `json:"next_run_at,omitempty"`
+	LastRunAt       *time.Time             `json:"last_run_at,omitempty"`
+	CreatedAt       time.Time              `json:"created_at"`
+}
+
+type ListedSchedulesPage struct {
+	Limit      int           `json:"limit"`
+	Page       int           `json:"page"`
+	TotalPages int           `json:"total_pages"`
+	Rows       []ScheduleDTO `json:"rows"`
+}
+
+type ScheduleRunDTO struct {
+	UUID       string    `json:"uuid"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	ChatUUID   string    `json:"chat_uuid,omitempty"`
+	TaskID     string    `json:"task_id,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+type ListedScheduleRunsPage struct {
+	Limit      int              `json:"limit"`
+	Page       int              `json:"page"`
+	TotalPages int              `json:"total_pages"`
+	Rows       []ScheduleRunDTO `json:"rows"`
+}
+
+type CreateScheduleRequest struct {
+	Name string `json:"name"`
+	// Bot is a bot UUID or owner-scoped name, a new interaction is started on every run.
+	Bot string `json:"bot,omitempty"`
+	// ChatUUID is a chat of the owner the message is posted into on every run.
+	ChatUUID        string                 `json:"chat_uuid,omitempty"`
+	Message         string                 `json:"message"`
+	ConfigOverrides map[string]interface{} `json:"config_overrides,omitempty"`
+	Cron            string                 `json:"cron"`
+	TimeZone        string                 `json:"time_zone,omitempty"`
+	Paused          bool                   `json:"paused,omitempty"`
+}
+
+type UpdateScheduleRequest struct {
+	Name            *string                `json:"name,omitempty"`
+	Message         *string                `json:"message,omitempty"`
+	ConfigOverrides map[string]interface{} `json:"config_overrides,omitempty"`
+	Cron            *string                `json:"cron,omitempty"`
+	TimeZone        *string                `json:"time_zone,omitempty"`
+}
+
+func parsePagination(r *http.Request, defaultLimit int) (int, int) {
+	page := 1
+	limit := defaultLimit
+	if pageParam := r.URL.Query().Get("page"); pageParam != "" {
+		if parsedPage, err := strconv.Atoi(pageParam); err == nil && parsedPage > 0 {
+			page = parsedPage
+		}
+	}
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	return page, limit
+}
+
+func toDTO(schedule database.BotSchedule, now time.Time) ScheduleDTO {
+	dto := ScheduleDTO{
+		UUID:      schedule.UUID,
+		Name:      schedule.Name,
+		Message:   schedule.Message,
+		Cron:      schedule.CronExpression,
+		TimeZone:  schedule.TimeZone,
+		Paused:    schedule.Paused,
+		NextRunAt: database.NextBotScheduleRun(schedule, now),
+		LastRunAt: schedule.LastRunAt,
+		CreatedAt: schedule.CreatedAt,
+	}
+	if schedule.BotRuntimeConfig != nil {
+		dto.BotUUID = schedule.BotRuntimeConfig.UUID
+		dto.BotName = schedule.BotRuntimeConfig.Name
+	}
+	if schedule.Chat != nil {
+		dto.ChatUUID = schedule.Chat.UUID
+	}
+	if len(schedule.ConfigOverrides) > 0 {
+		_ = json.Unmarshal(schedule.ConfigOverrides, &dto.ConfigOverrides)
+	}
+	return dto
+}
+
+// validateSchedule checks the message and the cron expression of a
+// schedule, writing the error response when either is invalid.
+func validateSchedule(w http.ResponseWriter, message, cronExpression, timeZone string) (string, bool) {
+	if strings.TrimSpace(message) == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return "", false
+	}
+	if len(message) > database.MaxBotScheduleMessageLength {
+		http.Error(w, "message is too long", http.StatusBadRequest)
+		return "", false
+	}
+	_, timeZone, err := database.ParseBotSchedule(cronExpression, timeZone)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return timeZone, true
+}
+
+// findOwnSchedule loads a schedule of the user, writing the error response
+// when it is not found.
+func findOwnSchedule(w http.ResponseWriter, DB *gorm.DB, user *database.User, scheduleUUID string) (database.BotSchedule, bool) {
+	var schedule database.BotSchedule
+	if err := DB.Preload("BotRuntimeConfig").
+		Preload("Chat").
+		Where("uuid = ? AND owner_id = ?", scheduleUUID, user.ID).
+		First(&schedule).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return schedule, false
+		}
+		http.Error(w, "Failed to load schedule", http.StatusInternalServerError)
+		return schedule, false
+	}
+	return schedule, true
+}
+
+// Create schedule
+// @Summary      Create schedule
+// @Description  Create a recurring job running a message on a cron expression (five fields or a descriptor like @daily) in a time zone. With a bot every run starts a new interaction with it, with a chat every run posts the message into the chat as you. Runs are at least 5 minutes apart.
+// @Tags         schedules
+// @Accept       json
+// @Produce      json
+// @Security     SessionAuth
+// @Param        request body schedules.CreateScheduleRequest true "Schedule"
+// @Success      200 {object} schedules.ScheduleDTO
+// @Failure      400 {string} string "Invalid request"
+// @Failure      404 {string} string "Bot or chat not found"
+// @Router       /api/v1/schedules [post]
+func (h *SchedulesHandler) Create(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	var req CreateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req.Bot = strings.TrimSpace(req.Bot)
+	req.ChatUUID = strings.TrimSpace(req.ChatUUID)
+	if (req.Bot == "") == (req.ChatUUID == "") {
+		http.Error(w, "exactly one of bot or chat_uuid is required", http.StatusBadRequest)
+		return
+	}
+	timeZone, ok := validateSchedule(w, req.Message, req.Cron, req.TimeZone)
+	if !ok {
+		return
+	}
+
+	schedule := database.BotSchedule{
+		OwnerId:        user.ID,
+		Name:           strings.TrimSpace(req.Name),
+		Message:        req.Message,
+		CronExpression: strings.TrimSpace(req.Cron),
+		TimeZone:       timeZone,
+		Paused:         req.Paused,
+	}
+	if req.Bot != "" {
+		runtime, err := bots.ResolveReadableBot(DB, user, req.Bot)
+		if err != nil {
+			if errors.Is(err, bots.ErrAmbiguousIdentifier) {
+				http.Error(w, "ambiguous bot identifier", http.StatusConflict)
+				return
+			}
+			http.Error(w, "Bot not found", http.StatusNotFound)
+			return
+		}
+		schedule.BotRuntimeConfigId = &runtime.ID
+		schedule.BotRuntimeConfig = &runtime
+		if req.ConfigOverrides != nil {
+			overrides, err := json.Marshal(req.ConfigOverrides)
+			if err != nil {
+				http.Error(w, "Invalid config_overrides", http.StatusBadRequest)
+				return
+			}
+			schedule.ConfigOverrides = overrides
+		}
+	} else {
+		if req.ConfigOverrides != nil {
+			http.Error(w, "config_overrides only apply to bot schedules", http.StatusBadRequest)
+			return
+		}
+		var chat database.Chat
+		if err := DB.Scopes(database.ChatParticipantScope(user.ID)).Where("uuid = ?", req.ChatUUID).First(&chat).Error; err != nil {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
+		schedule.ChatId = &chat.ID
+		schedule.Chat = &chat
+	}
+	if schedule.Name == "" {
+		schedule.Name = "Schedule"
+	}
+
+	if err := DB.Omit("BotRuntimeConfig", "Chat").Create(&schedule).Error; err != nil {
+		http.Error(w, "Failed to create schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toDTO(schedule, time.Now()))
+}
+
+// List schedules
+// @Summary      List schedules
+// @Description  List your schedules, newest first
+// @Tags         schedules
+// @Produce      json
+// @Security     SessionAuth
+// @Param        page  query int false "Page number" default(1)
+// @Param        limit query int false "Page size"   default(40)
+// @Success      200 {object} schedules.ListedSchedulesPage
+// @Router       /api/v1/schedules/list [get]
+func (h *SchedulesHandler) List(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	page, limit := parsePagination(r, 40)
+	query := DB.Model(&database.BotSchedule{}).Where("owner_id = ?", user.ID)
+	var totalRows int64
+	if err := query.Count(&totalRows).Error; err != nil {
+		http.Error(w, "Failed to count schedules", http.StatusInternalServerError)
+		return
+	}
+	totalPages := int((totalRows + int64(limit) - 1) / int64(limit))
+
+	var rows []database.BotSchedule
+	if err := query.Preload("BotRuntimeConfig").
+		Preload("Chat").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Order("id desc").
+		Find(&rows).Error; err != nil {
+		http.Error(w, "Failed to list schedules", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	items := make([]ScheduleDTO, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, toDTO(row, now))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListedSchedulesPage{Limit: limit, Page: page, TotalPages: totalPages, Rows: items})
+}
+
+// Get schedule
+// @Summary      Get schedule
+// @Description  Get one of your schedules with its next run
+// @Tags         schedules
+// @Produce      json
+// @Security     SessionAuth
+// @Param        schedule_uuid path string true "Schedule UUID"
+// @Success      200 {object} schedules.ScheduleDTO
+// @Failure      404 {string} string "Schedule not found"
+// @Router       /api/v1/schedules/{schedule_uuid} [get]
+func (h *SchedulesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	schedule, ok := findOwnSchedule(w, DB, user, r.PathValue("schedule_uuid"))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toDTO(schedule, time.Now()))
+}
+
+// Update schedule
+// @Summary      Update schedule
+// @Description  Change the name, message, config overrides, cron expression or time zone of a schedule. Changes reach the scheduler within 30 seconds.
+// @Tags         schedules
+// @Accept       json
+// @Produce      json
+// @Security     SessionAuth
+// @Param        schedule_uuid path string true "Schedule UUID"
+// @Param        request body schedules.UpdateScheduleRequest true "Changed fields"
+// @Success      200 {object} schedules.ScheduleDTO
+// @Failure      400 {string} string "Invalid request"
+// @Failure      404 {string} string "Schedule not found"
+// @Router       /api/v1/schedules/{schedule_uuid} [patch]
+func (h *SchedulesHandler) Update(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	schedule, ok := findOwnSchedule(w, DB, user, r.PathValue("schedule_uuid"))
+	if !ok {
+		return
+	}
+
+	var req UpdateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Name != nil && strings.TrimSpace(*req.Name) != "" {
+		schedule.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Message != nil {
+		schedule.Message = *req.Message
+	}
+	if req.Cron != nil {
+		schedule.CronExpression = strings.TrimSpace(*req.Cron)
+	}
+	if req.TimeZone != nil {
+		schedule.TimeZone = *req.TimeZone
+	}
+	if req.ConfigOverrides != nil {
+		if schedule.BotRuntimeConfigId == nil {
+			http.Error(w, "config_overrides only apply to bot schedules", http.StatusBadRequest)
+			return
+		}
+		overrides, err := json.Marshal(req.ConfigOverrides)
+		if err != nil {
+			http.Error(w, "Invalid config_overrides", http.StatusBadRequest)
+			return
+		}
+		schedule.ConfigOverrides = overrides
+	}
+	timeZone, ok := validateSchedule(w, schedule.Message, schedule.CronExpression, schedule.TimeZone)
+	if !ok {
+		return
+	}
+	schedule.TimeZone = timeZone
+
+	if err := DB.Model(&schedule).Updates(map[string]interface{}{
+		"name":             schedule.Name,
+		"message":          schedule.Message,
+		"config_overrides": schedule.ConfigOverrides,
+		"cron_expression":  schedule.CronExpression,
+		"time_zone":        schedule.TimeZone,
+	}).Error; err != nil {
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toDTO(schedule, time.Now()))
+}
+
+// Delete schedule
+// @Summary      Delete schedule
+// @Description  Delete a schedule, its run history is kept
+// @Tags         schedules
+// @Security     SessionAuth
+// @Param        schedule_uuid path string true "Schedule UUID"
+// @Success      204
+// @Failure      404 {string} string "Schedule not found"
+// @Router       /api/v1/schedules/{schedule_uuid} [delete]
+func (h *SchedulesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	schedule, ok := findOwnSchedule(w, DB, user, r.PathValue("schedule_uuid"))
+	if !ok {
+		return
+	}
+	if err := DB.Delete(&schedule).Error; err != nil {
+		http.Error(w, "Failed to delete schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Pause schedule
+// @Summary      Pause schedule
+// @Description  Stop running a schedule until it is resumed
+// @Tags         schedules
+// @Produce      json
+// @Security     SessionAuth
+// @Param        schedule_uuid path string true "Schedule UUID"
+// @Success      200 {object} schedules.ScheduleDTO
+// @Failure      404 {string} string "Schedule not found"
+// @Router       /api/v1/schedules/{schedule_uuid}/pause [post]
+func (h *SchedulesHandler) Pause(w http.ResponseWriter, r *http.Request) {
+	setPaused(w, r, true)
+}
+
+// Resume schedule
+// @Summary      Resume schedule
+// @Description  Run a paused schedule again from its next firing on
+// @Tags         schedules
+// @Produce      json
+// @Security     SessionAuth
+// @Param        schedule_uuid path string true "Schedule UUID"
+// @Success      200 {object} schedules.ScheduleDTO
+// @Failure      404 {string} string "Schedule not found"
+// @Router       /api/v1/schedules/{schedule_uuid}/resume [post]
+func (h *SchedulesHandler) Resume(w http.ResponseWriter, r *http.Request) {
+	setPaused(w, r, false)
+}
+
+func setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	schedule, ok := findOwnSchedule(w, DB, user, r.PathValue("schedule_uuid"))
+	if !ok {
+		return
+	}
+	if err := DB.Model(&schedule).Update("paused", paused).Error; err != nil {
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+	schedule.Paused = paused
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toDTO(schedule, time.Now()))
+}
+
+// List schedule runs
+// @Summary      List schedule runs
+// @Description  List the runs of a schedule, newest first, with the chat each run went to and the task that ran it
+// @Tags         schedules
+// @Produce      json
+// @Security     SessionAuth
+// @Param        schedule_uuid path string true "Schedule UUID"
+// @Param        page  query int false "Page number" default(1)
+// @Param        limit query int false "Page size"   default(40)
+// @Success      200 {object} schedules.ListedScheduleRunsPage
+// @Failure      404 {string} string "Schedule not found"
+// @Router       /api/v1/schedules/{schedule_uuid}/runs [get]
+func (h *SchedulesHandler) ListRuns(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	schedule, ok := findOwnSchedule(w, DB, user, r.PathValue("schedule_uuid"))
+	if !ok {
+		return
+	}
+
+	page, limit := parsePagination(r, 40)
+	query := DB.Model(&database.BotScheduleRun{}).Where("schedule_id = ?", schedule.ID)
+	var totalRows int64
+	if err := query.Count(&totalRows).Error; err != nil {
+		http.Error(w, "Failed to count runs", http.StatusInternalServerError)
+		return
+	}
+	totalPages := int((totalRows + int64(limit) - 1) / int64(limit))
+
+	var runs []database.BotScheduleRun
+	if err := query.Preload("Chat").
+		Preload("TaskResult").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Order("id desc").
+		Find(&runs).Error; err != nil {
+		http.Error(w, "Failed to list runs", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]ScheduleRunDTO, 0, len(runs))
+	for _, run := range runs {
+		item := ScheduleRunDTO{
+			UUID:       run.UUID,
+			Success:    run.Success,
+			Error:      run.Error,
+			StartedAt:  run.StartedAt,
+			FinishedAt: run.FinishedAt,
+		}
+		if run.Chat != nil {
+			item.ChatUUID = run.Chat.UUID
+		}
+		if run.TaskResult != nil {
+			item.TaskID = run.TaskResult.TaskID
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListedScheduleRunsPage{Limit: limit, Page: page, TotalPages: totalPages, Rows: items})
+}
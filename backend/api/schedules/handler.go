@@ -0,0 +1,3 @@
+package schedules
+
+type SchedulesHandler struct{}
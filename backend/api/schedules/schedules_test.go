@@ -0,0 +1,155 @@
+package schedules
+
+import (
+	"backend/database"
+	"backend/queue"
+	"backend/server/util"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func setupSchedulesTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return database.SetupDatabase(database.DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "schedules_test.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+}
+
+func createUserForSchedulesTest(t *testing.T, DB *gorm.DB, name string) *database.User {
+	t.Helper()
+	err, user := util.CreateUser(DB, name, "Passw0rd!", false)
+	if err != nil {
+		t.Fatalf("failed to create user %q: %v", name, err)
+	}
+	return user
+}
+
+func newSchedulesTestRequest(DB *gorm.DB, user *database.User, method string, payload interface{}, pathValues map[string]string) *http.Request {
+	var body []byte
+	if payload != nil {
+		body, _ = json.Marshal(payload)
+	}
+	req := httptest.NewRequest(method, "/api/v1/schedules", bytes.NewReader(body))
+	for key, value := range pathValues {
+		req.SetPathValue(key, value)
+	}
+	ctx := context.WithValue(req.Context(), "db", DB)
+	ctx = context.WithValue(ctx, "user", user)
+	return req.WithContext(ctx)
+}
+
+func TestBotScheduleLifecycle(t *testing.T) {
+	DB := setupSchedulesTestDB(t)
+	owner := createUserForSchedulesTest(t, DB, "schedule-owner")
+	other := createUserForSchedulesTest(t, DB, "schedule-other")
+	botUser := createUserForSchedulesTest(t, DB, "schedule-bot")
+	runtime := database.BotRuntimeConfig{BotUserId: botUser.ID, OwnerUserId: owner.ID, Name: "reporter", IsActive: true}
+	if err := DB.Create(&runtime).Error; err != nil {
+		t.Fatalf("failed to create bot: %v", err)
+	}
+	if err := DB.Create(&database.BotRuntimeOwner{BotRuntimeConfigId: runtime.ID, UserId: owner.ID}).Error; err != nil {
+		t.Fatalf("failed to add bot owner: %v", err)
+	}
+
+	h := &SchedulesHandler{}
+	for _, invalid := range []CreateScheduleRequest{
+		{Bot: "reporter", Message: "report", Cron: "* * * * *"},
+		{Bot: "reporter", Message: "report", Cron: "0 9 * * *", TimeZone: "Mars/Olympus"},
+		{Bot: "reporter", Message: "report", Cron: "CRON_TZ=UTC 0 9 * * *"},
+		{Message: "report", Cron: "0 9 * * *"},
+		{Bot: "reporter", Cron: "0 9 * * *"},
+	} {
+		rr := httptest.NewRecorder()
+		h.Create(rr, newSchedulesTestRequest(DB, owner, "POST", invalid, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected %+v to be rejected, got %d: %s", invalid, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	h.Create(rr, newSchedulesTestRequest(DB, owner, "POST", CreateScheduleRequest{
+		Name:     "weekday report",
+		Bot:      "reporter",
+		Message:  "Summarize yesterday",
+		Cron:     "0 9 * * 1-5",
+		TimeZone: "Europe/Berlin",
+	}, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created ScheduleDTO
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode schedule: %v", err)
+	}
+	if created.BotUUID != runtime.UUID || created.NextRunAt == nil || created.Paused {
+		t.Fatalf("unexpected schedule %+v", created)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	if next := created.NextRunAt.In(berlin); next.Hour() != 9 || next.Weekday() == time.Saturday || next.Weekday() == time.Sunday {
+		t.Fatalf("expected the next run on a weekday at 9 in Berlin, got %v", next)
+	}
+	pathValues := map[string]string{"schedule_uuid": created.UUID}
+
+	rr = httptest.NewRecorder()
+	h.Get(rr, newSchedulesTestRequest(DB, other, "GET", nil, pathValues))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected other users to not see the schedule, got %d", rr.Code)
+	}
+
+	provider := &queue.BotScheduleConfigProvider{DB: DB}
+	configs, err := provider.GetConfigs()
+	if err != nil || len(configs) != 1 || configs[0].Cronspec != "CRON_TZ=Europe/Berlin 0 9 * * 1-5" {
+		t.Fatalf("expected the schedule to be synced to the scheduler, got %+v (%v)", configs, err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.Pause(rr, newSchedulesTestRequest(DB, owner, "POST", nil, pathValues))
+	var paused ScheduleDTO
+	if err := json.Unmarshal(rr.Body.Bytes(), &paused); err != nil || !paused.Paused || paused.NextRunAt != nil {
+		t.Fatalf("expected a paused schedule without next run, got %+v (%v)", paused, err)
+	}
+	if configs, err := provider.GetConfigs(); err != nil || len(configs) != 0 {
+		t.Fatalf("expected paused schedules to not be synced, got %d (%v)", len(configs), err)
+	}
+
+	var schedule database.BotSchedule
+	if err := DB.Where("uuid = ?", created.UUID).First(&schedule).Error; err != nil {
+		t.Fatalf("failed to load schedule: %v", err)
+	}
+	result := database.TaskResult{TaskID: "run-1", TaskType: "bots:schedule", Queue: "default", Success: false, Error: "bot is no longer available"}
+	if err := DB.Create(&result).Error; err != nil {
+		t.Fatalf("failed to create task result: %v", err)
+	}
+	run := database.BotScheduleRun{ScheduleId: schedule.ID, TaskResultId: &result.ID, Error: result.Error, StartedAt: time.Now(), FinishedAt: time.Now()}
+	if err := database.RecordBotScheduleRun(DB, &run); err != nil {
+		t.Fatalf("failed to record run: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ListRuns(rr, newSchedulesTestRequest(DB, owner, "GET", nil, pathValues))
+	var runs ListedScheduleRunsPage
+	if err := json.Unmarshal(rr.Body.Bytes(), &runs); err != nil {
+		t.Fatalf("failed to decode runs: %v", err)
+	}
+	if len(runs.Rows) != 1 || runs.Rows[0].TaskID != "run-1" || runs.Rows[0].Success || runs.Rows[0].Error == "" {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+
+	rr = httptest.NewRecorder()
+	h.Get(rr, newSchedulesTestRequest(DB, owner, "GET", nil, pathValues))
+	var reloaded ScheduleDTO
+	if err := json.Unmarshal(rr.Body.Bytes(), &reloaded); err != nil || reloaded.LastRunAt == nil {
+		t.Fatalf("expected the run to be the schedule's last run, got %+v (%v)", reloaded, err)
+	}
+}
@@ -34,17 +34,30 @@ func getToolInitForChat(DB *gorm.DB, chat database.Chat, toolName string) map[st
 	return database.NewToolInitDataManager(DB).ResolveToolInitData(chat, toolName)
 }
 
-func findBotAndReceiver(chat database.Chat, user database.User) (uint, uint) {
-	if chat.User1.IsAutomated {
-		return chat.User1.ID, chat.User2.ID
+// findBotAndReceiver returns the bot that proposed an action and the user
+// confirming it. The bot is the sender of the source message, or the first
+// other participant of the chat, bots first, when the user sent it.
+func findBotAndReceiver(DB *gorm.DB, chat database.Chat, sourceMessage database.Message, user database.User) (uint, uint, error) {
+	if sourceMessage.SenderId != user.ID {
+		return sourceMessage.SenderId, user.ID, nil
 	}
-	if chat.User2.IsAutomated {
-		return chat.User2.ID, chat.User1.ID
+	participants, err := database.ListChatParticipants(DB, chat.ID)
+	if err != nil {
+		return 0, 0, err
 	}
-	if chat.User1.ID == user.ID {
-		return chat.User2.ID, chat.User1.ID
+	otherID := user.ID
+	for _, participant := range participants {
+		if participant.UserId == user.ID {
+			continue
+		}
+		if participant.User.IsAutomated {
+			return participant.UserId, user.ID, nil
+		}
+		if otherID == user.ID {
+			otherID = participant.UserId
+		}
 	}
-	return chat.User1.ID, chat.User2.ID
+	return otherID, user.ID, nil
 }
 
 func updateSourceMessageToolCallResult(tx *gorm.DB, sourceMessage database.Message, actionID, toolResult string) error {
@@ -113,10 +126,9 @@ func (h *ToolsHandler) ExecuteConfirmableAction(w http.ResponseWriter, r *http.R
 	}
 
 	var chat database.Chat
-	if err := DB.Preload("User1").
-		Preload("User2").
-		Preload("SharedConfig").
-		Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUUID, user.ID, user.ID).
+	if err := DB.Preload("SharedConfig").
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUUID).
 		First(&chat).Error; err != nil {
 		http.Error(w, "Chat not found or access denied", http.StatusNotFound)
 		return
@@ -246,7 +258,11 @@ func (h *ToolsHandler) ExecuteConfirmableAction(w http.ResponseWriter, r *http.R
 	messageMeta["confirmable_actions"] = actionsRaw
 	updatedMetaBytes, _ := json.Marshal(messageMeta)
 
-	botUserID, humanUserID := findBotAndReceiver(chat, *user)
+	botUserID, humanUserID, err := findBotAndReceiver(DB, chat, sourceMessage, *user)
+	if err != nil {
+		http.Error(w, "Failed to resolve chat participants", http.StatusInternalServerError)
+		return
+	}
 	continuationMessageUUID := ""
 
 	err = DB.Transaction(func(tx *gorm.DB) error {
@@ -87,7 +87,8 @@ func (h *ToolsHandler) ExecuteTool(w http.ResponseWriter, r *http.Request) {
 	result := DB.Preload("User1").
 		Preload("User2").
 		Preload("SharedConfig").
-		Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUuid, user.ID, user.ID).
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUuid).
 		First(&chat)
 
 	if result.Error != nil {
@@ -214,7 +215,8 @@ func (h *ToolsHandler) EnqueueTool(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var chat database.Chat
-	if err := DB.Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUuid, user.ID, user.ID).First(&chat).Error; err != nil {
+	if err := DB.Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUuid).First(&chat).Error; err != nil {
 		http.Error(w, "Chat not found or access denied", http.StatusNotFound)
 		return
 	}
@@ -293,7 +295,8 @@ func (h *ToolsHandler) GetAvailableTools(w http.ResponseWriter, r *http.Request)
 	result := DB.Preload("User1").
 		Preload("User2").
 		Preload("SharedConfig").
-		Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUuid, user.ID, user.ID).
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUuid).
 		First(&chat)
 
 	if result.Error != nil {
@@ -363,7 +366,8 @@ func (h *ToolsHandler) StoreToolInitData(w http.ResponseWriter, r *http.Request)
 	result := DB.Preload("User1").
 		Preload("User2").
 		Preload("SharedConfig").
-		Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", chatUuid, user.ID, user.ID).
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", chatUuid).
 		First(&chat)
 
 	if result.Error != nil {
@@ -0,0 +1,72 @@
+package user
+
+import (
+	"backend/database"
+	"backend/server/util"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type SelfTokenUsageResponse struct {
+	GroupBy string                       `json:"group_by"`
+	Rows    []database.TokenUsageSummary `json:"rows"`
+	Quota   *database.TokenQuota         `json:"quota,omitempty"`
+}
+
+// SelfUsage returns the token usage and cost of bot replies to the current user.
+//
+//	@Summary      Get own token usage
+//	@Description  Sum the tokens and cost bots spent replying to the current user, grouped by day, chat, model or bot
+//	@Tags         users
+//	@Produce      json
+//	@Security     SessionAuth
+//	@Param        group_by  query  string  false  "day (default), chat, model or bot"
+//	@Param        from      query  string  false  "First day, YYYY-MM-DD"
+//	@Param        to        query  string  false  "Last day, YYYY-MM-DD"
+//	@Success      200 {object} SelfTokenUsageResponse "Token usage"
+//	@Failure      400 {string} string "Invalid query"
+//	@Router       /api/v1/user/usage [get]
+func (h *UserHandler) SelfUsage(w http.ResponseWriter, r *http.Request) {
+	DB, user, err := util.GetDBAndUser(r)
+	if err != nil {
+		http.Error(w, "Unable to get database or user", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	groupBy := strings.TrimSpace(query.Get("group_by"))
+	if groupBy == "" {
+		groupBy = "day"
+	}
+	if groupBy != "day" && groupBy != "chat" && groupBy != "model" && groupBy != "bot" {
+		http.Error(w, "group_by must be day, chat, model or bot", http.StatusBadRequest)
+		return
+	}
+
+	filter := database.TokenUsageFilter{
+		UserId:  user.ID,
+		FromDay: strings.TrimSpace(query.Get("from")),
+		ToDay:   strings.TrimSpace(query.Get("to")),
+	}
+	for _, day := range []string{filter.FromDay, filter.ToDay} {
+		if day != "" && !database.IsValidTokenUsageDay(day) {
+			http.Error(w, "from and to must be YYYY-MM-DD days", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := database.SummarizeTokenUsage(DB, filter, groupBy)
+	if err != nil {
+		http.Error(w, "Failed to sum token usage", http.StatusInternalServerError)
+		return
+	}
+	quota, err := database.GetTokenQuota(DB, database.TokenQuotaSubjectUser, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load token quota", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SelfTokenUsageResponse{GroupBy: groupBy, Rows: rows, Quota: quota})
+}
@@ -0,0 +1,28 @@
+package websocket
+
+import "context"
+
+const (
+	BrokerScopeUser = "user"
+	BrokerScopeChat = "chat"
+	BrokerScopeAll  = "all"
+)
+
+// BrokerEvent is a websocket payload addressed to a user channel, a chat channel or everyone.
+type BrokerEvent struct {
+	Scope   string `json:"scope"`
+	Target  string `json:"target,omitempty"`
+	Payload []byte `json:"payload"`
+}
+
+// Broker distributes published events to every process that holds websocket
+// subscribers. Without a broker the handler delivers in-process only. With one,
+// the handler delivers every event from Subscribe to its local subscribers,
+// so a broker must also deliver events published by the same process.
+type Broker interface {
+	Publish(ctx context.Context, event BrokerEvent) error
+	// Subscribe returns once the subscription is established. The returned
+	// channel is closed when ctx is cancelled or the subscription is lost.
+	Subscribe(ctx context.Context) (<-chan BrokerEvent, error)
+	Close() error
+}
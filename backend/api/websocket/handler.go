@@ -1,7 +1,5 @@
 package websocket
 
-// TODO: Also implement a redis-compoatible handler
-
 import (
 	"context"
 	"github.com/coder/websocket"
@@ -29,6 +27,10 @@ type WebSocketHandler struct {
 	subscribers    map[*Subscriber]struct{}
 	connectionsMu  sync.Mutex
 	connections    map[*websocket.Conn]struct{}
+	brokerMu       sync.RWMutex
+	broker         Broker
+	stopBroker     context.CancelFunc
+	brokerDone     chan struct{}
 }
 
 func (cs *WebSocketHandler) GetSubscribers() []Subscriber {
@@ -73,7 +75,103 @@ func (cs *WebSocketHandler) deleteConnection(conn *websocket.Conn) {
 	cs.connectionsMu.Unlock()
 }
 
+// SetBroker routes all publishes through broker and delivers the events it
+// receives to local subscribers. Passing nil restores in-process delivery.
+func (cs *WebSocketHandler) SetBroker(broker Broker) error {
+	cs.closeBroker()
+	if broker == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := broker.Subscribe(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			for event := range events {
+				cs.deliver(event)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			cs.logf("websocket broker subscription lost; resubscribing")
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+				var subscribeErr error
+				events, subscribeErr = broker.Subscribe(ctx)
+				if subscribeErr == nil {
+					break
+				}
+				cs.logf("websocket broker resubscribe failed: %v", subscribeErr)
+			}
+		}
+	}()
+
+	cs.brokerMu.Lock()
+	cs.broker = broker
+	cs.stopBroker = cancel
+	cs.brokerDone = done
+	cs.brokerMu.Unlock()
+	return nil
+}
+
+func (cs *WebSocketHandler) closeBroker() {
+	cs.brokerMu.Lock()
+	broker, stop, done := cs.broker, cs.stopBroker, cs.brokerDone
+	cs.broker, cs.stopBroker, cs.brokerDone = nil, nil, nil
+	cs.brokerMu.Unlock()
+
+	if stop != nil {
+		stop()
+		<-done
+	}
+	if broker != nil {
+		if err := broker.Close(); err != nil {
+			cs.logf("failed to close websocket broker: %v", err)
+		}
+	}
+}
+
+func (cs *WebSocketHandler) publish(event BrokerEvent) {
+	cs.brokerMu.RLock()
+	broker := cs.broker
+	cs.brokerMu.RUnlock()
+
+	if broker == nil {
+		cs.deliver(event)
+		return
+	}
+	if err := broker.Publish(context.Background(), event); err != nil {
+		// Keep local clients up to date even if the broker is unreachable.
+		cs.logf("websocket broker publish failed, delivering locally: %v", err)
+		cs.deliver(event)
+	}
+}
+
+func (cs *WebSocketHandler) deliver(event BrokerEvent) {
+	switch event.Scope {
+	case BrokerScopeUser:
+		cs.deliverInChannel(event.Payload, event.Target)
+	case BrokerScopeChat:
+		cs.deliverInChatChannel(event.Payload, event.Target)
+	case BrokerScopeAll:
+		cs.deliverToAll(event.Payload)
+	}
+}
+
 func (cs *WebSocketHandler) Shutdown() {
+	cs.closeBroker()
+
 	cs.connectionsMu.Lock()
 	conns := make([]*websocket.Conn, 0, len(cs.connections))
 	for conn := range cs.connections {
@@ -87,6 +185,18 @@ func (cs *WebSocketHandler) Shutdown() {
 }
 
 func (cs *WebSocketHandler) PublishInChannel(msg []byte, receiverUUID string) {
+	cs.publish(BrokerEvent{Scope: BrokerScopeUser, Target: receiverUUID, Payload: msg})
+}
+
+func (cs *WebSocketHandler) PublishInChatChannel(msg []byte, chatUUID string) {
+	cs.publish(BrokerEvent{Scope: BrokerScopeChat, Target: chatUUID, Payload: msg})
+}
+
+func (cs *WebSocketHandler) Publish(msg []byte) {
+	cs.publish(BrokerEvent{Scope: BrokerScopeAll, Payload: msg})
+}
+
+func (cs *WebSocketHandler) deliverInChannel(msg []byte, receiverUUID string) {
 	cs.subscribersMu.Lock()
 	defer cs.subscribersMu.Unlock()
 
@@ -101,7 +211,7 @@ func (cs *WebSocketHandler) PublishInChannel(msg []byte, receiverUUID string) {
 	}
 }
 
-func (cs *WebSocketHandler) PublishInChatChannel(msg []byte, chatUUID string) {
+func (cs *WebSocketHandler) deliverInChatChannel(msg []byte, chatUUID string) {
 	cs.subscribersMu.Lock()
 	defer cs.subscribersMu.Unlock()
 
@@ -116,7 +226,7 @@ func (cs *WebSocketHandler) PublishInChatChannel(msg []byte, chatUUID string) {
 	}
 }
 
-func (cs *WebSocketHandler) Publish(msg []byte) {
+func (cs *WebSocketHandler) deliverToAll(msg []byte) {
 	cs.subscribersMu.Lock()
 	defer cs.subscribersMu.Unlock()
 
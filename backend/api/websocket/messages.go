@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"encoding/json"
+	"time"
 )
 
 type Messages struct{}
@@ -55,6 +56,7 @@ type EndPartialMessage struct {
 		ChatUUID   string `json:"chat_uuid"`
 		SenderUUID string `json:"sender_uuid"`
 		SessionID  string `json:"session_id,omitempty"`
+		State      string `json:"state,omitempty"`
 	} `json:"content"`
 }
 
@@ -66,6 +68,95 @@ type InterruptSignal struct {
 	} `json:"content"`
 }
 
+type ParticipantChanged struct {
+	Type    string `json:"type"`
+	Content struct {
+		ChatUUID  string `json:"chat_uuid"`
+		UserUUID  string `json:"user_uuid"`
+		ActorUUID string `json:"actor_uuid"`
+	} `json:"content"`
+}
+
+// MessageEdited tells clients a message's text changed. RemovedMessageUUIDs
+// lists the bot answers removed to reply to the edit again.
+type MessageEdited struct {
+	Type    string `json:"type"`
+	Content struct {
+		ChatUUID            string   `json:"chat_uuid"`
+		MessageUUID         string   `json:"message_uuid"`
+		SenderUUID          string   `json:"sender_uuid"`
+		Text                string   `json:"text"`
+		Revision            int      `json:"revision"`
+		EditedAt            string   `json:"edited_at"`
+		RemovedMessageUUIDs []string `json:"removed_message_uuids,omitempty"`
+	} `json:"content"`
+}
+
+// BranchSwitched tells clients the active branch of a chat changed, the
+// messages up to LeafMessageUUID should be reloaded.
+type BranchSwitched struct {
+	Type    string `json:"type"`
+	Content struct {
+		ChatUUID        string `json:"chat_uuid"`
+		MessageUUID     string `json:"message_uuid"`
+		LeafMessageUUID string `json:"leaf_message_uuid"`
+		ActorUUID       string `json:"actor_uuid"`
+	} `json:"content"`
+}
+
+// MessageReaction tells clients a user added or removed a reaction. Count is
+// the number of users with the reaction afterwards.
+type MessageReaction struct {
+	Type    string `json:"type"`
+	Content struct {
+		ChatUUID    string `json:"chat_uuid"`
+		MessageUUID string `json:"message_uuid"`
+		UserUUID    string `json:"user_uuid"`
+		Emoji       string `json:"emoji"`
+		Added       bool   `json:"added"`
+		Count       int    `json:"count"`
+	} `json:"content"`
+}
+
+// MessageReceipt tells clients a user received or read messages. With UpTo
+// every message up to MessageUUID is meant, else only the message itself.
+type MessageReceipt struct {
+	Type    string `json:"type"`
+	Content struct {
+		ChatUUID    string `json:"chat_uuid"`
+		UserUUID    string `json:"user_uuid"`
+		MessageUUID string `json:"message_uuid"`
+		Status      string `json:"status"`
+		UpTo        bool   `json:"up_to"`
+		At          string `json:"at"`
+	} `json:"content"`
+}
+
+// Typing tells clients a user started or stopped typing. Clients hide the
+// indicator after ExpiresIn seconds without a new event.
+type Typing struct {
+	Type    string `json:"type"`
+	Content struct {
+		ChatUUID  string `json:"chat_uuid"`
+		UserUUID  string `json:"user_uuid"`
+		Typing    bool   `json:"typing"`
+		ExpiresIn int    `json:"expires_in"`
+	} `json:"content"`
+}
+
+// ChatExport tells a user a chat export they requested finished, Status is
+// "ready" or "failed".
+type ChatExport struct {
+	Type    string `json:"type"`
+	Content struct {
+		ExportUUID string `json:"export_uuid"`
+		ChatUUID   string `json:"chat_uuid"`
+		Format     string `json:"format"`
+		Status     string `json:"status"`
+		Error      string `json:"error,omitempty"`
+	} `json:"content"`
+}
+
 type FileAttachment struct {
 	FileID      string `json:"file_id"`
 	DisplayName string `json:"display_name,omitempty"`
@@ -92,6 +183,28 @@ func (m *Messages) SendMessage(ch *WebSocketHandler, receiverUUID string, EncMes
 	}
 }
 
+// SendMessageToMany delivers a message to every receiver's user channel and
+// publishes it to the chat channel once, used for group chat fan-out.
+func (m *Messages) SendMessageToMany(ch *WebSocketHandler, receiverUUIDs []string, EncMessage []byte) {
+	for _, receiverUUID := range receiverUUIDs {
+		ch.PublishInChannel(
+			EncMessage,
+			receiverUUID,
+		)
+	}
+
+	var envelope struct {
+		Content struct {
+			ChatUUID string `json:"chat_uuid"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(EncMessage, &envelope); err == nil {
+		if envelope.Content.ChatUUID != "" {
+			ch.PublishInChatChannel(EncMessage, envelope.Content.ChatUUID)
+		}
+	}
+}
+
 func (m *Messages) StartPartialMessage(ChatUUID, SenderUUID, SessionID string) []byte {
 	msg := StartPartialMessage{
 		Type: "start_partial_message",
@@ -111,16 +224,24 @@ func (m *Messages) StartPartialMessage(ChatUUID, SenderUUID, SessionID string) [
 }
 
 func (m *Messages) EndPartialMessage(ChatUUID, SenderUUID, SessionID string) []byte {
+	return m.EndPartialMessageWithState(ChatUUID, SenderUUID, SessionID, "")
+}
+
+// EndPartialMessageWithState ends a partial message and tells clients how it ended,
+// e.g. "interrupted" when the reply was cancelled mid-stream.
+func (m *Messages) EndPartialMessageWithState(ChatUUID, SenderUUID, SessionID, State string) []byte {
 	msg := EndPartialMessage{
 		Type: "end_partial_message",
 		Content: struct {
 			ChatUUID   string `json:"chat_uuid"`
 			SenderUUID string `json:"sender_uuid"`
 			SessionID  string `json:"session_id,omitempty"`
+			State      string `json:"state,omitempty"`
 		}{
 			ChatUUID:   ChatUUID,
 			SenderUUID: SenderUUID,
 			SessionID:  SessionID,
+			State:      State,
 		},
 	}
 
@@ -211,3 +332,165 @@ func (m *Messages) UserWentOnline(UserUUID string) []byte {
 	encMsg, _ := json.Marshal(msg)
 	return encMsg
 }
+
+func (m *Messages) ParticipantJoined(ChatUUID, UserUUID, ActorUUID string) []byte {
+	return m.participantChanged("participant_joined", ChatUUID, UserUUID, ActorUUID)
+}
+
+func (m *Messages) ParticipantLeft(ChatUUID, UserUUID, ActorUUID string) []byte {
+	return m.participantChanged("participant_left", ChatUUID, UserUUID, ActorUUID)
+}
+
+func (m *Messages) participantChanged(Type, ChatUUID, UserUUID, ActorUUID string) []byte {
+	msg := ParticipantChanged{
+		Type: Type,
+		Content: struct {
+			ChatUUID  string `json:"chat_uuid"`
+			UserUUID  string `json:"user_uuid"`
+			ActorUUID string `json:"actor_uuid"`
+		}{
+			ChatUUID:  ChatUUID,
+			UserUUID:  UserUUID,
+			ActorUUID: ActorUUID,
+		},
+	}
+
+	encMsg, _ := json.Marshal(msg)
+	return encMsg
+}
+
+func (m *Messages) MessageEdited(ChatUUID, MessageUUID, SenderUUID, Text string, Revision int, EditedAt string, RemovedMessageUUIDs []string) []byte {
+	msg := MessageEdited{
+		Type: "message_edited",
+		Content: struct {
+			ChatUUID            string   `json:"chat_uuid"`
+			MessageUUID         string   `json:"message_uuid"`
+			SenderUUID          string   `json:"sender_uuid"`
+			Text                string   `json:"text"`
+			Revision            int      `json:"revision"`
+			EditedAt            string   `json:"edited_at"`
+			RemovedMessageUUIDs []string `json:"removed_message_uuids,omitempty"`
+		}{
+			ChatUUID:            ChatUUID,
+			MessageUUID:         MessageUUID,
+			SenderUUID:          SenderUUID,
+			Text:                Text,
+			Revision:            Revision,
+			EditedAt:            EditedAt,
+			RemovedMessageUUIDs: RemovedMessageUUIDs,
+		},
+	}
+
+	encMsg, _ := json.Marshal(msg)
+	return encMsg
+}
+
+func (m *Messages) BranchSwitched(ChatUUID, MessageUUID, LeafMessageUUID, ActorUUID string) []byte {
+	msg := BranchSwitched{
+		Type: "branch_switched",
+		Content: struct {
+			ChatUUID        string `json:"chat_uuid"`
+			MessageUUID     string `json:"message_uuid"`
+			LeafMessageUUID string `json:"leaf_message_uuid"`
+			ActorUUID       string `json:"actor_uuid"`
+		}{
+			ChatUUID:        ChatUUID,
+			MessageUUID:     MessageUUID,
+			LeafMessageUUID: LeafMessageUUID,
+			ActorUUID:       ActorUUID,
+		},
+	}
+
+	encMsg, _ := json.Marshal(msg)
+	return encMsg
+}
+
+func (m *Messages) MessageReaction(ChatUUID, MessageUUID, UserUUID, Emoji string, Added bool, Count int) []byte {
+	msg := MessageReaction{
+		Type: "message_reaction",
+		Content: struct {
+			ChatUUID    string `json:"chat_uuid"`
+			MessageUUID string `json:"message_uuid"`
+			UserUUID    string `json:"user_uuid"`
+			Emoji       string `json:"emoji"`
+			Added       bool   `json:"added"`
+			Count       int    `json:"count"`
+		}{
+			ChatUUID:    ChatUUID,
+			MessageUUID: MessageUUID,
+			UserUUID:    UserUUID,
+			Emoji:       Emoji,
+			Added:       Added,
+			Count:       Count,
+		},
+	}
+
+	encMsg, _ := json.Marshal(msg)
+	return encMsg
+}
+
+func (m *Messages) MessageReceipt(ChatUUID, UserUUID, MessageUUID, Status string, UpTo bool, At string) []byte {
+	msg := MessageReceipt{
+		Type: "message_receipt",
+		Content: struct {
+			ChatUUID    string `json:"chat_uuid"`
+			UserUUID    string `json:"user_uuid"`
+			MessageUUID string `json:"message_uuid"`
+			Status      string `json:"status"`
+			UpTo        bool   `json:"up_to"`
+			At          string `json:"at"`
+		}{
+			ChatUUID:    ChatUUID,
+			UserUUID:    UserUUID,
+			MessageUUID: MessageUUID,
+			Status:      Status,
+			UpTo:        UpTo,
+			At:          At,
+		},
+	}
+
+	encMsg, _ := json.Marshal(msg)
+	return encMsg
+}
+
+func (m *Messages) ChatExport(ExportUUID, ChatUUID, Format, Status, Error string) []byte {
+	msg := ChatExport{
+		Type: "chat_export",
+		Content: struct {
+			ExportUUID string `json:"export_uuid"`
+			ChatUUID   string `json:"chat_uuid"`
+			Format     string `json:"format"`
+			Status     string `json:"status"`
+			Error      string `json:"error,omitempty"`
+		}{
+			ExportUUID: ExportUUID,
+			ChatUUID:   ChatUUID,
+			Format:     Format,
+			Status:     Status,
+			Error:      Error,
+		},
+	}
+
+	encMsg, _ := json.Marshal(msg)
+	return encMsg
+}
+
+func (m *Messages) Typing(ChatUUID, UserUUID string, IsTyping bool, ExpiresIn time.Duration) []byte {
+	msg := Typing{
+		Type: "typing",
+		Content: struct {
+			ChatUUID  string `json:"chat_uuid"`
+			UserUUID  string `json:"user_uuid"`
+			Typing    bool   `json:"typing"`
+			ExpiresIn int    `json:"expires_in"`
+		}{
+			ChatUUID:  ChatUUID,
+			UserUUID:  UserUUID,
+			Typing:    IsTyping,
+			ExpiresIn: int(ExpiresIn.Seconds()),
+		},
+	}
+
+	encMsg, _ := json.Marshal(msg)
+	return encMsg
+}
@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const DefaultRedisBrokerChannel = "open-chat:websocket:events"
+
+// RedisBroker shares websocket events between server and worker processes over
+// Redis pub/sub, so partial bot messages streamed by a worker reach clients
+// connected to any server.
+type RedisBroker struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+func NewRedisBroker(client redis.UniversalClient, channel string) *RedisBroker {
+	if channel == "" {
+		channel = DefaultRedisBrokerChannel
+	}
+	return &RedisBroker{client: client, channel: channel}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, event BrokerEvent) error {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, encoded).Err()
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context) (<-chan BrokerEvent, error) {
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	// Wait for the confirmation so events published right after subscribing are not lost.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	events := make(chan BrokerEvent, 100)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event BrokerEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					log.Printf("websocket broker: dropping malformed event: %v", err)
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+func (b *RedisBroker) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,94 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisBroker(t *testing.T, addr string) *RedisBroker {
+	t.Helper()
+	return NewRedisBroker(redis.NewClient(&redis.Options{Addr: addr}), "")
+}
+
+func addTestSubscriber(cs *WebSocketHandler, userUUID string, chatUUID string) *Subscriber {
+	s := &Subscriber{
+		UserUUID:  userUUID,
+		ChatUUID:  chatUUID,
+		msgs:      make(chan []byte, 10),
+		closeSlow: func() {},
+	}
+	cs.addSubscriber(s)
+	return s
+}
+
+func expectMessage(t *testing.T, s *Subscriber, want string) {
+	t.Helper()
+	select {
+	case msg := <-s.msgs:
+		if string(msg) != want {
+			t.Fatalf("expected %q, got %q", want, string(msg))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %q", want)
+	}
+}
+
+func expectNoMessage(t *testing.T, s *Subscriber) {
+	t.Helper()
+	select {
+	case msg := <-s.msgs:
+		t.Fatalf("expected no message, got %q", string(msg))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRedisBrokerFansOutAcrossHandlers(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+
+	server := NewWebSocketHandler()
+	if err := server.SetBroker(newTestRedisBroker(t, redisServer.Addr())); err != nil {
+		t.Fatalf("failed to attach server broker: %v", err)
+	}
+	defer server.Shutdown()
+
+	worker := NewWebSocketHandler()
+	if err := worker.SetBroker(newTestRedisBroker(t, redisServer.Addr())); err != nil {
+		t.Fatalf("failed to attach worker broker: %v", err)
+	}
+	defer worker.Shutdown()
+
+	userSubscriber := addTestSubscriber(server, "user-1", "")
+	chatSubscriber := addTestSubscriber(server, "", "chat-1")
+	otherSubscriber := addTestSubscriber(server, "user-2", "")
+
+	worker.PublishInChannel([]byte(`{"type":"partial"}`), "user-1")
+	expectMessage(t, userSubscriber, `{"type":"partial"}`)
+	expectNoMessage(t, otherSubscriber)
+
+	worker.PublishInChatChannel([]byte(`{"type":"chat"}`), "chat-1")
+	expectMessage(t, chatSubscriber, `{"type":"chat"}`)
+
+	server.Publish([]byte(`{"type":"all"}`))
+	expectMessage(t, userSubscriber, `{"type":"all"}`)
+	expectMessage(t, chatSubscriber, `{"type":"all"}`)
+	expectMessage(t, otherSubscriber, `{"type":"all"}`)
+}
+
+func TestPublishFallsBackToLocalDeliveryWhenBrokerFails(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+
+	handler := NewWebSocketHandler()
+	if err := handler.SetBroker(newTestRedisBroker(t, redisServer.Addr())); err != nil {
+		t.Fatalf("failed to attach broker: %v", err)
+	}
+	defer handler.Shutdown()
+
+	subscriber := addTestSubscriber(handler, "user-1", "")
+	redisServer.Close()
+
+	handler.PublishInChannel([]byte(`{"type":"local"}`), "user-1")
+	expectMessage(t, subscriber, `{"type":"local"}`)
+}
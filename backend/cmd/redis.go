@@ -1,11 +1,20 @@
 package cmd
 
 import (
+	wsapi "backend/api/websocket"
 	"backend/queue"
+	"backend/workqueue"
+	"fmt"
+	"strings"
 
 	"github.com/urfave/cli/v3"
 )
 
+const (
+	WebsocketBrokerLocal = "local"
+	WebsocketBrokerRedis = "redis"
+)
+
 func GetRedisFlags() []cli.Flag {
 	return []cli.Flag{
 		&cli.StringFlag{
@@ -38,6 +47,12 @@ func GetRedisFlags() []cli.Flag {
 			Usage:   "Redis DB index if redis-url is not set",
 			Value:   0,
 		},
+		&cli.StringFlag{
+			Sources: cli.EnvVars("WEBSOCKET_BROKER"),
+			Name:    "websocket-broker",
+			Usage:   "Websocket event broker: redis (share live events between server and worker processes) | local (in-process only)",
+			Value:   WebsocketBrokerRedis,
+		},
 	}
 }
 
@@ -50,3 +65,51 @@ func resolveRedisRuntime(c *cli.Command) (*queue.RedisRuntime, error) {
 		int(c.Int("redis-db")),
 	)
 }
+
+// resolveWebsocketBroker returns the broker websocket events are published
+// through, or nil when events should only be delivered in-process.
+func resolveWebsocketBroker(c *cli.Command, redisRuntime *queue.RedisRuntime) (wsapi.Broker, error) {
+	switch strings.ToLower(strings.TrimSpace(c.String("websocket-broker"))) {
+	case WebsocketBrokerLocal:
+		return nil, nil
+	case "", WebsocketBrokerRedis:
+		client, err := redisRuntime.NewRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		return wsapi.NewRedisBroker(client, wsapi.DefaultRedisBrokerChannel), nil
+	default:
+		return nil, fmt.Errorf("invalid websocket broker %q, expected one of: redis, local", c.String("websocket-broker"))
+	}
+}
+
+// attachWebsocketBroker attaches the configured broker to ch.
+func attachWebsocketBroker(c *cli.Command, redisRuntime *queue.RedisRuntime, ch *wsapi.WebSocketHandler) error {
+	broker, err := resolveWebsocketBroker(c, redisRuntime)
+	if err != nil {
+		return err
+	}
+	if broker == nil {
+		return nil
+	}
+	if err := ch.SetBroker(broker); err != nil {
+		broker.Close()
+		return fmt.Errorf("failed to subscribe websocket broker: %w", err)
+	}
+	return nil
+}
+
+// startReplyCanceler subscribes to chat interrupts so bot replies running in
+// this process stop when a chat is interrupted through any server.
+func startReplyCanceler(redisRuntime *queue.RedisRuntime) (*workqueue.ReplyCanceler, error) {
+	client, err := redisRuntime.NewRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	replyCanceler := workqueue.NewReplyCanceler(client, workqueue.DefaultReplyCancelChannel)
+	if err := replyCanceler.Start(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to subscribe bot reply cancellations: %w", err)
+	}
+	return replyCanceler, nil
+}
@@ -252,6 +252,7 @@ func GetServerFlags() []cli.Flag {
 	}
 
 	flags = append(flags, GetRedisFlags()...)
+	flags = append(flags, GetStorageFlags()...)
 	return flags
 }
 
@@ -545,6 +546,7 @@ func ServerCli() *cli.Command {
 				"REDIS_ADDR":         {Value: c.String("redis-addr"), Sensitive: false},
 				"REDIS_PASSWORD":     {Value: c.String("redis-password"), Sensitive: true},
 				"REDIS_DB":           {Value: fmt.Sprintf("%d", c.Int("redis-db")), Sensitive: false},
+				"WEBSOCKET_BROKER":   {Value: c.String("websocket-broker"), Sensitive: false},
 				"OPENAI_API_KEY":     {Value: os.Getenv("OPENAI_API_KEY"), Sensitive: true},
 				"ANTHROPIC_API_KEY":  {Value: os.Getenv("ANTHROPIC_API_KEY"), Sensitive: true},
 				"ANTHROPIC_API_HOST": {Value: os.Getenv("ANTHROPIC_API_HOST"), Sensitive: true},
@@ -552,6 +554,9 @@ func ServerCli() *cli.Command {
 				"GROQ_API_KEY":       {Value: os.Getenv("GROQ_API_KEY"), Sensitive: true},
 				"LITELLM_API_KEY":    {Value: os.Getenv("LITELLM_API_KEY"), Sensitive: true},
 				"LITELLM_API_HOST":   {Value: os.Getenv("LITELLM_API_HOST"), Sensitive: true},
+				"GEMINI_API_KEY":     {Value: os.Getenv("GEMINI_API_KEY"), Sensitive: true},
+				"GEMINI_API_HOST":    {Value: os.Getenv("GEMINI_API_HOST"), Sensitive: true},
+				"OLLAMA_HOST":        {Value: os.Getenv("OLLAMA_HOST"), Sensitive: false},
 				"OPEN_CHAT_SEAL_KEY": {Value: os.Getenv("OPEN_CHAT_SEAL_KEY"), Sensitive: true},
 				"MOBILE_ROUTE_API_WS_TO_UPSTREAM": {
 					Value:     os.Getenv("MOBILE_ROUTE_API_WS_TO_UPSTREAM"),
@@ -577,6 +582,15 @@ func ServerCli() *cli.Command {
 					Value:     os.Getenv("MOBILE_API_CACHE_MAX_ROWS"),
 					Sensitive: false,
 				},
+				"STORAGE_BACKEND":      {Value: c.String("storage-backend"), Sensitive: false},
+				"STORAGE_LOCAL_DIR":    {Value: c.String("storage-local-dir"), Sensitive: false},
+				"S3_ENDPOINT":          {Value: c.String("s3-endpoint"), Sensitive: false},
+				"S3_REGION":            {Value: c.String("s3-region"), Sensitive: false},
+				"S3_BUCKET":            {Value: c.String("s3-bucket"), Sensitive: false},
+				"S3_ACCESS_KEY_ID":     {Value: c.String("s3-access-key-id"), Sensitive: true},
+				"S3_SECRET_ACCESS_KEY": {Value: c.String("s3-secret-access-key"), Sensitive: true},
+				"S3_PREFIX":            {Value: c.String("s3-prefix"), Sensitive: false},
+				"S3_PATH_STYLE":        {Value: fmt.Sprintf("%t", c.Bool("s3-path-style")), Sensitive: false},
 			}
 
 			for _, decl := range integrations.RuntimeEnvDeclarations() {
@@ -591,6 +605,10 @@ func ServerCli() *cli.Command {
 
 			runtimecfg.SetAll(runtimeValues)
 
+			if err := configureStorage(c); err != nil {
+				return err
+			}
+
 			redisRuntime, err := resolveRedisRuntime(c)
 			if err != nil {
 				return err
@@ -617,6 +635,12 @@ func ServerCli() *cli.Command {
 			})
 			defer asynqUIHandler.Close()
 
+			replyCanceler, err := startReplyCanceler(redisRuntime)
+			if err != nil {
+				return err
+			}
+			defer replyCanceler.Close()
+
 			DB := database.SetupDatabase(database.DBConfig{
 				Backend:  c.String("db-backend"),
 				FilePath: c.String("db-path"),
@@ -640,6 +664,7 @@ func ServerCli() *cli.Command {
 				DB,
 				queueClient,
 				queueInspector,
+				replyCanceler,
 				asynqUIHandler,
 				c.String("host"),
 				c.Uint16("port"),
@@ -651,6 +676,9 @@ func ServerCli() *cli.Command {
 			if err != nil {
 				return err
 			}
+			if err := attachWebsocketBroker(c, redisRuntime, ch); err != nil {
+				return err
+			}
 
 			fmt.Printf("Starting server on %s\n", fullHost)
 			fmt.Printf("Find API reference at %s/reference\n", fullHost)
@@ -759,14 +787,26 @@ func ServerCli() *cli.Command {
 				)
 
 				processor := &queue.Processor{
-					DB:          DB,
-					BackendHost: fullHost,
-					WSHandler:   ch,
+					DB:             DB,
+					BackendHost:    fullHost,
+					WSHandler:      ch,
+					ReplyCanceler:  replyCanceler,
+					QueueClient:    queueClient,
+					QueueInspector: queueInspector,
 				}
 				if workerErr := workerServer.Start(processor.NewServeMux()); workerErr != nil {
 					return fmt.Errorf("embedded asynq worker failed to start: %w", workerErr)
 				}
 				log.Printf("Started embedded asynq worker with concurrency=%d", c.Int("asynq-concurrency"))
+
+				periodicManager, err := queue.NewPeriodicTaskManager(redisRuntime.ConnOpt, DB)
+				if err != nil {
+					return fmt.Errorf("periodic task manager: %w", err)
+				}
+				if err := periodicManager.Start(); err != nil {
+					return fmt.Errorf("periodic task manager failed to start: %w", err)
+				}
+				defer periodicManager.Shutdown()
 			}
 
 			serverErrCh := make(chan error, 1)
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"backend/database"
+	"backend/storage"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+func GetStorageFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Sources: cli.EnvVars("STORAGE_BACKEND"),
+			Name:    "storage-backend",
+			Usage:   "Storage backend new uploads are written to: local|s3",
+			Value:   storage.BackendLocal,
+		},
+		&cli.StringFlag{
+			Sources: cli.EnvVars("STORAGE_LOCAL_DIR"),
+			Name:    "storage-local-dir",
+			Usage:   "Directory the local storage backend writes uploads to",
+			Value:   storage.DefaultLocalDir,
+		},
+		&cli.StringFlag{
+			Sources: cli.EnvVars("S3_ENDPOINT"),
+			Name:    "s3-endpoint",
+			Usage:   "S3 API base URL, e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000",
+		},
+		&cli.StringFlag{
+			Sources: cli.EnvVars("S3_REGION"),
+			Name:    "s3-region",
+			Usage:   "S3 region used to sign requests",
+			Value:   storage.DefaultS3Region,
+		},
+		&cli.StringFlag{
+			Sources: cli.EnvVars("S3_BUCKET"),
+			Name:    "s3-bucket",
+			Usage:   "S3 bucket uploads are stored in",
+		},
+		&cli.StringFlag{
+			Sources: cli.EnvVars("S3_ACCESS_KEY_ID"),
+			Name:    "s3-access-key-id",
+			Usage:   "S3 access key id",
+		},
+		&cli.StringFlag{
+			Sources: cli.EnvVars("S3_SECRET_ACCESS_KEY"),
+			Name:    "s3-secret-access-key",
+			Usage:   "S3 secret access key",
+		},
+		&cli.StringFlag{
+			Sources: cli.EnvVars("S3_PREFIX"),
+			Name:    "s3-prefix",
+			Usage:   "Prefix of the object keys uploads are stored under",
+			Value:   "uploads/",
+		},
+		&cli.BoolFlag{
+			Sources: cli.EnvVars("S3_PATH_STYLE"),
+			Name:    "s3-path-style",
+			Usage:   "Address objects as <endpoint>/<bucket>/<key>, required by most S3-compatible servers",
+			Value:   false,
+		},
+	}
+}
+
+// resolveStorageBackends returns the backend new uploads are written to and
+// the other configured backends existing uploads may still be stored in.
+func resolveStorageBackends(c *cli.Command) (storage.Storage, []storage.Storage, error) {
+	local := storage.NewLocal(c.String("storage-local-dir"))
+	var s3 storage.Storage
+	if c.String("s3-bucket") != "" {
+		backend, err := storage.NewS3(storage.S3Config{
+			Endpoint:        c.String("s3-endpoint"),
+			Region:          c.String("s3-region"),
+			Bucket:          c.String("s3-bucket"),
+			AccessKeyID:     c.String("s3-access-key-id"),
+			SecretAccessKey: c.String("s3-secret-access-key"),
+			Prefix:          c.String("s3-prefix"),
+			PathStyle:       c.Bool("s3-path-style"),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("s3 storage: %w", err)
+		}
+		s3 = backend
+	}
+
+	switch strings.ToLower(strings.TrimSpace(c.String("storage-backend"))) {
+	case "", storage.BackendLocal:
+		if s3 != nil {
+			return local, []storage.Storage{s3}, nil
+		}
+		return local, nil, nil
+	case storage.BackendS3:
+		if s3 == nil {
+			return nil, nil, fmt.Errorf("storage backend s3 requires --s3-bucket")
+		}
+		return s3, []storage.Storage{local}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage backend %q", c.String("storage-backend"))
+	}
+}
+
+func configureStorage(c *cli.Command) error {
+	defaultBackend, others, err := resolveStorageBackends(c)
+	if err != nil {
+		return err
+	}
+	storage.Configure(defaultBackend, others...)
+	log.Printf("Storing uploads in %s storage", defaultBackend.Name())
+	return nil
+}
+
+func StorageCli() *cli.Command {
+	return &cli.Command{
+		Name:  "storage",
+		Usage: "manage the storage backends uploaded files are kept in",
+		Commands: []*cli.Command{
+			{
+				Name:  "migrate",
+				Usage: "move uploaded files into the configured storage backend",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Sources: cli.EnvVars("DB_BACKEND"),
+						Name:    "db-backend",
+						Aliases: []string{"db"},
+						Value:   "sqlite",
+						Usage:   "database driver to use",
+					},
+					&cli.StringFlag{
+						Sources: cli.EnvVars("DB_PATH"),
+						Name:    "db-path",
+						Aliases: []string{"dp"},
+						Value:   "data.db",
+						Usage:   "For sqlite the path to the database file",
+					},
+					&cli.BoolFlag{
+						Name:  "delete-source",
+						Usage: "remove files from their old backend once they were copied",
+						Value: false,
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "only report how many files would be moved",
+						Value: false,
+					},
+				}, GetStorageFlags()...),
+				Action: func(ctx context.Context, c *cli.Command) error {
+					if err := configureStorage(c); err != nil {
+						return err
+					}
+					DB := database.SetupDatabase(database.DBConfig{
+						Backend:  c.String("db-backend"),
+						FilePath: c.String("db-path"),
+						Debug:    false,
+						ResetDB:  false,
+					})
+
+					target := storage.Default()
+					report, err := storage.MigrateFiles(ctx, DB, target, storage.MigrationOptions{
+						DryRun:       c.Bool("dry-run"),
+						DeleteSource: c.Bool("delete-source"),
+					})
+					if err != nil {
+						return fmt.Errorf("storage migration: %w", err)
+					}
+					verb := "Moved"
+					if c.Bool("dry-run") {
+						verb = "Would move"
+					}
+					fmt.Printf("%s %d files (%d bytes) to %s storage, %d already there, %d failed\n", verb, report.Moved, report.Bytes, target.Name(), report.Skipped, report.Failed)
+					if report.Failed > 0 {
+						return fmt.Errorf("%d files failed to migrate", report.Failed)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	wsapi "backend/api/websocket"
 	"backend/database"
 	"backend/integrations"
 	"backend/queue"
@@ -50,7 +51,7 @@ func WorkerCli() *cli.Command {
 				Usage:   "Backend base URL used by async bot tasks",
 				Value:   "http://127.0.0.1:1984",
 			},
-		}, GetRedisFlags()...),
+		}, append(GetRedisFlags(), GetStorageFlags()...)...),
 		Action: func(_ context.Context, c *cli.Command) error {
 			integrations.EnsureLoaded()
 			database.RegisterExternalModels(integrations.AdditionalModels()...)
@@ -61,6 +62,10 @@ func WorkerCli() *cli.Command {
 				})
 			}
 
+			if err := configureStorage(c); err != nil {
+				return err
+			}
+
 			redisRuntime, err := resolveRedisRuntime(c)
 			if err != nil {
 				return err
@@ -82,9 +87,33 @@ func WorkerCli() *cli.Command {
 				Debug:    c.Bool("debug"),
 				ResetDB:  false,
 			})
+			// Publish streamed bot events through the broker so clients connected
+			// to a server process receive them.
+			wsHandler := wsapi.NewWebSocketHandler()
+			if err := attachWebsocketBroker(c, redisRuntime, wsHandler); err != nil {
+				return err
+			}
+			defer wsHandler.Shutdown()
+
+			replyCanceler, err := startReplyCanceler(redisRuntime)
+			if err != nil {
+				return err
+			}
+			defer replyCanceler.Close()
+
+			// Delivered scheduled messages enqueue the bot replies to them
+			queueClient := asynq.NewClient(redisRuntime.ConnOpt)
+			defer queueClient.Close()
+			queueInspector := asynq.NewInspector(redisRuntime.ConnOpt)
+			defer queueInspector.Close()
+
 			processor := &queue.Processor{
-				DB:          DB,
-				BackendHost: c.String("backend-host"),
+				DB:             DB,
+				BackendHost:    c.String("backend-host"),
+				WSHandler:      wsHandler,
+				ReplyCanceler:  replyCanceler,
+				QueueClient:    queueClient,
+				QueueInspector: queueInspector,
 			}
 
 			server := asynq.NewServer(
@@ -97,6 +126,15 @@ func WorkerCli() *cli.Command {
 				},
 			)
 
+			periodicManager, err := queue.NewPeriodicTaskManager(redisRuntime.ConnOpt, DB)
+			if err != nil {
+				return fmt.Errorf("periodic task manager: %w", err)
+			}
+			if err := periodicManager.Start(); err != nil {
+				return fmt.Errorf("periodic task manager failed to start: %w", err)
+			}
+			defer periodicManager.Shutdown()
+
 			log.Printf("Starting asynq worker with concurrency=%d", c.Int("asynq-concurrency"))
 			if err := server.Run(processor.NewServeMux()); err != nil {
 				return fmt.Errorf("asynq worker failed: %w", err)
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	// The production image has no zoneinfo of its own
+	_ "time/tzdata"
+
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-bot-schedules
+// A bot schedule runs a message on a cron expression in the owner's time
+// zone. Schedules with a bot start a new interaction with the bot on every
+// run, schedules with a chat post the message into the chat as the owner so
+// the chat's bots reply as usual. Every worker process feeds the active
+// schedules to asynq's periodic task manager. Its task only enqueues the run
+// of the firing under a task ID made of the schedule and the firing time, so
+// the schedulers of several workers enqueue a single run per firing; runs are
+// recorded with the TaskResult of the task that ran them.
+
+const (
+	// MinBotScheduleInterval is the shortest time allowed between two runs.
+	MinBotScheduleInterval = 5 * time.Minute
+	// MaxBotScheduleMessageLength caps the scheduled message in bytes.
+	MaxBotScheduleMessageLength = 16000
+	// BotScheduleFiringWindow is how late a scheduler task may start and
+	// still be matched to its firing.
+	BotScheduleFiringWindow = time.Hour
+	// botScheduleClockSkew tolerates scheduler tasks starting before the
+	// firing on workers with a different clock.
+	botScheduleClockSkew = 30 * time.Second
+)
+
+var (
+	ErrInvalidCronExpression  = errors.New("invalid cron expression")
+	ErrInvalidTimeZone        = errors.New("invalid time zone")
+	ErrBotScheduleTooFrequent = fmt.Errorf("schedules may run at most every %s", MinBotScheduleInterval)
+)
+
+type BotSchedule struct {
+	Model
+	OwnerId            uint              `json:"-" gorm:"index"`
+	Owner              User              `json:"-" gorm:"foreignKey:OwnerId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Name               string            `json:"name"`
+	BotRuntimeConfigId *uint             `json:"-" gorm:"index"`
+	BotRuntimeConfig   *BotRuntimeConfig `json:"-" gorm:"foreignKey:BotRuntimeConfigId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	ChatId             *uint             `json:"-" gorm:"index"`
+	Chat               *Chat             `json:"-" gorm:"foreignKey:ChatId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Message            string            `json:"message" gorm:"type:text"`
+	// ConfigOverrides apply on top of the bot's default config for new interactions.
+	ConfigOverrides json.RawMessage `json:"config_overrides" gorm:"type:jsonb"`
+	CronExpression  string          `json:"cron"`
+	TimeZone        string          `json:"time_zone"`
+	Paused          bool            `json:"paused" gorm:"index"`
+	LastRunAt       *time.Time      `json:"last_run_at"`
+}
+
+type BotScheduleRun struct {
+	Model
+	ScheduleId   uint        `json:"-" gorm:"index"`
+	Schedule     BotSchedule `json:"-" gorm:"foreignKey:ScheduleId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	TaskResultId *uint       `json:"-"`
+	TaskResult   *TaskResult `json:"-" gorm:"foreignKey:TaskResultId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	// ChatId is the interaction created or the chat posted into.
+	ChatId     *uint     `json:"-"`
+	Chat       *Chat     `json:"-" gorm:"foreignKey:ChatId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty" gorm:"type:text"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Cronspec is the schedule's cron expression pinned to its time zone.
+func (s BotSchedule) Cronspec() string {
+	return "CRON_TZ=" + s.TimeZone + " " + s.CronExpression
+}
+
+// ParseBotSchedule checks a five field cron expression (or a descriptor like
+// @daily) in timeZone and returns the parsed schedule. An empty time zone is UTC.
+func ParseBotSchedule(expression, timeZone string) (cron.Schedule, string, error) {
+	expression = strings.TrimSpace(expression)
+	timeZone = strings.TrimSpace(timeZone)
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(timeZone); err != nil {
+		return nil, "", ErrInvalidTimeZone
+	}
+	if expression == "" || strings.Contains(expression, "TZ=") {
+		return nil, "", ErrInvalidCronExpression
+	}
+	schedule, err := cron.ParseStandard("CRON_TZ=" + timeZone + " " + expression)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidCronExpression, err)
+	}
+	next := schedule.Next(time.Now())
+	if next.IsZero() {
+		return nil, "", ErrInvalidCronExpression
+	}
+	if following := schedule.Next(next); !following.IsZero() && following.Sub(next) < MinBotScheduleInterval {
+		return nil, "", ErrBotScheduleTooFrequent
+	}
+	return schedule, timeZone, nil
+}
+
+// NextBotScheduleRun returns when a schedule runs next after now, nil for
+// paused schedules.
+func NextBotScheduleRun(schedule BotSchedule, now time.Time) *time.Time {
+	if schedule.Paused {
+		return nil
+	}
+	parsed, err := cron.ParseStandard(schedule.Cronspec())
+	if err != nil {
+		return nil
+	}
+	next := parsed.Next(now)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// BotScheduleFiring returns the firing a scheduler task started at now
+// belongs to, the latest firing up to now. Tasks that start later than
+// BotScheduleFiringWindow fall back to now truncated to the minute.
+func BotScheduleFiring(schedule BotSchedule, now time.Time) time.Time {
+	fallback := now.Truncate(time.Minute)
+	parsed, err := cron.ParseStandard(schedule.Cronspec())
+	if err != nil {
+		return fallback
+	}
+	at := now.Add(botScheduleClockSkew)
+	var fired time.Time
+	for next := parsed.Next(at.Add(-BotScheduleFiringWindow)); !next.IsZero() && !next.After(at); next = parsed.Next(next) {
+		fired = next
+	}
+	if fired.IsZero() {
+		return fallback
+	}
+	return fired
+}
+
+// ListActiveBotSchedules returns the schedules that are not paused.
+func ListActiveBotSchedules(db *gorm.DB) ([]BotSchedule, error) {
+	schedules := []BotSchedule{}
+	if err := db.Where("paused = ?", false).Order("id asc").Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// RecordBotScheduleRun stores a run and moves the schedule's last run to it.
+func RecordBotScheduleRun(db *gorm.DB, run *BotScheduleRun) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(run).Error; err != nil {
+			return err
+		}
+		return tx.Model(&BotSchedule{}).Where("id = ?", run.ScheduleId).Update("last_run_at", run.StartedAt).Error
+	})
+}
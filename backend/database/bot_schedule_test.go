@@ -0,0 +1,28 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBotScheduleFiringMatchesSchedulerTasksToTheirFiring(t *testing.T) {
+	schedule := BotSchedule{CronExpression: "30 9 * * *", TimeZone: "Europe/Berlin"}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("failed to load time zone: %v", err)
+	}
+	fired := time.Date(2026, 3, 2, 9, 30, 0, 0, berlin)
+
+	// Tasks of several schedulers, started on time, late or on a clock that
+	// is slightly behind, all belong to the same firing
+	for _, now := range []time.Time{fired, fired.Add(2 * time.Second), fired.Add(20 * time.Minute), fired.Add(-10 * time.Second)} {
+		if got := BotScheduleFiring(schedule, now); !got.Equal(fired) {
+			t.Fatalf("expected the firing at %s for a task at %s, got %s", fired, now, got)
+		}
+	}
+
+	late := fired.Add(2*BotScheduleFiringWindow + 90*time.Second)
+	if got := BotScheduleFiring(schedule, late); !got.Equal(late.Truncate(time.Minute)) {
+		t.Fatalf("expected tasks outside the window to use their minute, got %s", got)
+	}
+}
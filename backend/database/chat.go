@@ -20,6 +20,10 @@ type Message struct {
 	Reasoning  *[]string          `json:"reasoning,omitempty" gorm:"type:jsonb;serializer:json"`
 	ToolCalls  *[]json.RawMessage `json:"tool_calls,omitempty" gorm:"type:jsonb;serializer:json"`
 	MetaData   json.RawMessage    `json:"meta_data" gorm:"type:jsonb"`
+	EditedAt   *time.Time         `json:"edited_at,omitempty" gorm:"default:null"`
+	ParentId   *uint              `json:"-" gorm:"index"`
+	ReplyToId  *uint              `json:"-" gorm:"index"`
+	Inactive   bool               `json:"-" gorm:"default:false;index"`
 }
 
 // SharedChatConfig stores the shared LLM/tool configuration for a chat.
@@ -43,6 +47,11 @@ type Chat struct {
 	SharedConfigId  *uint             `json:"-" gorm:"index"`
 	SharedConfig    *SharedChatConfig `json:"config" gorm:"foreignKey:SharedConfigId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:NO ACTION;"`
 	ChatType        string            `json:"chat_type" gorm:"default:'conversation'"`
+	Title           string            `json:"title,omitempty"`
+	// BotTriggerPolicy decides which bot participants reply to a human message
+	// in group chats; two-party chats always trigger the counterparty bot.
+	BotTriggerPolicy string            `json:"bot_trigger_policy" gorm:"default:'always'"`
+	Participants     []ChatParticipant `json:"-" gorm:"foreignKey:ChatId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 }
 
 // ChatSettings stores per-chat settings owned by the backend for server-side
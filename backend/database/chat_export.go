@@ -0,0 +1,111 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-chat-exports
+// A chat export renders the active branch of a chat, with reasoning, tool
+// calls, confirmable-action results and attachments, to Markdown, a
+// self-contained HTML page or a JSON archive zipped with the attached files.
+// Small chats are rendered in the request. Larger ones are stored as a
+// pending export that an asynq task renders to disk, notifying the user over
+// the websocket once it is ready; ready exports can be downloaded until they
+// expire after ChatExportTTL.
+
+const (
+	ChatExportFormatMarkdown = "markdown"
+	ChatExportFormatHTML     = "html"
+	ChatExportFormatJSON     = "json"
+
+	ChatExportPending = "pending"
+	ChatExportRunning = "running"
+	ChatExportReady   = "ready"
+	ChatExportFailed  = "failed"
+
+	// ChatExportTTL is how long a finished export can be downloaded.
+	ChatExportTTL = 7 * 24 * time.Hour
+)
+
+var ErrChatExportNotPending = errors.New("chat export is no longer pending")
+
+// ChatExport is a chat export rendered by the worker. StorageURL addresses the
+// rendered file in storage, exports rendered before storage backends existed
+// hold a local path there.
+type ChatExport struct {
+	Model
+	OwnerId    uint       `json:"-" gorm:"index"`
+	Owner      User       `json:"-" gorm:"foreignKey:OwnerId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	ChatId     uint       `json:"-" gorm:"index"`
+	Chat       Chat       `json:"-" gorm:"foreignKey:ChatId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Format     string     `json:"format"`
+	Status     string     `json:"status" gorm:"index"`
+	StorageURL string     `json:"-" gorm:"column:file_path"`
+	FileName   string     `json:"file_name,omitempty"`
+	Size       int64      `json:"size,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty" gorm:"index"`
+}
+
+func IsValidChatExportFormat(format string) bool {
+	switch format {
+	case ChatExportFormatMarkdown, ChatExportFormatHTML, ChatExportFormatJSON:
+		return true
+	}
+	return false
+}
+
+// Expired reports whether a finished export can no longer be downloaded at now.
+func (e ChatExport) Expired(now time.Time) bool {
+	return e.FinishedAt != nil && now.Sub(*e.FinishedAt) > ChatExportTTL
+}
+
+// ClaimChatExport moves a pending export to running so it is rendered once.
+func ClaimChatExport(db *gorm.DB, uuid string) (*ChatExport, error) {
+	result := db.Model(&ChatExport{}).
+		Where("uuid = ? AND status = ?", uuid, ChatExportPending).
+		Update("status", ChatExportRunning)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrChatExportNotPending
+	}
+	var export ChatExport
+	if err := db.Preload("Owner").Preload("Chat").Where("uuid = ?", uuid).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// FinishChatExport records the rendered file of a claimed export or the
+// reason it could not be rendered.
+func FinishChatExport(db *gorm.DB, export *ChatExport, storageURL, fileName string, size int64, renderErr error, now time.Time) error {
+	updates := map[string]interface{}{
+		"status":      ChatExportReady,
+		"file_path":   storageURL,
+		"file_name":   fileName,
+		"size":        size,
+		"finished_at": now,
+	}
+	if renderErr != nil {
+		updates = map[string]interface{}{"status": ChatExportFailed, "error": renderErr.Error(), "finished_at": now}
+	}
+	if err := db.Model(export).Updates(updates).Error; err != nil {
+		return err
+	}
+	export.FinishedAt = &now
+	if renderErr != nil {
+		export.Status = ChatExportFailed
+		export.Error = renderErr.Error()
+		return nil
+	}
+	export.Status = ChatExportReady
+	export.StorageURL = storageURL
+	export.FileName = fileName
+	export.Size = size
+	return nil
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ChatTypeGroup = "group"
+
+	ChatParticipantRoleOwner  = "owner"
+	ChatParticipantRoleMember = "member"
+
+	// ChatBotTriggerAlways lets every message from a human trigger all bots in the chat.
+	ChatBotTriggerAlways = "always"
+	// ChatBotTriggerMention only triggers bots that are @-mentioned in the message text.
+	ChatBotTriggerMention = "mention"
+)
+
+// ChatParticipant grants a user membership in a chat.
+// User1Id/User2Id on Chat remain populated for two-party chats for compatibility,
+// but access checks and websocket fan-out are resolved through this table.
+// Removed participants are soft-deleted, their DeletedAt is the removal time
+// incremental sync reports.
+type ChatParticipant struct {
+	Model
+	ChatId uint   `json:"-" gorm:"index;uniqueIndex:idx_chat_participant"`
+	Chat   Chat   `json:"-" gorm:"foreignKey:ChatId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	UserId uint   `json:"-" gorm:"index;uniqueIndex:idx_chat_participant"`
+	User   User   `json:"user" gorm:"foreignKey:UserId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Role   string `json:"role" gorm:"default:'member'"`
+	// LastDeliveredMessageId and LastReadMessageId are the user's delivered
+	// and read positions in the chat.
+	LastDeliveredMessageId *uint `json:"-"`
+	LastReadMessageId      *uint `json:"-"`
+}
+
+// AfterCreate registers User1/User2 as participants so every code path that
+// creates a two-party chat keeps the participant table in sync.
+func (c *Chat) AfterCreate(tx *gorm.DB) error {
+	for _, userID := range []uint{c.User1Id, c.User2Id} {
+		if err := EnsureChatParticipant(tx, c.ID, userID, ChatParticipantRoleMember); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func EnsureChatParticipant(DB *gorm.DB, chatID uint, userID uint, role string) error {
+	if chatID == 0 || userID == 0 {
+		return nil
+	}
+	if strings.TrimSpace(role) == "" {
+		role = ChatParticipantRoleMember
+	}
+	var participant ChatParticipant
+	err := DB.Unscoped().Where("chat_id = ? AND user_id = ?", chatID, userID).First(&participant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DB.Create(&ChatParticipant{ChatId: chatID, UserId: userID, Role: role}).Error
+	}
+	if err != nil || !participant.DeletedAt.Valid {
+		return err
+	}
+	// A removed participant is re-added with the new role
+	return DB.Unscoped().Model(&participant).Updates(map[string]interface{}{"deleted_at": nil, "role": role}).Error
+}
+
+// RemoveChatParticipant soft-deletes the membership row, EnsureChatParticipant
+// restores it when the user is re-added later.
+func RemoveChatParticipant(DB *gorm.DB, chatID uint, userID uint) error {
+	return DB.Where("chat_id = ? AND user_id = ?", chatID, userID).Delete(&ChatParticipant{}).Error
+}
+
+func GetChatParticipant(DB *gorm.DB, chatID uint, userID uint) (*ChatParticipant, error) {
+	var participant ChatParticipant
+	if err := DB.Preload("User").Where("chat_id = ? AND user_id = ?", chatID, userID).First(&participant).Error; err != nil {
+		return nil, err
+	}
+	return &participant, nil
+}
+
+func ListChatParticipants(DB *gorm.DB, chatID uint) ([]ChatParticipant, error) {
+	participants := []ChatParticipant{}
+	if err := DB.Preload("User").Where("chat_id = ?", chatID).Order("id asc").Find(&participants).Error; err != nil {
+		return nil, err
+	}
+	return participants, nil
+}
+
+// ChatParticipantScope restricts a chat query to chats the user is a participant of.
+func ChatParticipantScope(userID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN (SELECT chat_id FROM chat_participants WHERE user_id = ? AND deleted_at IS NULL)", userID)
+	}
+}
+
+// ChatOwnerScope restricts a chat query to chats the user may manage: group
+// chats they own and two-party chats they are still a participant of.
+func ChatOwnerScope(userID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN (SELECT chat_id FROM chat_participants WHERE user_id = ? AND deleted_at IS NULL AND (role = ? OR chat_id NOT IN (SELECT id FROM chats WHERE chat_type = ?)))",
+			userID, ChatParticipantRoleOwner, ChatTypeGroup)
+	}
+}
+
+func NormalizeChatBotTriggerPolicy(policy string) string {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case ChatBotTriggerAlways:
+		return ChatBotTriggerAlways
+	case ChatBotTriggerMention:
+		return ChatBotTriggerMention
+	default:
+		return ""
+	}
+}
+
+type BackfillChatParticipantsMigration struct{}
+
+func (BackfillChatParticipantsMigration) Migrate(db *gorm.DB) error {
+	if db == nil {
+		return nil
+	}
+	if !db.Migrator().HasTable("chats") || !db.Migrator().HasTable("chat_participants") {
+		return nil
+	}
+
+	type chatUsersRow struct {
+		ID      uint
+		User1Id uint
+		User2Id uint
+	}
+	rows := []chatUsersRow{}
+	if err := db.Model(&Chat{}).
+		Select("id", "user1_id", "user2_id").
+		Where("id NOT IN (SELECT chat_id FROM chat_participants)").
+		Find(&rows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for _, userID := range []uint{row.User1Id, row.User2Id} {
+			if err := EnsureChatParticipant(db, row.ID, userID, ChatParticipantRoleMember); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
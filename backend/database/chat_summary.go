@@ -0,0 +1,57 @@
+package database
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-running-summary
+// ChatSummary is the running summary of a chat's history. When a chat's
+// history outgrows the token budget of its model, the bot rolls the oldest
+// turns into this summary and sends it along with the most recent messages.
+// SummarizedUntilUUID is the newest message folded into the summary, the
+// summary covers it and everything before it.
+type ChatSummary struct {
+	Model
+	ChatId              uint   `json:"-" gorm:"uniqueIndex"`
+	Chat                Chat   `json:"-" gorm:"foreignKey:ChatId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Text                string `json:"text" gorm:"type:text"`
+	SummarizedUntilUUID string `json:"summarized_until_uuid" gorm:"index"`
+	SummarizedMessages  int    `json:"summarized_messages"`
+}
+
+// GetChatSummary returns the running summary of a chat, nil when the chat
+// was never summarized.
+func GetChatSummary(db *gorm.DB, chatUUID string) (*ChatSummary, error) {
+	var summary ChatSummary
+	err := db.Joins("JOIN chats ON chats.id = chat_summaries.chat_id").
+		Where("chats.uuid = ?", chatUUID).
+		First(&summary).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// SaveChatSummary replaces the running summary of a chat after another
+// summarizedMessages messages up to summarizedUntilUUID were folded into it.
+func SaveChatSummary(db *gorm.DB, chatUUID, text, summarizedUntilUUID string, summarizedMessages int) error {
+	var chat Chat
+	if err := db.Select("id").Where("uuid = ?", chatUUID).First(&chat).Error; err != nil {
+		return err
+	}
+
+	summary := ChatSummary{ChatId: chat.ID}
+	if err := db.Where("chat_id = ?", chat.ID).FirstOrCreate(&summary).Error; err != nil {
+		return err
+	}
+	return db.Model(&summary).Updates(map[string]interface{}{
+		"text":                  text,
+		"summarized_until_uuid": summarizedUntilUUID,
+		"summarized_messages":   summary.SummarizedMessages + summarizedMessages,
+	}).Error
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestChatSummaryAndModelContextLength(t *testing.T) {
+	DB := SetupDatabase(DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "chat_summary.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+
+	user := User{Name: "reader", Email: "reader@example.invalid", Username: "reader"}
+	bot := User{Name: "bot", Email: "summary-bot@example.invalid", Username: "summary-bot", IsAutomated: true}
+	for _, record := range []*User{&user, &bot} {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatalf("failed creating user: %v", err)
+		}
+	}
+	chat := Chat{User1Id: user.ID, User2Id: bot.ID}
+	if err := DB.Create(&chat).Error; err != nil {
+		t.Fatalf("failed creating chat: %v", err)
+	}
+
+	summary, err := GetChatSummary(DB, chat.UUID)
+	if err != nil || summary != nil {
+		t.Fatalf("expected no summary for a new chat, got %+v (%v)", summary, err)
+	}
+
+	if err := SaveChatSummary(DB, chat.UUID, "The user is Ada.", "message-3", 3); err != nil {
+		t.Fatalf("save summary failed: %v", err)
+	}
+	if err := SaveChatSummary(DB, chat.UUID, "The user is Ada and plans a trip.", "message-5", 2); err != nil {
+		t.Fatalf("save summary failed: %v", err)
+	}
+	summary, err = GetChatSummary(DB, chat.UUID)
+	if err != nil || summary == nil {
+		t.Fatalf("expected a summary, got %v", err)
+	}
+	if summary.Text != "The user is Ada and plans a trip." || summary.SummarizedUntilUUID != "message-5" || summary.SummarizedMessages != 5 {
+		t.Fatalf("unexpected running summary %+v", summary)
+	}
+
+	createDefaultModelForTest(t, DB, "long-context-model", "openai", nil, `{"model":"long-context-model","backend":"openai","context_length":128000}`)
+	if contextLength, err := ResolveModelContextLength(DB, "", "long-context-model"); err != nil || contextLength != 128000 {
+		t.Fatalf("expected the configured context length, got %d (%v)", contextLength, err)
+	}
+	if contextLength, err := ResolveModelContextLength(DB, "", "unknown-model"); err != nil || contextLength != 0 {
+		t.Fatalf("expected an unknown context length, got %d (%v)", contextLength, err)
+	}
+}
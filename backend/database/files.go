@@ -2,7 +2,12 @@ package database
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UploadedFile struct {
@@ -16,6 +21,9 @@ type UploadedFile struct {
 	Owner      User            `gorm:"foreignKey:OwnerID"`
 	SharedWith []User          `gorm:"many2many:file_access;"` // Users with access
 	MetaData   json.RawMessage `gorm:"type:json"`              // Additional metadata (e.g., OpenAI file ID)
+
+	// ThumbnailStorageURL is the storage URL of the chat preview of an image
+	ThumbnailStorageURL string
 }
 
 // Join table for additional metadata (optional)
@@ -23,5 +31,187 @@ type FileAccess struct {
 	UserID         uint   `gorm:"primaryKey"`
 	UploadedFileID uint   `gorm:"primaryKey"`
 	Permission     string // E.g., "view", "edit"
-	CreatedAt      time.Time
+	// Implicit grants come from attaching the file to a message, they don't
+	// keep the file from being purged with its messages
+	Implicit  bool `gorm:"not null;default:false"`
+	CreatedAt time.Time
+}
+
+// @doc:open-chat-file-sharing
+// Files are shared through FileAccess rows. The owner of an UploadedFile can
+// do anything with it; a "view" grant lets a user read the file, an "edit"
+// grant also lets them share it further, attach it to their messages and
+// revoke grants. Attaching a file to a message implicitly grants view access
+// to the other participants of the chat, bots included. Grants are never
+// downgraded implicitly, only an explicit share changes a permission.
+
+const (
+	FilePermissionOwner = "owner"
+	FilePermissionView  = "view"
+	FilePermissionEdit  = "edit"
+)
+
+var ErrFileAccessDenied = errors.New("access to file denied")
+
+func IsValidFilePermission(permission string) bool {
+	return permission == FilePermissionView || permission == FilePermissionEdit
+}
+
+// CanShareFile reports whether a permission allows sharing a file further,
+// explicitly or by attaching it to a message.
+func CanShareFile(permission string) bool {
+	return permission == FilePermissionOwner || permission == FilePermissionEdit
+}
+
+// FilePermissionOf returns the permission a user has on a file, empty when
+// the user has no access.
+func FilePermissionOf(db *gorm.DB, file *UploadedFile, userID uint) (string, error) {
+	if file.OwnerID == userID {
+		return FilePermissionOwner, nil
+	}
+	var access FileAccess
+	err := db.Where("uploaded_file_id = ? AND user_id = ?", file.ID, userID).First(&access).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	// Grants stored before permissions were checked may be empty
+	if access.Permission == "" {
+		return FilePermissionView, nil
+	}
+	return access.Permission, nil
+}
+
+// FindAccessibleFile loads a file the user may read, returning
+// gorm.ErrRecordNotFound for unknown files and ErrFileAccessDenied otherwise.
+func FindAccessibleFile(db *gorm.DB, fileID string, userID uint) (*UploadedFile, string, error) {
+	var file UploadedFile
+	if err := db.Where("file_id = ?", fileID).First(&file).Error; err != nil {
+		return nil, "", err
+	}
+	permission, err := FilePermissionOf(db, &file, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if permission == "" {
+		return nil, "", ErrFileAccessDenied
+	}
+	return &file, permission, nil
+}
+
+// ShareFile grants users a permission on a file, replacing the permission of
+// existing grants. The owner is skipped.
+func ShareFile(db *gorm.DB, file *UploadedFile, userIDs []uint, permission string, now time.Time) error {
+	if !IsValidFilePermission(permission) {
+		return fmt.Errorf("unsupported file permission %q", permission)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, userID := range userIDs {
+			if userID == file.OwnerID {
+				continue
+			}
+			access := FileAccess{UserID: userID, UploadedFileID: file.ID, Permission: permission, CreatedAt: now}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}, {Name: "uploaded_file_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"permission", "implicit"}),
+			}).Create(&access).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GrantFileViewAccess gives users without access to a file an implicit view
+// access, keeping the permission of existing grants.
+func GrantFileViewAccess(db *gorm.DB, file *UploadedFile, userIDs []uint, now time.Time) error {
+	for _, userID := range userIDs {
+		if userID == file.OwnerID {
+			continue
+		}
+		access := FileAccess{UserID: userID, UploadedFileID: file.ID, Permission: FilePermissionView, Implicit: true, CreatedAt: now}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&access).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func RevokeFileAccess(db *gorm.DB, file *UploadedFile, userID uint) error {
+	return db.Where("uploaded_file_id = ? AND user_id = ?", file.ID, userID).Delete(&FileAccess{}).Error
+}
+
+// DeleteUploadedFile soft-deletes a file and revokes its grants, the
+// retention purge removes the row later.
+func DeleteUploadedFile(db *gorm.DB, file *UploadedFile) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("uploaded_file_id = ?", file.ID).Delete(&FileAccess{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(file).Error
+	})
+}
+
+// FileGrant is a FileAccess row with the user it grants access to.
+type FileGrant struct {
+	FileAccess
+	User User `gorm:"foreignKey:UserID"`
+}
+
+func (FileGrant) TableName() string {
+	return "file_accesses"
+}
+
+func ListFileGrants(db *gorm.DB, file *UploadedFile) ([]FileGrant, error) {
+	var grants []FileGrant
+	err := db.Preload("User").Where("uploaded_file_id = ?", file.ID).Order("created_at asc").Find(&grants).Error
+	return grants, err
+}
+
+// SharedFile is a file someone else shared with a user.
+type SharedFile struct {
+	UploadedFile
+	Permission string
+	SharedAt   time.Time
+}
+
+// ListFilesSharedWith returns the files other users shared with a user, the
+// most recently shared first.
+func ListFilesSharedWith(db *gorm.DB, userID uint) ([]SharedFile, error) {
+	var grants []FileAccess
+	if err := db.Where("user_id = ?", userID).Order("created_at desc").Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	if len(grants) == 0 {
+		return []SharedFile{}, nil
+	}
+	fileIDs := make([]uint, 0, len(grants))
+	for _, grant := range grants {
+		fileIDs = append(fileIDs, grant.UploadedFileID)
+	}
+	var files []UploadedFile
+	if err := db.Preload("Owner").Where("id IN ?", fileIDs).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]UploadedFile, len(files))
+	for _, file := range files {
+		byID[file.ID] = file
+	}
+
+	shared := make([]SharedFile, 0, len(grants))
+	for _, grant := range grants {
+		file, ok := byID[grant.UploadedFileID]
+		if !ok || file.OwnerID == userID {
+			continue
+		}
+		permission := grant.Permission
+		if permission == "" {
+			permission = FilePermissionView
+		}
+		shared = append(shared, SharedFile{UploadedFile: file, Permission: permission, SharedAt: grant.CreatedAt})
+	}
+	return shared, nil
 }
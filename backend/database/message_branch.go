@@ -0,0 +1,327 @@
+package database
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-message-branches
+// Messages of a chat form a tree: ParentId is the message a message answered
+// or followed. The messages on the path from the root to the chat's latest
+// message are the active branch, every other message is Inactive and hidden
+// from message listings. Rerunning a message creates a sibling of it, a
+// replaced bot answer gets a sibling answer, and switching branches swaps
+// which path is active.
+
+var ErrMessageNotInChat = errors.New("message is not part of the chat")
+
+// BeforeCreate appends new messages to the active branch of their chat unless
+// a parent was set explicitly.
+func (m *Message) BeforeCreate(tx *gorm.DB) error {
+	if err := m.Model.BeforeCreate(tx); err != nil {
+		return err
+	}
+	if m.ParentId != nil || m.ChatId == 0 {
+		return nil
+	}
+	parentIDs := []uint{}
+	if err := tx.Session(&gorm.Session{NewDB: true}).
+		Model(&Message{}).
+		Where("chat_id = ? AND inactive = ?", m.ChatId, false).
+		Order("id desc").
+		Limit(1).
+		Pluck("id", &parentIDs).Error; err != nil {
+		return err
+	}
+	if len(parentIDs) > 0 {
+		m.ParentId = &parentIDs[0]
+	}
+	return nil
+}
+
+type messageTreeNode struct {
+	ID       uint
+	ParentId *uint
+}
+
+// loadMessageTree returns the parent of every message of a chat.
+func loadMessageTree(db *gorm.DB, chatID uint) (map[uint]*uint, error) {
+	nodes := []messageTreeNode{}
+	if err := db.Model(&Message{}).Select("id", "parent_id").Where("chat_id = ?", chatID).Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	parents := make(map[uint]*uint, len(nodes))
+	for _, node := range nodes {
+		parents[node.ID] = node.ParentId
+	}
+	return parents, nil
+}
+
+// messagePathIDs returns the ids from the root of the tree to messageID.
+func messagePathIDs(parents map[uint]*uint, messageID uint) ([]uint, error) {
+	if _, ok := parents[messageID]; !ok {
+		return nil, ErrMessageNotInChat
+	}
+	path := []uint{}
+	seen := map[uint]bool{}
+	for id := &messageID; id != nil; id = parents[*id] {
+		if seen[*id] {
+			break
+		}
+		seen[*id] = true
+		path = append(path, *id)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// branchLeafID follows the newest child from messageID down to a leaf.
+func branchLeafID(parents map[uint]*uint, messageID uint) uint {
+	newestChild := map[uint]uint{}
+	for id, parent := range parents {
+		if parent != nil && id > newestChild[*parent] {
+			newestChild[*parent] = id
+		}
+	}
+	leaf := messageID
+	for {
+		child, ok := newestChild[leaf]
+		if !ok {
+			return leaf
+		}
+		leaf = child
+	}
+}
+
+// ListMessageBranches returns the message and its siblings, the alternative
+// branches at its position, oldest first.
+func ListMessageBranches(db *gorm.DB, message Message) ([]Message, error) {
+	siblings := []Message{}
+	query := db.Preload("Sender").Where("chat_id = ?", message.ChatId)
+	if message.ParentId == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *message.ParentId)
+	}
+	if err := query.Order("id asc").Find(&siblings).Error; err != nil {
+		return nil, err
+	}
+	return siblings, nil
+}
+
+// CountMessageBranches counts the siblings of each message, the messages
+// themselves included.
+func CountMessageBranches(db *gorm.DB, chatID uint, messages []Message) (map[uint]int, error) {
+	rows := []struct {
+		ParentId *uint
+		Branches int
+	}{}
+	if err := db.Model(&Message{}).
+		Select("parent_id, COUNT(*) AS branches").
+		Where("chat_id = ?", chatID).
+		Group("parent_id").
+		Having("COUNT(*) > 1").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	byParent := map[uint]int{}
+	roots := 0
+	for _, row := range rows {
+		if row.ParentId == nil {
+			roots = row.Branches
+			continue
+		}
+		byParent[*row.ParentId] = row.Branches
+	}
+
+	counts := map[uint]int{}
+	for _, message := range messages {
+		count := roots
+		if message.ParentId != nil {
+			count = byParent[*message.ParentId]
+		}
+		if count > 1 {
+			counts[message.ID] = count
+		}
+	}
+	return counts, nil
+}
+
+// DeactivateMessagesFrom moves the active messages of a chat from
+// fromMessageID on to an inactive branch and returns their UUIDs.
+func DeactivateMessagesFrom(db *gorm.DB, chatID, fromMessageID uint) ([]string, error) {
+	uuids := []string{}
+	query := db.Model(&Message{}).Where("chat_id = ? AND id >= ? AND inactive = ?", chatID, fromMessageID, false)
+	if err := query.Session(&gorm.Session{}).Pluck("uuid", &uuids).Error; err != nil {
+		return nil, err
+	}
+	if len(uuids) == 0 {
+		return uuids, nil
+	}
+	if err := query.Update("inactive", true).Error; err != nil {
+		return nil, err
+	}
+	return uuids, nil
+}
+
+// ActivateMessageBranch makes the branch through messageID the active one. The
+// branch continues to the newest leaf below the message, which becomes the
+// chat's latest message. The chat's running summary is dropped as it may
+// cover messages of the previous branch.
+func ActivateMessageBranch(db *gorm.DB, chatID, messageID uint) (uint, error) {
+	var leafID uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		parents, err := loadMessageTree(tx, chatID)
+		if err != nil {
+			return err
+		}
+		leafID = branchLeafID(parents, messageID)
+		path, err := messagePathIDs(parents, leafID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&Message{}).
+			Where("chat_id = ? AND inactive = ? AND id NOT IN ?", chatID, false, path).
+			Update("inactive", true).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Message{}).
+			Where("id IN ? AND inactive = ?", path, true).
+			Update("inactive", false).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Chat{}).Where("id = ?", chatID).Update("latest_message_id", leafID).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("chat_id = ?", chatID).Delete(&ChatSummary{}).Error
+	})
+	return leafID, err
+}
+
+// ForkChat copies a chat up to a message into a new chat with the same
+// participants and a copy of the shared config. Only the path leading to the
+// message is copied.
+func ForkChat(db *gorm.DB, source Chat, messageID uint) (*Chat, error) {
+	var fork Chat
+	err := db.Transaction(func(tx *gorm.DB) error {
+		parents, err := loadMessageTree(tx, source.ID)
+		if err != nil {
+			return err
+		}
+		path, err := messagePathIDs(parents, messageID)
+		if err != nil {
+			return err
+		}
+		messages := []Message{}
+		if err := tx.Where("id IN ?", path).Order("id asc").Find(&messages).Error; err != nil {
+			return err
+		}
+
+		fork = Chat{
+			User1Id:          source.User1Id,
+			User2Id:          source.User2Id,
+			ChatType:         source.ChatType,
+			Title:            source.Title,
+			BotTriggerPolicy: source.BotTriggerPolicy,
+		}
+		if err := tx.Create(&fork).Error; err != nil {
+			return err
+		}
+
+		participants, err := ListChatParticipants(tx, source.ID)
+		if err != nil {
+			return err
+		}
+		for _, participant := range participants {
+			if err := EnsureChatParticipant(tx, fork.ID, participant.UserId, participant.Role); err != nil {
+				return err
+			}
+			// User1 and User2 joined as members when the chat was created
+			if err := tx.Model(&ChatParticipant{}).
+				Where("chat_id = ? AND user_id = ?", fork.ID, participant.UserId).
+				Update("role", participant.Role).Error; err != nil {
+				return err
+			}
+		}
+
+		if source.SharedConfigId != nil {
+			var sourceConfig SharedChatConfig
+			if err := tx.First(&sourceConfig, *source.SharedConfigId).Error; err != nil {
+				return err
+			}
+			config := SharedChatConfig{ChatId: fork.ID, ConfigData: sourceConfig.ConfigData}
+			if err := tx.Create(&config).Error; err != nil {
+				return err
+			}
+			fork.SharedConfigId = &config.ID
+		}
+
+		var parentID *uint
+		copiedIDs := map[uint]uint{}
+		for _, message := range messages {
+			// Replies keep pointing at the quoted message when it was copied too
+			var replyToID *uint
+			if message.ReplyToId != nil {
+				if id, ok := copiedIDs[*message.ReplyToId]; ok {
+					replyToID = &id
+				}
+			}
+			copied := Message{
+				SenderId:   message.SenderId,
+				ReceiverId: message.ReceiverId,
+				DataType:   message.DataType,
+				ChatId:     fork.ID,
+				ParentId:   parentID,
+				ReplyToId:  replyToID,
+				Text:       message.Text,
+				Reasoning:  message.Reasoning,
+				ToolCalls:  message.ToolCalls,
+				MetaData:   message.MetaData,
+				EditedAt:   message.EditedAt,
+			}
+			if err := tx.Create(&copied).Error; err != nil {
+				return err
+			}
+			parentID = &copied.ID
+			copiedIDs[message.ID] = copied.ID
+		}
+		fork.LatestMessageId = parentID
+		// The participants have seen the copied history in the source chat
+		if parentID != nil {
+			if err := tx.Model(&ChatParticipant{}).Where("chat_id = ?", fork.ID).Updates(map[string]interface{}{
+				"last_delivered_message_id": *parentID,
+				"last_read_message_id":      *parentID,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&fork).Updates(map[string]interface{}{
+			"shared_config_id":  fork.SharedConfigId,
+			"latest_message_id": fork.LatestMessageId,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &fork, nil
+}
+
+// BackfillMessageParentsMigration chains the messages of chats created before
+// message branches in id order.
+type BackfillMessageParentsMigration struct{}
+
+func (BackfillMessageParentsMigration) Migrate(db *gorm.DB) error {
+	if db == nil || !db.Migrator().HasColumn(&Message{}, "parent_id") {
+		return nil
+	}
+	return db.Exec(`UPDATE messages SET parent_id = (
+		SELECT MAX(p.id) FROM messages p WHERE p.chat_id = messages.chat_id AND p.id < messages.id AND p.deleted_at IS NULL
+	) WHERE parent_id IS NULL AND deleted_at IS NULL AND chat_id IN (
+		SELECT chat_id FROM messages GROUP BY chat_id HAVING COUNT(parent_id) = 0
+	)`).Error
+}
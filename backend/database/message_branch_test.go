@@ -0,0 +1,107 @@
+package database
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestMessageBranchesSwitchAndFork(t *testing.T) {
+	DB := SetupDatabase(DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "message_branch.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+
+	alice := User{Name: "alice", Email: "branch-alice@example.invalid", Username: "branch-alice"}
+	bot := User{Name: "bot", Email: "branch-bot@example.invalid", Username: "branch-bot", IsAutomated: true}
+	for _, record := range []*User{&alice, &bot} {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatalf("failed creating user: %v", err)
+		}
+	}
+	chat := Chat{User1Id: alice.ID, User2Id: bot.ID}
+	if err := DB.Create(&chat).Error; err != nil {
+		t.Fatalf("failed creating chat: %v", err)
+	}
+	config := SharedChatConfig{ChatId: chat.ID, ConfigData: json.RawMessage(`{"edit_reply_policy":"replace"}`)}
+	if err := DB.Create(&config).Error; err != nil {
+		t.Fatalf("failed creating config: %v", err)
+	}
+	chat.SharedConfigId = &config.ID
+	if err := DB.Model(&chat).Update("shared_config_id", config.ID).Error; err != nil {
+		t.Fatalf("failed linking config: %v", err)
+	}
+
+	createMessage := func(sender, receiver User, text string) Message {
+		message := Message{ChatId: chat.ID, SenderId: sender.ID, ReceiverId: receiver.ID, Text: &text}
+		if err := DB.Create(&message).Error; err != nil {
+			t.Fatalf("failed creating message: %v", err)
+		}
+		return message
+	}
+	question := createMessage(alice, bot, "question")
+	firstAnswer := createMessage(bot, alice, "first answer")
+	if question.ParentId != nil || firstAnswer.ParentId == nil || *firstAnswer.ParentId != question.ID {
+		t.Fatalf("expected the answer to follow the question, got %v and %v", question.ParentId, firstAnswer.ParentId)
+	}
+	followUp := createMessage(alice, bot, "follow up")
+
+	deactivated, err := DeactivateMessagesFrom(DB, chat.ID, firstAnswer.ID)
+	if err != nil || len(deactivated) != 2 {
+		t.Fatalf("expected two deactivated messages, got %v (%v)", deactivated, err)
+	}
+	secondAnswer := createMessage(bot, alice, "second answer")
+	if secondAnswer.ParentId == nil || *secondAnswer.ParentId != question.ID {
+		t.Fatalf("expected the new answer to be a sibling of the first, got parent %v", secondAnswer.ParentId)
+	}
+
+	counts, err := CountMessageBranches(DB, chat.ID, []Message{question, secondAnswer})
+	if err != nil || counts[secondAnswer.ID] != 2 || counts[question.ID] != 0 {
+		t.Fatalf("unexpected branch counts %v (%v)", counts, err)
+	}
+	branches, err := ListMessageBranches(DB, secondAnswer)
+	if err != nil || len(branches) != 2 || branches[0].ID != firstAnswer.ID || !branches[0].Inactive || branches[1].Inactive {
+		t.Fatalf("unexpected branches %+v (%v)", branches, err)
+	}
+
+	leafID, err := ActivateMessageBranch(DB, chat.ID, firstAnswer.ID)
+	if err != nil || leafID != followUp.ID {
+		t.Fatalf("expected the first branch to continue to the follow up, got %d (%v)", leafID, err)
+	}
+	active := []uint{}
+	if err := DB.Model(&Message{}).Where("chat_id = ? AND inactive = ?", chat.ID, false).Order("id asc").Pluck("id", &active).Error; err != nil {
+		t.Fatalf("failed loading active messages: %v", err)
+	}
+	if len(active) != 3 || active[0] != question.ID || active[1] != firstAnswer.ID || active[2] != followUp.ID {
+		t.Fatalf("unexpected active branch %v", active)
+	}
+	var reloaded Chat
+	if err := DB.First(&reloaded, chat.ID).Error; err != nil || reloaded.LatestMessageId == nil || *reloaded.LatestMessageId != followUp.ID {
+		t.Fatalf("expected the follow up to be the latest message, got %+v (%v)", reloaded.LatestMessageId, err)
+	}
+
+	fork, err := ForkChat(DB, chat, secondAnswer.ID)
+	if err != nil {
+		t.Fatalf("failed forking chat: %v", err)
+	}
+	copied := []Message{}
+	if err := DB.Where("chat_id = ?", fork.ID).Order("id asc").Find(&copied).Error; err != nil {
+		t.Fatalf("failed loading forked messages: %v", err)
+	}
+	if len(copied) != 2 || *copied[0].Text != "question" || *copied[1].Text != "second answer" || copied[1].Inactive {
+		t.Fatalf("unexpected forked messages %+v", copied)
+	}
+	if copied[1].ParentId == nil || *copied[1].ParentId != copied[0].ID || fork.LatestMessageId == nil || *fork.LatestMessageId != copied[1].ID {
+		t.Fatalf("expected the forked messages to form a chain ending at the latest message")
+	}
+	var forkedConfig SharedChatConfig
+	if err := DB.Where("chat_id = ?", fork.ID).First(&forkedConfig).Error; err != nil || EditReplyPolicy(forkedConfig.ConfigData) != EditReplyPolicyReplace {
+		t.Fatalf("expected the shared config to be copied, got %+v (%v)", forkedConfig, err)
+	}
+	participants, err := ListChatParticipants(DB, fork.ID)
+	if err != nil || len(participants) != 2 {
+		t.Fatalf("expected both participants in the fork, got %+v (%v)", participants, err)
+	}
+}
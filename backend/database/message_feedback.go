@@ -0,0 +1,309 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-message-feedback
+// Any participant can react to any message with emojis, one reaction per user
+// and emoji. Answers of bots can also be rated thumbs up (+1) or down (-1)
+// with an optional comment, one rating per user and message. A rating records
+// the bot and the model that produced the answer, read from the "answered_by"
+// meta data of the message, so feedback can be reported per bot and per model
+// config even after a chat switched models.
+
+const (
+	RatingThumbsUp   = 1
+	RatingThumbsDown = -1
+	// MaxReactionLength caps a reaction in bytes, enough for emoji sequences
+	// and short :shortcodes:.
+	MaxReactionLength = 64
+	// MaxRatingCommentLength caps a rating comment in bytes.
+	MaxRatingCommentLength = 4000
+)
+
+var (
+	ErrInvalidReaction    = errors.New("reaction must be a single emoji or :shortcode:")
+	ErrInvalidRating      = errors.New("rating must be 1 or -1")
+	ErrRatingCommentLong  = fmt.Errorf("rating comment is longer than %d bytes", MaxRatingCommentLength)
+	ErrRatingNotBotAnswer = errors.New("only bot answers can be rated")
+)
+
+type MessageReaction struct {
+	Model
+	MessageId uint    `json:"-" gorm:"uniqueIndex:idx_message_reaction"`
+	Message   Message `json:"-" gorm:"foreignKey:MessageId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	UserId    uint    `json:"-" gorm:"uniqueIndex:idx_message_reaction;index"`
+	User      User    `json:"-" gorm:"foreignKey:UserId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Emoji     string  `json:"emoji" gorm:"type:varchar(64);uniqueIndex:idx_message_reaction"`
+}
+
+type MessageRating struct {
+	Model
+	MessageId     uint         `json:"-" gorm:"uniqueIndex:idx_message_rating"`
+	Message       Message      `json:"-" gorm:"foreignKey:MessageId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	UserId        uint         `json:"-" gorm:"uniqueIndex:idx_message_rating;index"`
+	User          User         `json:"-" gorm:"foreignKey:UserId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	BotUserId     uint         `json:"-" gorm:"index"`
+	BotUser       User         `json:"-" gorm:"foreignKey:BotUserId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:NO ACTION;"`
+	ModelConfigId *uint        `json:"-" gorm:"index"`
+	ModelConfig   *ModelConfig `json:"-" gorm:"foreignKey:ModelConfigId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	Backend       string       `json:"backend"`
+	ModelName     string       `json:"model" gorm:"index"`
+	Rating        int          `json:"rating"`
+	Comment       string       `json:"comment"`
+}
+
+// ReactionCount is the number of users that reacted to a message with an emoji.
+type ReactionCount struct {
+	MessageId uint   `json:"-"`
+	Emoji     string `json:"emoji"`
+	Count     int    `json:"count"`
+}
+
+// NormalizeReaction trims a reaction and checks it is a single emoji sequence
+// or a :shortcode: without spaces.
+func NormalizeReaction(emoji string) (string, error) {
+	emoji = strings.TrimSpace(emoji)
+	if emoji == "" || len(emoji) > MaxReactionLength {
+		return "", ErrInvalidReaction
+	}
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return "", ErrInvalidReaction
+		}
+	}
+	return emoji, nil
+}
+
+// AddMessageReaction adds a reaction of a user, added is false when the user
+// already reacted with the emoji.
+func AddMessageReaction(db *gorm.DB, messageID, userID uint, emoji string) (bool, error) {
+	emoji, err := NormalizeReaction(emoji)
+	if err != nil {
+		return false, err
+	}
+	var existing int64
+	if err := db.Model(&MessageReaction{}).
+		Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+		Count(&existing).Error; err != nil {
+		return false, err
+	}
+	if existing > 0 {
+		return false, nil
+	}
+	reaction := MessageReaction{MessageId: messageID, UserId: userID, Emoji: emoji}
+	if err := db.Create(&reaction).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RemoveMessageReaction removes a reaction of a user, removed is false when
+// there was none.
+func RemoveMessageReaction(db *gorm.DB, messageID, userID uint, emoji string) (bool, error) {
+	result := db.Unscoped().
+		Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, strings.TrimSpace(emoji)).
+		Delete(&MessageReaction{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// CountMessageReactions counts the reactions to each of messages per emoji,
+// the emojis ordered by their first use.
+func CountMessageReactions(db *gorm.DB, messages []Message) (map[uint][]ReactionCount, error) {
+	messageIDs := make([]uint, len(messages))
+	for i, message := range messages {
+		messageIDs[i] = message.ID
+	}
+	counts := map[uint][]ReactionCount{}
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+	rows := []ReactionCount{}
+	if err := db.Model(&MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count").
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("MIN(id) asc").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.MessageId] = append(counts[row.MessageId], row)
+	}
+	return counts, nil
+}
+
+// answeredByModel reads the model that produced a bot answer from its meta data.
+func answeredByModel(metaData json.RawMessage) (backend, model, modelConfigUUID string) {
+	meta := struct {
+		AnsweredBy struct {
+			Backend         string `json:"backend"`
+			Model           string `json:"model"`
+			ModelConfigUUID string `json:"model_config_uuid"`
+		} `json:"answered_by"`
+	}{}
+	if len(metaData) == 0 || json.Unmarshal(metaData, &meta) != nil {
+		return "", "", ""
+	}
+	return meta.AnsweredBy.Backend, meta.AnsweredBy.Model, meta.AnsweredBy.ModelConfigUUID
+}
+
+// RateMessage records or replaces the rating of a user for a bot answer. The
+// message's sender must be loaded.
+func RateMessage(db *gorm.DB, message Message, userID uint, rating int, comment string) (*MessageRating, error) {
+	if rating != RatingThumbsUp && rating != RatingThumbsDown {
+		return nil, ErrInvalidRating
+	}
+	comment = strings.TrimSpace(comment)
+	if len(comment) > MaxRatingCommentLength {
+		return nil, ErrRatingCommentLong
+	}
+	if !message.Sender.IsAutomated {
+		return nil, ErrRatingNotBotAnswer
+	}
+
+	backend, modelName, modelConfigUUID := answeredByModel(message.MetaData)
+	var modelConfigID *uint
+	if modelConfigUUID != "" || modelName != "" {
+		modelConfig, err := ResolveModelConfig(db, modelConfigUUID, modelName)
+		if err != nil {
+			return nil, err
+		}
+		if modelConfig != nil {
+			modelConfigID = &modelConfig.ID
+		}
+	}
+
+	var record MessageRating
+	err := db.Where("message_id = ? AND user_id = ?", message.ID, userID).First(&record).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	record.MessageId = message.ID
+	record.UserId = userID
+	record.BotUserId = message.SenderId
+	record.ModelConfigId = modelConfigID
+	record.Backend = backend
+	record.ModelName = modelName
+	record.Rating = rating
+	record.Comment = comment
+	if err := db.Save(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+type FeedbackFilter struct {
+	BotUserId     uint
+	ModelConfigId uint
+	ModelName     string
+	// Rating narrows listed ratings to thumbs up or down, 0 lists both.
+	Rating int
+	From   *time.Time
+	To     *time.Time
+}
+
+type FeedbackSummary struct {
+	Key        string `json:"key" gorm:"column:group_key"`
+	Label      string `json:"label" gorm:"column:group_label"`
+	Ratings    int64  `json:"ratings"`
+	ThumbsUp   int64  `json:"thumbs_up"`
+	ThumbsDown int64  `json:"thumbs_down"`
+	Comments   int64  `json:"comments"`
+	// Score is the share of thumbs up among the ratings.
+	Score float64 `json:"score" gorm:"-"`
+}
+
+func applyFeedbackFilter(query *gorm.DB, filter FeedbackFilter) *gorm.DB {
+	if filter.BotUserId != 0 {
+		query = query.Where("message_ratings.bot_user_id = ?", filter.BotUserId)
+	}
+	if filter.ModelConfigId != 0 {
+		query = query.Where("message_ratings.model_config_id = ?", filter.ModelConfigId)
+	}
+	if filter.ModelName != "" {
+		query = query.Where("message_ratings.model_name = ?", filter.ModelName)
+	}
+	if filter.Rating != 0 {
+		query = query.Where("message_ratings.rating = ?", filter.Rating)
+	}
+	if filter.From != nil {
+		query = query.Where("message_ratings.updated_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("message_ratings.updated_at < ?", *filter.To)
+	}
+	return query
+}
+
+// SummarizeFeedback aggregates the ratings grouped by "bot" (keyed by the bot
+// user's UUID), "model_config" (keyed by the model config UUID, empty for
+// ratings of unknown models) or "model" (the model name). Rows are ordered by
+// key.
+func SummarizeFeedback(db *gorm.DB, filter FeedbackFilter, groupBy string) ([]FeedbackSummary, error) {
+	query := applyFeedbackFilter(db.Model(&MessageRating{}), filter)
+
+	keyColumn, labelColumn := "", ""
+	switch groupBy {
+	case "bot":
+		query = query.Joins("LEFT JOIN users AS rated_bots ON rated_bots.id = message_ratings.bot_user_id")
+		keyColumn, labelColumn = "rated_bots.uuid", "rated_bots.name"
+	case "model_config":
+		query = query.Joins("LEFT JOIN model_configs AS rated_models ON rated_models.id = message_ratings.model_config_id")
+		keyColumn, labelColumn = "COALESCE(rated_models.uuid, '')", "COALESCE(rated_models.title, '')"
+	case "model":
+		keyColumn, labelColumn = "message_ratings.model_name", "message_ratings.model_name"
+	default:
+		return nil, fmt.Errorf("unsupported feedback grouping %q", groupBy)
+	}
+
+	rows := []FeedbackSummary{}
+	err := query.Select(fmt.Sprintf(
+		"%s AS group_key, MAX(%s) AS group_label, COUNT(*) AS ratings, "+
+			"SUM(CASE WHEN message_ratings.rating > 0 THEN 1 ELSE 0 END) AS thumbs_up, "+
+			"SUM(CASE WHEN message_ratings.rating < 0 THEN 1 ELSE 0 END) AS thumbs_down, "+
+			"SUM(CASE WHEN message_ratings.comment <> '' THEN 1 ELSE 0 END) AS comments",
+		keyColumn, labelColumn,
+	)).Group(keyColumn).Order(keyColumn + " asc").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		if rows[i].Ratings > 0 {
+			rows[i].Score = float64(rows[i].ThumbsUp) / float64(rows[i].Ratings)
+		}
+	}
+	return rows, nil
+}
+
+// ListMessageRatings lists single ratings, newest first, with the rated
+// message, its chat and the involved users loaded.
+func ListMessageRatings(db *gorm.DB, filter FeedbackFilter, limit, offset int) ([]MessageRating, int64, error) {
+	var total int64
+	if err := applyFeedbackFilter(db.Model(&MessageRating{}), filter).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	ratings := []MessageRating{}
+	err := applyFeedbackFilter(db.Model(&MessageRating{}), filter).
+		Preload("Message").
+		Preload("Message.Chat").
+		Preload("User").
+		Preload("BotUser").
+		Preload("ModelConfig").
+		Order("message_ratings.updated_at desc, message_ratings.id desc").
+		Limit(limit).
+		Offset(offset).
+		Find(&ratings).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return ratings, total, nil
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestReactionsAndRatingsReportPerBotAndModel(t *testing.T) {
+	DB := SetupDatabase(DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "message_feedback.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+
+	alice := User{Name: "alice", Email: "feedback-alice@example.invalid", Username: "feedback-alice"}
+	bob := User{Name: "bob", Email: "feedback-bob@example.invalid", Username: "feedback-bob"}
+	bot := User{Name: "bot", Email: "feedback-bot@example.invalid", Username: "feedback-bot", IsAutomated: true}
+	for _, record := range []*User{&alice, &bob, &bot} {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatalf("failed creating user: %v", err)
+		}
+	}
+	modelConfig := ModelConfig{Title: "Feedback model", ModelID: "feedback-model"}
+	if err := DB.Create(&modelConfig).Error; err != nil {
+		t.Fatalf("failed creating model config: %v", err)
+	}
+	chat := Chat{User1Id: alice.ID, User2Id: bot.ID}
+	if err := DB.Create(&chat).Error; err != nil {
+		t.Fatalf("failed creating chat: %v", err)
+	}
+
+	createMessage := func(sender User, text string, metaData string) Message {
+		message := Message{ChatId: chat.ID, SenderId: sender.ID, ReceiverId: alice.ID, Text: &text, MetaData: json.RawMessage(metaData)}
+		if err := DB.Create(&message).Error; err != nil {
+			t.Fatalf("failed creating message: %v", err)
+		}
+		message.Sender = sender
+		return message
+	}
+	question := createMessage(alice, "question", `{}`)
+	answer := createMessage(bot, "answer", `{"answered_by":{"backend":"openai","model":"feedback-model","model_config_uuid":"`+modelConfig.UUID+`"}}`)
+	otherAnswer := createMessage(bot, "other answer", `{"answered_by":{"backend":"openai","model":"unknown-model"}}`)
+
+	for _, reaction := range []struct {
+		user  User
+		emoji string
+	}{{alice, "👍"}, {bob, "👍"}, {bob, ":tada:"}} {
+		if added, err := AddMessageReaction(DB, answer.ID, reaction.user.ID, reaction.emoji); err != nil || !added {
+			t.Fatalf("expected the reaction to be added, got %v (%v)", added, err)
+		}
+	}
+	if added, err := AddMessageReaction(DB, answer.ID, alice.ID, " 👍 "); err != nil || added {
+		t.Fatalf("expected a repeated reaction to be ignored, got %v (%v)", added, err)
+	}
+	if _, err := AddMessageReaction(DB, answer.ID, alice.ID, "two words"); !errors.Is(err, ErrInvalidReaction) {
+		t.Fatalf("expected an invalid reaction error, got %v", err)
+	}
+	if removed, err := RemoveMessageReaction(DB, answer.ID, bob.ID, ":tada:"); err != nil || !removed {
+		t.Fatalf("expected the reaction to be removed, got %v (%v)", removed, err)
+	}
+	counts, err := CountMessageReactions(DB, []Message{question, answer})
+	if err != nil || len(counts[answer.ID]) != 1 || counts[answer.ID][0].Emoji != "👍" || counts[answer.ID][0].Count != 2 || len(counts[question.ID]) != 0 {
+		t.Fatalf("unexpected reaction counts %+v (%v)", counts, err)
+	}
+
+	if _, err := RateMessage(DB, question, bob.ID, RatingThumbsUp, ""); !errors.Is(err, ErrRatingNotBotAnswer) {
+		t.Fatalf("expected human messages to be rejected, got %v", err)
+	}
+	if _, err := RateMessage(DB, answer, bob.ID, 5, ""); !errors.Is(err, ErrInvalidRating) {
+		t.Fatalf("expected an invalid rating error, got %v", err)
+	}
+	rating, err := RateMessage(DB, answer, alice.ID, RatingThumbsDown, "")
+	if err != nil || rating.ModelConfigId == nil || *rating.ModelConfigId != modelConfig.ID || rating.BotUserId != bot.ID {
+		t.Fatalf("expected the rating to record the bot and model config, got %+v (%v)", rating, err)
+	}
+	if _, err := RateMessage(DB, answer, alice.ID, RatingThumbsUp, "  helpful  "); err != nil {
+		t.Fatalf("failed rating again: %v", err)
+	}
+	if _, err := RateMessage(DB, answer, bob.ID, RatingThumbsUp, ""); err != nil {
+		t.Fatalf("failed rating: %v", err)
+	}
+	if _, err := RateMessage(DB, otherAnswer, bob.ID, RatingThumbsDown, "wrong"); err != nil {
+		t.Fatalf("failed rating: %v", err)
+	}
+
+	byBot, err := SummarizeFeedback(DB, FeedbackFilter{}, "bot")
+	if err != nil || len(byBot) != 1 || byBot[0].Key != bot.UUID || byBot[0].Ratings != 3 || byBot[0].ThumbsUp != 2 || byBot[0].Comments != 2 {
+		t.Fatalf("unexpected feedback per bot %+v (%v)", byBot, err)
+	}
+	byModel, err := SummarizeFeedback(DB, FeedbackFilter{}, "model_config")
+	if err != nil || len(byModel) != 2 || byModel[1].Key != modelConfig.UUID || byModel[1].Label != "Feedback model" || byModel[1].Score != 1 {
+		t.Fatalf("unexpected feedback per model config %+v (%v)", byModel, err)
+	}
+
+	ratings, total, err := ListMessageRatings(DB, FeedbackFilter{Rating: RatingThumbsDown}, 10, 0)
+	if err != nil || total != 1 || len(ratings) != 1 || ratings[0].Comment != "wrong" || ratings[0].Message.Chat.UUID != chat.UUID {
+		t.Fatalf("unexpected thumbs down ratings %+v (%d, %v)", ratings, total, err)
+	}
+}
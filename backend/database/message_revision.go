@@ -0,0 +1,104 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Keys and values of the shared chat config deciding what a bot does when
+// the last user message before its answer is edited. Bots keep their answer
+// unless the policy is "replace", which removes the answer and triggers a new
+// reply to the edited message.
+const (
+	ChatConfigEditReplyPolicyKey = "edit_reply_policy"
+	EditReplyPolicyKeep          = "keep"
+	EditReplyPolicyReplace       = "replace"
+)
+
+var ErrMessageTextUnchanged = errors.New("message text is unchanged")
+
+// @doc:open-chat-message-revisions
+// MessageRevision is one version of an edited message's text. Revisions are
+// append-only: the first edit records the original text as revision 1 and
+// every edit appends the new text, so the latest revision always matches the
+// message.
+type MessageRevision struct {
+	Model
+	MessageId uint    `json:"-" gorm:"index;uniqueIndex:idx_message_revision"`
+	Message   Message `json:"-" gorm:"foreignKey:MessageId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Revision  int     `json:"revision" gorm:"uniqueIndex:idx_message_revision"`
+	EditorId  uint    `json:"-" gorm:"index"`
+	Editor    User    `json:"-" gorm:"foreignKey:EditorId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:NO ACTION;"`
+	Text      string  `json:"text" gorm:"type:text"`
+}
+
+// EditReplyPolicy reads the edit reply policy of a shared chat config.
+func EditReplyPolicy(configData json.RawMessage) string {
+	config := map[string]interface{}{}
+	if len(configData) > 0 {
+		_ = json.Unmarshal(configData, &config)
+	}
+	if policy, _ := config[ChatConfigEditReplyPolicyKey].(string); strings.EqualFold(strings.TrimSpace(policy), EditReplyPolicyReplace) {
+		return EditReplyPolicyReplace
+	}
+	return EditReplyPolicyKeep
+}
+
+// EditMessage replaces the text of a message and appends the revision.
+func EditMessage(db *gorm.DB, message *Message, editorID uint, text string, now time.Time) (*MessageRevision, error) {
+	previous := ""
+	if message.Text != nil {
+		previous = *message.Text
+	}
+	if previous == text {
+		return nil, ErrMessageTextUnchanged
+	}
+
+	var revision MessageRevision
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		if err := tx.Model(&MessageRevision{}).
+			Where("message_id = ?", message.ID).
+			Select("COALESCE(MAX(revision), 0)").
+			Scan(&latest).Error; err != nil {
+			return err
+		}
+		if latest == 0 {
+			original := MessageRevision{MessageId: message.ID, Revision: 1, EditorId: message.SenderId, Text: previous}
+			original.CreatedAt = message.CreatedAt
+			if err := tx.Create(&original).Error; err != nil {
+				return err
+			}
+			latest = 1
+		}
+
+		revision = MessageRevision{MessageId: message.ID, Revision: latest + 1, EditorId: editorID, Text: text}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+		return tx.Model(message).Updates(map[string]interface{}{
+			"text":      text,
+			"edited_at": now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	message.Text = &text
+	message.EditedAt = &now
+	return &revision, nil
+}
+
+// ListMessageRevisions lists the revisions of a message, oldest first. Messages
+// that were never edited have none.
+func ListMessageRevisions(db *gorm.DB, messageID uint) ([]MessageRevision, error) {
+	revisions := []MessageRevision{}
+	if err := db.Preload("Editor").Where("message_id = ?", messageID).Order("revision asc").Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
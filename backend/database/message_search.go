@@ -0,0 +1,351 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-message-search
+// Message search runs on the full-text index of the database backend. SQLite
+// keeps an FTS5 table (message_search) in sync with messages.text through
+// triggers, PostgreSQL a generated tsvector column with a GIN index. SQLite
+// builds without FTS5 (e.g. the android driver) fall back to LIKE matching.
+// Results only include messages the searching user can read in the chat
+// handlers: messages of chats the user participates in, and for two-party
+// chats only the ones sent to or by the user.
+const (
+	messageSearchTable          = "message_search"
+	messageSearchInsertTrigger  = "messages_search_ai"
+	messageSearchDeleteTrigger  = "messages_search_ad"
+	messageSearchUpdateTrigger  = "messages_search_au"
+	messageSearchVectorColumn   = "search_vector"
+	messageSearchPostgresConfig = "simple"
+
+	// Snippets are built with private use markers that cannot be part of
+	// the escaped text and replaced by <mark> tags after escaping.
+	messageSearchMarkStart = "\uE000"
+	messageSearchMarkEnd   = "\uE001"
+	messageSearchEllipsis  = "…"
+
+	messageSearchSnippetTokens = 16
+	messageSearchSnippetRunes  = 160
+)
+
+var ErrEmptySearchQuery = errors.New("search query is empty")
+
+// MessageSearchFilter narrows a message search. UserId is the searching user
+// and is required, all other fields are optional.
+type MessageSearchFilter struct {
+	Query         string
+	UserId        uint
+	ChatId        uint
+	SenderId      uint
+	BotUserId     uint
+	From          *time.Time
+	To            *time.Time
+	HasAttachment bool
+	Limit         int
+	Offset        int
+}
+
+type MessageSearchHit struct {
+	MessageUUID       string    `json:"message_uuid"`
+	ChatUUID          string    `json:"chat_uuid"`
+	ChatType          string    `json:"chat_type"`
+	ChatTitle         string    `json:"chat_title"`
+	SenderUUID        string    `json:"sender_uuid"`
+	SenderName        string    `json:"sender_name"`
+	SenderIsAutomated bool      `json:"sender_is_automated"`
+	DataType          string    `json:"data_type"`
+	Snippet           string    `json:"snippet"`
+	SendAt            time.Time `json:"send_at" gorm:"column:created_at"`
+	Score             float64   `json:"score"`
+	Text              string    `json:"-"`
+}
+
+// MessageSearchMigration creates the full-text index of the messages table.
+// On SQLite the index is rebuilt whenever its triggers are missing, which is
+// the case on first run and after the messages table was dropped.
+type MessageSearchMigration struct{}
+
+func (MessageSearchMigration) Migrate(db *gorm.DB) error {
+	if db == nil || !db.Migrator().HasTable("messages") {
+		return nil
+	}
+	switch db.Dialector.Name() {
+	case "sqlite":
+		if err := migrateSQLiteMessageSearch(db); err != nil {
+			// FTS5 is a compile time option, search falls back to LIKE without it.
+			log.Printf("Full-text message search is unavailable, falling back to LIKE matching: %v", err)
+		}
+		return nil
+	case "postgres":
+		return migratePostgresMessageSearch(db)
+	default:
+		return nil
+	}
+}
+
+func migrateSQLiteMessageSearch(db *gorm.DB) error {
+	var triggers int64
+	if err := db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?", messageSearchInsertTrigger).Scan(&triggers).Error; err != nil {
+		return err
+	}
+	if triggers > 0 && db.Migrator().HasTable(messageSearchTable) {
+		return nil
+	}
+
+	statements := []string{
+		"DROP TABLE IF EXISTS " + messageSearchTable,
+		"CREATE VIRTUAL TABLE " + messageSearchTable + " USING fts5(text, content='messages', content_rowid='id', tokenize='unicode61 remove_diacritics 2')",
+		"CREATE TRIGGER IF NOT EXISTS " + messageSearchInsertTrigger + " AFTER INSERT ON messages BEGIN " +
+			"INSERT INTO " + messageSearchTable + "(rowid, text) VALUES (new.id, new.text); END",
+		"CREATE TRIGGER IF NOT EXISTS " + messageSearchDeleteTrigger + " AFTER DELETE ON messages BEGIN " +
+			"INSERT INTO " + messageSearchTable + "(" + messageSearchTable + ", rowid, text) VALUES ('delete', old.id, old.text); END",
+		"CREATE TRIGGER IF NOT EXISTS " + messageSearchUpdateTrigger + " AFTER UPDATE OF text ON messages BEGIN " +
+			"INSERT INTO " + messageSearchTable + "(" + messageSearchTable + ", rowid, text) VALUES ('delete', old.id, old.text); " +
+			"INSERT INTO " + messageSearchTable + "(rowid, text) VALUES (new.id, new.text); END",
+		"INSERT INTO " + messageSearchTable + "(" + messageSearchTable + ") VALUES ('rebuild')",
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func migratePostgresMessageSearch(db *gorm.DB) error {
+	statements := []string{
+		fmt.Sprintf("ALTER TABLE messages ADD COLUMN IF NOT EXISTS %s tsvector GENERATED ALWAYS AS (to_tsvector('%s', coalesce(text, ''))) STORED",
+			messageSearchVectorColumn, messageSearchPostgresConfig),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_messages_%s ON messages USING GIN (%s)", messageSearchVectorColumn, messageSearchVectorColumn),
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchTerms splits a query into its words, quotes are stripped so user
+// input never reaches the FTS5 query syntax.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return r == '"' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
+
+// sqliteMatchQuery builds an FTS5 query matching every term as a prefix.
+func sqliteMatchQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// EscapeLikePattern escapes the LIKE wildcards of term for use with ESCAPE '\'.
+func EscapeLikePattern(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+}
+
+// SearchMessages runs a full-text search over the messages the user can read,
+// best matches first and newer messages first among equal matches. It returns
+// one page of hits and the total number of matches.
+func SearchMessages(db *gorm.DB, filter MessageSearchFilter) ([]MessageSearchHit, int64, error) {
+	terms := searchTerms(filter.Query)
+	if len(terms) == 0 {
+		return nil, 0, ErrEmptySearchQuery
+	}
+	if filter.UserId == 0 {
+		return nil, 0, fmt.Errorf("search requires a user")
+	}
+
+	dialect := db.Dialector.Name()
+	query := db.Table("messages AS m").
+		Joins("JOIN chats AS c ON c.id = m.chat_id AND c.deleted_at IS NULL").
+		Joins("JOIN users AS s ON s.id = m.sender_id").
+		Where("m.deleted_at IS NULL AND m.inactive = ? AND m.data_type <> ?", false, "event").
+		Where("m.chat_id IN (SELECT chat_id FROM chat_participants WHERE user_id = ? AND deleted_at IS NULL)", filter.UserId).
+		Where("c.chat_type = ? OR m.sender_id = ? OR m.receiver_id = ?", ChatTypeGroup, filter.UserId, filter.UserId)
+
+	selectColumns := "m.uuid AS message_uuid, c.uuid AS chat_uuid, c.chat_type, c.title AS chat_title, " +
+		"s.uuid AS sender_uuid, s.name AS sender_name, s.is_automated AS sender_is_automated, " +
+		"m.data_type, m.created_at, m.text"
+	var selectArgs []interface{}
+	order := "m.created_at desc, m.id desc"
+
+	fullText := false
+	switch dialect {
+	case "sqlite":
+		fullText = db.Migrator().HasTable(messageSearchTable)
+		if fullText {
+			query = query.Joins("JOIN "+messageSearchTable+" ON "+messageSearchTable+".rowid = m.id").
+				Where(messageSearchTable+" MATCH ?", sqliteMatchQuery(terms))
+			selectColumns += fmt.Sprintf(", snippet(%s, 0, ?, ?, ?, %d) AS snippet, -bm25(%s) AS score",
+				messageSearchTable, messageSearchSnippetTokens, messageSearchTable)
+			selectArgs = append(selectArgs, messageSearchMarkStart, messageSearchMarkEnd, messageSearchEllipsis)
+			order = "score desc, " + order
+		}
+	case "postgres":
+		fullText = true
+		tsQuery := fmt.Sprintf("websearch_to_tsquery('%s', ?)", messageSearchPostgresConfig)
+		query = query.Where("m."+messageSearchVectorColumn+" @@ "+tsQuery, filter.Query)
+		selectColumns += fmt.Sprintf(", ts_headline('%s', coalesce(m.text, ''), %s, ?) AS snippet, ts_rank(m.%s, %s) AS score",
+			messageSearchPostgresConfig, tsQuery, messageSearchVectorColumn, tsQuery)
+		selectArgs = append(selectArgs,
+			filter.Query,
+			fmt.Sprintf("StartSel=%s, StopSel=%s, FragmentDelimiter=\" %s \", MaxFragments=2, MaxWords=%d, MinWords=%d",
+				messageSearchMarkStart, messageSearchMarkEnd, messageSearchEllipsis, messageSearchSnippetTokens+8, messageSearchSnippetTokens/2),
+			filter.Query)
+		order = "score desc, " + order
+	}
+	if !fullText {
+		for _, term := range terms {
+			query = query.Where(`LOWER(m.text) LIKE ? ESCAPE '\'`, "%"+EscapeLikePattern(strings.ToLower(term))+"%")
+		}
+	}
+
+	if filter.ChatId != 0 {
+		query = query.Where("m.chat_id = ?", filter.ChatId)
+	}
+	if filter.SenderId != 0 {
+		query = query.Where("m.sender_id = ?", filter.SenderId)
+	}
+	if filter.BotUserId != 0 {
+		query = query.Where("m.chat_id IN (SELECT chat_id FROM chat_participants WHERE user_id = ? AND deleted_at IS NULL)", filter.BotUserId)
+	}
+	if filter.From != nil {
+		query = query.Where("m.created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("m.created_at < ?", *filter.To)
+	}
+	if filter.HasAttachment {
+		if dialect == "postgres" {
+			query = query.Where("(CASE WHEN jsonb_typeof(m.meta_data->'attachments') = 'array' THEN jsonb_array_length(m.meta_data->'attachments') ELSE 0 END) > 0")
+		} else {
+			query = query.Where("(CASE WHEN json_valid(CAST(m.meta_data AS TEXT)) THEN coalesce(json_array_length(CAST(m.meta_data AS TEXT), '$.attachments'), 0) ELSE 0 END) > 0")
+		}
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	hits := []MessageSearchHit{}
+	if err := query.Select(selectColumns, selectArgs...).
+		Order(order).
+		Limit(limit).
+		Offset(filter.Offset).
+		Scan(&hits).Error; err != nil {
+		return nil, 0, err
+	}
+
+	for i := range hits {
+		if !fullText {
+			hits[i].Snippet = likeSnippet(hits[i].Text, terms)
+		}
+		hits[i].Snippet = renderSearchSnippet(hits[i].Snippet)
+	}
+	return hits, total, nil
+}
+
+// renderSearchSnippet escapes a marked snippet for HTML and turns the
+// markers into <mark> tags.
+func renderSearchSnippet(snippet string) string {
+	return strings.NewReplacer(messageSearchMarkStart, "<mark>", messageSearchMarkEnd, "</mark>").Replace(html.EscapeString(snippet))
+}
+
+// likeSnippet cuts a window around the first matched term out of text and
+// marks all terms in it, the LIKE fallback's counterpart to FTS snippets.
+func likeSnippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// Lowercasing changed the length, match on the text as is.
+		lower = runes
+	}
+
+	first := -1
+	for _, term := range terms {
+		if at := runeIndex(lower, []rune(strings.ToLower(term))); at >= 0 && (first < 0 || at < first) {
+			first = at
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+	start := first - messageSearchSnippetRunes/4
+	if start < 0 {
+		start = 0
+	}
+	end := start + messageSearchSnippetRunes
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var snippet strings.Builder
+	if start > 0 {
+		snippet.WriteString(messageSearchEllipsis)
+	}
+	for i := start; i < end; {
+		matched := 0
+		for _, term := range terms {
+			termRunes := []rune(strings.ToLower(term))
+			if len(termRunes) > matched && i+len(termRunes) <= end && runesEqual(lower[i:i+len(termRunes)], termRunes) {
+				matched = len(termRunes)
+			}
+		}
+		if matched > 0 {
+			snippet.WriteString(messageSearchMarkStart + string(runes[i:i+matched]) + messageSearchMarkEnd)
+			i += matched
+			continue
+		}
+		snippet.WriteRune(runes[i])
+		i++
+	}
+	if end < len(runes) {
+		snippet.WriteString(messageSearchEllipsis)
+	}
+	return snippet.String()
+}
+
+func runeIndex(haystack, needle []rune) int {
+	if len(needle) == 0 {
+		return -1
+	}
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if runesEqual(haystack[i:i+len(needle)], needle) {
+			return i
+		}
+	}
+	return -1
+}
+
+func runesEqual(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchMessagesRespectsParticipantsAndFilters(t *testing.T) {
+	DB := SetupDatabase(DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "message_search.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+	if !DB.Migrator().HasTable(messageSearchTable) {
+		t.Fatalf("expected the FTS5 index to be created")
+	}
+
+	alice := User{Name: "alice", Email: "alice@example.invalid", Username: "alice"}
+	bob := User{Name: "bob", Email: "bob@example.invalid", Username: "bob"}
+	carol := User{Name: "carol", Email: "carol@example.invalid", Username: "carol"}
+	bot := User{Name: "bot", Email: "search-bot@example.invalid", Username: "search-bot", IsAutomated: true}
+	for _, record := range []*User{&alice, &bob, &carol, &bot} {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatalf("failed creating user: %v", err)
+		}
+	}
+
+	botChat := Chat{User1Id: alice.ID, User2Id: bot.ID}
+	privateChat := Chat{User1Id: bob.ID, User2Id: carol.ID}
+	for _, record := range []*Chat{&botChat, &privateChat} {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatalf("failed creating chat: %v", err)
+		}
+	}
+
+	createMessage := func(chat Chat, sender, receiver User, text string, metaData string, createdAt time.Time) Message {
+		message := Message{ChatId: chat.ID, SenderId: sender.ID, ReceiverId: receiver.ID, Text: &text}
+		if metaData != "" {
+			message.MetaData = json.RawMessage(metaData)
+		}
+		message.CreatedAt = createdAt
+		if err := DB.Create(&message).Error; err != nil {
+			t.Fatalf("failed creating message: %v", err)
+		}
+		return message
+	}
+
+	day := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	question := createMessage(botChat, alice, bot, "How do I configure the <b>postgres</b> backup?", "", day)
+	answer := createMessage(botChat, bot, alice, "Postgres backups run nightly, see the attached guide.", `{"attachments":[{"file_id":"f1"}]}`, day.Add(time.Hour))
+	createMessage(privateChat, bob, carol, "Secret postgres credentials", "", day)
+
+	hits, total, err := SearchMessages(DB, MessageSearchFilter{Query: "postgre", UserId: alice.ID})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if total != 2 || len(hits) != 2 {
+		t.Fatalf("expected alice to find her 2 messages only, got %d (%+v)", total, hits)
+	}
+	for _, hit := range hits {
+		if hit.ChatUUID != botChat.UUID {
+			t.Fatalf("found a message of a chat alice is not part of: %+v", hit)
+		}
+	}
+
+	hits, _, err = SearchMessages(DB, MessageSearchFilter{Query: "configure postgres", UserId: alice.ID})
+	if err != nil || len(hits) != 1 || hits[0].MessageUUID != question.UUID {
+		t.Fatalf("expected all terms to match the question, got %+v (%v)", hits, err)
+	}
+	if !strings.Contains(hits[0].Snippet, "<mark>configure</mark>") || !strings.Contains(hits[0].Snippet, "&lt;b&gt;") {
+		t.Fatalf("expected an escaped snippet with highlights, got %q", hits[0].Snippet)
+	}
+
+	hits, _, err = SearchMessages(DB, MessageSearchFilter{Query: "postgres", UserId: alice.ID, HasAttachment: true})
+	if err != nil || len(hits) != 1 || hits[0].MessageUUID != answer.UUID {
+		t.Fatalf("expected the attachment filter to keep the answer, got %+v (%v)", hits, err)
+	}
+
+	hits, _, err = SearchMessages(DB, MessageSearchFilter{Query: "postgres", UserId: alice.ID, SenderId: alice.ID})
+	if err != nil || len(hits) != 1 || hits[0].MessageUUID != question.UUID {
+		t.Fatalf("expected the sender filter to keep the question, got %+v (%v)", hits, err)
+	}
+
+	from := day.Add(30 * time.Minute)
+	hits, _, err = SearchMessages(DB, MessageSearchFilter{Query: "postgres", UserId: alice.ID, From: &from})
+	if err != nil || len(hits) != 1 || hits[0].MessageUUID != answer.UUID {
+		t.Fatalf("expected the date filter to keep the answer, got %+v (%v)", hits, err)
+	}
+
+	hits, _, err = SearchMessages(DB, MessageSearchFilter{Query: "postgres", UserId: bob.ID, BotUserId: bot.ID})
+	if err != nil || len(hits) != 0 {
+		t.Fatalf("expected no bot chat results for bob, got %+v (%v)", hits, err)
+	}
+
+	updated := "The restore drill is on friday"
+	if err := DB.Model(&question).Update("text", updated).Error; err != nil {
+		t.Fatalf("failed updating message: %v", err)
+	}
+	hits, _, err = SearchMessages(DB, MessageSearchFilter{Query: "drill", UserId: alice.ID})
+	if err != nil || len(hits) != 1 {
+		t.Fatalf("expected the index to follow edits, got %+v (%v)", hits, err)
+	}
+	if err := DB.Delete(&question).Error; err != nil {
+		t.Fatalf("failed deleting message: %v", err)
+	}
+	hits, _, err = SearchMessages(DB, MessageSearchFilter{Query: "drill", UserId: alice.ID})
+	if err != nil || len(hits) != 0 {
+		t.Fatalf("expected deleted messages to be hidden, got %+v (%v)", hits, err)
+	}
+
+	if _, _, err := SearchMessages(DB, MessageSearchFilter{Query: ` "" `, UserId: alice.ID}); err != ErrEmptySearchQuery {
+		t.Fatalf("expected an empty query error, got %v", err)
+	}
+}
+
+func TestLikeSnippetMarksTerms(t *testing.T) {
+	snippet := renderSearchSnippet(likeSnippet("Nightly Postgres backups & restores", []string{"postgres", "restore"}))
+	if snippet != "Nightly <mark>Postgres</mark> backups &amp; <mark>restore</mark>s" {
+		t.Fatalf("unexpected snippet %q", snippet)
+	}
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-message-threads
+// A message can reply to an earlier message of the same chat through
+// ReplyToId. The direct replies to a message are its thread. The quoted
+// message is also stored in the reply's meta data under "reply_to", so clients
+// and bots can render the quote without loading the original message, and the
+// quote stays as it was when the reply was sent.
+
+const (
+	// ReplyToMetaKey is the meta data key holding the quoted message.
+	ReplyToMetaKey = "reply_to"
+	// MaxQuotedTextLength caps the quoted text stored with a reply in runes.
+	MaxQuotedTextLength = 2000
+)
+
+var ErrReplyToNotFound = errors.New("replied to message not found in chat")
+
+// FindReplyTarget loads the message a new message of the chat replies to. Only
+// messages of the active branch can be replied to.
+func FindReplyTarget(db *gorm.DB, chatID uint, messageUUID string) (*Message, error) {
+	var target Message
+	err := db.Preload("Sender").
+		Where("chat_id = ? AND uuid = ? AND inactive = ?", chatID, messageUUID, false).
+		First(&target).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrReplyToNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// QuoteMessage returns the meta data stored with a reply to message. The
+// message's sender must be loaded.
+func QuoteMessage(message Message) map[string]interface{} {
+	text := ""
+	if message.Text != nil {
+		text = *message.Text
+	}
+	if utf8.RuneCountInString(text) > MaxQuotedTextLength {
+		text = string([]rune(text)[:MaxQuotedTextLength]) + "…"
+	}
+	return map[string]interface{}{
+		"message_uuid":        message.UUID,
+		"sender_uuid":         message.Sender.UUID,
+		"sender_is_automated": message.Sender.IsAutomated,
+		"text":                text,
+	}
+}
+
+// ReplyToUUIDs returns the UUIDs of the messages replied to by messages.
+func ReplyToUUIDs(db *gorm.DB, messages []Message) (map[uint]string, error) {
+	targetIDs := []uint{}
+	for _, message := range messages {
+		if message.ReplyToId != nil {
+			targetIDs = append(targetIDs, *message.ReplyToId)
+		}
+	}
+	uuids := map[uint]string{}
+	if len(targetIDs) == 0 {
+		return uuids, nil
+	}
+	targets := []Message{}
+	if err := db.Unscoped().Select("id", "uuid").Where("id IN ?", targetIDs).Find(&targets).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]string, len(targets))
+	for _, target := range targets {
+		byID[target.ID] = target.UUID
+	}
+	for _, message := range messages {
+		if message.ReplyToId != nil {
+			uuids[message.ID] = byID[*message.ReplyToId]
+		}
+	}
+	return uuids, nil
+}
+
+// CountMessageReplies counts the replies on the active branch to each of
+// messages.
+func CountMessageReplies(db *gorm.DB, messages []Message) (map[uint]int, error) {
+	messageIDs := make([]uint, len(messages))
+	for i, message := range messages {
+		messageIDs[i] = message.ID
+	}
+	counts := map[uint]int{}
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+	rows := []struct {
+		ReplyToId uint
+		Replies   int
+	}{}
+	if err := db.Model(&Message{}).
+		Select("reply_to_id, COUNT(*) AS replies").
+		Where("reply_to_id IN ? AND inactive = ?", messageIDs, false).
+		Group("reply_to_id").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.ReplyToId] = row.Replies
+	}
+	return counts, nil
+}
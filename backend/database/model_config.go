@@ -6,12 +6,14 @@ import (
 	"database/sql/driver"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"slices"
 	"strings"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -93,6 +95,116 @@ func (m ModelConfig) AssignedToBot(username string) bool {
 	return slices.Contains(m.BotUsernames, username)
 }
 
+// ConfigurationMap decodes the model configuration, invalid JSON yields an empty map.
+func (m ModelConfig) ConfigurationMap() map[string]interface{} {
+	cfg := map[string]interface{}{}
+	if len(bytes.TrimSpace(m.Configuration)) == 0 {
+		return cfg
+	}
+	if err := json.Unmarshal(m.Configuration, &cfg); err != nil {
+		return map[string]interface{}{}
+	}
+	return cfg
+}
+
+// ResolveModelConfig returns the model config with the given UUID or else
+// the newest model config of the model id, nil when there is none.
+func ResolveModelConfig(db *gorm.DB, modelConfigUUID, modelID string) (*ModelConfig, error) {
+	var modelConfig ModelConfig
+	var err error
+	if _, parseErr := uuid.Parse(modelConfigUUID); parseErr == nil {
+		err = db.Where("uuid = ?", modelConfigUUID).First(&modelConfig).Error
+	} else if modelID != "" {
+		err = db.Where("model_id = ?", modelID).Order("id desc").First(&modelConfig).Error
+	} else {
+		return nil, nil
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &modelConfig, nil
+}
+
+// ResolveModelContextLength returns the "context_length" in tokens of the
+// model config resolved by ResolveModelConfig, 0 when it is unknown.
+func ResolveModelContextLength(db *gorm.DB, modelConfigUUID, modelID string) (int, error) {
+	return resolveModelConfigInt(db, modelConfigUUID, modelID, "context_length")
+}
+
+// ResolveModelMaxImageDimension returns the "max_image_dimension" in pixels,
+// the longest image side the model accepts, of the model config resolved by
+// ResolveModelConfig, 0 when it is unknown.
+func ResolveModelMaxImageDimension(db *gorm.DB, modelConfigUUID, modelID string) (int, error) {
+	return resolveModelConfigInt(db, modelConfigUUID, modelID, "max_image_dimension")
+}
+
+func resolveModelConfigInt(db *gorm.DB, modelConfigUUID, modelID, key string) (int, error) {
+	modelConfig, err := ResolveModelConfig(db, modelConfigUUID, modelID)
+	if err != nil || modelConfig == nil {
+		return 0, err
+	}
+	value, _ := modelConfig.ConfigurationMap()[key].(float64)
+	if value < 0 {
+		return 0, nil
+	}
+	return int(value), nil
+}
+
+// ResolveFallbackModelConfigs returns the ordered fallback chain of a model.
+// When configured is empty the chain is read from "fallback_models" in the
+// configuration of the model config for primaryModelID. Entries may be model
+// config UUIDs or model ids, unknown entries and the primary model are skipped.
+func ResolveFallbackModelConfigs(db *gorm.DB, primaryModelID string, configured []string) ([]ModelConfig, error) {
+	if len(configured) == 0 {
+		var primary ModelConfig
+		err := db.Where("model_id = ?", primaryModelID).Order("id desc").Limit(1).Find(&primary).Error
+		if err != nil {
+			return nil, err
+		}
+		if primary.ID == 0 {
+			return nil, nil
+		}
+		rawFallbacks, _ := primary.ConfigurationMap()["fallback_models"].([]interface{})
+		for _, rawFallback := range rawFallbacks {
+			if fallback, ok := rawFallback.(string); ok {
+				configured = append(configured, fallback)
+			}
+		}
+	}
+
+	fallbacks := []ModelConfig{}
+	seen := map[uint]bool{}
+	for _, entry := range configured {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || entry == primaryModelID {
+			continue
+		}
+		query := db.Where("model_id = ?", entry)
+		// Postgres rejects comparing the uuid column with arbitrary model ids.
+		if _, parseErr := uuid.Parse(entry); parseErr == nil {
+			query = db.Where("uuid = ?", entry)
+		}
+		var cfg ModelConfig
+		err := query.Order("id desc").Limit(1).Find(&cfg).Error
+		if err != nil {
+			return nil, err
+		}
+		if cfg.ID == 0 {
+			log.Printf("Fallback model %q not found, skipping", entry)
+			continue
+		}
+		if seen[cfg.ID] || cfg.ModelID == primaryModelID {
+			continue
+		}
+		seen[cfg.ID] = true
+		fallbacks = append(fallbacks, cfg)
+	}
+	return fallbacks, nil
+}
+
 type modelConfigFileEntry struct {
 	Title         string          `json:"title"`
 	Description   string          `json:"description"`
@@ -177,11 +289,23 @@ func managedProviderAPIKeyEnv(backend string) (string, bool) {
 		return "GROQ_API_KEY", true
 	case "litellm":
 		return "LITELLM_API_KEY", true
+	case "gemini":
+		return "GEMINI_API_KEY", true
 	default:
 		return "", false
 	}
 }
 
+// ProviderAPIKey returns the configured API key of a managed provider backend,
+// or an empty string for unknown backends.
+func ProviderAPIKey(backend string) string {
+	apiKeyEnv, managed := managedProviderAPIKeyEnv(backend)
+	if !managed {
+		return ""
+	}
+	return runtimeConfigValue(apiKeyEnv)
+}
+
 func modelConfigBackend(raw json.RawMessage) (string, error) {
 	if len(bytes.TrimSpace(raw)) == 0 {
 		return "", nil
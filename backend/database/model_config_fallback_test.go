@@ -0,0 +1,40 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveFallbackModelConfigs(t *testing.T) {
+	DB := SetupDatabase(DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "model_fallbacks.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+
+	createDefaultModelForTest(t, DB, "gemini-2.5-flash", "gemini", nil, "")
+	createDefaultModelForTest(t, DB, "llama3.2", "ollama", nil, "")
+	var ollamaModel ModelConfig
+	if err := DB.Where("model_id = ?", "llama3.2").First(&ollamaModel).Error; err != nil {
+		t.Fatalf("failed loading model config: %v", err)
+	}
+	createDefaultModelForTest(t, DB, "claude-sonnet-4-5", "anthropic", nil,
+		`{"model":"claude-sonnet-4-5","backend":"anthropic","fallback_models":["gemini-2.5-flash","missing-model","claude-sonnet-4-5","`+ollamaModel.UUID+`"]}`)
+
+	fallbacks, err := ResolveFallbackModelConfigs(DB, "claude-sonnet-4-5", nil)
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if len(fallbacks) != 2 || fallbacks[0].ModelID != "gemini-2.5-flash" || fallbacks[1].UUID != ollamaModel.UUID {
+		t.Fatalf("expected chain from model config by id and uuid, got %+v", fallbacks)
+	}
+
+	fallbacks, err = ResolveFallbackModelConfigs(DB, "claude-sonnet-4-5", []string{"llama3.2"})
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if len(fallbacks) != 1 || fallbacks[0].ModelID != "llama3.2" {
+		t.Fatalf("expected the configured chain to take precedence, got %+v", fallbacks)
+	}
+}
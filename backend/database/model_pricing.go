@@ -0,0 +1,78 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Pricing keys of a model configuration. Prices are per token in the
+// currency used for billing, usually USD.
+const (
+	ModelConfigInputCostKey       = "input_cost_per_token"
+	ModelConfigOutputCostKey      = "output_cost_per_token"
+	ModelConfigCachedInputCostKey = "cached_input_cost_per_token"
+)
+
+// ModelPricing holds the per-token prices of a model. Cached input tokens
+// are billed at the input price when no cached input price is set.
+type ModelPricing struct {
+	InputCostPerToken       float64 `json:"input_cost_per_token"`
+	OutputCostPerToken      float64 `json:"output_cost_per_token"`
+	CachedInputCostPerToken float64 `json:"cached_input_cost_per_token,omitempty"`
+}
+
+// IsZero reports whether no price is set.
+func (p ModelPricing) IsZero() bool {
+	return p.InputCostPerToken == 0 && p.OutputCostPerToken == 0 && p.CachedInputCostPerToken == 0
+}
+
+// Cost prices a completion. cachedPromptTokens is the part of promptTokens
+// that was read from the provider's prompt cache.
+func (p ModelPricing) Cost(promptTokens, cachedPromptTokens, completionTokens int64) float64 {
+	if cachedPromptTokens > promptTokens {
+		cachedPromptTokens = promptTokens
+	}
+	cachedPrice := p.CachedInputCostPerToken
+	if cachedPrice == 0 {
+		cachedPrice = p.InputCostPerToken
+	}
+	return float64(promptTokens-cachedPromptTokens)*p.InputCostPerToken +
+		float64(cachedPromptTokens)*cachedPrice +
+		float64(completionTokens)*p.OutputCostPerToken
+}
+
+// ModelPricingFromConfig reads the pricing keys of a model configuration.
+func ModelPricingFromConfig(cfg map[string]interface{}) (ModelPricing, error) {
+	pricing := ModelPricing{}
+	prices := []struct {
+		key    string
+		target *float64
+	}{
+		{ModelConfigInputCostKey, &pricing.InputCostPerToken},
+		{ModelConfigOutputCostKey, &pricing.OutputCostPerToken},
+		{ModelConfigCachedInputCostKey, &pricing.CachedInputCostPerToken},
+	}
+	for _, price := range prices {
+		raw, exists := cfg[price.key]
+		if !exists || raw == nil {
+			continue
+		}
+		value, ok := raw.(float64)
+		if !ok || value < 0 {
+			return ModelPricing{}, fmt.Errorf("%s must be a non-negative number", price.key)
+		}
+		*price.target = value
+	}
+	return pricing, nil
+}
+
+// ResolveModelPricing returns the prices of the model config resolved by
+// ResolveModelConfig. Models without a model config or without prices are free.
+func ResolveModelPricing(db *gorm.DB, modelConfigUUID, modelID string) (ModelPricing, error) {
+	modelConfig, err := ResolveModelConfig(db, modelConfigUUID, modelID)
+	if err != nil || modelConfig == nil {
+		return ModelPricing{}, err
+	}
+	return ModelPricingFromConfig(modelConfig.ConfigurationMap())
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-read-receipts
+// Every participant has a delivered and a read position in a chat, the newest
+// message the user's client received and the newest message the user read up
+// to. Messages can also be read one by one out of order, those reads are kept
+// as MessageReadReceipt rows until the read position passes them. Messages
+// sent by the user and event messages never count as unread. For two-party
+// chats Message.ReadAt is also set when the receiver reads the message.
+
+const (
+	// HumanTypingTimeout is how long clients show a typing indicator of a
+	// human without a new typing signal.
+	HumanTypingTimeout = 10 * time.Second
+	// BotTypingTimeout is how long clients show a typing indicator of a bot
+	// with a queued reply, unless the reply arrives or is stopped earlier.
+	BotTypingTimeout = 2 * time.Minute
+)
+
+type MessageReadReceipt struct {
+	Model
+	MessageId uint      `json:"-" gorm:"uniqueIndex:idx_message_read_receipt"`
+	Message   Message   `json:"-" gorm:"foreignKey:MessageId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	UserId    uint      `json:"-" gorm:"uniqueIndex:idx_message_read_receipt;index"`
+	User      User      `json:"-" gorm:"foreignKey:UserId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	ChatId    uint      `json:"-" gorm:"index"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+// advanceParticipantPosition moves the position column of a participant to
+// messageID unless it is already there or past it.
+func advanceParticipantPosition(db *gorm.DB, column string, chatID, userID, messageID uint) (bool, error) {
+	result := db.Model(&ChatParticipant{}).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Where("("+column+" IS NULL OR "+column+" < ?)", messageID).
+		Update(column, messageID)
+	return result.RowsAffected > 0, result.Error
+}
+
+// MarkChatDelivered records that the user's client received the messages of
+// the chat up to messageID. It reports whether the delivered position moved.
+func MarkChatDelivered(db *gorm.DB, chatID, userID, messageID uint) (bool, error) {
+	return advanceParticipantPosition(db, "last_delivered_message_id", chatID, userID, messageID)
+}
+
+// MarkChatReadUpTo records that the user read the messages of the chat up to
+// messageID, which also counts them as delivered. It reports whether the read
+// position moved.
+func MarkChatReadUpTo(db *gorm.DB, chatID, userID, messageID uint, now time.Time) (bool, error) {
+	moved := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		moved, err = advanceParticipantPosition(tx, "last_read_message_id", chatID, userID, messageID)
+		if err != nil || !moved {
+			return err
+		}
+		if _, err := advanceParticipantPosition(tx, "last_delivered_message_id", chatID, userID, messageID); err != nil {
+			return err
+		}
+		if err := tx.Model(&Message{}).
+			Where("chat_id = ? AND receiver_id = ? AND id <= ? AND read_at IS NULL", chatID, userID, messageID).
+			Update("read_at", now).Error; err != nil {
+			return err
+		}
+		// Single reads before the read position are covered by it now
+		return tx.Unscoped().
+			Where("chat_id = ? AND user_id = ? AND message_id <= ?", chatID, userID, messageID).
+			Delete(&MessageReadReceipt{}).Error
+	})
+	return moved, err
+}
+
+// MarkMessageRead records that the user read a single message without
+// moving the read position. It reports false for the user's own messages and
+// messages that were already read.
+func MarkMessageRead(db *gorm.DB, userID uint, message Message, now time.Time) (bool, error) {
+	if message.SenderId == userID {
+		return false, nil
+	}
+	var participant ChatParticipant
+	if err := db.Select("last_read_message_id").
+		Where("chat_id = ? AND user_id = ?", message.ChatId, userID).
+		First(&participant).Error; err != nil {
+		return false, err
+	}
+	if participant.LastReadMessageId != nil && *participant.LastReadMessageId >= message.ID {
+		return false, nil
+	}
+
+	created := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		receipt := MessageReadReceipt{MessageId: message.ID, UserId: userID, ChatId: message.ChatId, ReadAt: now}
+		result := tx.Where("message_id = ? AND user_id = ?", message.ID, userID).FirstOrCreate(&receipt)
+		if result.Error != nil {
+			return result.Error
+		}
+		created = result.RowsAffected > 0
+		if !created {
+			return nil
+		}
+		if _, err := advanceParticipantPosition(tx, "last_delivered_message_id", message.ChatId, userID, message.ID); err != nil {
+			return err
+		}
+		return tx.Model(&Message{}).
+			Where("id = ? AND receiver_id = ? AND read_at IS NULL", message.ID, userID).
+			Update("read_at", now).Error
+	})
+	return created, err
+}
+
+// CountUnreadMessages counts the unread messages of the active branch of each
+// of the chats for the user.
+func CountUnreadMessages(db *gorm.DB, userID uint, chatIDs []uint) (map[uint]int64, error) {
+	counts := map[uint]int64{}
+	if len(chatIDs) == 0 {
+		return counts, nil
+	}
+	rows := []struct {
+		ChatId uint
+		Unread int64
+	}{}
+	err := db.Table("messages AS m").
+		Select("m.chat_id, COUNT(*) AS unread").
+		Joins("JOIN chat_participants AS p ON p.chat_id = m.chat_id AND p.user_id = ? AND p.deleted_at IS NULL", userID).
+		Where("m.chat_id IN ? AND m.sender_id <> ? AND m.inactive = ? AND m.deleted_at IS NULL AND m.data_type <> ?", chatIDs, userID, false, "event").
+		Where("p.last_read_message_id IS NULL OR m.id > p.last_read_message_id").
+		Where("NOT EXISTS (SELECT 1 FROM message_read_receipts r WHERE r.message_id = m.id AND r.user_id = ? AND r.deleted_at IS NULL)", userID).
+		Group("m.chat_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.ChatId] = row.Unread
+	}
+	return counts, nil
+}
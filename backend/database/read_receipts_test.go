@@ -0,0 +1,98 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadPositionsAndUnreadCounts(t *testing.T) {
+	DB := SetupDatabase(DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "read_receipts.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+
+	alice := User{Name: "alice", Email: "receipts-alice@example.invalid", Username: "receipts-alice"}
+	bob := User{Name: "bob", Email: "receipts-bob@example.invalid", Username: "receipts-bob"}
+	for _, record := range []*User{&alice, &bob} {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatalf("failed creating user: %v", err)
+		}
+	}
+	chat := Chat{User1Id: alice.ID, User2Id: bob.ID}
+	if err := DB.Create(&chat).Error; err != nil {
+		t.Fatalf("failed creating chat: %v", err)
+	}
+
+	createMessage := func(sender, receiver User, text, dataType string) Message {
+		message := Message{ChatId: chat.ID, SenderId: sender.ID, ReceiverId: receiver.ID, Text: &text, DataType: dataType}
+		if err := DB.Create(&message).Error; err != nil {
+			t.Fatalf("failed creating message: %v", err)
+		}
+		return message
+	}
+	first := createMessage(alice, bob, "one", "text")
+	createMessage(alice, bob, "joined", "event")
+	second := createMessage(alice, bob, "two", "text")
+	third := createMessage(alice, bob, "three", "text")
+	createMessage(bob, alice, "reply", "text")
+
+	unread := func(user User) int64 {
+		counts, err := CountUnreadMessages(DB, user.ID, []uint{chat.ID})
+		if err != nil {
+			t.Fatalf("failed counting unread messages: %v", err)
+		}
+		return counts[chat.ID]
+	}
+	if got := unread(bob); got != 3 {
+		t.Fatalf("expected 3 unread messages for bob, got %d", got)
+	}
+	if got := unread(alice); got != 1 {
+		t.Fatalf("expected 1 unread message for alice, got %d", got)
+	}
+
+	now := time.Now()
+	if read, err := MarkMessageRead(DB, bob.ID, third, now); err != nil || !read {
+		t.Fatalf("expected the single message to be read, got %v (%v)", read, err)
+	}
+	if read, err := MarkMessageRead(DB, bob.ID, third, now); err != nil || read {
+		t.Fatalf("expected reading again to change nothing, got %v (%v)", read, err)
+	}
+	if read, err := MarkMessageRead(DB, alice.ID, third, now); err != nil || read {
+		t.Fatalf("expected own messages to never be read, got %v (%v)", read, err)
+	}
+	if got := unread(bob); got != 2 {
+		t.Fatalf("expected 2 unread messages after reading one, got %d", got)
+	}
+
+	if moved, err := MarkChatReadUpTo(DB, chat.ID, bob.ID, second.ID, now); err != nil || !moved {
+		t.Fatalf("expected the read position to move, got %v (%v)", moved, err)
+	}
+	if moved, err := MarkChatReadUpTo(DB, chat.ID, bob.ID, first.ID, now); err != nil || moved {
+		t.Fatalf("expected the read position to never move back, got %v (%v)", moved, err)
+	}
+	if got := unread(bob); got != 0 {
+		t.Fatalf("expected no unread messages, got %d", got)
+	}
+	var reloaded Message
+	if err := DB.First(&reloaded, first.ID).Error; err != nil || reloaded.ReadAt == nil {
+		t.Fatalf("expected the read message to have read_at set, got %+v (%v)", reloaded.ReadAt, err)
+	}
+
+	participant, err := GetChatParticipant(DB, chat.ID, bob.ID)
+	if err != nil || participant.LastDeliveredMessageId == nil || *participant.LastDeliveredMessageId != third.ID {
+		t.Fatalf("expected reads to count as delivered, got %+v (%v)", participant, err)
+	}
+	if moved, err := MarkChatDelivered(DB, chat.ID, bob.ID, second.ID); err != nil || moved {
+		t.Fatalf("expected the delivered position to never move back, got %v (%v)", moved, err)
+	}
+	if moved, err := MarkChatReadUpTo(DB, chat.ID, bob.ID, third.ID, now); err != nil || !moved {
+		t.Fatalf("expected the read position to move, got %v (%v)", moved, err)
+	}
+	var receipts int64
+	if err := DB.Model(&MessageReadReceipt{}).Where("user_id = ?", bob.ID).Count(&receipts).Error; err != nil || receipts != 0 {
+		t.Fatalf("expected single reads behind the read position to be dropped, got %d (%v)", receipts, err)
+	}
+}
@@ -0,0 +1,683 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-retention
+// Retention policies decide how long chat history is kept. A policy is
+// global, scoped to a bot (BotRuntimeConfig) or scoped to a chat type
+// ("interaction", "conversation" or "group"). The most specific policy of a
+// chat applies: the policy of a bot in the chat, then the policy of its chat
+// type, then the global one. Messages older than the policy's message_days
+// are hard-deleted, and chats left without newer messages are deleted with
+// them; message_days 0 keeps history, so a bot policy of 0 exempts the bot's
+// chats from a global limit. The global policy's deleted_days additionally
+// purges soft-deleted chats, messages and uploads, and the attachments of
+// purged messages no other message refers to; uploads shared explicitly are
+// kept, the view access attaching a file grants doesn't keep it. Chat
+// exports go once they expire or with their chat. A periodic worker task runs
+// the purge, PlanRetentionPurge previews it.
+
+const (
+	RetentionScopeGlobal   = "global"
+	RetentionScopeBot      = "bot"
+	RetentionScopeChatType = "chat_type"
+
+	// retentionBatchSize bounds the ids bound into one IN clause.
+	retentionBatchSize = 500
+)
+
+var (
+	ErrInvalidRetentionScope    = errors.New("scope must be global, bot or chat_type")
+	ErrInvalidRetentionChatType = errors.New("chat type must be interaction, conversation or group")
+	ErrInvalidRetentionDays     = errors.New("retention days must not be negative")
+	ErrRetentionDeletedDays     = errors.New("deleted_days can only be set on the global policy")
+)
+
+type RetentionPolicy struct {
+	Model
+	Scope              string            `json:"scope" gorm:"type:varchar(16);index"`
+	BotRuntimeConfigId *uint             `json:"-" gorm:"index"`
+	BotRuntimeConfig   *BotRuntimeConfig `json:"-" gorm:"foreignKey:BotRuntimeConfigId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	ChatType           string            `json:"chat_type,omitempty" gorm:"type:varchar(32)"`
+	RetentionPolicyLimits
+}
+
+type RetentionPolicyLimits struct {
+	// MessageDays is how long messages are kept, 0 keeps them.
+	MessageDays int `json:"message_days"`
+	// DeletedDays is how long soft-deleted rows and the attachments of purged
+	// messages are kept, 0 keeps them. Only used on the global policy.
+	DeletedDays int `json:"deleted_days"`
+}
+
+// RetentionPolicyCount is what one policy purges.
+type RetentionPolicyCount struct {
+	PolicyUUID  string `json:"policy_uuid"`
+	Scope       string `json:"scope"`
+	Target      string `json:"target,omitempty"`
+	MessageDays int    `json:"message_days"`
+	Chats       int    `json:"chats"`
+	Messages    int    `json:"messages"`
+}
+
+// RetentionReport counts the rows a purge deletes.
+type RetentionReport struct {
+	GeneratedAt     time.Time              `json:"generated_at"`
+	Chats           int                    `json:"chats"`
+	Messages        int                    `json:"messages"`
+	DeletedChats    int                    `json:"deleted_chats"`
+	DeletedMessages int                    `json:"deleted_messages"`
+	ToolInitData    int                    `json:"tool_init_data"`
+	Uploads         int                    `json:"uploads"`
+	UploadBytes     int64                  `json:"upload_bytes"`
+	ChatExports     int                    `json:"chat_exports"`
+	Policies        []RetentionPolicyCount `json:"policies"`
+}
+
+// RetentionPlan is the set of rows a purge deletes, see PlanRetentionPurge.
+type RetentionPlan struct {
+	Report          RetentionReport
+	MessageIDs      []uint
+	ChatIDs         []uint
+	ToolInitDataIDs []uint
+	Uploads         []UploadedFile
+	ChatExports     []ChatExport
+}
+
+func IsValidRetentionChatType(chatType string) bool {
+	switch chatType {
+	case "interaction", "conversation", ChatTypeGroup:
+		return true
+	}
+	return false
+}
+
+// RetentionTarget is the bot UUID of bot policies and the chat type of chat
+// type policies.
+func (p RetentionPolicy) RetentionTarget() string {
+	switch p.Scope {
+	case RetentionScopeBot:
+		if p.BotRuntimeConfig != nil {
+			return p.BotRuntimeConfig.UUID
+		}
+	case RetentionScopeChatType:
+		return p.ChatType
+	}
+	return ""
+}
+
+func ListRetentionPolicies(db *gorm.DB) ([]RetentionPolicy, error) {
+	policies := []RetentionPolicy{}
+	if err := db.Preload("BotRuntimeConfig").Order("scope asc, id asc").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// SetRetentionPolicy creates or replaces the policy of a scope. bot is the
+// bot of bot policies and chatType the chat type of chat type policies.
+func SetRetentionPolicy(db *gorm.DB, scope string, bot *BotRuntimeConfig, chatType string, limits RetentionPolicyLimits) (*RetentionPolicy, error) {
+	scope = strings.ToLower(strings.TrimSpace(scope))
+	chatType = strings.ToLower(strings.TrimSpace(chatType))
+	if limits.MessageDays < 0 || limits.DeletedDays < 0 {
+		return nil, ErrInvalidRetentionDays
+	}
+	if scope != RetentionScopeGlobal && limits.DeletedDays != 0 {
+		return nil, ErrRetentionDeletedDays
+	}
+
+	query := db.Where("scope = ?", scope)
+	policy := RetentionPolicy{Scope: scope}
+	switch scope {
+	case RetentionScopeGlobal:
+	case RetentionScopeBot:
+		if bot == nil || bot.ID == 0 {
+			return nil, errors.New("bot policies need a bot")
+		}
+		query = query.Where("bot_runtime_config_id = ?", bot.ID)
+		policy.BotRuntimeConfigId = &bot.ID
+		policy.BotRuntimeConfig = bot
+	case RetentionScopeChatType:
+		if !IsValidRetentionChatType(chatType) {
+			return nil, ErrInvalidRetentionChatType
+		}
+		query = query.Where("chat_type = ?", chatType)
+		policy.ChatType = chatType
+	default:
+		return nil, ErrInvalidRetentionScope
+	}
+
+	err := query.First(&policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		policy.RetentionPolicyLimits = limits
+		if err := db.Create(&policy).Error; err != nil {
+			return nil, err
+		}
+		return &policy, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.RetentionPolicyLimits = limits
+	if err := db.Model(&policy).Updates(map[string]interface{}{
+		"message_days": limits.MessageDays,
+		"deleted_days": limits.DeletedDays,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func DeleteRetentionPolicy(db *gorm.DB, policyUUID string) error {
+	result := db.Unscoped().Where("uuid = ?", policyUUID).Delete(&RetentionPolicy{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// retentionBatches calls fn with ids in slices of at most retentionBatchSize.
+func retentionBatches(ids []uint, fn func([]uint) error) error {
+	for start := 0; start < len(ids); start += retentionBatchSize {
+		end := start + retentionBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := fn(ids[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type retentionIDSet map[uint]struct{}
+
+func (s retentionIDSet) add(ids ...uint) int {
+	added := 0
+	for _, id := range ids {
+		if _, ok := s[id]; !ok {
+			s[id] = struct{}{}
+			added++
+		}
+	}
+	return added
+}
+
+func (s retentionIDSet) sorted() []uint {
+	ids := make([]uint, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// resolveChatRetentionPolicies maps every chat to the policy that applies to
+// it. Chats of several bots with a policy use the shortest of them.
+func resolveChatRetentionPolicies(db *gorm.DB, policies []RetentionPolicy) (map[uint]*RetentionPolicy, error) {
+	var global *RetentionPolicy
+	byChatType := map[string]*RetentionPolicy{}
+	byBotUser := map[uint]*RetentionPolicy{}
+	for i := range policies {
+		policy := &policies[i]
+		switch policy.Scope {
+		case RetentionScopeGlobal:
+			global = policy
+		case RetentionScopeChatType:
+			byChatType[policy.ChatType] = policy
+		case RetentionScopeBot:
+			if policy.BotRuntimeConfig != nil {
+				byBotUser[policy.BotRuntimeConfig.BotUserId] = policy
+			}
+		}
+	}
+
+	var chats []struct {
+		ID       uint
+		ChatType string
+		User1Id  uint
+		User2Id  uint
+	}
+	if err := db.Model(&Chat{}).Unscoped().Select("id, chat_type, user1_id, user2_id").Find(&chats).Error; err != nil {
+		return nil, err
+	}
+
+	botsByChat := map[uint][]uint{}
+	if len(byBotUser) > 0 {
+		botUserIDs := make([]uint, 0, len(byBotUser))
+		for botUserID := range byBotUser {
+			botUserIDs = append(botUserIDs, botUserID)
+		}
+		var participants []struct {
+			ChatId uint
+			UserId uint
+		}
+		if err := db.Model(&ChatParticipant{}).
+			Select("chat_id, user_id").
+			Where("user_id IN ?", botUserIDs).
+			Find(&participants).Error; err != nil {
+			return nil, err
+		}
+		for _, participant := range participants {
+			botsByChat[participant.ChatId] = append(botsByChat[participant.ChatId], participant.UserId)
+		}
+	}
+
+	resolved := map[uint]*RetentionPolicy{}
+	for _, chat := range chats {
+		var botPolicy *RetentionPolicy
+		for _, botUserID := range append([]uint{chat.User1Id, chat.User2Id}, botsByChat[chat.ID]...) {
+			policy, ok := byBotUser[botUserID]
+			if !ok {
+				continue
+			}
+			if botPolicy == nil || retentionDaysShorter(policy.MessageDays, botPolicy.MessageDays) {
+				botPolicy = policy
+			}
+		}
+		switch {
+		case botPolicy != nil:
+			resolved[chat.ID] = botPolicy
+		case byChatType[chat.ChatType] != nil:
+			resolved[chat.ID] = byChatType[chat.ChatType]
+		case global != nil:
+			resolved[chat.ID] = global
+		}
+	}
+	return resolved, nil
+}
+
+// retentionDaysShorter reports whether a keeps history for less time than b,
+// 0 keeping it forever.
+func retentionDaysShorter(a, b int) bool {
+	if a == 0 {
+		return false
+	}
+	return b == 0 || a < b
+}
+
+// PlanRetentionPurge collects what a purge at now deletes without deleting it.
+func PlanRetentionPurge(db *gorm.DB, now time.Time) (*RetentionPlan, error) {
+	policies, err := ListRetentionPolicies(db)
+	if err != nil {
+		return nil, err
+	}
+	plan := &RetentionPlan{Report: RetentionReport{GeneratedAt: now, Policies: []RetentionPolicyCount{}}}
+	if len(policies) == 0 {
+		return plan, nil
+	}
+
+	resolved, err := resolveChatRetentionPolicies(db, policies)
+	if err != nil {
+		return nil, err
+	}
+	chatsByPolicy := map[uint][]uint{}
+	for chatID, policy := range resolved {
+		if policy.MessageDays > 0 {
+			chatsByPolicy[policy.ID] = append(chatsByPolicy[policy.ID], chatID)
+		}
+	}
+
+	messages := retentionIDSet{}
+	chats := retentionIDSet{}
+	// Chats still used by a bot schedule or a pending scheduled message keep existing
+	keptChats := db.Raw(
+		"SELECT chat_id FROM bot_schedules WHERE chat_id IS NOT NULL AND deleted_at IS NULL UNION SELECT chat_id FROM scheduled_messages WHERE status = ? AND deleted_at IS NULL",
+		ScheduledMessagePending,
+	)
+
+	var global *RetentionPolicy
+	for i := range policies {
+		policy := &policies[i]
+		if policy.Scope == RetentionScopeGlobal {
+			global = policy
+		}
+		chatIDs := chatsByPolicy[policy.ID]
+		if len(chatIDs) == 0 {
+			continue
+		}
+		sort.Slice(chatIDs, func(a, b int) bool { return chatIDs[a] < chatIDs[b] })
+		cutoff := now.AddDate(0, 0, -policy.MessageDays)
+		count := RetentionPolicyCount{
+			PolicyUUID:  policy.UUID,
+			Scope:       policy.Scope,
+			Target:      policy.RetentionTarget(),
+			MessageDays: policy.MessageDays,
+		}
+		err := retentionBatches(chatIDs, func(batch []uint) error {
+			var expiredMessages []uint
+			if err := db.Unscoped().Model(&Message{}).
+				Where("chat_id IN ? AND created_at < ?", batch, cutoff).
+				Pluck("id", &expiredMessages).Error; err != nil {
+				return err
+			}
+			count.Messages += messages.add(expiredMessages...)
+
+			var expiredChats []uint
+			if err := db.Unscoped().Model(&Chat{}).
+				Where("id IN ? AND created_at < ?", batch, cutoff).
+				Where("NOT EXISTS (SELECT 1 FROM messages WHERE messages.chat_id = chats.id AND messages.created_at >= ?)", cutoff).
+				Where("id NOT IN (?)", keptChats).
+				Pluck("id", &expiredChats).Error; err != nil {
+				return err
+			}
+			count.Chats += chats.add(expiredChats...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		plan.Report.Policies = append(plan.Report.Policies, count)
+		plan.Report.Messages += count.Messages
+		plan.Report.Chats += count.Chats
+	}
+
+	deletedCutoff := time.Time{}
+	if global != nil && global.DeletedDays > 0 {
+		deletedCutoff = now.AddDate(0, 0, -global.DeletedDays)
+		var deletedMessages []uint
+		if err := db.Unscoped().Model(&Message{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedCutoff).
+			Pluck("id", &deletedMessages).Error; err != nil {
+			return nil, err
+		}
+		plan.Report.DeletedMessages = messages.add(deletedMessages...)
+
+		var deletedChats []uint
+		if err := db.Unscoped().Model(&Chat{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedCutoff).
+			Where("id NOT IN (?)", keptChats).
+			Pluck("id", &deletedChats).Error; err != nil {
+			return nil, err
+		}
+		plan.Report.DeletedChats = chats.add(deletedChats...)
+	}
+
+	plan.ChatIDs = chats.sorted()
+	// Everything left in a purged chat goes with it
+	err = retentionBatches(plan.ChatIDs, func(batch []uint) error {
+		var chatMessages []uint
+		if err := db.Unscoped().Model(&Message{}).Where("chat_id IN ?", batch).Pluck("id", &chatMessages).Error; err != nil {
+			return err
+		}
+		plan.Report.Messages += messages.add(chatMessages...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan.MessageIDs = messages.sorted()
+
+	toolInitData := retentionIDSet{}
+	var expiredToolInitData []uint
+	expiredQuery := db.Unscoped().Model(&ToolInitData{}).Where("expires_at IS NOT NULL AND expires_at < ?", now)
+	if !deletedCutoff.IsZero() {
+		expiredQuery = expiredQuery.Or("deleted_at IS NOT NULL AND deleted_at < ?", deletedCutoff)
+	}
+	if err := expiredQuery.Pluck("id", &expiredToolInitData).Error; err != nil {
+		return nil, err
+	}
+	toolInitData.add(expiredToolInitData...)
+	err = retentionBatches(plan.ChatIDs, func(batch []uint) error {
+		var chatToolInitData []uint
+		if err := db.Unscoped().Model(&ToolInitData{}).Where("chat_id IN ?", batch).Pluck("id", &chatToolInitData).Error; err != nil {
+			return err
+		}
+		toolInitData.add(chatToolInitData...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan.ToolInitDataIDs = toolInitData.sorted()
+	plan.Report.ToolInitData = len(plan.ToolInitDataIDs)
+
+	plan.ChatExports = []ChatExport{}
+	if err := db.Unscoped().
+		Where("finished_at IS NOT NULL AND finished_at < ?", now.Add(-ChatExportTTL)).
+		Order("id asc").
+		Find(&plan.ChatExports).Error; err != nil {
+		return nil, err
+	}
+	// Exports of purged chats go with them
+	err = retentionBatches(plan.ChatIDs, func(batch []uint) error {
+		var chatExports []ChatExport
+		if err := db.Unscoped().
+			Where("chat_id IN ? AND NOT (finished_at IS NOT NULL AND finished_at < ?)", batch, now.Add(-ChatExportTTL)).
+			Find(&chatExports).Error; err != nil {
+			return err
+		}
+		plan.ChatExports = append(plan.ChatExports, chatExports...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan.Report.ChatExports = len(plan.ChatExports)
+
+	if !deletedCutoff.IsZero() {
+		uploads, err := orphanedUploads(db, messages, deletedCutoff)
+		if err != nil {
+			return nil, err
+		}
+		plan.Uploads = uploads
+		plan.Report.Uploads = len(uploads)
+		for _, upload := range uploads {
+			plan.Report.UploadBytes += upload.Size
+		}
+	}
+	return plan, nil
+}
+
+// orphanedUploads returns the uploads deleted before cutoff and the uploads
+// created before cutoff that were attached to a message in purged, unless a
+// message outside purged or a pending scheduled message still refers to them.
+// Uploads that are shared through explicit FileAccess grants are kept, as are
+// library files that were never attached.
+func orphanedUploads(db *gorm.DB, purged retentionIDSet, cutoff time.Time) ([]UploadedFile, error) {
+	referenced := map[string]struct{}{}
+	released := map[string]struct{}{}
+	collect := func(raw []byte, into map[string]struct{}) {
+		var data struct {
+			Attachments []struct {
+				FileID string `json:"file_id"`
+			} `json:"attachments"`
+		}
+		if json.Unmarshal(raw, &data) != nil {
+			return
+		}
+		for _, attachment := range data.Attachments {
+			into[attachment.FileID] = struct{}{}
+		}
+	}
+
+	var rows []struct {
+		ID       uint
+		MetaData []byte
+	}
+	if err := db.Unscoped().Model(&Message{}).
+		Select("id, meta_data").
+		Where("CAST(meta_data AS TEXT) LIKE ?", "%file_id%").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if _, ok := purged[row.ID]; ok {
+			collect(row.MetaData, released)
+		} else {
+			collect(row.MetaData, referenced)
+		}
+	}
+
+	var payloads [][]byte
+	if err := db.Model(&ScheduledMessage{}).
+		Where("status = ?", ScheduledMessagePending).
+		Pluck("payload", &payloads).Error; err != nil {
+		return nil, err
+	}
+	for _, payload := range payloads {
+		collect(payload, referenced)
+	}
+
+	unshared := func(query *gorm.DB) *gorm.DB {
+		return query.Where("id NOT IN (SELECT uploaded_file_id FROM file_accesses WHERE implicit = ?)", false)
+	}
+	candidates := []UploadedFile{}
+	if err := unshared(db.Unscoped()).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Order("id asc").
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	releasedIDs := make([]string, 0, len(released))
+	for fileID := range released {
+		if _, ok := referenced[fileID]; !ok {
+			releasedIDs = append(releasedIDs, fileID)
+		}
+	}
+	sort.Strings(releasedIDs)
+	for start := 0; start < len(releasedIDs); start += retentionBatchSize {
+		end := min(start+retentionBatchSize, len(releasedIDs))
+		batch := []UploadedFile{}
+		if err := unshared(db).
+			Where("created_at < ? AND file_id IN ?", cutoff, releasedIDs[start:end]).
+			Order("id asc").
+			Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, batch...)
+	}
+
+	orphaned := []UploadedFile{}
+	for _, upload := range candidates {
+		if _, ok := referenced[upload.FileID]; !ok {
+			orphaned = append(orphaned, upload)
+		}
+	}
+	sort.Slice(orphaned, func(i, j int) bool { return orphaned[i].ID < orphaned[j].ID })
+	return orphaned, nil
+}
+
+// ExecuteRetentionPurge hard-deletes the rows of a plan. The stored files of
+// the plan's uploads and chat exports are left to the caller.
+func ExecuteRetentionPurge(db *gorm.DB, plan *RetentionPlan) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := retentionBatches(plan.MessageIDs, func(batch []uint) error {
+			return purgeMessages(tx, batch)
+		}); err != nil {
+			return fmt.Errorf("purge messages: %w", err)
+		}
+		if err := retentionBatches(plan.ChatIDs, func(batch []uint) error {
+			return purgeChats(tx, batch)
+		}); err != nil {
+			return fmt.Errorf("purge chats: %w", err)
+		}
+		if err := retentionBatches(plan.ToolInitDataIDs, func(batch []uint) error {
+			return tx.Unscoped().Where("id IN ?", batch).Delete(&ToolInitData{}).Error
+		}); err != nil {
+			return fmt.Errorf("purge tool init data: %w", err)
+		}
+
+		exportIDs := make([]uint, len(plan.ChatExports))
+		for i, export := range plan.ChatExports {
+			exportIDs[i] = export.ID
+		}
+		if err := retentionBatches(exportIDs, func(batch []uint) error {
+			return tx.Unscoped().Where("id IN ?", batch).Delete(&ChatExport{}).Error
+		}); err != nil {
+			return fmt.Errorf("purge chat exports: %w", err)
+		}
+
+		uploadIDs := make([]uint, len(plan.Uploads))
+		for i, upload := range plan.Uploads {
+			uploadIDs[i] = upload.ID
+		}
+		if err := retentionBatches(uploadIDs, func(batch []uint) error {
+			if err := tx.Where("uploaded_file_id IN ?", batch).Delete(&FileAccess{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("id IN ?", batch).Delete(&UploadedFile{}).Error
+		}); err != nil {
+			return fmt.Errorf("purge uploads: %w", err)
+		}
+		return nil
+	})
+}
+
+// purgeMessages deletes a batch of messages with the rows referring to them.
+// Chats pointing at a purged latest message move to their newest remaining
+// one and replies to purged messages become branch roots.
+func purgeMessages(tx *gorm.DB, batch []uint) error {
+	for _, model := range []interface{}{&MessageReaction{}, &MessageRating{}, &MessageRevision{}, &MessageReadReceipt{}} {
+		if err := tx.Unscoped().Where("message_id IN ?", batch).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&ScheduledMessage{}).Unscoped().Where("message_id IN ?", batch).Update("message_id", nil).Error; err != nil {
+		return err
+	}
+	var latestChats []uint
+	if err := tx.Model(&Chat{}).Unscoped().Where("latest_message_id IN ?", batch).Pluck("id", &latestChats).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Chat{}).Unscoped().Where("latest_message_id IN ?", batch).Update("latest_message_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Message{}).Unscoped().Where("parent_id IN ?", batch).Update("parent_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Message{}).Unscoped().Where("reply_to_id IN ?", batch).Update("reply_to_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("id IN ?", batch).Delete(&Message{}).Error; err != nil {
+		return err
+	}
+	if len(latestChats) == 0 {
+		return nil
+	}
+	return tx.Exec(
+		"UPDATE chats SET latest_message_id = (SELECT MAX(m.id) FROM messages AS m WHERE m.chat_id = chats.id AND m.inactive = ? AND m.deleted_at IS NULL) WHERE id IN ?",
+		false, latestChats,
+	).Error
+}
+
+// purgeChats deletes a batch of chats, whose messages are already purged,
+// with the rows referring to them.
+func purgeChats(tx *gorm.DB, batch []uint) error {
+	for _, model := range []interface{}{
+		&ToolInitData{},
+		&ChatSummary{},
+		&SharedChatInstance{},
+		&ChatSettings{},
+		&ChatParticipant{},
+		&MessageReadReceipt{},
+		&ScheduledMessage{},
+		&ChatExport{},
+	} {
+		if err := tx.Unscoped().Where("chat_id IN ?", batch).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&BotScheduleRun{}).Unscoped().Where("chat_id IN ?", batch).Update("chat_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Chat{}).Unscoped().Where("id IN ?", batch).Update("shared_config_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("chat_id IN ?", batch).Delete(&SharedChatConfig{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id IN ?", batch).Delete(&Chat{}).Error
+}
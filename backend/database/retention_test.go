@@ -0,0 +1,184 @@
+package database
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestRetentionPurgeFollowsMostSpecificPolicy(t *testing.T) {
+	DB := SetupDatabase(DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "retention.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+
+	alice := User{Name: "alice", Email: "retention-alice@example.invalid", Username: "retention-alice"}
+	bob := User{Name: "bob", Email: "retention-bob@example.invalid", Username: "retention-bob"}
+	keeper := User{Name: "keeper", Email: "retention-keeper@example.invalid", Username: "retention-keeper", IsAutomated: true}
+	helper := User{Name: "helper", Email: "retention-helper@example.invalid", Username: "retention-helper", IsAutomated: true}
+	for _, record := range []*User{&alice, &bob, &keeper, &helper} {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatalf("failed creating user: %v", err)
+		}
+	}
+	keeperRuntime := BotRuntimeConfig{BotUserId: keeper.ID, OwnerUserId: alice.ID, Name: "keeper"}
+	if err := DB.Create(&keeperRuntime).Error; err != nil {
+		t.Fatalf("failed creating bot: %v", err)
+	}
+
+	now := time.Now()
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+	createChat := func(user1, user2 User, chatType string, createdAt time.Time) Chat {
+		chat := Chat{User1Id: user1.ID, User2Id: user2.ID, ChatType: chatType, Model: Model{CreatedAt: createdAt}}
+		if err := DB.Create(&chat).Error; err != nil {
+			t.Fatalf("failed creating chat: %v", err)
+		}
+		return chat
+	}
+	createMessage := func(chat Chat, sender, receiver User, createdAt time.Time, fileID string) Message {
+		text := "hello"
+		message := Message{ChatId: chat.ID, SenderId: sender.ID, ReceiverId: receiver.ID, Text: &text, Model: Model{CreatedAt: createdAt}}
+		if fileID != "" {
+			message.MetaData, _ = json.Marshal(map[string]interface{}{"attachments": []map[string]string{{"file_id": fileID}}})
+		}
+		if err := DB.Create(&message).Error; err != nil {
+			t.Fatalf("failed creating message: %v", err)
+		}
+		if err := DB.Model(&Chat{}).Where("id = ?", chat.ID).Update("latest_message_id", message.ID).Error; err != nil {
+			t.Fatalf("failed updating latest message: %v", err)
+		}
+		return message
+	}
+	createUpload := func(fileID string, createdAt time.Time) UploadedFile {
+		upload := UploadedFile{FileID: fileID, StorageURL: "./uploads/" + fileID, Size: 10, OwnerID: alice.ID, Model: Model{CreatedAt: createdAt}}
+		if err := DB.Create(&upload).Error; err != nil {
+			t.Fatalf("failed creating upload: %v", err)
+		}
+		return upload
+	}
+
+	// An old interaction with a bot without policy expires with the interaction policy
+	expiredInteraction := createChat(alice, helper, "interaction", daysAgo(60))
+	createMessage(expiredInteraction, alice, helper, daysAgo(60), "expired-file")
+	if err := NewToolInitDataManager(DB).StoreToolInitData(expiredInteraction.ID, "search", map[string]interface{}{"key": "value"}); err != nil {
+		t.Fatalf("failed storing tool init data: %v", err)
+	}
+	// The keeper bot's policy of 0 days exempts its interactions
+	keptInteraction := createChat(alice, keeper, "interaction", daysAgo(60))
+	createMessage(keptInteraction, alice, keeper, daysAgo(60), "")
+	// Conversations use the global policy and keep their recent messages
+	conversation := createChat(alice, bob, "conversation", daysAgo(120))
+	oldMessage := createMessage(conversation, alice, bob, daysAgo(100), "")
+	recentMessage := createMessage(conversation, bob, alice, daysAgo(10), "kept-file")
+	deletedMessage := createMessage(conversation, alice, bob, daysAgo(9), "")
+	if err := DB.Model(&deletedMessage).Update("deleted_at", daysAgo(8)).Error; err != nil {
+		t.Fatalf("failed soft-deleting message: %v", err)
+	}
+	if err := DB.Model(&Chat{}).Where("id = ?", conversation.ID).Update("latest_message_id", recentMessage.ID).Error; err != nil {
+		t.Fatalf("failed updating latest message: %v", err)
+	}
+	if _, err := AddMessageReaction(DB, oldMessage.ID, bob.ID, "👍"); err != nil {
+		t.Fatalf("failed reacting: %v", err)
+	}
+	createUpload("expired-file", daysAgo(60))
+	createUpload("kept-file", daysAgo(10))
+	createUpload("fresh-file", now)
+	// Library files never attached and files shared with others are kept,
+	// files their owner deleted go once deleted_days passed
+	createUpload("library-file", daysAgo(60))
+	shared := createUpload("shared-file", daysAgo(60))
+	createMessage(expiredInteraction, alice, helper, daysAgo(60), "shared-file")
+	if err := ShareFile(DB, &shared, []uint{bob.ID}, FilePermissionView, now); err != nil {
+		t.Fatalf("failed sharing upload: %v", err)
+	}
+	deleted := createUpload("deleted-file", daysAgo(60))
+	if err := DeleteUploadedFile(DB, &deleted); err != nil {
+		t.Fatalf("failed deleting upload: %v", err)
+	}
+	if err := DB.Unscoped().Model(&deleted).Update("deleted_at", daysAgo(30)).Error; err != nil {
+		t.Fatalf("failed backdating upload deletion: %v", err)
+	}
+
+	policies := []struct {
+		scope    string
+		bot      *BotRuntimeConfig
+		chatType string
+		limits   RetentionPolicyLimits
+	}{
+		{RetentionScopeGlobal, nil, "", RetentionPolicyLimits{MessageDays: 90, DeletedDays: 7}},
+		{RetentionScopeChatType, nil, "interaction", RetentionPolicyLimits{MessageDays: 30}},
+		{RetentionScopeBot, &keeperRuntime, "", RetentionPolicyLimits{MessageDays: 0}},
+	}
+	for _, policy := range policies {
+		if _, err := SetRetentionPolicy(DB, policy.scope, policy.bot, policy.chatType, policy.limits); err != nil {
+			t.Fatalf("failed setting %s policy: %v", policy.scope, err)
+		}
+	}
+	if _, err := SetRetentionPolicy(DB, RetentionScopeChatType, nil, "interaction", RetentionPolicyLimits{MessageDays: 30, DeletedDays: 1}); err != ErrRetentionDeletedDays {
+		t.Fatalf("expected deleted_days to be rejected on chat type policies, got %v", err)
+	}
+	if _, err := SetRetentionPolicy(DB, RetentionScopeGlobal, nil, "", RetentionPolicyLimits{MessageDays: 90, DeletedDays: 7}); err != nil {
+		t.Fatalf("failed replacing the global policy: %v", err)
+	}
+	if listed, err := ListRetentionPolicies(DB); err != nil || len(listed) != 3 {
+		t.Fatalf("expected setting a policy again to replace it, got %d (%v)", len(listed), err)
+	}
+
+	plan, err := PlanRetentionPurge(DB, now)
+	if err != nil {
+		t.Fatalf("failed planning purge: %v", err)
+	}
+	report := plan.Report
+	if report.Chats != 1 || report.Messages != 3 || report.DeletedMessages != 1 || report.ToolInitData != 1 || report.Uploads != 2 || report.UploadBytes != 20 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(plan.Uploads) != 2 || plan.Uploads[0].FileID != "expired-file" || plan.Uploads[1].FileID != "deleted-file" {
+		t.Fatalf("expected the upload of the expired interaction and the deleted upload to be orphaned, got %+v", plan.Uploads)
+	}
+
+	var messageCount int64
+	DB.Unscoped().Model(&Message{}).Count(&messageCount)
+	if messageCount != 6 {
+		t.Fatalf("expected the dry run to delete nothing, got %d messages", messageCount)
+	}
+
+	if err := ExecuteRetentionPurge(DB, plan); err != nil {
+		t.Fatalf("failed purging: %v", err)
+	}
+
+	var remaining []Message
+	if err := DB.Unscoped().Order("id asc").Find(&remaining).Error; err != nil {
+		t.Fatalf("failed loading messages: %v", err)
+	}
+	if len(remaining) != 2 || remaining[0].ChatId != keptInteraction.ID || remaining[1].ID != recentMessage.ID {
+		t.Fatalf("expected the kept interaction and the recent message to remain, got %+v", remaining)
+	}
+	if remaining[1].ParentId != nil {
+		t.Fatalf("expected the reply to a purged message to become a branch root, got parent %v", *remaining[1].ParentId)
+	}
+	if err := DB.Unscoped().First(&Chat{}, expiredInteraction.ID).Error; err != gorm.ErrRecordNotFound {
+		t.Fatalf("expected the expired interaction to be hard-deleted, got %v", err)
+	}
+	var participants, toolInitData, reactions int64
+	DB.Unscoped().Model(&ChatParticipant{}).Where("chat_id = ?", expiredInteraction.ID).Count(&participants)
+	DB.Unscoped().Model(&ToolInitData{}).Count(&toolInitData)
+	DB.Unscoped().Model(&MessageReaction{}).Count(&reactions)
+	if participants != 0 || toolInitData != 0 || reactions != 0 {
+		t.Fatalf("expected rows of purged chats and messages to go, got %d participants, %d tool init data, %d reactions", participants, toolInitData, reactions)
+	}
+	var uploads []string
+	DB.Unscoped().Model(&UploadedFile{}).Order("file_id asc").Pluck("file_id", &uploads)
+	if len(uploads) != 4 || uploads[0] != "fresh-file" || uploads[1] != "kept-file" || uploads[2] != "library-file" || uploads[3] != "shared-file" {
+		t.Fatalf("expected referenced, fresh, library and shared uploads to remain, got %v", uploads)
+	}
+
+	plan, err = PlanRetentionPurge(DB, now)
+	if err != nil || plan.Report.Chats != 0 || plan.Report.Messages != 0 || plan.Report.Uploads != 0 {
+		t.Fatalf("expected nothing left to purge, got %+v (%v)", plan, err)
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-scheduled-messages
+// A scheduled message is a message a user queued for later delivery into one
+// of their chats. The request is stored as sent to the send endpoint and a
+// delayed asynq task delivers it through the same path once send_at is
+// reached. Only pending messages can be cancelled or rescheduled; delivery
+// claims the row first so a message is never sent twice. A claim that is not
+// finished within ScheduledMessageClaimTimeout belongs to a worker that
+// crashed, the retried delivery task claims the row again.
+
+const (
+	ScheduledMessagePending   = "pending"
+	ScheduledMessageSending   = "sending"
+	ScheduledMessageSent      = "sent"
+	ScheduledMessageCancelled = "cancelled"
+	ScheduledMessageFailed    = "failed"
+
+	// MaxScheduleAhead is how far in the future messages can be scheduled.
+	MaxScheduleAhead = 365 * 24 * time.Hour
+	// ScheduledMessageClockSkew tolerates delivery tasks starting slightly
+	// before send_at on workers with a different clock.
+	ScheduledMessageClockSkew = 5 * time.Second
+	// ScheduledMessageClaimTimeout is the timeout of the delivery task, a
+	// message still sending after it is reclaimed.
+	ScheduledMessageClaimTimeout = 2 * time.Minute
+)
+
+var (
+	ErrScheduledMessageNotPending = errors.New("scheduled message is no longer pending")
+	ErrSendAtInPast               = errors.New("send_at must be in the future")
+	ErrSendAtTooFar               = errors.New("send_at is too far in the future")
+)
+
+type ScheduledMessage struct {
+	Model
+	ChatId   uint            `json:"-" gorm:"index"`
+	Chat     Chat            `json:"-" gorm:"foreignKey:ChatId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	SenderId uint            `json:"-" gorm:"index"`
+	Sender   User            `json:"-" gorm:"foreignKey:SenderId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Payload  json.RawMessage `json:"-" gorm:"type:jsonb"`
+	SendAt   time.Time       `json:"send_at" gorm:"index"`
+	Status   string          `json:"status" gorm:"index"`
+	// TaskID is the asynq task delivering the message at SendAt.
+	TaskID    string     `json:"-"`
+	MessageId *uint      `json:"-"`
+	Message   *Message   `json:"-" gorm:"foreignKey:MessageId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// ValidateSendAt checks sendAt is in the future and within MaxScheduleAhead.
+func ValidateSendAt(sendAt, now time.Time) error {
+	if !sendAt.After(now) {
+		return ErrSendAtInPast
+	}
+	if sendAt.Sub(now) > MaxScheduleAhead {
+		return ErrSendAtTooFar
+	}
+	return nil
+}
+
+// RescheduleMessage moves a pending message to sendAt and the task delivering it.
+func RescheduleMessage(db *gorm.DB, scheduled *ScheduledMessage, sendAt time.Time, taskID string) error {
+	result := db.Model(&ScheduledMessage{}).
+		Where("id = ? AND status = ?", scheduled.ID, ScheduledMessagePending).
+		Updates(map[string]interface{}{"send_at": sendAt, "task_id": taskID})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrScheduledMessageNotPending
+	}
+	scheduled.SendAt = sendAt
+	scheduled.TaskID = taskID
+	return nil
+}
+
+// CancelScheduledMessage cancels a pending message.
+func CancelScheduledMessage(db *gorm.DB, scheduled *ScheduledMessage) error {
+	result := db.Model(&ScheduledMessage{}).
+		Where("id = ? AND status = ?", scheduled.ID, ScheduledMessagePending).
+		Update("status", ScheduledMessageCancelled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrScheduledMessageNotPending
+	}
+	scheduled.Status = ScheduledMessageCancelled
+	return nil
+}
+
+// ClaimScheduledMessage moves a pending message that is due at now to
+// sending, or reclaims one whose claim timed out. It returns
+// ErrScheduledMessageNotPending when the message was cancelled, rescheduled
+// to later, already delivered or is being delivered.
+func ClaimScheduledMessage(db *gorm.DB, uuid string, now time.Time) (*ScheduledMessage, error) {
+	result := db.Model(&ScheduledMessage{}).
+		Where("uuid = ? AND send_at <= ?", uuid, now.Add(ScheduledMessageClockSkew)).
+		Where("status = ? OR (status = ? AND updated_at < ?)", ScheduledMessagePending, ScheduledMessageSending, now.Add(-ScheduledMessageClaimTimeout)).
+		Updates(map[string]interface{}{"status": ScheduledMessageSending, "updated_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrScheduledMessageNotPending
+	}
+	var scheduled ScheduledMessage
+	if err := db.Preload("Chat").Preload("Sender").Where("uuid = ?", uuid).First(&scheduled).Error; err != nil {
+		return nil, err
+	}
+	return &scheduled, nil
+}
+
+// FinishScheduledMessage records the outcome of delivering a claimed message,
+// the created message or the reason it could not be sent.
+func FinishScheduledMessage(db *gorm.DB, scheduled *ScheduledMessage, messageID *uint, deliveryErr error, now time.Time) error {
+	updates := map[string]interface{}{"status": ScheduledMessageSent, "message_id": messageID, "sent_at": now}
+	if deliveryErr != nil {
+		updates = map[string]interface{}{"status": ScheduledMessageFailed, "error": deliveryErr.Error()}
+	}
+	return db.Model(scheduled).Updates(updates).Error
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-sync
+// Incremental sync hands out an opaque token that holds a (changed at, id)
+// keyset cursor per synced table. A row's changed at is its deletion time for
+// soft-deleted rows and its update time otherwise, so a sync returns every
+// message and chat created, edited or deleted after the token, and every
+// chat the user left or was removed from, keyed on the deletion time of the
+// soft-deleted participant row. Rows changed
+// within SyncSettleDelay are left for the next sync to not skip rows of
+// transactions that commit after a newer row.
+var SyncSettleDelay = time.Second
+
+const (
+	DefaultSyncLimit = 200
+	MaxSyncLimit     = 1000
+)
+
+// syncChangedAtSQL is the SQL counterpart of syncChangedAt.
+const syncChangedAtSQL = "(CASE WHEN deleted_at IS NOT NULL AND deleted_at > updated_at THEN deleted_at ELSE updated_at END)"
+
+type SyncCursor struct {
+	ChangedAt int64 `json:"t"`
+	ID        uint  `json:"id"`
+}
+
+type SyncToken struct {
+	Messages SyncCursor `json:"m"`
+	Chats    SyncCursor `json:"c"`
+	Removals SyncCursor `json:"r"`
+}
+
+func (t SyncToken) Encode() string {
+	encoded, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// DecodeSyncToken reads a token issued by Encode, an empty token syncs from
+// the beginning.
+func DecodeSyncToken(token string) (SyncToken, error) {
+	var decoded SyncToken
+	if token == "" {
+		return decoded, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return decoded, fmt.Errorf("invalid sync token")
+	}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return decoded, fmt.Errorf("invalid sync token")
+	}
+	return decoded, nil
+}
+
+// SyncChanges is one page of changes. Deleted rows are included with their
+// DeletedAt set, Removals are the user's removed participant rows.
+type SyncChanges struct {
+	Messages []Message
+	Chats    []Chat
+	Removals []ChatParticipant
+	Token    SyncToken
+	HasMore  bool
+}
+
+func syncChangedAt(model Model) time.Time {
+	if model.DeletedAt.Valid && model.DeletedAt.Time.After(model.UpdatedAt) {
+		return model.DeletedAt.Time
+	}
+	return model.UpdatedAt
+}
+
+func syncAfter(cursor SyncCursor, settle time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		since := time.Unix(0, cursor.ChangedAt)
+		return db.Where(syncChangedAtSQL+" <= ?", settle).
+			Where(syncChangedAtSQL+" > ? OR ("+syncChangedAtSQL+" = ? AND id > ?)", since, since, cursor.ID).
+			Order(syncChangedAtSQL + " asc, id asc")
+	}
+}
+
+// SyncChangesSince returns the messages and chats visible to the user that
+// changed after token and the chats the user was removed from since, at most
+// limit of each, oldest changes first. Message
+// visibility follows the chat handlers: messages of chats the user
+// participates in, for two-party chats only the ones sent to or by the user.
+func SyncChangesSince(db *gorm.DB, userID uint, token SyncToken, limit int, now time.Time) (*SyncChanges, error) {
+	if limit <= 0 {
+		limit = DefaultSyncLimit
+	}
+	if limit > MaxSyncLimit {
+		limit = MaxSyncLimit
+	}
+	settle := now.Add(-SyncSettleDelay)
+	changes := &SyncChanges{Token: token}
+
+	messages := []Message{}
+	if err := db.Unscoped().
+		Where("chat_id IN (SELECT chat_id FROM chat_participants WHERE user_id = ? AND deleted_at IS NULL)", userID).
+		Where("chat_id IN (SELECT id FROM chats WHERE chat_type = ?) OR sender_id = ? OR receiver_id = ?", ChatTypeGroup, userID, userID).
+		Scopes(syncAfter(token.Messages, settle)).
+		Limit(limit + 1).
+		Preload("Sender").
+		Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		changes.HasMore = true
+	}
+	if len(messages) > 0 {
+		last := messages[len(messages)-1].Model
+		changes.Token.Messages = SyncCursor{ChangedAt: syncChangedAt(last).UnixNano(), ID: last.ID}
+	}
+	changes.Messages = messages
+
+	chats := []Chat{}
+	if err := db.Unscoped().
+		Scopes(ChatParticipantScope(userID), syncAfter(token.Chats, settle)).
+		Limit(limit + 1).
+		Preload("User1").
+		Preload("User2").
+		Preload("SharedConfig").
+		Preload("LatestMessage").
+		Preload("Participants.User").
+		Find(&chats).Error; err != nil {
+		return nil, err
+	}
+	if len(chats) > limit {
+		chats = chats[:limit]
+		changes.HasMore = true
+	}
+	if len(chats) > 0 {
+		last := chats[len(chats)-1].Model
+		changes.Token.Chats = SyncCursor{ChangedAt: syncChangedAt(last).UnixNano(), ID: last.ID}
+	}
+	changes.Chats = chats
+
+	removals := []ChatParticipant{}
+	if err := db.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Scopes(syncAfter(token.Removals, settle)).
+		Limit(limit + 1).
+		Find(&removals).Error; err != nil {
+		return nil, err
+	}
+	if len(removals) > limit {
+		removals = removals[:limit]
+		changes.HasMore = true
+	}
+	if len(removals) > 0 {
+		last := removals[len(removals)-1].Model
+		changes.Token.Removals = SyncCursor{ChangedAt: syncChangedAt(last).UnixNano(), ID: last.ID}
+	}
+	changes.Removals = removals
+
+	return changes, nil
+}
+
+// ChatUUIDsByID resolves chat ids to UUIDs, deleted chats included.
+func ChatUUIDsByID(db *gorm.DB, chatIDs []uint) (map[uint]string, error) {
+	uuids := map[uint]string{}
+	if len(chatIDs) == 0 {
+		return uuids, nil
+	}
+	rows := []struct {
+		ID   uint
+		UUID string
+	}{}
+	if err := db.Unscoped().Model(&Chat{}).Select("id", "uuid").Where("id IN ?", chatIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		uuids[row.ID] = row.UUID
+	}
+	return uuids, nil
+}
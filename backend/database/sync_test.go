@@ -0,0 +1,123 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyncChangesSinceReturnsCreatedEditedAndDeletedRows(t *testing.T) {
+	DB := SetupDatabase(DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "sync.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+	previousDelay := SyncSettleDelay
+	SyncSettleDelay = 0
+	t.Cleanup(func() { SyncSettleDelay = previousDelay })
+
+	alice := User{Name: "alice", Email: "alice@example.invalid", Username: "alice"}
+	bob := User{Name: "bob", Email: "bob@example.invalid", Username: "bob"}
+	carol := User{Name: "carol", Email: "carol@example.invalid", Username: "carol"}
+	for _, record := range []*User{&alice, &bob, &carol} {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatalf("failed creating user: %v", err)
+		}
+	}
+	chat := Chat{User1Id: alice.ID, User2Id: bob.ID}
+	otherChat := Chat{User1Id: bob.ID, User2Id: carol.ID}
+	for _, record := range []*Chat{&chat, &otherChat} {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatalf("failed creating chat: %v", err)
+		}
+	}
+
+	messages := make([]Message, 3)
+	for i := range messages {
+		text := "hello"
+		messages[i] = Message{ChatId: chat.ID, SenderId: bob.ID, ReceiverId: alice.ID, Text: &text}
+		if err := DB.Create(&messages[i]).Error; err != nil {
+			t.Fatalf("failed creating message: %v", err)
+		}
+	}
+	hidden := "not for alice"
+	if err := DB.Create(&Message{ChatId: otherChat.ID, SenderId: bob.ID, ReceiverId: carol.ID, Text: &hidden}).Error; err != nil {
+		t.Fatalf("failed creating message: %v", err)
+	}
+
+	changes, err := SyncChangesSince(DB, alice.ID, SyncToken{}, 2, time.Now())
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if !changes.HasMore || len(changes.Messages) != 2 || len(changes.Chats) != 1 || changes.Chats[0].ID != chat.ID {
+		t.Fatalf("expected the first page of alice's changes, got %d messages, %d chats, more %v", len(changes.Messages), len(changes.Chats), changes.HasMore)
+	}
+
+	token, err := DecodeSyncToken(changes.Token.Encode())
+	if err != nil || token != changes.Token {
+		t.Fatalf("expected the token to round trip, got %+v (%v)", token, err)
+	}
+	changes, err = SyncChangesSince(DB, alice.ID, token, 2, time.Now())
+	if err != nil || changes.HasMore || len(changes.Messages) != 1 || changes.Messages[0].ID != messages[2].ID || len(changes.Chats) != 0 {
+		t.Fatalf("expected the remaining message, got %+v (%v)", changes, err)
+	}
+	token = changes.Token
+
+	changes, err = SyncChangesSince(DB, alice.ID, token, 10, time.Now())
+	if err != nil || len(changes.Messages) != 0 || len(changes.Chats) != 0 {
+		t.Fatalf("expected no changes, got %+v (%v)", changes, err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	edited := "hello again"
+	if err := DB.Model(&messages[0]).Update("text", edited).Error; err != nil {
+		t.Fatalf("failed editing message: %v", err)
+	}
+	if err := DB.Delete(&messages[1]).Error; err != nil {
+		t.Fatalf("failed deleting message: %v", err)
+	}
+	changes, err = SyncChangesSince(DB, alice.ID, token, 10, time.Now())
+	if err != nil || len(changes.Messages) != 2 {
+		t.Fatalf("expected the edited and the deleted message, got %+v (%v)", changes, err)
+	}
+	if changes.Messages[0].ID != messages[0].ID || changes.Messages[0].DeletedAt.Valid {
+		t.Fatalf("expected the edit first, got %+v", changes.Messages[0])
+	}
+	if changes.Messages[1].ID != messages[1].ID || !changes.Messages[1].DeletedAt.Valid {
+		t.Fatalf("expected the deletion last, got %+v", changes.Messages[1])
+	}
+
+	changes, err = SyncChangesSince(DB, alice.ID, token, 10, time.Now().Add(-time.Hour))
+	if err != nil || len(changes.Messages) != 0 {
+		t.Fatalf("expected unsettled changes to wait, got %+v (%v)", changes, err)
+	}
+
+	// Leaving a chat is synced with its removal time, even though its
+	// messages and the chat itself are no longer visible
+	if err := RemoveChatParticipant(DB, chat.ID, alice.ID); err != nil {
+		t.Fatalf("failed removing participant: %v", err)
+	}
+	changes, err = SyncChangesSince(DB, alice.ID, SyncToken{}, 10, time.Now())
+	if err != nil || len(changes.Removals) != 1 || changes.Removals[0].ChatId != chat.ID || !changes.Removals[0].DeletedAt.Valid {
+		t.Fatalf("expected the removal from the chat, got %+v (%v)", changes, err)
+	}
+	if len(changes.Chats) != 0 || len(changes.Messages) != 0 {
+		t.Fatalf("expected the chat to be hidden after leaving, got %+v", changes)
+	}
+	changes, err = SyncChangesSince(DB, alice.ID, changes.Token, 10, time.Now())
+	if err != nil || len(changes.Removals) != 0 {
+		t.Fatalf("expected the removal to be synced once, got %+v (%v)", changes, err)
+	}
+	if err := EnsureChatParticipant(DB, chat.ID, alice.ID, ChatParticipantRoleMember); err != nil {
+		t.Fatalf("failed re-adding participant: %v", err)
+	}
+	changes, err = SyncChangesSince(DB, alice.ID, SyncToken{}, 10, time.Now())
+	if err != nil || len(changes.Removals) != 0 || len(changes.Chats) != 1 {
+		t.Fatalf("expected the re-added chat without removals, got %+v (%v)", changes, err)
+	}
+
+	if _, err := DecodeSyncToken("not a token"); err == nil {
+		t.Fatalf("expected an invalid token error")
+	}
+}
@@ -222,6 +222,7 @@ var Tabels []interface{} = []interface{}{
 	&PublicProfile{},
 	&Contact{},
 	&Chat{},
+	&ChatParticipant{},
 	&SharedChatConfig{},
 	&ChatSettings{},
 	&SharedChatInstance{},
@@ -236,6 +237,20 @@ var Tabels []interface{} = []interface{}{
 	&Permission{},
 	&AccessToken{},
 	&IntegrationAccess{},
+	&TokenUsageEntry{},
+	&TokenQuota{},
+	&ChatSummary{},
+	&MessageRevision{},
+	&MessageReaction{},
+	&MessageRating{},
+	&MessageReadReceipt{},
+	&ScheduledMessage{},
+	&BotSchedule{},
+	&BotScheduleRun{},
+	&RetentionPolicy{},
+	&ChatExport{},
+	&TusUpload{},
+	&UploadLimit{},
 }
 
 var Migrations []Migration = []Migration{
@@ -248,6 +263,8 @@ var Migrations []Migration = []Migration{
 	ChatAndMessageMigration{}, // Migrates: 'Chat', 'SharedChatConfig', 'Message'
 	TableMigration{&ChatSettings{}},
 	TableMigration{&SharedChatInstance{}},
+	TableMigration{&ChatParticipant{}},
+	BackfillChatParticipantsMigration{},
 	FileUploadMigration{},
 	TableMigration{&ToolInitData{}},
 	TableMigration{&TaskResult{}},
@@ -259,6 +276,22 @@ var Migrations []Migration = []Migration{
 	TableMigration{&Permission{}},
 	TableMigration{&AccessToken{}},
 	TableMigration{&IntegrationAccess{}},
+	TableMigration{&TokenUsageEntry{}},
+	TableMigration{&TokenQuota{}},
+	TableMigration{&ChatSummary{}},
+	MessageSearchMigration{},
+	TableMigration{&MessageRevision{}},
+	BackfillMessageParentsMigration{},
+	TableMigration{&MessageReaction{}},
+	TableMigration{&MessageRating{}},
+	TableMigration{&MessageReadReceipt{}},
+	TableMigration{&ScheduledMessage{}},
+	TableMigration{&BotSchedule{}},
+	TableMigration{&BotScheduleRun{}},
+	TableMigration{&RetentionPolicy{}},
+	TableMigration{&ChatExport{}},
+	TableMigration{&TusUpload{}},
+	TableMigration{&UploadLimit{}},
 	GrantDefaultPermissionsMigration{},
 }
 
@@ -0,0 +1,349 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TokenQuotaSubjectUser = "user"
+	TokenQuotaSubjectBot  = "bot"
+)
+
+// tokenUsageDayLayout is the layout of TokenUsageEntry.Day, kept as a string
+// so usage can be grouped by day the same way on sqlite and postgres.
+const tokenUsageDayLayout = "2006-01-02"
+
+// @doc:open-chat-token-usage-ledger
+// TokenUsageEntry is one row of the token usage ledger. A row is written for
+// every completion a bot streams, so tool loops that call the provider
+// several times for one reply are accounted per provider round. UserId is the
+// user the bot replied to, BotUserId the replying bot. Cost is priced from the
+// model config when the entry is written, so later price changes do not
+// rewrite past spend.
+type TokenUsageEntry struct {
+	Model
+	UserId             uint    `json:"-" gorm:"index"`
+	BotUserId          uint    `json:"-" gorm:"index"`
+	ChatUUID           string  `json:"chat_uuid" gorm:"index"`
+	Backend            string  `json:"backend" gorm:"index"`
+	ModelName          string  `json:"model" gorm:"index"`
+	PromptTokens       int64   `json:"prompt_tokens"`
+	CompletionTokens   int64   `json:"completion_tokens"`
+	TotalTokens        int64   `json:"total_tokens"`
+	CachedPromptTokens int64   `json:"cached_prompt_tokens" gorm:"default:0"`
+	Cost               float64 `json:"cost" gorm:"default:0"`
+	Day                string  `json:"day" gorm:"type:varchar(10);index"`
+}
+
+// TokenQuota limits the tokens a user may cause or a bot may spend, and the
+// monthly cost of them. Limits of 0 are unlimited. Days and months are UTC.
+type TokenQuota struct {
+	Model
+	Subject string `json:"subject" gorm:"type:varchar(16);uniqueIndex:idx_token_quota_subject"`
+	UserId  uint   `json:"-" gorm:"uniqueIndex:idx_token_quota_subject"`
+	User    User   `json:"-" gorm:"foreignKey:UserId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	TokenQuotaLimits
+}
+
+type TokenQuotaLimits struct {
+	DailyTokens   int64   `json:"daily_tokens"`
+	MonthlyTokens int64   `json:"monthly_tokens"`
+	MonthlyBudget float64 `json:"monthly_budget" gorm:"default:0"`
+}
+
+// TokenQuotaExceededError is returned by CheckTokenQuotas when a quota is used up.
+type TokenQuotaExceededError struct {
+	Subject string
+	Period  string
+	Limit   int64
+	Used    int64
+}
+
+func (e *TokenQuotaExceededError) Error() string {
+	return fmt.Sprintf("%s token quota of the %s exceeded (%d of %d tokens used)", e.Period, e.Subject, e.Used, e.Limit)
+}
+
+// TokenBudgetExceededError is returned by CheckTokenQuotas when the monthly
+// budget is spent.
+type TokenBudgetExceededError struct {
+	Subject string
+	Budget  float64
+	Spent   float64
+}
+
+func (e *TokenBudgetExceededError) Error() string {
+	return fmt.Sprintf("monthly budget of the %s exceeded (%.4f of %.4f spent)", e.Subject, e.Spent, e.Budget)
+}
+
+type TokenUsageFilter struct {
+	UserId    uint
+	BotUserId uint
+	ChatUUID  string
+	ModelName string
+	// FromDay and ToDay are inclusive YYYY-MM-DD bounds, empty means open.
+	FromDay string
+	ToDay   string
+}
+
+type TokenUsageSummary struct {
+	Key              string  `json:"key" gorm:"column:group_key"`
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func normalizeTokenQuotaSubject(subject string) string {
+	return strings.ToLower(strings.TrimSpace(subject))
+}
+
+func IsValidTokenQuotaSubject(subject string) bool {
+	switch normalizeTokenQuotaSubject(subject) {
+	case TokenQuotaSubjectUser, TokenQuotaSubjectBot:
+		return true
+	}
+	return false
+}
+
+// TokenUsageDay returns the ledger day of a point in time.
+func TokenUsageDay(t time.Time) string {
+	return t.UTC().Format(tokenUsageDayLayout)
+}
+
+func IsValidTokenUsageDay(day string) bool {
+	_, err := time.Parse(tokenUsageDayLayout, day)
+	return err == nil
+}
+
+// RecordTokenUsage writes a ledger entry, deriving the day and a missing
+// total from the entry itself.
+func RecordTokenUsage(db *gorm.DB, entry TokenUsageEntry) error {
+	if entry.TotalTokens == 0 {
+		entry.TotalTokens = entry.PromptTokens + entry.CompletionTokens
+	}
+	if entry.Day == "" {
+		entry.Day = TokenUsageDay(time.Now())
+	}
+	return db.Create(&entry).Error
+}
+
+func applyTokenUsageFilter(query *gorm.DB, filter TokenUsageFilter) *gorm.DB {
+	if filter.UserId != 0 {
+		query = query.Where("token_usage_entries.user_id = ?", filter.UserId)
+	}
+	if filter.BotUserId != 0 {
+		query = query.Where("token_usage_entries.bot_user_id = ?", filter.BotUserId)
+	}
+	if filter.ChatUUID != "" {
+		query = query.Where("token_usage_entries.chat_uuid = ?", filter.ChatUUID)
+	}
+	if filter.ModelName != "" {
+		query = query.Where("token_usage_entries.model_name = ?", filter.ModelName)
+	}
+	if filter.FromDay != "" {
+		query = query.Where("token_usage_entries.day >= ?", filter.FromDay)
+	}
+	if filter.ToDay != "" {
+		query = query.Where("token_usage_entries.day <= ?", filter.ToDay)
+	}
+	return query
+}
+
+// SummarizeTokenUsage sums the ledger grouped by "user", "bot", "chat",
+// "model" or "day". Users and bots are keyed by their UUID. Rows are ordered
+// by key.
+func SummarizeTokenUsage(db *gorm.DB, filter TokenUsageFilter, groupBy string) ([]TokenUsageSummary, error) {
+	query := applyTokenUsageFilter(db.Model(&TokenUsageEntry{}), filter)
+
+	keyColumn := ""
+	switch groupBy {
+	case "user":
+		query = query.Joins("LEFT JOIN users AS grouped_users ON grouped_users.id = token_usage_entries.user_id")
+		keyColumn = "grouped_users.uuid"
+	case "bot":
+		query = query.Joins("LEFT JOIN users AS grouped_users ON grouped_users.id = token_usage_entries.bot_user_id")
+		keyColumn = "grouped_users.uuid"
+	case "chat":
+		keyColumn = "token_usage_entries.chat_uuid"
+	case "model":
+		keyColumn = "token_usage_entries.model_name"
+	case "day":
+		keyColumn = "token_usage_entries.day"
+	default:
+		return nil, fmt.Errorf("unsupported usage grouping %q", groupBy)
+	}
+
+	rows := []TokenUsageSummary{}
+	err := query.Select(fmt.Sprintf(
+		"%s AS group_key, COUNT(*) AS requests, SUM(token_usage_entries.prompt_tokens) AS prompt_tokens, "+
+			"SUM(token_usage_entries.completion_tokens) AS completion_tokens, SUM(token_usage_entries.total_tokens) AS total_tokens, "+
+			"SUM(token_usage_entries.cost) AS cost",
+		keyColumn,
+	)).Group(keyColumn).Order(keyColumn + " asc").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func tokenQuotaSubjectFilter(subject string, userID uint, fromDay string) (TokenUsageFilter, error) {
+	filter := TokenUsageFilter{FromDay: fromDay}
+	switch normalizeTokenQuotaSubject(subject) {
+	case TokenQuotaSubjectUser:
+		filter.UserId = userID
+	case TokenQuotaSubjectBot:
+		filter.BotUserId = userID
+	default:
+		return filter, fmt.Errorf("unsupported quota subject %q", subject)
+	}
+	return filter, nil
+}
+
+// TokenUsageSince sums the total tokens of a quota subject from the given day on.
+func TokenUsageSince(db *gorm.DB, subject string, userID uint, fromDay string) (int64, error) {
+	filter, err := tokenQuotaSubjectFilter(subject, userID, fromDay)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	err = applyTokenUsageFilter(db.Model(&TokenUsageEntry{}), filter).
+		Select("COALESCE(SUM(token_usage_entries.total_tokens), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+// TokenSpendSince sums the cost of a quota subject from the given day on.
+func TokenSpendSince(db *gorm.DB, subject string, userID uint, fromDay string) (float64, error) {
+	filter, err := tokenQuotaSubjectFilter(subject, userID, fromDay)
+	if err != nil {
+		return 0, err
+	}
+
+	var total float64
+	err = applyTokenUsageFilter(db.Model(&TokenUsageEntry{}), filter).
+		Select("COALESCE(SUM(token_usage_entries.cost), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+func GetTokenQuota(db *gorm.DB, subject string, userID uint) (*TokenQuota, error) {
+	var quota TokenQuota
+	err := db.Where("subject = ? AND user_id = ?", normalizeTokenQuotaSubject(subject), userID).First(&quota).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// SetTokenQuota creates or replaces the quota of a user or bot.
+func SetTokenQuota(db *gorm.DB, subject string, userID uint, limits TokenQuotaLimits) (*TokenQuota, error) {
+	subject = normalizeTokenQuotaSubject(subject)
+	if !IsValidTokenQuotaSubject(subject) {
+		return nil, fmt.Errorf("unsupported quota subject %q", subject)
+	}
+	if userID == 0 {
+		return nil, errors.New("quota user is required")
+	}
+	if limits.DailyTokens < 0 || limits.MonthlyTokens < 0 || limits.MonthlyBudget < 0 {
+		return nil, errors.New("quota limits must not be negative")
+	}
+
+	quota := TokenQuota{Subject: subject, UserId: userID}
+	if err := db.Where("subject = ? AND user_id = ?", subject, userID).FirstOrCreate(&quota).Error; err != nil {
+		return nil, err
+	}
+	quota.TokenQuotaLimits = limits
+	if err := db.Model(&quota).Updates(map[string]interface{}{
+		"daily_tokens":   limits.DailyTokens,
+		"monthly_tokens": limits.MonthlyTokens,
+		"monthly_budget": limits.MonthlyBudget,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+func DeleteTokenQuota(db *gorm.DB, subject string, userID uint) error {
+	return db.Unscoped().Where("subject = ? AND user_id = ?", normalizeTokenQuotaSubject(subject), userID).Delete(&TokenQuota{}).Error
+}
+
+// CheckTokenQuotas returns a *TokenQuotaExceededError when the daily or
+// monthly quota of the user or of the bot is used up at the given time, or a
+// *TokenBudgetExceededError when the monthly budget of either is spent.
+func CheckTokenQuotas(db *gorm.DB, userID, botUserID uint, now time.Time) error {
+	subjects := []struct {
+		subject string
+		userID  uint
+	}{
+		{TokenQuotaSubjectUser, userID},
+		{TokenQuotaSubjectBot, botUserID},
+	}
+
+	now = now.UTC()
+	today := TokenUsageDay(now)
+	monthStart := TokenUsageDay(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC))
+
+	for _, subject := range subjects {
+		if subject.userID == 0 {
+			continue
+		}
+		quota, err := GetTokenQuota(db, subject.subject, subject.userID)
+		if err != nil {
+			return err
+		}
+		if quota == nil {
+			continue
+		}
+
+		periods := []struct {
+			name    string
+			limit   int64
+			fromDay string
+		}{
+			{"daily", quota.DailyTokens, today},
+			{"monthly", quota.MonthlyTokens, monthStart},
+		}
+		for _, period := range periods {
+			if period.limit <= 0 {
+				continue
+			}
+			used, err := TokenUsageSince(db, subject.subject, subject.userID, period.fromDay)
+			if err != nil {
+				return err
+			}
+			if used >= period.limit {
+				return &TokenQuotaExceededError{
+					Subject: subject.subject,
+					Period:  period.name,
+					Limit:   period.limit,
+					Used:    used,
+				}
+			}
+		}
+
+		if quota.MonthlyBudget > 0 {
+			spent, err := TokenSpendSince(db, subject.subject, subject.userID, monthStart)
+			if err != nil {
+				return err
+			}
+			if spent >= quota.MonthlyBudget {
+				return &TokenBudgetExceededError{
+					Subject: subject.subject,
+					Budget:  quota.MonthlyBudget,
+					Spent:   spent,
+				}
+			}
+		}
+	}
+	return nil
+}
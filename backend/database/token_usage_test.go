@@ -0,0 +1,158 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTokenUsageLedgerAndQuotas(t *testing.T) {
+	DB := SetupDatabase(DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "token_usage.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+
+	sender := User{Name: "sender", Email: "sender@example.invalid", Username: "sender"}
+	bot := User{Name: "bot", Email: "bot@example.invalid", Username: "bot", IsAutomated: true}
+	for _, user := range []*User{&sender, &bot} {
+		if err := DB.Create(user).Error; err != nil {
+			t.Fatalf("failed creating user: %v", err)
+		}
+	}
+
+	now := time.Date(2026, time.March, 15, 12, 0, 0, 0, time.UTC)
+	entries := []TokenUsageEntry{
+		{UserId: sender.ID, BotUserId: bot.ID, ModelName: "gpt-4o", PromptTokens: 300, CompletionTokens: 100, Day: "2026-03-15"},
+		{UserId: sender.ID, BotUserId: bot.ID, ModelName: "claude-sonnet-4-5", PromptTokens: 200, CompletionTokens: 100, Day: "2026-03-15"},
+		{UserId: sender.ID, BotUserId: bot.ID, ModelName: "gpt-4o", PromptTokens: 500, CompletionTokens: 500, Day: "2026-03-02"},
+		{UserId: sender.ID, BotUserId: bot.ID, ModelName: "gpt-4o", PromptTokens: 5000, CompletionTokens: 5000, Day: "2026-02-28"},
+	}
+	for _, entry := range entries {
+		if err := RecordTokenUsage(DB, entry); err != nil {
+			t.Fatalf("failed recording usage: %v", err)
+		}
+	}
+
+	byModel, err := SummarizeTokenUsage(DB, TokenUsageFilter{FromDay: "2026-03-01"}, "model")
+	if err != nil {
+		t.Fatalf("summarize failed: %v", err)
+	}
+	if len(byModel) != 2 || byModel[0].Key != "claude-sonnet-4-5" || byModel[0].TotalTokens != 300 ||
+		byModel[1].Key != "gpt-4o" || byModel[1].TotalTokens != 1400 || byModel[1].Requests != 2 {
+		t.Fatalf("unexpected usage by model: %+v", byModel)
+	}
+
+	byBot, err := SummarizeTokenUsage(DB, TokenUsageFilter{UserId: sender.ID}, "bot")
+	if err != nil {
+		t.Fatalf("summarize failed: %v", err)
+	}
+	if len(byBot) != 1 || byBot[0].Key != bot.UUID || byBot[0].TotalTokens != 11700 {
+		t.Fatalf("unexpected usage by bot: %+v", byBot)
+	}
+
+	if _, err := SummarizeTokenUsage(DB, TokenUsageFilter{}, "backend"); err == nil {
+		t.Fatalf("expected an unsupported grouping to fail")
+	}
+
+	if err := CheckTokenQuotas(DB, sender.ID, bot.ID, now); err != nil {
+		t.Fatalf("expected no quota to allow replies, got %v", err)
+	}
+
+	if _, err := SetTokenQuota(DB, TokenQuotaSubjectUser, sender.ID, TokenQuotaLimits{DailyTokens: 1000}); err != nil {
+		t.Fatalf("set quota failed: %v", err)
+	}
+	if err := CheckTokenQuotas(DB, sender.ID, bot.ID, now); err != nil {
+		t.Fatalf("expected 700 of 1000 daily tokens to pass, got %v", err)
+	}
+
+	if _, err := SetTokenQuota(DB, TokenQuotaSubjectBot, bot.ID, TokenQuotaLimits{MonthlyTokens: 1500}); err != nil {
+		t.Fatalf("set quota failed: %v", err)
+	}
+	var quotaErr *TokenQuotaExceededError
+	err = CheckTokenQuotas(DB, sender.ID, bot.ID, now)
+	if !errors.As(err, &quotaErr) || quotaErr.Subject != TokenQuotaSubjectBot || quotaErr.Period != "monthly" || quotaErr.Used != 1700 {
+		t.Fatalf("expected the bot's monthly quota to be exceeded, got %v", err)
+	}
+
+	if err := DeleteTokenQuota(DB, TokenQuotaSubjectBot, bot.ID); err != nil {
+		t.Fatalf("delete quota failed: %v", err)
+	}
+	if _, err := SetTokenQuota(DB, TokenQuotaSubjectUser, sender.ID, TokenQuotaLimits{DailyTokens: 700}); err != nil {
+		t.Fatalf("replacing quota failed: %v", err)
+	}
+	err = CheckTokenQuotas(DB, sender.ID, bot.ID, now)
+	if !errors.As(err, &quotaErr) || quotaErr.Subject != TokenQuotaSubjectUser || quotaErr.Period != "daily" {
+		t.Fatalf("expected the user's daily quota to be exceeded, got %v", err)
+	}
+	if err := CheckTokenQuotas(DB, sender.ID, bot.ID, now.AddDate(0, 0, 1)); err != nil {
+		t.Fatalf("expected the daily quota to reset the next day, got %v", err)
+	}
+}
+
+func TestModelPricingAndMonthlyBudget(t *testing.T) {
+	DB := SetupDatabase(DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "token_spend.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+
+	createDefaultModelForTest(t, DB, "priced-model", "openai", nil,
+		`{"model":"priced-model","backend":"openai","input_cost_per_token":0.000002,"output_cost_per_token":0.00001,"cached_input_cost_per_token":0.0000005}`)
+	createDefaultModelForTest(t, DB, "free-model", "ollama", nil, "")
+
+	pricing, err := ResolveModelPricing(DB, "", "priced-model")
+	if err != nil {
+		t.Fatalf("resolve pricing failed: %v", err)
+	}
+	// 600 uncached and 400 cached prompt tokens plus 100 completion tokens
+	if cost := pricing.Cost(1000, 400, 100); cost < 0.0024-1e-12 || cost > 0.0024+1e-12 {
+		t.Fatalf("unexpected cost %v", cost)
+	}
+	if pricing, err := ResolveModelPricing(DB, "", "free-model"); err != nil || !pricing.IsZero() {
+		t.Fatalf("expected a model without prices to be free, got %+v (%v)", pricing, err)
+	}
+	if _, err := ModelPricingFromConfig(map[string]interface{}{"input_cost_per_token": "cheap"}); err == nil {
+		t.Fatalf("expected a non-numeric price to be rejected")
+	}
+
+	user := User{Name: "spender", Email: "spender@example.invalid", Username: "spender"}
+	if err := DB.Create(&user).Error; err != nil {
+		t.Fatalf("failed creating user: %v", err)
+	}
+	now := time.Date(2026, time.April, 20, 8, 0, 0, 0, time.UTC)
+	for _, entry := range []TokenUsageEntry{
+		{UserId: user.ID, ChatUUID: "chat-a", ModelName: "priced-model", PromptTokens: 10, Cost: 1.5, Day: "2026-04-02"},
+		{UserId: user.ID, ChatUUID: "chat-b", ModelName: "priced-model", PromptTokens: 10, Cost: 2.25, Day: "2026-04-19"},
+		{UserId: user.ID, ChatUUID: "chat-b", ModelName: "priced-model", PromptTokens: 10, Cost: 40, Day: "2026-03-31"},
+	} {
+		if err := RecordTokenUsage(DB, entry); err != nil {
+			t.Fatalf("failed recording usage: %v", err)
+		}
+	}
+
+	byChat, err := SummarizeTokenUsage(DB, TokenUsageFilter{UserId: user.ID, FromDay: "2026-04-01"}, "chat")
+	if err != nil {
+		t.Fatalf("summarize failed: %v", err)
+	}
+	if len(byChat) != 2 || byChat[0].Key != "chat-a" || byChat[0].Cost != 1.5 || byChat[1].Key != "chat-b" || byChat[1].Cost != 2.25 {
+		t.Fatalf("unexpected spend by chat: %+v", byChat)
+	}
+
+	if _, err := SetTokenQuota(DB, TokenQuotaSubjectUser, user.ID, TokenQuotaLimits{MonthlyBudget: 5}); err != nil {
+		t.Fatalf("set quota failed: %v", err)
+	}
+	if err := CheckTokenQuotas(DB, user.ID, 0, now); err != nil {
+		t.Fatalf("expected 3.75 of a 5 budget to pass, got %v", err)
+	}
+	if _, err := SetTokenQuota(DB, TokenQuotaSubjectUser, user.ID, TokenQuotaLimits{MonthlyBudget: 3}); err != nil {
+		t.Fatalf("set quota failed: %v", err)
+	}
+	var budgetErr *TokenBudgetExceededError
+	if err := CheckTokenQuotas(DB, user.ID, 0, now); !errors.As(err, &budgetErr) || budgetErr.Spent != 3.75 {
+		t.Fatalf("expected the monthly budget to be exceeded, got %v", err)
+	}
+}
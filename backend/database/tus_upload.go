@@ -0,0 +1,139 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// @doc:open-chat-tus-uploads
+// Large attachments are uploaded with the tus 1.0 resumable upload protocol.
+// A TusUpload tracks one upload: its declared length, the offset received so
+// far and the partial file the chunks are appended to on the server that
+// received them. Once the last chunk arrives the file is moved to the storage
+// backend and recorded as an UploadedFile whose FileID is the upload UUID.
+// Uploads without progress for TusUploadTTL expire and a periodic task
+// removes them with their partial files. The size of a single upload is
+// limited per user by an UploadLimit, falling back to DefaultMaxUploadSize.
+
+const (
+	// DefaultMaxUploadSize limits resumable uploads of users without an UploadLimit.
+	DefaultMaxUploadSize int64 = 256 << 20
+
+	// TusUploadTTL is how long an upload may go without receiving a chunk.
+	TusUploadTTL = 24 * time.Hour
+)
+
+var ErrTusOffsetMismatch = errors.New("upload offset does not match")
+
+type TusUpload struct {
+	Model
+	OwnerId     uint       `json:"-" gorm:"index"`
+	Owner       User       `json:"-" gorm:"foreignKey:OwnerId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	FileName    string     `json:"file_name"`
+	MIMEType    string     `json:"mime_type"`
+	Length      int64      `json:"length" gorm:"column:upload_length"`
+	Offset      int64      `json:"offset" gorm:"column:upload_offset"`
+	PartialPath string     `json:"-"`
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"index"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// UploadLimit overrides DefaultMaxUploadSize for a user.
+type UploadLimit struct {
+	Model
+	UserId        uint  `json:"-" gorm:"uniqueIndex"`
+	User          User  `json:"-" gorm:"foreignKey:UserId;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	MaxUploadSize int64 `json:"max_upload_size"`
+}
+
+func (u TusUpload) Expired(now time.Time) bool {
+	return now.After(u.ExpiresAt)
+}
+
+// MaxUploadSize returns the largest upload a user may create.
+func MaxUploadSize(db *gorm.DB, userID uint) (int64, error) {
+	var limit UploadLimit
+	err := db.Where("user_id = ?", userID).First(&limit).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefaultMaxUploadSize, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return limit.MaxUploadSize, nil
+}
+
+// SetUploadLimit creates or replaces the upload size limit of a user.
+func SetUploadLimit(db *gorm.DB, userID uint, maxUploadSize int64) (*UploadLimit, error) {
+	if userID == 0 {
+		return nil, errors.New("upload limit user is required")
+	}
+	if maxUploadSize <= 0 {
+		return nil, errors.New("max_upload_size must be positive")
+	}
+	limit := UploadLimit{UserId: userID}
+	if err := db.Where("user_id = ?", userID).FirstOrCreate(&limit).Error; err != nil {
+		return nil, err
+	}
+	limit.MaxUploadSize = maxUploadSize
+	if err := db.Model(&limit).Update("max_upload_size", maxUploadSize).Error; err != nil {
+		return nil, err
+	}
+	return &limit, nil
+}
+
+func DeleteUploadLimit(db *gorm.DB, userID uint) error {
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&UploadLimit{}).Error
+}
+
+// AdvanceTusUpload records that the chunk from offset to next was written,
+// returning ErrTusOffsetMismatch when another request moved the upload first.
+func AdvanceTusUpload(db *gorm.DB, upload *TusUpload, next int64, now time.Time) error {
+	expiresAt := now.Add(TusUploadTTL)
+	result := db.Model(&TusUpload{}).
+		Where("id = ? AND upload_offset = ?", upload.ID, upload.Offset).
+		Updates(map[string]interface{}{"upload_offset": next, "expires_at": expiresAt})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTusOffsetMismatch
+	}
+	upload.Offset = next
+	upload.ExpiresAt = expiresAt
+	return nil
+}
+
+// CompleteTusUpload records the stored file of a fully received upload.
+func CompleteTusUpload(db *gorm.DB, upload *TusUpload, storageURL string, now time.Time) (*UploadedFile, error) {
+	file := UploadedFile{
+		FileID:     upload.UUID,
+		FileName:   upload.FileName,
+		Size:       upload.Length,
+		MIMEType:   upload.MIMEType,
+		StorageURL: storageURL,
+		OwnerID:    upload.OwnerId,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&file).Error; err != nil {
+			return err
+		}
+		return tx.Model(upload).Updates(map[string]interface{}{"completed_at": now, "partial_path": ""}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	upload.CompletedAt = &now
+	upload.PartialPath = ""
+	return &file, nil
+}
+
+// ListExpiredTusUploads returns the uploads that expired at now. Completed
+// uploads expire as well, their files are kept.
+func ListExpiredTusUploads(db *gorm.DB, now time.Time) ([]TusUpload, error) {
+	var uploads []TusUpload
+	err := db.Where("expires_at < ?", now).Order("id asc").Find(&uploads).Error
+	return uploads, err
+}
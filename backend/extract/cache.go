@@ -0,0 +1,68 @@
+package extract
+
+import (
+	"backend/database"
+	"backend/storage"
+	"context"
+	"encoding/json"
+
+	"gorm.io/gorm"
+)
+
+// MetaDataKey is the key of the cached text in UploadedFile.MetaData
+const MetaDataKey = "extracted_text"
+
+// CachedText returns the text cached in the metadata of a file.
+func CachedText(file *database.UploadedFile) (string, bool) {
+	if len(file.MetaData) == 0 {
+		return "", false
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(file.MetaData, &metadata); err != nil {
+		return "", false
+	}
+	text, ok := metadata[MetaDataKey].(string)
+	return text, ok
+}
+
+// FileText returns the text of an uploaded file, extracting it from storage
+// and caching it on first use.
+func FileText(ctx context.Context, db *gorm.DB, file *database.UploadedFile) (string, error) {
+	if text, ok := CachedText(file); ok {
+		return text, nil
+	}
+	if !Supported(file.MIMEType) {
+		return "", ErrUnsupported
+	}
+	data, err := storage.ReadAll(ctx, file.StorageURL)
+	if err != nil {
+		return "", err
+	}
+	return CacheText(db, file, data)
+}
+
+// CacheText extracts the text of a file from its content and caches it in the
+// file's metadata, keeping the metadata already there.
+func CacheText(db *gorm.DB, file *database.UploadedFile, data []byte) (string, error) {
+	text, err := Text(data, file.MIMEType)
+	if err != nil {
+		return "", err
+	}
+
+	metadata := map[string]interface{}{}
+	if len(file.MetaData) > 0 {
+		if err := json.Unmarshal(file.MetaData, &metadata); err != nil {
+			metadata = map[string]interface{}{}
+		}
+	}
+	metadata[MetaDataKey] = text
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	if err := db.Model(file).Update("meta_data", json.RawMessage(metadataBytes)).Error; err != nil {
+		return "", err
+	}
+	file.MetaData = metadataBytes
+	return text, nil
+}
@@ -0,0 +1,38 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+)
+
+// csvText renders a CSV file, separated by commas, semicolons or tabs, as a
+// Markdown table.
+func csvText(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = csvDelimiter(data)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return "", fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(rows) == 0 {
+		return "", nil
+	}
+	return markdownTable(rows), nil
+}
+
+// csvDelimiter guesses the delimiter from the first line
+func csvDelimiter(data []byte) rune {
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	delimiter, count := ',', bytes.Count(firstLine, []byte(","))
+	for _, candidate := range []rune{';', '\t'} {
+		if n := bytes.Count(firstLine, []byte(string(candidate))); n > count {
+			delimiter, count = candidate, n
+		}
+	}
+	return delimiter
+}
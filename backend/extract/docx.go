@@ -0,0 +1,115 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// docxText extracts the paragraphs of a DOCX document, its tables rendered as
+// Markdown tables.
+func docxText(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("invalid DOCX: %w", err)
+	}
+	document, err := readZipFile(archive, "word/document.xml")
+	if err != nil {
+		return "", err
+	}
+
+	var out, cell strings.Builder
+	var rows [][]string
+	var row []string
+	tableDepth := 0
+	inText := false
+	current := func() *strings.Builder {
+		if tableDepth > 0 {
+			return &cell
+		}
+		return &out
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid DOCX document: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				current().WriteString("\t")
+			case "br", "cr":
+				current().WriteString("\n")
+			case "tbl":
+				tableDepth++
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if tableDepth > 0 {
+					cell.WriteString(" ")
+				} else {
+					out.WriteString("\n")
+				}
+			case "tc":
+				if tableDepth == 1 {
+					row = append(row, cell.String())
+					cell.Reset()
+				}
+			case "tr":
+				if tableDepth == 1 {
+					rows = append(rows, row)
+					row = nil
+				}
+			case "tbl":
+				tableDepth--
+				if tableDepth == 0 && len(rows) > 0 {
+					out.WriteString("\n" + markdownTable(rows) + "\n")
+					rows = nil
+				}
+			}
+		case xml.CharData:
+			if inText {
+				current().Write(t)
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+func readZipFile(archive *zip.Reader, name string) ([]byte, error) {
+	for _, file := range archive.File {
+		if file.Name != name {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		// Guard against zip bombs, no document part gets near this size
+		data, err := io.ReadAll(io.LimitReader(reader, 64<<20+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 64<<20 {
+			return nil, errors.New(name + " is too large")
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s not found", name)
+}
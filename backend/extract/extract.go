@@ -0,0 +1,126 @@
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// @doc:open-chat-text-extraction
+// Providers without native file inputs cannot read documents, so the text of
+// attachments is extracted on the server with pure Go readers: the text
+// operators of PDF content streams, the paragraphs and tables of DOCX files,
+// the sheets of XLSX workbooks and CSV files rendered as Markdown tables, and
+// plain text as is. Text is extracted when a file is uploaded, or on first use
+// for files uploaded before, and cached in UploadedFile.MetaData so every
+// later message that carries the attachment reuses it.
+
+const (
+	MimePDF  = "application/pdf"
+	MimeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	MimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	MimeCSV  = "text/csv"
+
+	// MaxTextLength caps the extracted text, in bytes, of a single file.
+	MaxTextLength = 200_000
+)
+
+var ErrUnsupported = errors.New("text extraction not supported for this file type")
+
+// textTypes are read as they are
+var textTypes = map[string]bool{
+	"text/plain":       true,
+	"text/markdown":    true,
+	"application/json": true,
+}
+
+// Supported reports whether text can be extracted from files of a MIME type.
+func Supported(mimeType string) bool {
+	switch mimeType = baseType(mimeType); mimeType {
+	case MimePDF, MimeDOCX, MimeXLSX, MimeCSV:
+		return true
+	default:
+		return textTypes[mimeType]
+	}
+}
+
+// Text extracts the text of a file, truncated to MaxTextLength. The readers
+// parse untrusted files, a reader that panics on one fails with an error.
+func Text(data []byte, mimeType string) (_ string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("failed to extract text: %v", recovered)
+		}
+	}()
+	var text string
+	switch mimeType = baseType(mimeType); {
+	case mimeType == MimePDF:
+		text, err = pdfText(data)
+	case mimeType == MimeDOCX:
+		text, err = docxText(data)
+	case mimeType == MimeXLSX:
+		text, err = xlsxText(data)
+	case mimeType == MimeCSV:
+		text, err = csvText(data)
+	case textTypes[mimeType]:
+		text = string(data)
+	default:
+		return "", ErrUnsupported
+	}
+	if err != nil {
+		return "", err
+	}
+	return truncate(strings.TrimSpace(strings.ToValidUTF8(text, "�"))), nil
+}
+
+func baseType(mimeType string) string {
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(mimeType))
+}
+
+func truncate(text string) string {
+	if len(text) <= MaxTextLength {
+		return text
+	}
+	cut := MaxTextLength
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "\n\n[... text truncated ...]"
+}
+
+// markdownTable renders rows as a Markdown table with the first row as header.
+func markdownTable(rows [][]string) string {
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+	if width == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	writeRow := func(row []string) {
+		b.WriteString("|")
+		for i := 0; i < width; i++ {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			cell = strings.ReplaceAll(strings.TrimSpace(cell), "|", "\\|")
+			cell = strings.Join(strings.Fields(cell), " ")
+			b.WriteString(" " + cell + " |")
+		}
+		b.WriteString("\n")
+	}
+	writeRow(rows[0])
+	b.WriteString("|" + strings.Repeat(" --- |", width) + "\n")
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	return b.String()
+}
@@ -0,0 +1,193 @@
+package extract
+
+import (
+	"archive/zip"
+	"backend/database"
+	"backend/server/util"
+	"backend/storage"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildPDF writes a two page PDF, the second page using a font with a
+// ToUnicode cmap and a compressed content stream
+func buildPDF(t *testing.T) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write([]byte("BT /F2 12 Tf 72 700 Td <00010002> Tj [<0003>] TJ ET"))
+	zw.Close()
+	cmap := "/CIDInit /ProcSet findresource begin 12 dict begin begincmap\n" +
+		"1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+		"1 beginbfchar <0003> <0021> endbfchar\n" +
+		"1 beginbfrange <0001> <0002> <0048> endbfrange\n" +
+		"endcmap end end"
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 6 0 R] /Count 2 /Resources << /Font << /F1 4 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"stream:BT /F1 12 Tf 72 720 Td (Quarterly \\(draft\\)) Tj 0 -14 Td [(re) 10 (port) -300 (2024)] TJ T* (\\223done\\224) Tj ET",
+		"<< /Type /Page /Parent 2 0 R /Contents [7 0 R] /Resources << /Font << /F2 8 0 R >> >> >>",
+		"zstream:" + compressed.String(),
+		"<< /Type /Font /Subtype /Type0 /BaseFont /Custom /ToUnicode 9 0 R >>",
+		"stream:" + cmap,
+	}
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	for i, object := range objects {
+		fmt.Fprintf(&pdf, "%d 0 obj\n", i+1)
+		switch {
+		case strings.HasPrefix(object, "stream:"):
+			content := strings.TrimPrefix(object, "stream:")
+			fmt.Fprintf(&pdf, "<< /Length %d >>\nstream\n%s\nendstream\n", len(content), content)
+		case strings.HasPrefix(object, "zstream:"):
+			content := strings.TrimPrefix(object, "zstream:")
+			fmt.Fprintf(&pdf, "<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream\n", len(content), content)
+		default:
+			pdf.WriteString(object + "\n")
+		}
+		pdf.WriteString("endobj\n")
+	}
+	pdf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func buildZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestTextExtractsDocuments(t *testing.T) {
+	docx := buildZip(t, map[string]string{
+		"word/document.xml": `<?xml version="1.0"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>Meeting</w:t></w:r><w:r><w:t xml:space="preserve"> notes</w:t></w:r></w:p>
+<w:p><w:r><w:t>Owner:</w:t><w:tab/><w:t>Ana</w:t></w:r></w:p>
+<w:tbl><w:tr><w:tc><w:p><w:r><w:t>Item</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Cost</w:t></w:r></w:p></w:tc></w:tr>
+<w:tr><w:tc><w:p><w:r><w:t>Rent | office</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>1200</w:t></w:r></w:p></w:tc></w:tr></w:tbl>
+<w:p><w:r><w:delText>removed</w:delText><w:t>End</w:t></w:r></w:p>
+</w:body></w:document>`,
+	})
+	xlsx := buildZip(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Budget" sheetId="1" r:id="rId1"/><sheet name="Empty" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><si><t>Item</t></si><si><r><t>Tot</t></r><r><t>al</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="inlineStr"><is><t>Paid</t></is></c></row>
+<row r="2"><c r="A2" t="s"><v>1</v></c><c r="B2"><v>42.5</v></c><c r="C2" t="b"><v>1</v></c></row>
+</sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData/></worksheet>`,
+	})
+
+	cases := []struct {
+		name     string
+		data     []byte
+		mimeType string
+		want     string
+	}{
+		{"pdf", buildPDF(t), MimePDF, "Quarterly (draft)\nreport 2024\n“done”\n\nHI!"},
+		{"docx", docx, MimeDOCX, "Meeting notes\nOwner:\tAna\n\n| Item | Cost |\n| --- | --- |\n| Rent \\| office | 1200 |\n\nEnd"},
+		{"xlsx", xlsx, MimeXLSX, "## Budget\n\n| Item |  | Paid |\n| --- | --- | --- |\n| Total | 42.5 | TRUE |"},
+		{"csv", []byte("\xef\xbb\xbfname;amount\nTea;3\nCake;\"4;5\"\n"), "text/csv; charset=utf-8", "| name | amount |\n| --- | --- |\n| Tea | 3 |\n| Cake | 4;5 |"},
+		{"text", []byte("  plain notes \xff\n"), "text/plain", "plain notes �"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if !Supported(tc.mimeType) {
+				t.Fatalf("expected %s to be supported", tc.mimeType)
+			}
+			got, err := Text(tc.data, tc.mimeType)
+			if err != nil {
+				t.Fatalf("failed to extract text: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("unexpected text:\n%q\nwant:\n%q", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := Text([]byte("GIF89a"), "image/gif"); err != ErrUnsupported {
+		t.Fatalf("expected images to be unsupported, got %v", err)
+	}
+	if _, err := Text([]byte("not a pdf"), MimePDF); err == nil {
+		t.Fatalf("expected invalid PDFs to fail")
+	}
+	// Object stream offsets come from the file and may be out of range
+	objStm := "5 -100 (hello) Tj"
+	malformed := fmt.Sprintf("%%PDF-1.5\n1 0 obj\n<< /Type /ObjStm /N 1 /First 7 /Length %d >>\nstream\n%s\nendstream\nendobj\n%%%%EOF\n", len(objStm), objStm)
+	if text, err := Text([]byte(malformed), MimePDF); err != nil || text != "" {
+		t.Fatalf("expected the object to be skipped, got %q (%v)", text, err)
+	}
+	long, _ := Text([]byte(strings.Repeat("é", MaxTextLength)), "text/plain")
+	if !strings.HasSuffix(long, "[... text truncated ...]") || len(long) > MaxTextLength+64 {
+		t.Fatalf("expected long text to be truncated, got %d bytes", len(long))
+	}
+}
+
+func TestFileTextCachesExtractedText(t *testing.T) {
+	DB := database.SetupDatabase(database.DBConfig{
+		Backend:  "sqlite",
+		FilePath: filepath.Join(t.TempDir(), "extract_test.db"),
+		Debug:    false,
+		ResetDB:  true,
+	})
+	err, owner := util.CreateUser(DB, "extract-owner", "Passw0rd!", false)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	ctx := context.Background()
+	local := storage.NewLocal(t.TempDir())
+	storageURL, err := local.Put(ctx, "notes", strings.NewReader("first draft"), -1, "text/plain")
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	file := database.UploadedFile{FileID: "notes", FileName: "notes.txt", MIMEType: "text/plain", StorageURL: storageURL, OwnerID: owner.ID, MetaData: []byte(`{"openai_file_id":"file-1"}`)}
+	if err := DB.Create(&file).Error; err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	if text, err := FileText(ctx, DB, &file); err != nil || text != "first draft" {
+		t.Fatalf("expected the text to be extracted, got %q (%v)", text, err)
+	}
+	// Later reads use the cache, not the stored file
+	if err := local.Delete(ctx, storageURL); err != nil {
+		t.Fatalf("failed to delete stored file: %v", err)
+	}
+	var reloaded database.UploadedFile
+	if err := DB.First(&reloaded, file.ID).Error; err != nil {
+		t.Fatalf("failed to reload file: %v", err)
+	}
+	if text, err := FileText(ctx, DB, &reloaded); err != nil || text != "first draft" {
+		t.Fatalf("expected the cached text, got %q (%v)", text, err)
+	}
+	if !bytes.Contains(reloaded.MetaData, []byte(`"openai_file_id":"file-1"`)) {
+		t.Fatalf("expected existing metadata to be kept, got %s", reloaded.MetaData)
+	}
+
+	image := database.UploadedFile{FileID: "photo", FileName: "photo.png", MIMEType: "image/png", StorageURL: storageURL, OwnerID: owner.ID}
+	if _, err := FileText(ctx, DB, &image); err != ErrUnsupported {
+		t.Fatalf("expected images to be unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,796 @@
+package extract
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxStreamSize guards against decompression bombs in PDF streams
+const maxStreamSize = 64 << 20
+
+var (
+	pdfObjectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	pdfRef          = regexp.MustCompile(`(\d+)\s+\d+\s+R\b`)
+	pdfDirectRef    = regexp.MustCompile(`^(\d+)\s+\d+\s+R\b`)
+	pdfNamedRef     = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s+(\d+)\s+\d+\s+R\b`)
+	pdfFilterName   = regexp.MustCompile(`/(\w+)`)
+	pdfLength       = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+	pdfObjStmType   = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+	pdfPagesType    = regexp.MustCompile(`/Type\s*/Pages\b`)
+	pdfPageType     = regexp.MustCompile(`/Type\s*/Page\b`)
+	pdfType0Font    = regexp.MustCompile(`/Subtype\s*/Type0\b`)
+)
+
+type pdfObject struct {
+	dict   []byte
+	stream []byte
+}
+
+type pdfFont struct {
+	cmap *pdfCMap
+	// composite fonts show multi byte codes that are unreadable without a cmap
+	composite bool
+}
+
+type pdfDocument struct {
+	objects map[int]pdfObject
+	fonts   map[int]*pdfFont
+}
+
+// pdfText extracts the text shown by the content streams of a PDF, page by
+// page. Fonts with a ToUnicode cmap are decoded through it, other strings are
+// read as WinAnsi text.
+func pdfText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\n\r "), []byte("%PDF")) {
+		return "", errors.New("invalid PDF: missing header")
+	}
+	doc := &pdfDocument{objects: parsePDFObjects(data), fonts: map[int]*pdfFont{}}
+
+	var out strings.Builder
+	pages := doc.pages()
+	if len(pages) == 0 {
+		// Without a page tree every stream with text operators is a page
+		numbers := make([]int, 0, len(doc.objects))
+		for number := range doc.objects {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		for _, number := range numbers {
+			content, ok := doc.streamOf(number)
+			if ok && bytes.Contains(content, []byte("BT")) {
+				out.WriteString(showText(content, nil))
+				out.WriteString("\n\n")
+			}
+		}
+		return out.String(), nil
+	}
+
+	for _, page := range pages {
+		fonts := doc.pageFonts(page)
+		for _, number := range doc.contentRefs(page) {
+			if content, ok := doc.streamOf(number); ok {
+				out.WriteString(showText(content, fonts))
+				out.WriteString("\n")
+			}
+		}
+		out.WriteString("\n")
+	}
+	return out.String(), nil
+}
+
+// parsePDFObjects reads the indirect objects of a PDF, including the ones
+// packed into object streams. Later definitions win, like incremental updates.
+func parsePDFObjects(data []byte) map[int]pdfObject {
+	objects := map[int]pdfObject{}
+	pos := 0
+	for pos < len(data) {
+		loc := pdfObjectHeader.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		number, _ := strconv.Atoi(string(data[pos+loc[2] : pos+loc[3]]))
+		start := pos + loc[1]
+		end := bytes.Index(data[start:], []byte("endobj"))
+		if end < 0 {
+			end = len(data) - start
+		}
+		end += start
+
+		object := pdfObject{dict: data[start:end]}
+		if streamAt := bytes.Index(data[start:end], []byte("stream")); streamAt >= 0 {
+			streamAt += start
+			object.dict = data[start:streamAt]
+			raw, rawEnd := rawStream(data, object.dict, streamAt+len("stream"))
+			object.stream = raw
+			if next := bytes.Index(data[rawEnd:], []byte("endobj")); next >= 0 {
+				end = rawEnd + next
+			} else {
+				end = len(data)
+			}
+		}
+		objects[number] = object
+		pos = end
+	}
+
+	for _, object := range objects {
+		if !pdfObjStmType.Match(object.dict) {
+			continue
+		}
+		content, ok := decodeStream(object)
+		if !ok {
+			continue
+		}
+		count, first := dictInt(object.dict, "N"), dictInt(object.dict, "First")
+		if first <= 0 || first > len(content) {
+			continue
+		}
+		header := strings.Fields(string(content[:first]))
+		for i := 0; i+1 < len(header) && i/2 < count; i += 2 {
+			number, err1 := strconv.Atoi(header[i])
+			offset, err2 := strconv.Atoi(header[i+1])
+			// Offsets come from the file, they may point anywhere
+			if err1 != nil || err2 != nil || offset < 0 || first+offset > len(content) {
+				continue
+			}
+			end := len(content)
+			if i+3 < len(header) {
+				if next, err := strconv.Atoi(header[i+3]); err == nil && next >= 0 && first+next <= len(content) && next >= offset {
+					end = first + next
+				}
+			}
+			if first+offset > end {
+				continue
+			}
+			if _, exists := objects[number]; !exists {
+				objects[number] = pdfObject{dict: content[first+offset : end]}
+			}
+		}
+	}
+	return objects
+}
+
+// rawStream returns the undecoded stream data starting after the stream
+// keyword at start and the position after it
+func rawStream(data, dict []byte, start int) ([]byte, int) {
+	if start < len(data) && data[start] == '\r' {
+		start++
+	}
+	if start < len(data) && data[start] == '\n' {
+		start++
+	}
+	// Direct lengths are exact, indirect ones need a lookup, so search instead
+	if match := pdfLength.FindSubmatch(dict); match != nil && len(match[2]) == 0 {
+		length, _ := strconv.Atoi(string(match[1]))
+		if end := start + length; end <= len(data) && bytes.HasPrefix(bytes.TrimLeft(data[end:], "\r\n \t"), []byte("endstream")) {
+			return data[start:end], end
+		}
+	}
+	end := bytes.Index(data[start:], []byte("endstream"))
+	if end < 0 {
+		return data[start:], len(data)
+	}
+	return bytes.TrimRight(data[start:start+end], "\r\n"), start + end
+}
+
+// decodeStream applies the filters of a stream, reporting false for filters
+// that do not hold text, like image codecs
+func decodeStream(object pdfObject) ([]byte, bool) {
+	data := object.stream
+	if data == nil {
+		return nil, false
+	}
+	filters := []string{}
+	if value := dictValue(object.dict, "Filter"); value != nil {
+		for _, match := range pdfFilterName.FindAllSubmatch(value, -1) {
+			filters = append(filters, string(match[1]))
+		}
+	}
+
+	for _, filter := range filters {
+		var reader io.Reader
+		switch filter {
+		case "FlateDecode", "Fl":
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				// Some writers omit the zlib header
+				reader = flate.NewReader(bytes.NewReader(data))
+			} else {
+				reader = zr
+			}
+		case "ASCIIHexDecode", "AHx":
+			cleaned := bytes.Map(func(r rune) rune {
+				if strings.ContainsRune("0123456789abcdefABCDEF", r) {
+					return r
+				}
+				return -1
+			}, bytes.TrimSuffix(bytes.TrimSpace(data), []byte(">")))
+			if len(cleaned)%2 == 1 {
+				cleaned = append(cleaned, '0')
+			}
+			decoded, err := hex.DecodeString(string(cleaned))
+			if err != nil {
+				return nil, false
+			}
+			data = decoded
+			continue
+		case "ASCII85Decode", "A85":
+			trimmed := bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+			if end := bytes.Index(trimmed, []byte("~>")); end >= 0 {
+				trimmed = trimmed[:end]
+			}
+			reader = ascii85.NewDecoder(bytes.NewReader(trimmed))
+		default:
+			return nil, false
+		}
+		// Truncated streams still hold the text before the damage
+		decoded, err := io.ReadAll(io.LimitReader(reader, maxStreamSize))
+		if err != nil && len(decoded) == 0 {
+			return nil, false
+		}
+		data = decoded
+	}
+	return data, true
+}
+
+func (doc *pdfDocument) streamOf(number int) ([]byte, bool) {
+	object, ok := doc.objects[number]
+	if !ok {
+		return nil, false
+	}
+	return decodeStream(object)
+}
+
+// resolve returns the dictionary of the object a value refers to, or the
+// value itself when it is direct
+func (doc *pdfDocument) resolve(value []byte) []byte {
+	if match := pdfDirectRef.FindSubmatch(bytes.TrimSpace(value)); match != nil {
+		number, _ := strconv.Atoi(string(match[1]))
+		return doc.objects[number].dict
+	}
+	return value
+}
+
+// pages returns the page objects in the order of the page tree
+func (doc *pdfDocument) pages() []int {
+	numbers := make([]int, 0, len(doc.objects))
+	for number := range doc.objects {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	pages := []int{}
+	visited := map[int]bool{}
+	var walk func(number int)
+	walk = func(number int) {
+		if visited[number] {
+			return
+		}
+		visited[number] = true
+		dict := doc.objects[number].dict
+		if pdfPagesType.Match(dict) {
+			for _, match := range pdfRef.FindAllSubmatch(doc.resolve(dictValue(dict, "Kids")), -1) {
+				kid, _ := strconv.Atoi(string(match[1]))
+				walk(kid)
+			}
+		} else if pdfPageType.Match(dict) {
+			pages = append(pages, number)
+		}
+	}
+	for _, number := range numbers {
+		dict := doc.objects[number].dict
+		if pdfPagesType.Match(dict) && dictValue(dict, "Parent") == nil {
+			walk(number)
+		}
+	}
+	return pages
+}
+
+// contentRefs returns the content streams of a page
+func (doc *pdfDocument) contentRefs(page int) []int {
+	value := dictValue(doc.objects[page].dict, "Contents")
+	if value == nil {
+		return nil
+	}
+	// A reference to an array object holds the stream references
+	if match := pdfDirectRef.FindSubmatch(bytes.TrimSpace(value)); match != nil {
+		number, _ := strconv.Atoi(string(match[1]))
+		if object := doc.objects[number]; object.stream == nil {
+			value = object.dict
+		}
+	}
+	refs := []int{}
+	for _, match := range pdfRef.FindAllSubmatch(value, -1) {
+		number, _ := strconv.Atoi(string(match[1]))
+		refs = append(refs, number)
+	}
+	return refs
+}
+
+// pageFonts maps the font names of a page's resources, which may be
+// inherited from the page tree, to their fonts
+func (doc *pdfDocument) pageFonts(page int) map[string]*pdfFont {
+	dict := doc.objects[page].dict
+	resources := dictValue(dict, "Resources")
+	for depth := 0; resources == nil && depth < 32; depth++ {
+		parent := dictValue(dict, "Parent")
+		if parent == nil {
+			break
+		}
+		dict = doc.resolve(parent)
+		resources = dictValue(dict, "Resources")
+	}
+	fonts := map[string]*pdfFont{}
+	if resources == nil {
+		return fonts
+	}
+	fontDict := dictValue(doc.resolve(resources), "Font")
+	if fontDict == nil {
+		return fonts
+	}
+	for _, match := range pdfNamedRef.FindAllSubmatch(doc.resolve(fontDict), -1) {
+		number, _ := strconv.Atoi(string(match[2]))
+		fonts[string(match[1])] = doc.font(number)
+	}
+	return fonts
+}
+
+func (doc *pdfDocument) font(number int) *pdfFont {
+	if font, ok := doc.fonts[number]; ok {
+		return font
+	}
+	dict := doc.objects[number].dict
+	font := &pdfFont{composite: pdfType0Font.Match(dict)}
+	if value := dictValue(dict, "ToUnicode"); value != nil {
+		if match := pdfRef.FindSubmatch(value); match != nil {
+			cmapNumber, _ := strconv.Atoi(string(match[1]))
+			if content, ok := doc.streamOf(cmapNumber); ok {
+				font.cmap = parseCMap(content)
+			}
+		}
+	}
+	doc.fonts[number] = font
+	return font
+}
+
+// dictValue returns the raw value of a key in a dictionary, nil when missing
+func dictValue(dict []byte, key string) []byte {
+	name := []byte("/" + key)
+	from := 0
+	for {
+		at := bytes.Index(dict[from:], name)
+		if at < 0 {
+			return nil
+		}
+		from += at + len(name)
+		if from == len(dict) || isPDFSpace(dict[from]) || isPDFDelimiter(dict[from]) {
+			break
+		}
+	}
+
+	rest := bytes.TrimLeft(dict[from:], "\x00\t\n\f\r ")
+	switch {
+	case bytes.HasPrefix(rest, []byte("<<")):
+		depth := 0
+		for i := 0; i+1 < len(rest); i++ {
+			if rest[i] == '<' && rest[i+1] == '<' {
+				depth++
+				i++
+			} else if rest[i] == '>' && rest[i+1] == '>' {
+				depth--
+				i++
+				if depth == 0 {
+					return rest[:i+1]
+				}
+			}
+		}
+		return rest
+	case bytes.HasPrefix(rest, []byte("[")):
+		if end := bytes.IndexByte(rest, ']'); end >= 0 {
+			return rest[:end+1]
+		}
+		return rest
+	case bytes.HasPrefix(rest, []byte("/")):
+		lexer := &pdfLexer{data: rest, pos: 1}
+		lexer.regular()
+		return rest[:lexer.pos]
+	}
+	if match := pdfDirectRef.Find(rest); match != nil {
+		return match
+	}
+	lexer := &pdfLexer{data: rest}
+	lexer.regular()
+	return rest[:lexer.pos]
+}
+
+func dictInt(dict []byte, key string) int {
+	value, err := strconv.Atoi(string(bytes.TrimSpace(dictValue(dict, key))))
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// pdfOperand is a value a content stream operator consumes
+type pdfOperand struct {
+	number  float64
+	isNum   bool
+	str     []byte
+	isStr   bool
+	name    string
+	array   []pdfOperand
+	isArray bool
+}
+
+// pdfLexer reads the operands and operators of content streams and cmaps
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// next returns the next operand, or the operator name when op is true, and
+// false at the end of the data
+func (l *pdfLexer) next() (operand pdfOperand, op string, ok bool) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			return pdfOperand{str: l.literalString(), isStr: true}, "", true
+		case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<',
+			c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+			// Dictionaries only appear as operands of operators we ignore
+			l.pos += 2
+		case c == '<':
+			return pdfOperand{str: l.hexString(), isStr: true}, "", true
+		case c == '[':
+			l.pos++
+			array := []pdfOperand{}
+			for {
+				item, itemOp, itemOK := l.next()
+				if !itemOK || itemOp == "]" {
+					break
+				}
+				if itemOp == "" {
+					array = append(array, item)
+				}
+			}
+			return pdfOperand{array: array, isArray: true}, "", true
+		case c == ']':
+			l.pos++
+			return pdfOperand{}, "]", true
+		case c == '/':
+			l.pos++
+			return pdfOperand{name: l.regular()}, "", true
+		case c == '{' || c == '}' || c == ')' || c == '>':
+			l.pos++
+		default:
+			word := l.regular()
+			if number, err := strconv.ParseFloat(word, 64); err == nil {
+				return pdfOperand{number: number, isNum: true}, "", true
+			}
+			return pdfOperand{}, word, true
+		}
+	}
+	return pdfOperand{}, "", false
+}
+
+func (l *pdfLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+func (l *pdfLexer) literalString() []byte {
+	l.pos++
+	out := []byte{}
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			escaped := l.data[l.pos]
+			l.pos++
+			switch escaped {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if escaped >= '0' && escaped <= '7' {
+					value := int(escaped - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						value = value*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(value))
+				} else {
+					out = append(out, escaped)
+				}
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func (l *pdfLexer) hexString() []byte {
+	l.pos++
+	digits := []byte{}
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	decoded, _ := hex.DecodeString(string(digits))
+	return decoded
+}
+
+// skipInlineImage skips the binary data of an inline image up to its EI
+func (l *pdfLexer) skipInlineImage() {
+	for l.pos+2 < len(l.data) {
+		if isPDFSpace(l.data[l.pos]) && l.data[l.pos+1] == 'E' && l.data[l.pos+2] == 'I' &&
+			(l.pos+3 == len(l.data) || isPDFSpace(l.data[l.pos+3])) {
+			l.pos += 3
+			return
+		}
+		l.pos++
+	}
+	l.pos = len(l.data)
+}
+
+// pdfTextWriter collects shown text, separating words and lines once
+type pdfTextWriter struct {
+	strings.Builder
+	last byte
+}
+
+func (w *pdfTextWriter) text(text string) {
+	if text != "" {
+		w.WriteString(text)
+		w.last = text[len(text)-1]
+	}
+}
+
+func (w *pdfTextWriter) space() {
+	if w.last != 0 && w.last != ' ' && w.last != '\n' {
+		w.text(" ")
+	}
+}
+
+func (w *pdfTextWriter) newline() {
+	if w.last != 0 && w.last != '\n' {
+		w.text("\n")
+	}
+}
+
+// showText runs the text operators of a content stream
+func showText(content []byte, fonts map[string]*pdfFont) string {
+	var out pdfTextWriter
+	lexer := &pdfLexer{data: content}
+	operands := []pdfOperand{}
+	var font *pdfFont
+	lastY, haveY := 0.0, false
+
+	for {
+		operand, op, ok := lexer.next()
+		if !ok {
+			break
+		}
+		if op == "" {
+			operands = append(operands, operand)
+			continue
+		}
+		switch op {
+		case "Tf":
+			if len(operands) >= 2 {
+				font = fonts[operands[len(operands)-2].name]
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				out.text(decodePDFString(operands[len(operands)-1].str, font))
+			}
+		case "'", "\"":
+			out.newline()
+			if len(operands) >= 1 {
+				out.text(decodePDFString(operands[len(operands)-1].str, font))
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				for _, item := range operands[len(operands)-1].array {
+					if item.isStr {
+						out.text(decodePDFString(item.str, font))
+					} else if item.isNum && item.number < -200 {
+						// Large negative adjustments separate words
+						out.space()
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 && operands[len(operands)-1].number != 0 {
+				out.newline()
+			}
+		case "T*":
+			out.newline()
+		case "Tm":
+			if len(operands) >= 6 {
+				y := operands[len(operands)-1].number
+				if haveY && y != lastY {
+					out.newline()
+				}
+				lastY, haveY = y, true
+			}
+		case "ID":
+			lexer.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+	return out.String()
+}
+
+// winAnsi maps the WinAnsiEncoding codes that differ from Latin-1
+var winAnsi = map[byte]rune{
+	0x80: '€', 0x85: '…', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”',
+	0x95: '•', 0x96: '–', 0x97: '—', 0x99: '™',
+}
+
+func decodePDFString(str []byte, font *pdfFont) string {
+	if font != nil && font.cmap != nil {
+		return font.cmap.decode(str)
+	}
+	if font != nil && font.composite {
+		return ""
+	}
+	if len(str) >= 2 && str[0] == 0xfe && str[1] == 0xff {
+		return decodeUTF16(str[2:])
+	}
+	runes := make([]rune, 0, len(str))
+	for _, c := range str {
+		if r, ok := winAnsi[c]; ok {
+			runes = append(runes, r)
+		} else if c >= 0x20 || c == '\t' || c == '\n' {
+			runes = append(runes, rune(c))
+		}
+	}
+	return string(runes)
+}
+
+func decodeUTF16(data []byte) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// pdfCMap maps the character codes of a font to Unicode text
+type pdfCMap struct {
+	codeLength int
+	chars      map[uint32]string
+}
+
+func codeOf(data []byte) uint32 {
+	var code uint32
+	for _, c := range data {
+		code = code<<8 | uint32(c)
+	}
+	return code
+}
+
+// parseCMap reads the codespace and bfchar/bfrange mappings of a ToUnicode cmap
+func parseCMap(content []byte) *pdfCMap {
+	cmap := &pdfCMap{codeLength: 1, chars: map[uint32]string{}}
+	lexer := &pdfLexer{data: content}
+	operands := []pdfOperand{}
+	for {
+		operand, op, ok := lexer.next()
+		if !ok {
+			break
+		}
+		if op == "" {
+			operands = append(operands, operand)
+			continue
+		}
+		switch op {
+		case "endcodespacerange":
+			if len(operands) >= 1 && len(operands[0].str) > 0 {
+				cmap.codeLength = len(operands[0].str)
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				cmap.chars[codeOf(operands[i].str)] = decodeUTF16(operands[i+1].str)
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, high := codeOf(operands[i].str), codeOf(operands[i+1].str)
+				if high < low || high-low > 0xffff {
+					continue
+				}
+				target := operands[i+2]
+				for code := low; code <= high; code++ {
+					if target.isArray {
+						if index := int(code - low); index < len(target.array) {
+							cmap.chars[code] = decodeUTF16(target.array[index].str)
+						}
+						continue
+					}
+					// The last byte of the destination is incremented through the range
+					dst := append([]byte(nil), target.str...)
+					if len(dst) > 0 {
+						offset := code - low
+						value := uint32(dst[len(dst)-1]) + offset
+						dst[len(dst)-1] = byte(value)
+						if len(dst) >= 2 {
+							dst[len(dst)-2] += byte(value >> 8)
+						}
+					}
+					cmap.chars[code] = decodeUTF16(dst)
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	return cmap
+}
+
+func (c *pdfCMap) decode(str []byte) string {
+	var out strings.Builder
+	for i := 0; i+c.codeLength <= len(str); i += c.codeLength {
+		if text, ok := c.chars[codeOf(str[i:i+c.codeLength])]; ok {
+			out.WriteString(text)
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,165 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// xlsxMaxColumns is the column count of Excel sheets, XFD
+const xlsxMaxColumns = 16384
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxString holds a shared or inline string, either plain or in rich text runs
+type xlsxString struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxString) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, run := range t.Runs {
+		b.WriteString(run.T)
+	}
+	return b.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxString `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string     `xml:"r,attr"`
+			Type   string     `xml:"t,attr"`
+			Value  string     `xml:"v"`
+			Inline xlsxString `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// xlsxText renders every sheet of an XLSX workbook as a Markdown table.
+func xlsxText(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("invalid XLSX: %w", err)
+	}
+
+	var workbook xlsxWorkbook
+	if err := unmarshalZipFile(archive, "xl/workbook.xml", &workbook); err != nil {
+		return "", err
+	}
+	var relationships xlsxRelationships
+	if err := unmarshalZipFile(archive, "xl/_rels/workbook.xml.rels", &relationships); err != nil {
+		return "", err
+	}
+	targets := map[string]string{}
+	for _, relationship := range relationships.Relationships {
+		if strings.HasPrefix(relationship.Target, "/") {
+			targets[relationship.ID] = strings.TrimPrefix(relationship.Target, "/")
+		} else {
+			targets[relationship.ID] = path.Join("xl", relationship.Target)
+		}
+	}
+	// Workbooks without any text cells have no shared strings
+	var sharedStrings xlsxSharedStrings
+	unmarshalZipFile(archive, "xl/sharedStrings.xml", &sharedStrings)
+
+	var out strings.Builder
+	for _, sheetRef := range workbook.Sheets {
+		target, ok := targets[sheetRef.ID]
+		if !ok {
+			continue
+		}
+		var sheet xlsxSheet
+		if err := unmarshalZipFile(archive, target, &sheet); err != nil {
+			return "", err
+		}
+
+		rows := [][]string{}
+		for _, sheetRow := range sheet.Rows {
+			row := []string{}
+			for _, cell := range sheetRow.Cells {
+				column := len(row)
+				if cell.Ref != "" {
+					column = xlsxColumn(cell.Ref)
+				}
+				if column >= xlsxMaxColumns {
+					continue
+				}
+				for len(row) <= column {
+					row = append(row, "")
+				}
+				switch cell.Type {
+				case "s":
+					if index, err := strconv.Atoi(cell.Value); err == nil && index >= 0 && index < len(sharedStrings.Items) {
+						row[column] = sharedStrings.Items[index].String()
+					}
+				case "inlineStr":
+					row[column] = cell.Inline.String()
+				case "b":
+					row[column] = strings.ToUpper(strconv.FormatBool(cell.Value == "1"))
+				default:
+					row[column] = cell.Value
+				}
+			}
+			for len(row) > 0 && strings.TrimSpace(row[len(row)-1]) == "" {
+				row = row[:len(row)-1]
+			}
+			if len(row) > 0 {
+				rows = append(rows, row)
+			}
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		fmt.Fprintf(&out, "## %s\n\n%s\n", sheetRef.Name, markdownTable(rows))
+	}
+	return out.String(), nil
+}
+
+// xlsxColumn returns the zero based column of a cell reference like "AB12"
+func xlsxColumn(ref string) int {
+	column := 0
+	for _, r := range strings.ToUpper(ref) {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A'+1)
+	}
+	return max(column-1, 0)
+}
+
+func unmarshalZipFile(archive *zip.Reader, name string, v interface{}) error {
+	data, err := readZipFile(archive, name)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
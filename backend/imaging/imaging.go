@@ -0,0 +1,195 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// @doc:open-chat-image-processing
+// Uploaded images go through a small pure Go pipeline. StripMetadata removes
+// EXIF, XMP and IPTC data, which carries GPS positions and camera details,
+// before the image is stored; JPEGs that rely on their EXIF orientation are
+// rotated first so they still display upright, those too large to decode keep
+// their orientation as the only EXIF tag. StripMetadataTo does the same
+// without decoding while streaming uploads too large to hold in memory.
+// Thumbnail renders the preview
+// shown in chats. Downscale shrinks images to the largest dimension a vision
+// model accepts before they are base64 encoded into a request. WebP images
+// can't be decoded with the standard library: their metadata is stripped but
+// they get no thumbnail and are forwarded at their original size.
+
+const (
+	MimeJPEG = "image/jpeg"
+	MimePNG  = "image/png"
+	MimeGIF  = "image/gif"
+	MimeWebP = "image/webp"
+
+	// ThumbnailSize is the longest edge of thumbnails.
+	ThumbnailSize = 320
+
+	// MaxPixels guards against decompression bombs, larger images are left as they are.
+	MaxPixels = 50_000_000
+
+	jpegQuality = 85
+)
+
+var (
+	ErrUnsupported = errors.New("image type not supported")
+	ErrTooLarge    = errors.New("image too large to process")
+)
+
+// Supported reports whether images of a MIME type can be processed.
+func Supported(mimeType string) bool {
+	switch mimeType {
+	case MimeJPEG, MimePNG, MimeGIF, MimeWebP:
+		return true
+	}
+	return false
+}
+
+// decode reads an image, refusing the ones above MaxPixels before allocating them
+func decode(data []byte, mimeType string) (image.Image, error) {
+	if mimeType == MimeWebP || !Supported(mimeType) {
+		return nil, ErrUnsupported
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// Downscale shrinks an image so neither side exceeds maxDimension. Images
+// that already fit are returned unchanged; resized GIFs become PNGs.
+func Downscale(data []byte, mimeType string, maxDimension int) ([]byte, string, error) {
+	if mimeType == MimeWebP || !Supported(mimeType) {
+		return nil, "", ErrUnsupported
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if maxDimension <= 0 || (config.Width <= maxDimension && config.Height <= maxDimension) {
+		return data, mimeType, nil
+	}
+
+	img, err := decode(data, mimeType)
+	if err != nil {
+		return nil, "", err
+	}
+	width, height := fit(config.Width, config.Height, maxDimension)
+	if mimeType == MimeJPEG {
+		return encode(resize(img, width, height), MimeJPEG)
+	}
+	return encode(resize(img, width, height), MimePNG)
+}
+
+// Thumbnail renders a preview of an image no larger than ThumbnailSize,
+// a PNG for the types that may be transparent and a JPEG otherwise.
+func Thumbnail(data []byte, mimeType string) ([]byte, string, error) {
+	img, err := decode(data, mimeType)
+	if err != nil {
+		return nil, "", err
+	}
+	bounds := img.Bounds()
+	width, height := fit(bounds.Dx(), bounds.Dy(), ThumbnailSize)
+	if mimeType == MimeJPEG {
+		return encode(resize(img, width, height), MimeJPEG)
+	}
+	return encode(resize(img, width, height), MimePNG)
+}
+
+func encode(img image.Image, mimeType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	var err error
+	if mimeType == MimeJPEG {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), mimeType, nil
+}
+
+// fit scales width and height down so the longer side is at most maxDimension
+func fit(width, height, maxDimension int) (int, int) {
+	longest := max(width, height)
+	if longest <= maxDimension {
+		return width, height
+	}
+	scale := float64(maxDimension) / float64(longest)
+	return max(1, int(math.Round(float64(width)*scale))), max(1, int(math.Round(float64(height)*scale)))
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// resize scales an image with a box filter, averaging the source pixels each
+// target pixel covers, first along rows and then along columns.
+func resize(img image.Image, width, height int) *image.RGBA {
+	src := toRGBA(img)
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	if srcWidth == width && srcHeight == height {
+		return src
+	}
+
+	rows := image.NewRGBA(image.Rect(0, 0, width, srcHeight))
+	for x := 0; x < width; x++ {
+		x0, x1 := span(x, width, srcWidth)
+		for y := 0; y < srcHeight; y++ {
+			average(rows.Pix[y*rows.Stride+x*4:], src.Pix[y*src.Stride+x0*4:], 4, x1-x0)
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := span(y, height, srcHeight)
+		for x := 0; x < width; x++ {
+			average(dst.Pix[y*dst.Stride+x*4:], rows.Pix[y0*rows.Stride+x*4:], rows.Stride, y1-y0)
+		}
+	}
+	return dst
+}
+
+// span returns the source pixels [lo, hi) target pixel i covers
+func span(i, dstSize, srcSize int) (int, int) {
+	lo := i * srcSize / dstSize
+	hi := (i + 1) * srcSize / dstSize
+	if hi <= lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+// average writes the mean of count pixels, stride bytes apart, to dst
+func average(dst, src []byte, stride, count int) {
+	var sum [4]int
+	for i := 0; i < count; i++ {
+		pixel := src[i*stride : i*stride+4]
+		sum[0] += int(pixel[0])
+		sum[1] += int(pixel[1])
+		sum[2] += int(pixel[2])
+		sum[3] += int(pixel[3])
+	}
+	for c := 0; c < 4; c++ {
+		dst[c] = byte((sum[c] + count/2) / count)
+	}
+}
@@ -0,0 +1,212 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// testImage is red on its left half and blue on its right half
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+// exifSegment is an APP1 segment with an orientation and a GPS IFD pointer
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 2)
+	tiff = append(tiff, 0x01, 0x12, 0x00, 0x03, 0, 0, 0, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	tiff = append(tiff, 0x88, 0x25, 0x00, 0x04, 0, 0, 0, 1, 0, 0, 0, 0)
+	tiff = append(tiff, 0, 0, 0, 0)
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xff, 0xe1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image, segments ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("failed to encode JPEG: %v", err)
+	}
+	data := buf.Bytes()
+	out := append([]byte(nil), data[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, data[2:]...)
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+}
+
+func TestStripMetadata(t *testing.T) {
+	comment := append([]byte{0xff, 0xfe, 0x00, 0x08}, "camera"...)
+	plain := encodeJPEG(t, testImage(40, 20), exifSegment(1), comment)
+	stripped, err := StripMetadata(plain, MimeJPEG)
+	if err != nil {
+		t.Fatalf("failed to strip JPEG: %v", err)
+	}
+	if bytes.Contains(stripped, []byte("Exif")) || bytes.Contains(stripped, []byte("camera")) {
+		t.Fatalf("expected EXIF data and comments to be removed")
+	}
+	if len(plain)-len(stripped) != len(exifSegment(1))+len(comment) {
+		t.Fatalf("expected only the metadata segments to be removed, %d -> %d bytes", len(plain), len(stripped))
+	}
+
+	// Rotated photos are turned upright before their orientation is dropped
+	rotated, err := StripMetadata(encodeJPEG(t, testImage(40, 20), exifSegment(6)), MimeJPEG)
+	if err != nil {
+		t.Fatalf("failed to strip rotated JPEG: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(rotated))
+	if err != nil {
+		t.Fatalf("failed to decode stripped JPEG: %v", err)
+	}
+	if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 40 || bytes.Contains(rotated, []byte("Exif")) {
+		t.Fatalf("expected an upright 20x40 image without EXIF, got %v", img.Bounds())
+	}
+	// Rotating clockwise moves the red left half to the top
+	if r, _, b, _ := img.At(10, 5).RGBA(); r < b {
+		t.Fatalf("expected the top to be red after rotating")
+	}
+
+	var encoded bytes.Buffer
+	png.Encode(&encoded, testImage(8, 8))
+	pngData := encoded.Bytes()
+	withText := append(append(append([]byte(nil), pngData[:33]...), pngChunk("tEXt", []byte("GPS\x0048.1,11.5"))...), pngData[33:]...)
+	stripped, err = StripMetadata(withText, MimePNG)
+	if err != nil {
+		t.Fatalf("failed to strip PNG: %v", err)
+	}
+	if !bytes.Equal(stripped, pngData) {
+		t.Fatalf("expected the text chunk to be removed")
+	}
+
+	vp8x := append([]byte("VP8X"), 10, 0, 0, 0, 0x08|0x04|0x10, 0, 0, 0, 7, 0, 0, 7, 0, 0)
+	exif := append([]byte("EXIF"), 3, 0, 0, 0, 'g', 'p', 's', 0)
+	frame := append([]byte("VP8L"), 2, 0, 0, 0, 1, 2)
+	body := append(append(append([]byte("WEBP"), vp8x...), exif...), frame...)
+	webp := append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+	stripped, err = StripMetadata(webp, MimeWebP)
+	if err != nil {
+		t.Fatalf("failed to strip WebP: %v", err)
+	}
+	if bytes.Contains(stripped, []byte("EXIF")) || stripped[20] != 0x10 || int(binary.LittleEndian.Uint32(stripped[4:])) != len(stripped)-8 {
+		t.Fatalf("expected the EXIF chunk and flags to be removed, got %q", stripped)
+	}
+}
+
+func TestDownscaleAndThumbnail(t *testing.T) {
+	large := encodeJPEG(t, testImage(3000, 1500))
+	resized, mimeType, err := Downscale(large, MimeJPEG, 1568)
+	if err != nil {
+		t.Fatalf("failed to downscale: %v", err)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(resized))
+	if err != nil || mimeType != MimeJPEG || config.Width != 1568 || config.Height != 784 {
+		t.Fatalf("expected a 1568x784 JPEG, got %s %dx%d (%v)", mimeType, config.Width, config.Height, err)
+	}
+	if unchanged, _, err := Downscale(resized, MimeJPEG, 2048); err != nil || !bytes.Equal(unchanged, resized) {
+		t.Fatalf("expected images that fit to be unchanged (%v)", err)
+	}
+	if _, _, err := Downscale([]byte("RIFF"), MimeWebP, 1024); err != ErrUnsupported {
+		t.Fatalf("expected WebP to be unsupported, got %v", err)
+	}
+
+	var transparent bytes.Buffer
+	png.Encode(&transparent, image.NewNRGBA(image.Rect(0, 0, 200, 800)))
+	thumbnail, mimeType, err := Thumbnail(transparent.Bytes(), MimePNG)
+	if err != nil {
+		t.Fatalf("failed to render thumbnail: %v", err)
+	}
+	config, _, err = image.DecodeConfig(bytes.NewReader(thumbnail))
+	if err != nil || mimeType != MimePNG || config.Width != 80 || config.Height != ThumbnailSize {
+		t.Fatalf("expected an 80x%d PNG thumbnail, got %s %dx%d (%v)", ThumbnailSize, mimeType, config.Width, config.Height, err)
+	}
+
+	// The box filter keeps colors, the halves stay red and blue
+	img := resize(testImage(100, 10), 10, 1)
+	if left, right := img.RGBAAt(2, 0), img.RGBAAt(7, 0); left.R != 255 || left.B != 0 || right.B != 255 || right.R != 0 {
+		t.Fatalf("unexpected resized colors %v %v", left, right)
+	}
+}
+
+// withSize rewrites the dimensions in the frame header of a baseline JPEG
+func withSize(data []byte, width, height uint16) []byte {
+	data = append([]byte(nil), data...)
+	sof := bytes.Index(data, []byte{0xff, 0xc0})
+	binary.BigEndian.PutUint16(data[sof+5:], height)
+	binary.BigEndian.PutUint16(data[sof+7:], width)
+	return data
+}
+
+func TestStripMetadataWithoutDecoding(t *testing.T) {
+	// Rotated photos too large to decode keep their orientation and nothing else
+	large := withSize(encodeJPEG(t, testImage(40, 20), exifSegment(6)), 10000, 8000)
+	stripped, err := StripMetadata(large, MimeJPEG)
+	if err != nil {
+		t.Fatalf("failed to strip large JPEG: %v", err)
+	}
+	expected := withSize(encodeJPEG(t, testImage(40, 20), orientationSegment(6)), 10000, 8000)
+	if !bytes.Equal(stripped, expected) || jpegOrientation(stripped) != 6 {
+		t.Fatalf("expected only the orientation to be kept, %d -> %d bytes", len(large), len(stripped))
+	}
+
+	vp8x := append([]byte("VP8X"), 10, 0, 0, 0, 0x08|0x10, 0, 0, 0, 7, 0, 0, 7, 0, 0)
+	xmp := append([]byte("XMP "), 5, 0, 0, 0, 'x', 'm', 'p', 'g', 'p', 0)
+	frame := append([]byte("VP8L"), 2, 0, 0, 0, 1, 2)
+	body := append(append(append([]byte("WEBP"), vp8x...), xmp...), frame...)
+	webp := append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+	var out bytes.Buffer
+	n, err := StripMetadataTo(&out, bytes.NewReader(webp), MimeWebP)
+	if err != nil {
+		t.Fatalf("failed to stream WebP: %v", err)
+	}
+	streamed := out.Bytes()
+	if n != int64(len(streamed)) || len(streamed) != len(webp)-len(xmp) || bytes.Contains(streamed, []byte("XMP")) {
+		t.Fatalf("expected the XMP chunk to be removed, got %q", streamed)
+	}
+	if streamed[20] != 0x10 || int(binary.LittleEndian.Uint32(streamed[4:])) != len(streamed)-8 {
+		t.Fatalf("expected the XMP flag and RIFF size to be updated, got %q", streamed)
+	}
+
+	// Truncated images are rejected rather than passed through
+	for _, mimeType := range []string{MimeJPEG, MimePNG, MimeWebP} {
+		var truncated []byte
+		switch mimeType {
+		case MimeJPEG:
+			truncated = large[:30]
+		case MimePNG:
+			truncated = append([]byte("\x89PNG\r\n\x1a\n"), pngChunk("tEXt", []byte("GPS\x0048.1,11.5"))[:10]...)
+		case MimeWebP:
+			truncated = webp[:len(webp)-1]
+		}
+		if _, err := StripMetadataTo(io.Discard, bytes.NewReader(truncated), mimeType); err != errMalformed {
+			t.Fatalf("expected a truncated %s to be malformed, got %v", mimeType, err)
+		}
+	}
+}
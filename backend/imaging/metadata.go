@@ -0,0 +1,380 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+var errMalformed = errors.New("malformed image")
+
+// StripMetadata removes the EXIF, XMP, IPTC and comment data of an image.
+// Color profiles are kept. GIFs carry no such data and are returned as is.
+func StripMetadata(data []byte, mimeType string) ([]byte, error) {
+	if mimeType == MimeJPEG {
+		// A rotated image is re-encoded upright, without its orientation it
+		// would turn sideways. Those too large to decode keep their orientation.
+		if orientation := jpegOrientation(data); orientation > 1 {
+			if img, err := decode(data, MimeJPEG); err == nil {
+				stripped, _, err := encode(orient(img, orientation), MimeJPEG)
+				return stripped, err
+			}
+		}
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	if _, err := StripMetadataTo(out, bytes.NewReader(data), mimeType); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// StripMetadataTo writes an image without its metadata to w, returning the
+// number of bytes written. It streams the image segment by segment for those
+// too large to hold in memory, so it never decodes them: the EXIF data of a
+// rotated JPEG is replaced by its orientation alone.
+func StripMetadataTo(w io.Writer, r io.ReadSeeker, mimeType string) (int64, error) {
+	counter := &countingWriter{w: w}
+	var err error
+	switch mimeType {
+	case MimeJPEG:
+		err = stripJPEG(counter, bufio.NewReader(r))
+	case MimePNG:
+		err = stripPNG(counter, bufio.NewReader(r))
+	case MimeWebP:
+		err = stripWebP(counter, r)
+	case MimeGIF:
+		_, err = io.Copy(counter, r)
+	default:
+		err = ErrUnsupported
+	}
+	return counter.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readSegment fills buf, an image that ends early is malformed
+func readSegment(r io.Reader, buf []byte) error {
+	_, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errMalformed
+	}
+	return err
+}
+
+// copySegment copies the next n bytes of an image, discarding them when w is nil
+func copySegment(w io.Writer, r io.Reader, n int64) error {
+	if w == nil {
+		w = io.Discard
+	}
+	_, err := io.CopyN(w, r, n)
+	if err == io.EOF {
+		return errMalformed
+	}
+	return err
+}
+
+// stripJPEG drops the APP segments other than JFIF (APP0), ICC profiles
+// (APP2) and Adobe color transforms (APP14), and the comments.
+func stripJPEG(w io.Writer, r *bufio.Reader) error {
+	var header [4]byte
+	if err := readSegment(r, header[:2]); err != nil {
+		return err
+	}
+	if header[0] != 0xff || header[1] != 0xd8 {
+		return errMalformed
+	}
+	if _, err := w.Write(header[:2]); err != nil {
+		return err
+	}
+	for {
+		if err := readSegment(r, header[:2]); err != nil {
+			return err
+		}
+		if header[0] != 0xff {
+			return errMalformed
+		}
+		// Fill bytes before a marker
+		for header[1] == 0xff {
+			if err := readSegment(r, header[1:2]); err != nil {
+				return err
+			}
+		}
+		if err := readSegment(r, header[2:4]); err != nil {
+			return err
+		}
+		marker := header[1]
+		length := int64(binary.BigEndian.Uint16(header[2:]))
+		if length < 2 {
+			return errMalformed
+		}
+		if marker == 0xda {
+			// The entropy coded data after start of scan holds no metadata
+			if _, err := w.Write(header[:]); err != nil {
+				return err
+			}
+			_, err := io.Copy(w, r)
+			return err
+		}
+		keep := !(marker >= 0xe0 && marker <= 0xef || marker == 0xfe) ||
+			marker == 0xe0 || marker == 0xe2 || marker == 0xee
+		switch {
+		case keep:
+			if _, err := w.Write(header[:]); err != nil {
+				return err
+			}
+			if err := copySegment(w, r, length-2); err != nil {
+				return err
+			}
+		case marker == 0xe1:
+			segment := make([]byte, length-2)
+			if err := readSegment(r, segment); err != nil {
+				return err
+			}
+			if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				if orientation := tiffOrientation(segment[6:]); orientation > 1 {
+					if _, err := w.Write(orientationSegment(orientation)); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			if err := copySegment(nil, r, length-2); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// orientationSegment is an APP1 segment with an EXIF orientation and nothing else
+func orientationSegment(orientation int) []byte {
+	segment := []byte{0xff, 0xe1, 0x00, 0x22}
+	segment = append(segment, "Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01"...)
+	segment = append(segment, 0x01, 0x12, 0x00, 0x03, 0, 0, 0, 1)
+	segment = binary.BigEndian.AppendUint16(segment, uint16(orientation))
+	return append(segment, 0, 0, 0, 0, 0, 0)
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, 1 when it has none
+func jpegOrientation(data []byte) int {
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xff {
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xda || length < 2 || pos+2+length > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation reads the orientation tag of the first IFD of a TIFF header
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient applies an EXIF orientation, returning the upright image
+func orient(img image.Image, orientation int) *image.RGBA {
+	src := toRGBA(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = width-1-x, y
+			case 3:
+				sx, sy = width-1-x, height-1-y
+			case 4:
+				sx, sy = x, height-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, height-1-x
+			case 7:
+				sx, sy = width-1-y, height-1-x
+			case 8:
+				sx, sy = width-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:])
+		}
+	}
+	return dst
+}
+
+// pngMetadataChunks hold EXIF data, text like XMP and comments, and the edit time
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func stripPNG(w io.Writer, r io.Reader) error {
+	var header [8]byte
+	if err := readSegment(r, header[:]); err != nil {
+		return err
+	}
+	if string(header[:]) != "\x89PNG\r\n\x1a\n" {
+		return errMalformed
+	}
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	for {
+		if err := readSegment(r, header[:]); err != nil {
+			return err
+		}
+		chunkType := string(header[4:])
+		// The chunk data is followed by its CRC
+		length := int64(binary.BigEndian.Uint32(header[:4])) + 4
+		if pngMetadataChunks[chunkType] {
+			if err := copySegment(nil, r, length); err != nil {
+				return err
+			}
+		} else {
+			if _, err := w.Write(header[:]); err != nil {
+				return err
+			}
+			if err := copySegment(w, r, length); err != nil {
+				return err
+			}
+		}
+		if chunkType == "IEND" {
+			return nil
+		}
+	}
+}
+
+// webpMetadataChunks hold EXIF data and XMP
+var webpMetadataChunks = map[string]bool{"EXIF": true, "XMP ": true}
+
+// stripWebP drops the EXIF and XMP chunks and clears their VP8X flags. The
+// RIFF header holds the size of the stripped image, the chunks are measured
+// in a first pass before they are copied.
+func stripWebP(w io.Writer, r io.ReadSeeker) error {
+	total, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var header [12]byte
+	if err := readSegment(r, header[:]); err != nil {
+		return err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return errMalformed
+	}
+
+	size := int64(4)
+	err = walkWebPChunks(r, total, func(chunk [8]byte, length int64) error {
+		if !webpMetadataChunks[string(chunk[:4])] {
+			size += 8 + length
+		}
+		_, err := r.Seek(length, io.SeekCurrent)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(12, io.SeekStart); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(header[4:], uint32(size))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	return walkWebPChunks(r, total, func(chunk [8]byte, length int64) error {
+		fourCC := string(chunk[:4])
+		if webpMetadataChunks[fourCC] {
+			_, err := r.Seek(length, io.SeekCurrent)
+			return err
+		}
+		if _, err := w.Write(chunk[:]); err != nil {
+			return err
+		}
+		if fourCC != "VP8X" {
+			return copySegment(w, r, length)
+		}
+		data := make([]byte, length)
+		if err := readSegment(r, data); err != nil {
+			return err
+		}
+		if length > 0 {
+			data[0] &^= 0x08 | 0x04
+		}
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// walkWebPChunks calls visit with the header and padded length of every chunk
+// after the RIFF header, visit consumes the chunk data
+func walkWebPChunks(r io.Reader, total int64, visit func(chunk [8]byte, length int64) error) error {
+	var chunk [8]byte
+	for pos := int64(12); pos+8 <= total; {
+		if err := readSegment(r, chunk[:]); err != nil {
+			return err
+		}
+		length := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		length += length % 2
+		end := pos + 8 + length
+		if end > total {
+			return errMalformed
+		}
+		if err := visit(chunk, length); err != nil {
+			return err
+		}
+		pos = end
+	}
+	return nil
+}
@@ -375,6 +375,7 @@ func main() {
 			cmd.RunCli(),
 			cmd.ServerCli(),
 			cmd.WorkerCli(),
+			cmd.StorageCli(),
 			cmd.ClientCli(),
 		},
 	}
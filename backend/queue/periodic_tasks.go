@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"log"
+	"time"
+
+	"backend/database"
+	"backend/workqueue"
+
+	"github.com/hibiken/asynq"
+	"gorm.io/gorm"
+)
+
+// BotScheduleSyncInterval is how often schedule changes reach the scheduler.
+const BotScheduleSyncInterval = 30 * time.Second
+
+// BotScheduleConfigProvider feeds the active bot schedules to an
+// asynq.PeriodicTaskManager.
+type BotScheduleConfigProvider struct {
+	DB *gorm.DB
+}
+
+func (p *BotScheduleConfigProvider) GetConfigs() ([]*asynq.PeriodicTaskConfig, error) {
+	schedules, err := database.ListActiveBotSchedules(p.DB)
+	if err != nil {
+		return nil, err
+	}
+	configs := make([]*asynq.PeriodicTaskConfig, 0, len(schedules))
+	for _, schedule := range schedules {
+		// Schedules stored before a validation change must not stop the others from syncing
+		if _, _, err := database.ParseBotSchedule(schedule.CronExpression, schedule.TimeZone); err != nil {
+			log.Printf("Skipping bot schedule %s: %v", schedule.UUID, err)
+			continue
+		}
+		task, err := workqueue.NewBotScheduleTask(workqueue.BotSchedulePayload{ScheduleUUID: schedule.UUID})
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, &asynq.PeriodicTaskConfig{
+			Cronspec: schedule.Cronspec(),
+			Task:     task,
+			Opts:     workqueue.BotScheduleTaskOptions(),
+		})
+	}
+	return configs, nil
+}
+
+// PeriodicTaskConfigProvider feeds the maintenance tasks and the active bot
+// schedules to an asynq.PeriodicTaskManager.
+type PeriodicTaskConfigProvider struct {
+	DB *gorm.DB
+}
+
+func (p *PeriodicTaskConfigProvider) GetConfigs() ([]*asynq.PeriodicTaskConfig, error) {
+	configs := []*asynq.PeriodicTaskConfig{{
+		Cronspec: workqueue.RetentionPurgeCronspec,
+		Task:     workqueue.NewRetentionPurgeTask(),
+		Opts:     workqueue.RetentionPurgeTaskOptions(),
+	}, {
+		Cronspec: workqueue.TusExpireCronspec,
+		Task:     workqueue.NewTusExpireTask(),
+		Opts:     workqueue.TusExpireTaskOptions(),
+	}}
+	schedules, err := (&BotScheduleConfigProvider{DB: p.DB}).GetConfigs()
+	if err != nil {
+		return nil, err
+	}
+	return append(configs, schedules...), nil
+}
+
+// NewPeriodicTaskManager returns the scheduler enqueueing the maintenance
+// tasks and the runs of bot schedules.
+func NewPeriodicTaskManager(connOpt asynq.RedisConnOpt, DB *gorm.DB) (*asynq.PeriodicTaskManager, error) {
+	return asynq.NewPeriodicTaskManager(asynq.PeriodicTaskManagerOpts{
+		RedisConnOpt:               connOpt,
+		PeriodicTaskConfigProvider: &PeriodicTaskConfigProvider{DB: DB},
+		SyncInterval:               BotScheduleSyncInterval,
+	})
+}
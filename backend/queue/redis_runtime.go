@@ -6,6 +6,7 @@ import (
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
@@ -63,6 +64,19 @@ func ResolveRedisRuntime(mode string, redisURL string, redisAddr string, redisPa
 	}
 }
 
+// NewRedisClient opens a separate go-redis client against the same Redis the
+// asynq runtime uses, e.g. for websocket pub/sub. The caller must close it.
+func (r *RedisRuntime) NewRedisClient() (redis.UniversalClient, error) {
+	if r == nil || r.ConnOpt == nil {
+		return nil, fmt.Errorf("redis runtime is not initialized")
+	}
+	client, ok := r.ConnOpt.MakeRedisClient().(redis.UniversalClient)
+	if !ok {
+		return nil, fmt.Errorf("unsupported redis connection option %T", r.ConnOpt)
+	}
+	return client, nil
+}
+
 func normalizeRedisMode(mode string) (string, error) {
 	mode = strings.ToLower(strings.TrimSpace(mode))
 	if mode == "" {
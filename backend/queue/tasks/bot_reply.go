@@ -45,6 +45,8 @@ func HandleBotReply(ctx context.Context, task *asynq.Task, deps Deps) error {
 	if !botUser.IsAutomated {
 		return fmt.Errorf("%w: receiver is not an automated user", asynq.SkipRetry)
 	}
+	// The typing indicator shown while the reply was queued ends with the task
+	defer publishBotStoppedTyping(deps, payload.ChatUUID, botUser)
 
 	var incomingMessage database.Message
 	if err := deps.DB.Where("uuid = ?", payload.MessageUUID).First(&incomingMessage).Error; err != nil {
@@ -76,6 +78,22 @@ func HandleBotReply(ctx context.Context, task *asynq.Task, deps Deps) error {
 	ocClient.SetSessionId(token)
 	ocClient.User = client.User{UUID: botUser.UUID}
 
+	// Quotas are checked when the reply runs, so every way of enqueueing
+	// bot:reply is covered by the same check.
+	if err := database.CheckTokenQuotas(deps.DB, senderUser.ID, botUser.ID, time.Now()); err != nil {
+		failureMessage, blocked := quotaFailureMessage(err)
+		if !blocked {
+			return fmt.Errorf("failed to check token quotas: %w", err)
+		}
+		if sendErr := sendBotFailureMessage(ocClient, payload.ChatUUID, failureMessage); sendErr != nil {
+			failureMessage = fmt.Sprintf("%s (fallback send failed: %v)", failureMessage, sendErr)
+		}
+		failure := ToolExecutionResult{Success: false, Error: failureMessage}
+		_ = writeResult(task, failure)
+		persistTaskResult(deps.DB, task, failure)
+		return fmt.Errorf("%w: %v", asynq.SkipRetry, err)
+	}
+
 	wsHandler := deps.WSHandler
 	if wsHandler == nil {
 		wsHandler = wsapi.NewWebSocketHandler()
@@ -86,6 +104,7 @@ func HandleBotReply(ctx context.Context, task *asynq.Task, deps Deps) error {
 		BotUser:      botUser,
 		WSHandler:    wsHandler,
 		ChatCanceler: msgmate.NewChatCanceler(),
+		DB:           deps.DB,
 	}
 
 	message := wsapi.NewMessage{Type: "new_message"}
@@ -125,6 +144,11 @@ func HandleBotReply(ctx context.Context, task *asynq.Task, deps Deps) error {
 		}
 	}
 
+	// Stop streaming as soon as the chat is interrupted, even if the interrupt
+	// was sent to a different server or worker process.
+	ctx, stopWatching := deps.ReplyCanceler.Watch(ctx, payload.ChatUUID)
+	defer stopWatching()
+
 	aiHandler := msgmate.NewAIHandler(botContext)
 	if err := aiHandler.GenerateResponse(ctx, message); err != nil {
 		responseAlreadySent := errors.Is(err, msgmate.ErrResponseAlreadySent)
@@ -162,6 +186,34 @@ func HandleBotReply(ctx context.Context, task *asynq.Task, deps Deps) error {
 	return writeResult(task, success)
 }
 
+// publishBotStoppedTyping hides the typing indicator of a bot from the
+// human participants of a chat.
+func publishBotStoppedTyping(deps Deps, chatUUID string, bot database.User) {
+	if deps.WSHandler == nil {
+		return
+	}
+	var chat database.Chat
+	if err := deps.DB.Select("id").Where("uuid = ?", chatUUID).First(&chat).Error; err != nil {
+		return
+	}
+	participants, err := database.ListChatParticipants(deps.DB, chat.ID)
+	if err != nil {
+		log.Printf("Failed to load participants to stop typing in chat %s: %v", chatUUID, err)
+		return
+	}
+	receiverUUIDs := []string{}
+	for _, participant := range participants {
+		if !participant.User.IsAutomated {
+			receiverUUIDs = append(receiverUUIDs, participant.User.UUID)
+		}
+	}
+	deps.WSHandler.MessageHandler.SendMessageToMany(
+		deps.WSHandler,
+		receiverUUIDs,
+		deps.WSHandler.MessageHandler.Typing(chatUUID, bot.UUID, false, 0),
+	)
+}
+
 func shouldDisposeToolInitForChat(chat database.Chat) bool {
 	if !strings.HasPrefix(chat.ChatType, "interaction") {
 		return false
@@ -228,6 +280,42 @@ func botReplyFailureMessage(err error) string {
 	return "I ran into an error while generating a reply. Please try again in a moment."
 }
 
+// quotaFailureMessage explains a failed quota check to the chat. It reports
+// false when the check failed for another reason than a used up quota.
+func quotaFailureMessage(err error) (string, bool) {
+	var quotaErr *database.TokenQuotaExceededError
+	if errors.As(err, &quotaErr) {
+		return tokenQuotaExceededMessage(quotaErr), true
+	}
+	var budgetErr *database.TokenBudgetExceededError
+	if errors.As(err, &budgetErr) {
+		owner := "your"
+		if budgetErr.Subject == database.TokenQuotaSubjectBot {
+			owner = "this bot's"
+		}
+		return fmt.Sprintf(
+			"I can't reply because %s monthly budget is spent (%.2f of %.2f). Please try again next month or ask an admin to raise the budget.",
+			owner, budgetErr.Spent, budgetErr.Budget,
+		), true
+	}
+	return "", false
+}
+
+func tokenQuotaExceededMessage(err *database.TokenQuotaExceededError) string {
+	owner := "your"
+	if err.Subject == database.TokenQuotaSubjectBot {
+		owner = "this bot's"
+	}
+	retry := "tomorrow"
+	if err.Period == "monthly" {
+		retry = "next month"
+	}
+	return fmt.Sprintf(
+		"I can't reply because %s %s token quota is used up (%d of %d tokens). Please try again %s or ask an admin to raise the quota.",
+		owner, err.Period, err.Used, err.Limit, retry,
+	)
+}
+
 func sendBotFailureMessage(ocClient *client.Client, chatUUID, text string) error {
 	metadata := map[string]interface{}{
 		"finished": true,
@@ -1,6 +1,7 @@
 package tasks
 
 import (
+	"backend/database"
 	"context"
 	"errors"
 	"testing"
@@ -48,3 +49,16 @@ func TestBotReplyFailureMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenQuotaExceededMessage(t *testing.T) {
+	got := tokenQuotaExceededMessage(&database.TokenQuotaExceededError{
+		Subject: database.TokenQuotaSubjectBot,
+		Period:  "monthly",
+		Limit:   1000,
+		Used:    1250,
+	})
+	expected := "I can't reply because this bot's monthly token quota is used up (1250 of 1000 tokens). Please try again next month or ask an admin to raise the quota."
+	if got != expected {
+		t.Fatalf("unexpected message\nexpected: %q\nactual:   %q", expected, got)
+	}
+}
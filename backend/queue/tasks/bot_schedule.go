@@ -0,0 +1,138 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"backend/api/bots"
+	"backend/api/chats"
+	"backend/database"
+	"backend/workqueue"
+
+	"github.com/hibiken/asynq"
+	"gorm.io/gorm"
+)
+
+// HandleBotSchedule enqueues the run of a bot schedule's firing. Every
+// worker's scheduler enqueues this task, the runs they enqueue share a task
+// ID and all but the first are dropped.
+func HandleBotSchedule(_ context.Context, task *asynq.Task, deps Deps) error {
+	if deps.DB == nil || deps.QueueClient == nil {
+		return fmt.Errorf("%w: database or queue unavailable", asynq.SkipRetry)
+	}
+	var payload workqueue.BotSchedulePayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", asynq.SkipRetry, err)
+	}
+
+	schedule, err := loadActiveBotSchedule(deps.DB, payload.ScheduleUUID)
+	if err != nil || schedule == nil {
+		return err
+	}
+	_, err = workqueue.EnqueueBotScheduleRun(deps.QueueClient, workqueue.BotScheduleRunPayload{
+		ScheduleUUID: schedule.UUID,
+		FiredAt:      database.BotScheduleFiring(*schedule, time.Now()),
+	})
+	if errors.Is(err, asynq.ErrTaskIDConflict) {
+		return nil
+	}
+	return err
+}
+
+// HandleBotScheduleRun runs a bot schedule's firing: it starts a new
+// interaction with the schedule's bot or posts the message into the
+// schedule's chat, and records the run. Schedules paused or deleted since the
+// scheduler last synced are skipped.
+func HandleBotScheduleRun(_ context.Context, task *asynq.Task, deps Deps) error {
+	if deps.DB == nil || deps.WSHandler == nil {
+		return fmt.Errorf("%w: database or websocket unavailable", asynq.SkipRetry)
+	}
+	var payload workqueue.BotScheduleRunPayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", asynq.SkipRetry, err)
+	}
+
+	schedule, err := loadActiveBotSchedule(deps.DB, payload.ScheduleUUID)
+	if err != nil || schedule == nil {
+		return err
+	}
+
+	run := database.BotScheduleRun{ScheduleId: schedule.ID, StartedAt: time.Now()}
+	chatID, runErr := runBotSchedule(deps, *schedule)
+	run.FinishedAt = time.Now()
+	run.ChatId = chatID
+	result := ToolExecutionResult{Success: runErr == nil}
+	if runErr != nil {
+		log.Printf("Bot schedule %s failed: %v", schedule.UUID, runErr)
+		run.Error = runErr.Error()
+		result.Error = runErr.Error()
+	} else {
+		run.Success = true
+		result.Result = "ok"
+	}
+	if record := persistTaskResult(deps.DB, task, result); record != nil {
+		run.TaskResultId = &record.ID
+	}
+	if err := database.RecordBotScheduleRun(deps.DB, &run); err != nil {
+		return fmt.Errorf("%w: failed to record run of bot schedule %s: %v", asynq.SkipRetry, schedule.UUID, err)
+	}
+	if runErr != nil {
+		return fmt.Errorf("%w: %v", asynq.SkipRetry, runErr)
+	}
+	return nil
+}
+
+// loadActiveBotSchedule returns the schedule with its owner and bot, nil when
+// it was deleted or paused.
+func loadActiveBotSchedule(DB *gorm.DB, scheduleUUID string) (*database.BotSchedule, error) {
+	var schedule database.BotSchedule
+	err := DB.Preload("Owner").
+		Preload("BotRuntimeConfig").
+		Where("uuid = ?", scheduleUUID).
+		First(&schedule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Paused {
+		return nil, nil
+	}
+	return &schedule, nil
+}
+
+// runBotSchedule sends the scheduled message and returns the chat it went to.
+func runBotSchedule(deps Deps, schedule database.BotSchedule) (*uint, error) {
+	if schedule.ChatId != nil {
+		message, err := chats.SendChatMessageAs(deps.DB, deps.WSHandler, deps.QueueClient, deps.QueueInspector, *schedule.ChatId, schedule.Owner, chats.SendMessage{Text: schedule.Message})
+		if err != nil {
+			return nil, err
+		}
+		return &message.ChatId, nil
+	}
+
+	if schedule.BotRuntimeConfig == nil {
+		return nil, fmt.Errorf("schedule has neither a bot nor a chat")
+	}
+	// The owner may have lost access to the bot since the schedule was created
+	runtime, err := bots.ResolveReadableBot(deps.DB, &schedule.Owner, schedule.BotRuntimeConfig.UUID)
+	if err != nil {
+		return nil, fmt.Errorf("bot is no longer available")
+	}
+	req := bots.CreateBotInteractionRequest{Message: schedule.Message}
+	if len(schedule.ConfigOverrides) > 0 {
+		if err := json.Unmarshal(schedule.ConfigOverrides, &req.ConfigOverrides); err != nil {
+			return nil, fmt.Errorf("invalid config overrides: %w", err)
+		}
+	}
+	chat, _, err := bots.CreateInteractionChat(deps.DB, deps.QueueClient, deps.QueueInspector, &schedule.Owner, runtime, req)
+	if err != nil {
+		return nil, err
+	}
+	return &chat.ID, nil
+}
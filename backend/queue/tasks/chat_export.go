@@ -0,0 +1,38 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"backend/api/exports"
+	"backend/database"
+	"backend/workqueue"
+
+	"github.com/hibiken/asynq"
+)
+
+// HandleChatExport renders a pending chat export and notifies its owner.
+// Exports deleted or already rendered are skipped.
+func HandleChatExport(_ context.Context, task *asynq.Task, deps Deps) error {
+	if deps.DB == nil {
+		return fmt.Errorf("%w: database unavailable", asynq.SkipRetry)
+	}
+	var payload workqueue.ChatExportPayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", asynq.SkipRetry, err)
+	}
+
+	export, err := database.ClaimChatExport(deps.DB, payload.ExportUUID)
+	if errors.Is(err, database.ErrChatExportNotPending) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := exports.RunChatExport(deps.DB, deps.WSHandler, export); err != nil {
+		return fmt.Errorf("%w: failed to record chat export %s: %v", asynq.SkipRetry, export.UUID, err)
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package tasks
 
 import (
 	wsapi "backend/api/websocket"
+	"backend/workqueue"
 
+	"github.com/hibiken/asynq"
 	"gorm.io/gorm"
 )
 
@@ -11,4 +13,11 @@ type Deps struct {
 	DB          *gorm.DB
 	BackendHost string
 	WSHandler   *wsapi.WebSocketHandler
+	// ReplyCanceler interrupts running bot replies when a chat is interrupted
+	// from any process. Nil disables distributed interruption.
+	ReplyCanceler *workqueue.ReplyCanceler
+	// QueueClient and QueueInspector enqueue the bot replies to delivered
+	// scheduled messages.
+	QueueClient    *asynq.Client
+	QueueInspector *asynq.Inspector
 }
@@ -36,9 +36,11 @@ func convertMapToJSON(data map[string]interface{}) string {
 	return string(jsonBytes)
 }
 
-func persistTaskResult(DB *gorm.DB, task *asynq.Task, result ToolExecutionResult) {
+// persistTaskResult stores the result of a task, it returns nil when it could
+// not be stored.
+func persistTaskResult(DB *gorm.DB, task *asynq.Task, result ToolExecutionResult) *database.TaskResult {
 	if DB == nil || task == nil {
-		return
+		return nil
 	}
 
 	taskID := ""
@@ -61,5 +63,8 @@ func persistTaskResult(DB *gorm.DB, task *asynq.Task, result ToolExecutionResult
 		CompletedAt: time.Now().UTC().Format(time.RFC3339),
 	}
 
-	_ = DB.Create(&record).Error
+	if err := DB.Create(&record).Error; err != nil {
+		return nil
+	}
+	return &record
 }
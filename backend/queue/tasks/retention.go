@@ -0,0 +1,61 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"backend/database"
+	"backend/storage"
+
+	"github.com/hibiken/asynq"
+)
+
+// HandleRetentionPurge hard-deletes what the retention policies expire and
+// removes the stored files of purged uploads and chat exports.
+func HandleRetentionPurge(ctx context.Context, task *asynq.Task, deps Deps) error {
+	if deps.DB == nil {
+		return fmt.Errorf("%w: database unavailable", asynq.SkipRetry)
+	}
+
+	plan, err := database.PlanRetentionPurge(deps.DB, time.Now())
+	if err != nil {
+		return fmt.Errorf("plan retention purge: %w", err)
+	}
+	if err := database.ExecuteRetentionPurge(deps.DB, plan); err != nil {
+		return fmt.Errorf("retention purge: %w", err)
+	}
+	for _, upload := range plan.Uploads {
+		if err := storage.Delete(ctx, upload.StorageURL); err != nil {
+			log.Printf("Retention purge failed to remove upload %s: %v", upload.FileID, err)
+		}
+		if upload.ThumbnailStorageURL != "" {
+			if err := storage.Delete(ctx, upload.ThumbnailStorageURL); err != nil {
+				log.Printf("Retention purge failed to remove the thumbnail of upload %s: %v", upload.FileID, err)
+			}
+		}
+	}
+	for _, export := range plan.ChatExports {
+		if export.StorageURL == "" {
+			continue
+		}
+		if err := storage.Delete(ctx, export.StorageURL); err != nil {
+			log.Printf("Retention purge failed to remove chat export %s: %v", export.UUID, err)
+		}
+	}
+
+	report := plan.Report
+	summary := fmt.Sprintf(
+		"purged %d chats, %d messages, %d tool init data, %d uploads (%d bytes) and %d chat exports",
+		report.Chats+report.DeletedChats,
+		report.Messages+report.DeletedMessages,
+		report.ToolInitData,
+		report.Uploads,
+		report.UploadBytes,
+		report.ChatExports,
+	)
+	log.Printf("Retention purge %s", summary)
+	persistTaskResult(deps.DB, task, ToolExecutionResult{Success: true, Result: summary})
+	return nil
+}
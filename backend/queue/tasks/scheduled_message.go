@@ -0,0 +1,48 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"backend/api/chats"
+	"backend/database"
+	"backend/workqueue"
+
+	"github.com/hibiken/asynq"
+)
+
+// HandleScheduledMessage delivers a scheduled message once it is due. Messages
+// cancelled or rescheduled since the task was enqueued are skipped.
+func HandleScheduledMessage(_ context.Context, task *asynq.Task, deps Deps) error {
+	if deps.DB == nil || deps.WSHandler == nil {
+		return fmt.Errorf("%w: database or websocket unavailable", asynq.SkipRetry)
+	}
+	var payload workqueue.ScheduledMessagePayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", asynq.SkipRetry, err)
+	}
+
+	scheduled, err := database.ClaimScheduledMessage(deps.DB, payload.ScheduledMessageUUID, time.Now())
+	if errors.Is(err, database.ErrScheduledMessageNotPending) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	message, deliveryErr := chats.DeliverScheduledMessage(deps.DB, deps.WSHandler, deps.QueueClient, deps.QueueInspector, *scheduled)
+	var messageID *uint
+	if deliveryErr != nil {
+		log.Printf("Failed to deliver scheduled message %s: %v", scheduled.UUID, deliveryErr)
+	} else {
+		messageID = &message.ID
+	}
+	if err := database.FinishScheduledMessage(deps.DB, scheduled, messageID, deliveryErr, time.Now()); err != nil {
+		return fmt.Errorf("%w: failed to record scheduled message %s: %v", asynq.SkipRetry, scheduled.UUID, err)
+	}
+	return nil
+}
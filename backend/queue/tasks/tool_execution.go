@@ -37,7 +37,8 @@ func HandleToolExecution(_ context.Context, task *asynq.Task, deps Deps) error {
 	if err := deps.DB.Preload("User1").
 		Preload("User2").
 		Preload("SharedConfig").
-		Where("uuid = ? AND (user1_id = ? OR user2_id = ?)", payload.ChatUUID, user.ID, user.ID).
+		Scopes(database.ChatParticipantScope(user.ID)).
+		Where("uuid = ?", payload.ChatUUID).
 		First(&chat).Error; err != nil {
 		return fmt.Errorf("%w: chat not found or access denied", asynq.SkipRetry)
 	}
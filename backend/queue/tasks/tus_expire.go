@@ -0,0 +1,46 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"backend/database"
+
+	"github.com/hibiken/asynq"
+)
+
+// HandleTusExpire removes expired resumable uploads with their partial files.
+// Partial files are only reachable when the worker shares the disk of the
+// server that received the upload.
+func HandleTusExpire(_ context.Context, task *asynq.Task, deps Deps) error {
+	if deps.DB == nil {
+		return fmt.Errorf("%w: database unavailable", asynq.SkipRetry)
+	}
+
+	uploads, err := database.ListExpiredTusUploads(deps.DB, time.Now())
+	if err != nil {
+		return fmt.Errorf("list expired uploads: %w", err)
+	}
+	ids := make([]uint, 0, len(uploads))
+	for _, upload := range uploads {
+		if upload.PartialPath != "" {
+			if err := os.Remove(upload.PartialPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove partial upload %s: %v", upload.UUID, err)
+			}
+		}
+		ids = append(ids, upload.ID)
+	}
+	if len(ids) > 0 {
+		if err := deps.DB.Unscoped().Delete(&database.TusUpload{}, ids).Error; err != nil {
+			return fmt.Errorf("delete expired uploads: %w", err)
+		}
+	}
+
+	summary := fmt.Sprintf("removed %d expired uploads", len(ids))
+	log.Printf("Tus expiration %s", summary)
+	persistTaskResult(deps.DB, task, ToolExecutionResult{Success: true, Result: summary})
+	return nil
+}
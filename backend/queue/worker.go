@@ -15,6 +15,11 @@ type Processor struct {
 	DB          *gorm.DB
 	BackendHost string
 	WSHandler   *wsapi.WebSocketHandler
+	// ReplyCanceler is optional; see tasks.Deps.
+	ReplyCanceler *workqueue.ReplyCanceler
+	// QueueClient and QueueInspector are optional; see tasks.Deps.
+	QueueClient    *asynq.Client
+	QueueInspector *asynq.Inspector
 }
 
 func (p *Processor) NewServeMux() *asynq.ServeMux {
@@ -30,13 +35,34 @@ func (p *Processor) NewServeMux() *asynq.ServeMux {
 	mux.HandleFunc(workqueue.TypeEmailAutomation, func(ctx context.Context, task *asynq.Task) error {
 		return tasks.HandleEmailAutomation(ctx, task, deps)
 	})
+	mux.HandleFunc(workqueue.TypeScheduledMessage, func(ctx context.Context, task *asynq.Task) error {
+		return tasks.HandleScheduledMessage(ctx, task, deps)
+	})
+	mux.HandleFunc(workqueue.TypeBotSchedule, func(ctx context.Context, task *asynq.Task) error {
+		return tasks.HandleBotSchedule(ctx, task, deps)
+	})
+	mux.HandleFunc(workqueue.TypeBotScheduleRun, func(ctx context.Context, task *asynq.Task) error {
+		return tasks.HandleBotScheduleRun(ctx, task, deps)
+	})
+	mux.HandleFunc(workqueue.TypeChatExport, func(ctx context.Context, task *asynq.Task) error {
+		return tasks.HandleChatExport(ctx, task, deps)
+	})
+	mux.HandleFunc(workqueue.TypeRetentionPurge, func(ctx context.Context, task *asynq.Task) error {
+		return tasks.HandleRetentionPurge(ctx, task, deps)
+	})
+	mux.HandleFunc(workqueue.TypeTusExpire, func(ctx context.Context, task *asynq.Task) error {
+		return tasks.HandleTusExpire(ctx, task, deps)
+	})
 	return mux
 }
 
 func (p *Processor) deps() tasks.Deps {
 	return tasks.Deps{
-		DB:          p.DB,
-		BackendHost: p.BackendHost,
-		WSHandler:   p.WSHandler,
+		DB:             p.DB,
+		BackendHost:    p.BackendHost,
+		WSHandler:      p.WSHandler,
+		ReplyCanceler:  p.ReplyCanceler,
+		QueueClient:    p.QueueClient,
+		QueueInspector: p.QueueInspector,
 	}
 }
@@ -5,16 +5,19 @@ import (
 	"backend/api/bots"
 	"backend/api/chats"
 	"backend/api/contacts"
+	"backend/api/exports"
 	"backend/api/files"
 	apiintegrations "backend/api/integrations"
 	"backend/api/metrics"
 	"backend/api/models"
 	"backend/api/reference"
+	"backend/api/schedules"
 	"backend/api/tools"
 	"backend/api/user"
 	"backend/api/websocket"
 	"backend/integrations"
 	"backend/runtimecfg"
+	"backend/workqueue"
 	"bytes"
 	"context"
 	"embed"
@@ -92,11 +95,12 @@ func websocketMiddleware(ch *websocket.WebSocketHandler) func(next http.Handler)
 	}
 }
 
-func queueMiddleware(queueClient *asynq.Client, queueInspector *asynq.Inspector) func(next http.Handler) http.Handler {
+func queueMiddleware(queueClient *asynq.Client, queueInspector *asynq.Inspector, replyCanceler *workqueue.ReplyCanceler) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := context.WithValue(r.Context(), "asynq_client", queueClient)
 			ctx = context.WithValue(ctx, "asynq_inspector", queueInspector)
+			ctx = context.WithValue(ctx, "reply_canceler", replyCanceler)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -391,6 +395,7 @@ func BackendRouting(
 	DB *gorm.DB,
 	queueClient *asynq.Client,
 	queueInspector *asynq.Inspector,
+	replyCanceler *workqueue.ReplyCanceler,
 	asynqUIHandler http.Handler,
 	debug bool,
 	frontendProxy string,
@@ -415,17 +420,50 @@ func BackendRouting(
 	toolsHandler := &tools.ToolsHandler{}
 	modelsHandler := &models.ModelsHandler{}
 	botsHandler := &bots.BotsHandler{}
+	schedulesHandler := &schedules.SchedulesHandler{}
+	exportsHandler := &exports.ExportsHandler{}
 
 	v1PrivateApis.HandleFunc("GET /chats/list", chatsHandler.List)
+	v1PrivateApis.HandleFunc("GET /chats/search", chatsHandler.SearchMessages)
+	v1PrivateApis.HandleFunc("GET /chats/sync", chatsHandler.Sync)
+	v1PrivateApis.HandleFunc("GET /chats/scheduled", chatsHandler.ListScheduledMessages)
+	v1PrivateApis.HandleFunc("PATCH /chats/scheduled/{scheduled_uuid}", chatsHandler.RescheduleMessage)
+	v1PrivateApis.HandleFunc("DELETE /chats/scheduled/{scheduled_uuid}", chatsHandler.CancelScheduledMessage)
+	v1PrivateApis.HandleFunc("GET /exports", exportsHandler.ListExports)
+	v1PrivateApis.HandleFunc("GET /exports/{export_uuid}", exportsHandler.GetExport)
+	v1PrivateApis.HandleFunc("GET /exports/{export_uuid}/download", exportsHandler.DownloadExport)
+	v1PrivateApis.HandleFunc("DELETE /exports/{export_uuid}", exportsHandler.DeleteExport)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/export", exportsHandler.ExportChat)
 	v1PrivateApis.HandleFunc("GET /chats/{chat_uuid}/messages/list", chatsHandler.ListMessages)
+	v1PrivateApis.HandleFunc("GET /chats/{chat_uuid}/threads", chatsHandler.ListThreads)
 	v1PrivateApis.HandleFunc("GET /chats/{chat_uuid}", chatsHandler.GetChat)
 	v1PrivateApis.HandleFunc("GET /chats/{chat_uuid}/status", chatsHandler.GetInteractionStatus)
 	v1PrivateApis.HandleFunc("GET /chats/{chat_uuid}/contact", contactsHandler.GetContactByChatUUID)
 	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/messages/send", chatsHandler.MessageSend)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/messages/schedule", chatsHandler.ScheduleMessage)
 	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/messages/{message_uuid}/rerun", chatsHandler.RerunMessage)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/messages/{message_uuid}/edit", chatsHandler.EditMessage)
+	v1PrivateApis.HandleFunc("GET /chats/{chat_uuid}/messages/{message_uuid}/revisions", chatsHandler.ListMessageRevisions)
+	v1PrivateApis.HandleFunc("GET /chats/{chat_uuid}/messages/{message_uuid}/branches", chatsHandler.ListBranches)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/messages/{message_uuid}/activate", chatsHandler.ActivateBranch)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/messages/{message_uuid}/fork", chatsHandler.ForkChat)
+	v1PrivateApis.HandleFunc("GET /chats/{chat_uuid}/messages/{message_uuid}/thread", chatsHandler.ListThread)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/messages/{message_uuid}/reactions", chatsHandler.AddReaction)
+	v1PrivateApis.HandleFunc("DELETE /chats/{chat_uuid}/messages/{message_uuid}/reactions/{emoji}", chatsHandler.RemoveReaction)
+	v1PrivateApis.HandleFunc("PUT /chats/{chat_uuid}/messages/{message_uuid}/rating", chatsHandler.RateMessage)
+	v1PrivateApis.HandleFunc("DELETE /chats/{chat_uuid}/messages/{message_uuid}/rating", chatsHandler.DeleteRating)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/messages/{message_uuid}/read", chatsHandler.MarkMessageRead)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/read", chatsHandler.MarkChatRead)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/delivered", chatsHandler.MarkChatDelivered)
 	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/messages/{message_uuid}/confirm-actions/{action_id}/execute", toolsHandler.ExecuteConfirmableAction)
 	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/signals/{signal}", chatsHandler.SignalSendMessage)
 	v1PrivateApis.HandleFunc("POST /chats/create", chatsHandler.Create)
+	v1PrivateApis.HandleFunc("POST /chats/groups/create", chatsHandler.CreateGroup)
+	v1PrivateApis.HandleFunc("GET /chats/{chat_uuid}/participants", chatsHandler.ListParticipants)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/participants/join", chatsHandler.JoinChat)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/participants/leave", chatsHandler.LeaveChat)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/participants/{user_uuid}/kick", chatsHandler.KickParticipant)
+	v1PrivateApis.HandleFunc("POST /chats/{chat_uuid}/bot-trigger-policy", chatsHandler.SetBotTriggerPolicy)
 	v1PrivateApis.HandleFunc("POST /bots", botsHandler.Create)
 	v1PrivateApis.HandleFunc("GET /bots/list", botsHandler.List)
 	v1PrivateApis.HandleFunc("GET /bots/{identifier}", botsHandler.Get)
@@ -433,6 +471,14 @@ func BackendRouting(
 	v1PrivateApis.HandleFunc("PUT /bots/{identifier}/config", botsHandler.SaveConfig)
 	v1PrivateApis.HandleFunc("DELETE /bots/{identifier}", botsHandler.Delete)
 	v1PrivateApis.HandleFunc("POST /bots/{identifier}/interactions", botsHandler.CreateInteraction)
+	v1PrivateApis.HandleFunc("POST /schedules", schedulesHandler.Create)
+	v1PrivateApis.HandleFunc("GET /schedules/list", schedulesHandler.List)
+	v1PrivateApis.HandleFunc("GET /schedules/{schedule_uuid}", schedulesHandler.Get)
+	v1PrivateApis.HandleFunc("PATCH /schedules/{schedule_uuid}", schedulesHandler.Update)
+	v1PrivateApis.HandleFunc("DELETE /schedules/{schedule_uuid}", schedulesHandler.Delete)
+	v1PrivateApis.HandleFunc("POST /schedules/{schedule_uuid}/pause", schedulesHandler.Pause)
+	v1PrivateApis.HandleFunc("POST /schedules/{schedule_uuid}/resume", schedulesHandler.Resume)
+	v1PrivateApis.HandleFunc("GET /schedules/{schedule_uuid}/runs", schedulesHandler.ListRuns)
 	v1PrivateApis.HandleFunc("POST /models", modelsHandler.Create)
 	v1PrivateApis.HandleFunc("PATCH /models/{model_uuid}", modelsHandler.Patch)
 	v1PrivateApis.HandleFunc("DELETE /models/{model_uuid}", modelsHandler.Delete)
@@ -452,6 +498,7 @@ func BackendRouting(
 	v1PrivateApis.HandleFunc("POST /user/access-tokens", userHandler.CreateAccessToken)
 	v1PrivateApis.HandleFunc("GET /user/access-tokens/list", userHandler.ListAccessTokens)
 	v1PrivateApis.HandleFunc("POST /user/access-tokens/{token_uuid}/revoke", userHandler.RevokeAccessToken)
+	v1PrivateApis.HandleFunc("GET /user/usage", userHandler.SelfUsage)
 	v1PrivateApis.HandleFunc("POST /user/2fa/setup", userHandler.SetupTwoFactor)
 	v1PrivateApis.HandleFunc("POST /user/2fa/confirm", userHandler.ConfirmTwoFactor)
 	v1PrivateApis.HandleFunc("POST /user/2fa/disable", userHandler.DisableTwoFactor)
@@ -473,6 +520,21 @@ func BackendRouting(
 	v1PrivateApis.HandleFunc("GET /admin/asynq/queues/{queue}/tasks/{task_id}", admin.GetAsynqTask)
 	v1PrivateApis.HandleFunc("GET /admin/asynq/queues/{queue}/stats", admin.GetAsynqQueueStats)
 	v1PrivateApis.HandleFunc("POST /admin/bots/{bot_uuid}/models/selection", admin.UpdateBotModelSelection)
+	v1PrivateApis.HandleFunc("GET /admin/providers", admin.ListProviders)
+	v1PrivateApis.HandleFunc("GET /admin/providers/{provider}/models", admin.ListProviderModels)
+	v1PrivateApis.HandleFunc("GET /admin/usage", admin.GetTokenUsage)
+	v1PrivateApis.HandleFunc("GET /admin/quotas", admin.ListTokenQuotas)
+	v1PrivateApis.HandleFunc("PUT /admin/quotas/{subject}/{user_uuid}", admin.SetTokenQuota)
+	v1PrivateApis.HandleFunc("DELETE /admin/quotas/{subject}/{user_uuid}", admin.DeleteTokenQuota)
+	v1PrivateApis.HandleFunc("GET /admin/upload-limits", admin.ListUploadLimits)
+	v1PrivateApis.HandleFunc("PUT /admin/upload-limits/{user_uuid}", admin.SetUploadLimit)
+	v1PrivateApis.HandleFunc("DELETE /admin/upload-limits/{user_uuid}", admin.DeleteUploadLimit)
+	v1PrivateApis.HandleFunc("GET /admin/feedback", admin.GetFeedbackReport)
+	v1PrivateApis.HandleFunc("GET /admin/feedback/ratings", admin.ListFeedbackRatings)
+	v1PrivateApis.HandleFunc("GET /admin/retention/policies", admin.ListRetentionPolicies)
+	v1PrivateApis.HandleFunc("PUT /admin/retention/policies", admin.SetRetentionPolicy)
+	v1PrivateApis.HandleFunc("DELETE /admin/retention/policies/{policy_uuid}", admin.DeleteRetentionPolicy)
+	v1PrivateApis.HandleFunc("GET /admin/retention/report", admin.GetRetentionReport)
 
 	v1PrivateApis.HandleFunc("GET /metrics", metricsHandler.Metrics)
 
@@ -480,13 +542,24 @@ func BackendRouting(
 	v1PrivateApis.HandleFunc("GET /files/{file_id}", filesHandler.GetFile)
 	v1PrivateApis.HandleFunc("GET /files/{file_id}/info", filesHandler.GetFileInfo)
 	v1PrivateApis.HandleFunc("GET /files/{file_id}/data", filesHandler.GetFileData)
+	v1PrivateApis.HandleFunc("GET /files/{file_id}/text", filesHandler.GetFileText)
+	v1PrivateApis.HandleFunc("GET /files/{file_id}/thumbnail", filesHandler.GetFileThumbnail)
 	v1PrivateApis.HandleFunc("DELETE /files/{file_id}", filesHandler.DeleteFile)
+	v1PrivateApis.HandleFunc("GET /files/shared", filesHandler.ListSharedWithMe)
+	v1PrivateApis.HandleFunc("GET /files/{file_id}/access", filesHandler.GetFileAccess)
+	v1PrivateApis.HandleFunc("POST /files/{file_id}/share", filesHandler.ShareFile)
+	v1PrivateApis.HandleFunc("DELETE /files/{file_id}/access/{user_uuid}", filesHandler.RevokeFileAccess)
+	v1PrivateApis.HandleFunc("OPTIONS /uploads", filesHandler.TusOptions)
+	v1PrivateApis.HandleFunc("POST /uploads", filesHandler.TusCreate)
+	v1PrivateApis.HandleFunc("HEAD /uploads/{upload_id}", filesHandler.TusHead)
+	v1PrivateApis.HandleFunc("PATCH /uploads/{upload_id}", filesHandler.TusPatch)
+	v1PrivateApis.HandleFunc("DELETE /uploads/{upload_id}", filesHandler.TusTerminate)
 
 	commonMiddlewares := CreateStack(
 		APINoCacheMiddleware,
 		dbMiddleware(DB),
 		websocketMiddleware(websocketHandler),
-		queueMiddleware(queueClient, queueInspector),
+		queueMiddleware(queueClient, queueInspector, replyCanceler),
 	)
 
 	mobileProxyCfg := resolveMobileAPIWSProxyConfig()
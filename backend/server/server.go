@@ -3,6 +3,7 @@ package server
 import (
 	"backend/api/websocket"
 	"backend/database"
+	"backend/workqueue"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -16,6 +17,7 @@ func BackendServer(
 	DB *gorm.DB,
 	queueClient *asynq.Client,
 	queueInspector *asynq.Inspector,
+	replyCanceler *workqueue.ReplyCanceler,
 	asynqUIHandler http.Handler,
 	host string,
 	port uint16,
@@ -25,7 +27,7 @@ func BackendServer(
 	signupRequiresAdminApproval bool,
 ) (*http.Server, *websocket.WebSocketHandler, string, error) {
 	fullHost := fmt.Sprintf("http://%s:%d", host, port)
-	router, websocketHandler := BackendRouting(DB, queueClient, queueInspector, asynqUIHandler, debug, frontendProxy, sessionCookieDomain, signupRequiresAdminApproval)
+	router, websocketHandler := BackendRouting(DB, queueClient, queueInspector, replyCanceler, asynqUIHandler, debug, frontendProxy, sessionCookieDomain, signupRequiresAdminApproval)
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", host, port),
 		Handler: router,
@@ -3,6 +3,7 @@ package util
 import (
 	"backend/api/websocket"
 	"backend/database"
+	"backend/workqueue"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -64,6 +65,14 @@ func GetAsynqInspector(r *http.Request) (*asynq.Inspector, error) {
 	return inspector, nil
 }
 
+func GetReplyCanceler(r *http.Request) (*workqueue.ReplyCanceler, error) {
+	replyCanceler, ok := r.Context().Value("reply_canceler").(*workqueue.ReplyCanceler)
+	if !ok || replyCanceler == nil {
+		return nil, errors.New("invalid reply canceler")
+	}
+	return replyCanceler, nil
+}
+
 func CreateUserPwPreHashed(
 	DB *gorm.DB,
 	username string,
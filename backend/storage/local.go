@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const DefaultLocalDir = "./uploads"
+
+// Local stores files in a directory on disk. Its storage URLs are the file
+// paths, so it can read and delete files from any directory.
+type Local struct {
+	Dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+func (l *Local) Name() string {
+	return BackendLocal
+}
+
+func (l *Local) Put(_ context.Context, key string, body io.Reader, _ int64, _ string) (string, error) {
+	path := filepath.Join(l.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	// Write to a temporary file first so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return path, nil
+}
+
+// Open returns the stored *os.File, which callers can seek.
+func (l *Local) Open(_ context.Context, storageURL string) (io.ReadCloser, error) {
+	file, err := os.Open(storageURL)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (l *Local) Delete(_ context.Context, storageURL string) error {
+	if err := os.Remove(storageURL); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Owns(storageURL string) bool {
+	return storageURL != "" && !strings.Contains(storageURL, "://")
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"mime"
+	"path"
+
+	"backend/database"
+
+	"gorm.io/gorm"
+)
+
+type MigrationReport struct {
+	Moved   int   `json:"moved"`
+	Skipped int   `json:"skipped"`
+	Failed  int   `json:"failed"`
+	Bytes   int64 `json:"bytes"`
+}
+
+type MigrationOptions struct {
+	// DryRun only counts the files that would be moved.
+	DryRun bool
+	// DeleteSource removes a file from its old backend once it was copied.
+	DeleteSource bool
+}
+
+// MigrateFiles copies every uploaded file that is not stored in target yet
+// to target and points its StorageURL at the copy. Files that fail to copy
+// keep their old StorageURL and are counted as failed, so the migration can
+// simply be run again.
+func MigrateFiles(ctx context.Context, DB *gorm.DB, target Storage, options MigrationOptions) (MigrationReport, error) {
+	var report MigrationReport
+	var files []database.UploadedFile
+	result := DB.Unscoped().Model(&database.UploadedFile{}).FindInBatches(&files, 100, func(tx *gorm.DB, _ int) error {
+		for _, file := range files {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if file.StorageURL == "" || target.Owns(file.StorageURL) {
+				report.Skipped++
+				continue
+			}
+			if options.DryRun {
+				report.Moved++
+				report.Bytes += file.Size
+				continue
+			}
+			if err := migrateFile(ctx, DB, target, file, options.DeleteSource); err != nil {
+				log.Printf("Failed to migrate file %s to %s storage: %v", file.FileID, target.Name(), err)
+				report.Failed++
+				continue
+			}
+			report.Moved++
+			report.Bytes += file.Size
+		}
+		return nil
+	})
+	return report, result.Error
+}
+
+func migrateFile(ctx context.Context, DB *gorm.DB, target Storage, file database.UploadedFile, deleteSource bool) error {
+	if err := migrateObject(ctx, DB, target, file, "storage_url", file.StorageURL, file.FileID, file.Size, file.MIMEType, deleteSource); err != nil {
+		return err
+	}
+	// Thumbnails move with their file
+	if file.ThumbnailStorageURL == "" || target.Owns(file.ThumbnailStorageURL) {
+		return nil
+	}
+	key := path.Base(file.ThumbnailStorageURL)
+	return migrateObject(ctx, DB, target, file, "thumbnail_storage_url", file.ThumbnailStorageURL, key, -1, mime.TypeByExtension(path.Ext(key)), deleteSource)
+}
+
+// migrateObject copies the stored object in column of a file to target
+func migrateObject(ctx context.Context, DB *gorm.DB, target Storage, file database.UploadedFile, column, sourceURL, key string, size int64, contentType string, deleteSource bool) error {
+	source, err := Resolve(sourceURL)
+	if err != nil {
+		return err
+	}
+	reader, err := source.Open(ctx, sourceURL)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	storageURL, err := target.Put(ctx, key, reader, size, contentType)
+	if err != nil {
+		return err
+	}
+	// Only switch files nobody moved in the meantime
+	result := DB.Unscoped().Model(&database.UploadedFile{}).
+		Where("id = ? AND "+column+" = ?", file.ID, sourceURL).
+		Update(column, storageURL)
+	if result.Error != nil {
+		target.Delete(ctx, storageURL)
+		return result.Error
+	}
+	if deleteSource && result.RowsAffected > 0 {
+		if err := source.Delete(ctx, sourceURL); err != nil {
+			log.Printf("Migrated file %s but failed to remove it from %s storage: %v", file.FileID, source.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// @doc:open-chat-storage
+// Uploaded files are kept by a storage backend. Every backend addresses its
+// files by a storage URL, which is what UploadedFile.StorageURL records: the
+// local driver uses plain file paths (the format uploads always had) and the
+// S3 driver uses s3://<bucket>/<key>. New files go to the configured default
+// backend while files already stored elsewhere are read from whichever
+// configured backend owns their URL, so switching backends does not break
+// existing uploads; MigrateFiles moves them over.
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+var ErrNotFound = errors.New("stored file not found")
+
+// Storage is a backend uploaded files are written to and read from.
+type Storage interface {
+	// Name is the backend name, BackendLocal or BackendS3.
+	Name() string
+	// Put stores size bytes of body under key and returns the storage URL of
+	// the stored file. A negative size means the size is unknown.
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) (string, error)
+	// Open returns the content of a stored file, or ErrNotFound.
+	Open(ctx context.Context, storageURL string) (io.ReadCloser, error)
+	// Delete removes a stored file; deleting a missing file is not an error.
+	Delete(ctx context.Context, storageURL string) error
+	// Owns reports whether the storage URL addresses a file of this backend.
+	Owns(storageURL string) bool
+}
+
+var (
+	mu       sync.RWMutex
+	primary  Storage = NewLocal(DefaultLocalDir)
+	backends []Storage
+)
+
+// Configure sets the backend new files are stored in and the other backends
+// existing files may still be read from.
+func Configure(defaultBackend Storage, others ...Storage) {
+	mu.Lock()
+	defer mu.Unlock()
+	primary = defaultBackend
+	backends = append([]Storage(nil), others...)
+}
+
+// Default returns the backend new files are stored in.
+func Default() Storage {
+	mu.RLock()
+	defer mu.RUnlock()
+	return primary
+}
+
+// Resolve returns the configured backend that owns a storage URL. Plain paths
+// always resolve to a local driver so files uploaded before a backend switch
+// stay readable.
+func Resolve(storageURL string) (Storage, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, backend := range append([]Storage{primary}, backends...) {
+		if backend.Owns(storageURL) {
+			return backend, nil
+		}
+	}
+	if !strings.Contains(storageURL, "://") {
+		return NewLocal(DefaultLocalDir), nil
+	}
+	return nil, fmt.Errorf("no storage backend configured for %q", storageURL)
+}
+
+// Open returns the content of the file stored at storageURL.
+func Open(ctx context.Context, storageURL string) (io.ReadCloser, error) {
+	backend, err := Resolve(storageURL)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Open(ctx, storageURL)
+}
+
+// ReadAll returns the whole content of the file stored at storageURL.
+func ReadAll(ctx context.Context, storageURL string) ([]byte, error) {
+	reader, err := Open(ctx, storageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Delete removes the file stored at storageURL.
+func Delete(ctx context.Context, storageURL string) error {
+	backend, err := Resolve(storageURL)
+	if err != nil {
+		return err
+	}
+	return backend.Delete(ctx, storageURL)
+}
@@ -12,13 +12,33 @@ func BotReplyTaskID(chatUUID string) string {
 	return "bot-reply:" + chatUUID
 }
 
+// GroupBotReplyTaskID returns the asynq task ID for one bot's reply in a group chat,
+// so several bots can answer the same message concurrently.
+func GroupBotReplyTaskID(chatUUID string, botUserID uint) string {
+	return fmt.Sprintf("bot-reply:%s:%d", chatUUID, botUserID)
+}
+
 // CancelBotReplyTask stops an in-flight bot reply and removes a queued one, if any.
 func CancelBotReplyTask(inspector *asynq.Inspector, chatUUID string) {
 	if inspector == nil || chatUUID == "" {
 		return
 	}
 
-	taskID := BotReplyTaskID(chatUUID)
+	cancelBotReplyTaskID(inspector, BotReplyTaskID(chatUUID))
+}
+
+// CancelGroupBotReplyTasks stops in-flight and queued replies of the given bots in a group chat.
+func CancelGroupBotReplyTasks(inspector *asynq.Inspector, chatUUID string, botUserIDs []uint) {
+	if inspector == nil || chatUUID == "" {
+		return
+	}
+
+	for _, botUserID := range botUserIDs {
+		cancelBotReplyTaskID(inspector, GroupBotReplyTaskID(chatUUID, botUserID))
+	}
+}
+
+func cancelBotReplyTaskID(inspector *asynq.Inspector, taskID string) {
 	_ = inspector.CancelProcessing(taskID)
 	_ = inspector.DeleteTask(QueueDefault, taskID)
 }
@@ -31,6 +51,22 @@ func EnqueueBotReply(client *asynq.Client, inspector *asynq.Inspector, payload B
 
 	CancelBotReplyTask(inspector, payload.ChatUUID)
 
+	return enqueueBotReplyTask(client, BotReplyTaskID(payload.ChatUUID), payload, opts...)
+}
+
+// EnqueueGroupBotReply schedules one bot's reply in a group chat, cancelling only
+// that bot's existing reply task so other bots in the chat keep running.
+func EnqueueGroupBotReply(client *asynq.Client, inspector *asynq.Inspector, payload BotReplyPayload, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	if client == nil {
+		return nil, fmt.Errorf("asynq client is required")
+	}
+
+	CancelGroupBotReplyTasks(inspector, payload.ChatUUID, []uint{payload.BotUserID})
+
+	return enqueueBotReplyTask(client, GroupBotReplyTaskID(payload.ChatUUID, payload.BotUserID), payload, opts...)
+}
+
+func enqueueBotReplyTask(client *asynq.Client, taskID string, payload BotReplyPayload, opts ...asynq.Option) (*asynq.TaskInfo, error) {
 	task, err := NewBotReplyTask(payload)
 	if err != nil {
 		return nil, err
@@ -38,7 +74,7 @@ func EnqueueBotReply(client *asynq.Client, inspector *asynq.Inspector, payload B
 
 	enqueueOpts := []asynq.Option{
 		asynq.Queue(QueueDefault),
-		asynq.TaskID(BotReplyTaskID(payload.ChatUUID)),
+		asynq.TaskID(taskID),
 		asynq.MaxRetry(10),
 		asynq.Timeout(5 * time.Minute),
 		asynq.Retention(0),
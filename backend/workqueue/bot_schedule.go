@@ -0,0 +1,51 @@
+package workqueue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+// BotScheduleTaskOptions are the options of the periodic tasks of bot
+// schedules. They only enqueue the run of their firing, the uniqueness
+// window spares the duplicates enqueued when several worker processes run
+// the scheduler most of that work.
+func BotScheduleTaskOptions() []asynq.Option {
+	return []asynq.Option{
+		asynq.Queue(QueueDefault),
+		asynq.MaxRetry(3),
+		asynq.Timeout(time.Minute),
+		asynq.Unique(time.Minute),
+		asynq.Retention(time.Hour),
+	}
+}
+
+// BotScheduleRunTaskID returns the asynq task ID running a bot schedule's
+// firing at firedAt. The schedulers of all workers enqueue a firing's run
+// under the same ID, so it runs once.
+func BotScheduleRunTaskID(scheduleUUID string, firedAt time.Time) string {
+	return fmt.Sprintf("bot-schedule:%s:%d", scheduleUUID, firedAt.Unix())
+}
+
+// EnqueueBotScheduleRun enqueues the run of a bot schedule's firing. Runs are
+// not retried so a failing run never posts twice, and are kept long enough
+// for late duplicates to conflict with their task ID.
+func EnqueueBotScheduleRun(client *asynq.Client, payload BotScheduleRunPayload, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	if client == nil {
+		return nil, fmt.Errorf("asynq client is required")
+	}
+	task, err := NewBotScheduleRunTask(payload)
+	if err != nil {
+		return nil, err
+	}
+	enqueueOpts := []asynq.Option{
+		asynq.Queue(QueueDefault),
+		asynq.TaskID(BotScheduleRunTaskID(payload.ScheduleUUID, payload.FiredAt)),
+		asynq.MaxRetry(0),
+		asynq.Timeout(2 * time.Minute),
+		asynq.Retention(24 * time.Hour),
+	}
+	enqueueOpts = append(enqueueOpts, opts...)
+	return client.Enqueue(task, enqueueOpts...)
+}
@@ -0,0 +1,29 @@
+package workqueue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+// EnqueueChatExport schedules rendering a pending chat export. The export is
+// claimed before rendering, so a run is not retried.
+func EnqueueChatExport(client *asynq.Client, payload ChatExportPayload, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	if client == nil {
+		return nil, fmt.Errorf("asynq client is required")
+	}
+	task, err := NewChatExportTask(payload)
+	if err != nil {
+		return nil, err
+	}
+	enqueueOpts := []asynq.Option{
+		asynq.Queue(QueueDefault),
+		asynq.TaskID("chat-export:" + payload.ExportUUID),
+		asynq.MaxRetry(0),
+		asynq.Timeout(15 * time.Minute),
+		asynq.Retention(24 * time.Hour),
+	}
+	enqueueOpts = append(enqueueOpts, opts...)
+	return client.Enqueue(task, enqueueOpts...)
+}
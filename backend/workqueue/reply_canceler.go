@@ -0,0 +1,147 @@
+package workqueue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const DefaultReplyCancelChannel = "open-chat:bot-reply:cancel"
+
+// ErrReplyInterrupted is the cancellation cause of a bot reply stopped through a ReplyCanceler.
+var ErrReplyInterrupted = errors.New("bot reply interrupted")
+
+// ReplyCanceler cancels in-flight bot replies by chat UUID across all server and
+// worker processes. Cancel publishes the chat UUID over Redis pub/sub and every
+// process cancels the contexts it handed out through Watch for that chat.
+// A nil *ReplyCanceler is valid: Watch then returns a plain cancellable
+// context and Cancel does nothing.
+type ReplyCanceler struct {
+	client  redis.UniversalClient
+	channel string
+
+	mu       sync.Mutex
+	nextID   uint64
+	watchers map[string]map[uint64]context.CancelCauseFunc
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func NewReplyCanceler(client redis.UniversalClient, channel string) *ReplyCanceler {
+	if channel == "" {
+		channel = DefaultReplyCancelChannel
+	}
+	return &ReplyCanceler{
+		client:   client,
+		channel:  channel,
+		watchers: make(map[string]map[uint64]context.CancelCauseFunc),
+	}
+}
+
+// Start subscribes to cancellations published by other processes.
+// It returns once the subscription is established.
+func (rc *ReplyCanceler) Start() error {
+	ctx, stop := context.WithCancel(context.Background())
+	pubsub := rc.client.Subscribe(ctx, rc.channel)
+	// Wait for the confirmation so cancellations published right after starting are not lost.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		stop()
+		return err
+	}
+
+	rc.stop = stop
+	rc.done = make(chan struct{})
+	go func() {
+		defer close(rc.done)
+		defer pubsub.Close()
+
+		// The pubsub channel reconnects on its own and is closed with the subscription.
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				rc.cancelLocal(msg.Payload)
+			}
+		}
+	}()
+	return nil
+}
+
+// Watch returns a context that is cancelled with ErrReplyInterrupted as soon as
+// Cancel is called for chatUUID in any process. Callers must call the returned
+// function once the reply is done.
+func (rc *ReplyCanceler) Watch(parent context.Context, chatUUID string) (context.Context, context.CancelFunc) {
+	if rc == nil || chatUUID == "" {
+		return context.WithCancel(parent)
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+
+	rc.mu.Lock()
+	rc.nextID++
+	id := rc.nextID
+	if rc.watchers[chatUUID] == nil {
+		rc.watchers[chatUUID] = make(map[uint64]context.CancelCauseFunc)
+	}
+	rc.watchers[chatUUID][id] = cancel
+	rc.mu.Unlock()
+
+	return ctx, func() {
+		rc.mu.Lock()
+		delete(rc.watchers[chatUUID], id)
+		if len(rc.watchers[chatUUID]) == 0 {
+			delete(rc.watchers, chatUUID)
+		}
+		rc.mu.Unlock()
+		cancel(context.Canceled)
+	}
+}
+
+// Cancel stops every in-flight reply in the chat. Replies running in this
+// process are cancelled even if publishing to other processes fails.
+func (rc *ReplyCanceler) Cancel(ctx context.Context, chatUUID string) error {
+	if rc == nil || chatUUID == "" {
+		return nil
+	}
+
+	rc.cancelLocal(chatUUID)
+	return rc.client.Publish(ctx, rc.channel, chatUUID).Err()
+}
+
+func (rc *ReplyCanceler) cancelLocal(chatUUID string) {
+	rc.mu.Lock()
+	cancels := make([]context.CancelCauseFunc, 0, len(rc.watchers[chatUUID]))
+	for _, cancel := range rc.watchers[chatUUID] {
+		cancels = append(cancels, cancel)
+	}
+	rc.mu.Unlock()
+
+	if len(cancels) > 0 {
+		log.Printf("Interrupting %d bot replies for chat %s", len(cancels), chatUUID)
+	}
+	for _, cancel := range cancels {
+		cancel(ErrReplyInterrupted)
+	}
+}
+
+// Close stops the subscription and closes the Redis client.
+func (rc *ReplyCanceler) Close() error {
+	if rc == nil {
+		return nil
+	}
+	if rc.stop != nil {
+		rc.stop()
+		<-rc.done
+	}
+	return rc.client.Close()
+}
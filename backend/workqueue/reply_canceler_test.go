@@ -0,0 +1,84 @@
+package workqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func startTestReplyCanceler(t *testing.T, addr string) *ReplyCanceler {
+	t.Helper()
+	replyCanceler := NewReplyCanceler(redis.NewClient(&redis.Options{Addr: addr}), "")
+	if err := replyCanceler.Start(); err != nil {
+		t.Fatalf("failed to start reply canceler: %v", err)
+	}
+	t.Cleanup(func() { replyCanceler.Close() })
+	return replyCanceler
+}
+
+func TestReplyCancelerInterruptsRepliesOnOtherProcesses(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	server := startTestReplyCanceler(t, redisServer.Addr())
+	worker := startTestReplyCanceler(t, redisServer.Addr())
+
+	replyCtx, stop := worker.Watch(context.Background(), "chat-1")
+	defer stop()
+	otherCtx, stopOther := worker.Watch(context.Background(), "chat-2")
+	defer stopOther()
+
+	if err := server.Cancel(context.Background(), "chat-1"); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+
+	select {
+	case <-replyCtx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("reply on worker was not interrupted")
+	}
+	if !errors.Is(replyCtx.Err(), context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", replyCtx.Err())
+	}
+	if !errors.Is(context.Cause(replyCtx), ErrReplyInterrupted) {
+		t.Fatalf("expected ErrReplyInterrupted cause, got %v", context.Cause(replyCtx))
+	}
+	if otherCtx.Err() != nil {
+		t.Fatalf("reply in another chat should keep running")
+	}
+}
+
+func TestReplyCancelerCancelsLocallyWhenPublishFails(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	replyCanceler := NewReplyCanceler(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}), "")
+	defer replyCanceler.Close()
+
+	replyCtx, stop := replyCanceler.Watch(context.Background(), "chat-1")
+	defer stop()
+	redisServer.Close()
+
+	if err := replyCanceler.Cancel(context.Background(), "chat-1"); err == nil {
+		t.Fatalf("expected publish error with redis down")
+	}
+	if !errors.Is(context.Cause(replyCtx), ErrReplyInterrupted) {
+		t.Fatalf("expected local reply to be interrupted, got %v", context.Cause(replyCtx))
+	}
+}
+
+func TestNilReplyCancelerIsNoop(t *testing.T) {
+	var replyCanceler *ReplyCanceler
+
+	replyCtx, stop := replyCanceler.Watch(context.Background(), "chat-1")
+	if err := replyCanceler.Cancel(context.Background(), "chat-1"); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if replyCtx.Err() != nil {
+		t.Fatalf("nil canceler should not cancel replies")
+	}
+	stop()
+	if replyCtx.Err() == nil {
+		t.Fatalf("stop should release the context")
+	}
+}
@@ -0,0 +1,24 @@
+package workqueue
+
+import (
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+// RetentionPurgeCronspec runs the retention purge every hour, keeping each
+// purge small.
+const RetentionPurgeCronspec = "CRON_TZ=UTC 17 * * * *"
+
+// RetentionPurgeTaskOptions are the options of the periodic retention purge.
+// The uniqueness window drops the duplicates enqueued when several worker
+// processes run the scheduler.
+func RetentionPurgeTaskOptions() []asynq.Option {
+	return []asynq.Option{
+		asynq.Queue(QueueDefault),
+		asynq.MaxRetry(2),
+		asynq.Timeout(30 * time.Minute),
+		asynq.Unique(30 * time.Minute),
+		asynq.Retention(24 * time.Hour),
+	}
+}
@@ -0,0 +1,45 @@
+package workqueue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+// ScheduledMessageTaskID returns the asynq task ID delivering a scheduled
+// message at sendAt. Rescheduling gets a new ID so it never conflicts with a
+// task that could not be removed.
+func ScheduledMessageTaskID(scheduledMessageUUID string, sendAt time.Time) string {
+	return fmt.Sprintf("scheduled-message:%s:%d", scheduledMessageUUID, sendAt.Unix())
+}
+
+// EnqueueScheduledMessage schedules the delivery of a scheduled message at sendAt.
+func EnqueueScheduledMessage(client *asynq.Client, payload ScheduledMessagePayload, sendAt time.Time, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	if client == nil {
+		return nil, fmt.Errorf("asynq client is required")
+	}
+	task, err := NewScheduledMessageTask(payload)
+	if err != nil {
+		return nil, err
+	}
+	enqueueOpts := []asynq.Option{
+		asynq.Queue(QueueDefault),
+		asynq.TaskID(ScheduledMessageTaskID(payload.ScheduledMessageUUID, sendAt)),
+		asynq.ProcessAt(sendAt),
+		asynq.MaxRetry(10),
+		// Matches database.ScheduledMessageClaimTimeout
+		asynq.Timeout(2 * time.Minute),
+		asynq.Retention(24 * time.Hour),
+	}
+	enqueueOpts = append(enqueueOpts, opts...)
+	return client.Enqueue(task, enqueueOpts...)
+}
+
+// CancelScheduledMessageTask removes the delivery task of a scheduled message, if any.
+func CancelScheduledMessageTask(inspector *asynq.Inspector, taskID string) {
+	if inspector == nil || taskID == "" {
+		return
+	}
+	_ = inspector.DeleteTask(QueueDefault, taskID)
+}
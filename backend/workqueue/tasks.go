@@ -2,14 +2,21 @@ package workqueue
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/hibiken/asynq"
 )
 
 const (
-	QueueDefault        = "default"
-	TypeBotReply        = "bot:reply"
-	TypeEmailAutomation = "emails:automation"
+	QueueDefault         = "default"
+	TypeBotReply         = "bot:reply"
+	TypeEmailAutomation  = "emails:automation"
+	TypeScheduledMessage = "messages:scheduled"
+	TypeBotSchedule      = "bots:schedule"
+	TypeBotScheduleRun   = "bots:schedule-run"
+	TypeRetentionPurge   = "maintenance:retention"
+	TypeChatExport       = "chats:export"
+	TypeTusExpire        = "maintenance:tus-expire"
 )
 
 type BotReplyPayload struct {
@@ -26,6 +33,23 @@ type EmailAutomationPayload struct {
 	TemplateValues   map[string]string `json:"template_values,omitempty"`
 }
 
+type ScheduledMessagePayload struct {
+	ScheduledMessageUUID string `json:"scheduled_message_uuid"`
+}
+
+type BotSchedulePayload struct {
+	ScheduleUUID string `json:"schedule_uuid"`
+}
+
+type BotScheduleRunPayload struct {
+	ScheduleUUID string    `json:"schedule_uuid"`
+	FiredAt      time.Time `json:"fired_at"`
+}
+
+type ChatExportPayload struct {
+	ExportUUID string `json:"export_uuid"`
+}
+
 func NewBotReplyTask(payload BotReplyPayload) (*asynq.Task, error) {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
@@ -43,3 +67,47 @@ func NewEmailAutomationTask(payload EmailAutomationPayload) (*asynq.Task, error)
 
 	return asynq.NewTask(TypeEmailAutomation, payloadBytes), nil
 }
+
+func NewScheduledMessageTask(payload ScheduledMessagePayload) (*asynq.Task, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(TypeScheduledMessage, payloadBytes), nil
+}
+
+func NewBotScheduleTask(payload BotSchedulePayload) (*asynq.Task, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(TypeBotSchedule, payloadBytes), nil
+}
+
+func NewBotScheduleRunTask(payload BotScheduleRunPayload) (*asynq.Task, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(TypeBotScheduleRun, payloadBytes), nil
+}
+
+func NewChatExportTask(payload ChatExportPayload) (*asynq.Task, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(TypeChatExport, payloadBytes), nil
+}
+
+func NewRetentionPurgeTask() *asynq.Task {
+	return asynq.NewTask(TypeRetentionPurge, nil)
+}
+
+func NewTusExpireTask() *asynq.Task {
+	return asynq.NewTask(TypeTusExpire, nil)
+}
@@ -0,0 +1,22 @@
+package workqueue
+
+import (
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+// TusExpireCronspec removes expired resumable uploads every hour.
+const TusExpireCronspec = "CRON_TZ=UTC 47 * * * *"
+
+// TusExpireTaskOptions are the options of the periodic removal of expired
+// resumable uploads, unique like the retention purge.
+func TusExpireTaskOptions() []asynq.Option {
+	return []asynq.Option{
+		asynq.Queue(QueueDefault),
+		asynq.MaxRetry(2),
+		asynq.Timeout(10 * time.Minute),
+		asynq.Unique(30 * time.Minute),
+		asynq.Retention(24 * time.Hour),
+	}
+}